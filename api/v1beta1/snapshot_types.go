@@ -19,6 +19,7 @@ package v1beta1
 import (
 	"time"
 
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -41,11 +42,28 @@ type KindReference struct {
 	Name string `json:"name"`
 }
 
+// +kubebuilder:validation:XValidation:rule="[has(self.container), has(self.containers), has(self.allContainers) && self.allContainers].filter(x, x).size() == 1",message="exactly one of container, containers or allContainers must be set"
 type SnapShotSelector struct {
+	// Object is a Pod, or a Deployment, StatefulSet, DaemonSet or ReplicaSet
+	// in which case one ready pod per node architecture is checkpointed and
+	// the checkpoints are pushed as a single image index
 	// +required
 	Object ObjectReference `json:"object"`
-	// +required
-	Container string `json:"container"`
+	// Container is checkpointed to the output image reference, a regular
+	// container or a native sidecar, an init container with restartPolicy
+	// Always. Other init containers and ephemeral containers can't be selected
+	// +optional
+	Container string `json:"container,omitempty"`
+	// Containers of a Pod are checkpointed together, each to the output image
+	// reference with the container name appended to its tag, and swapped
+	// together once every image is pushed
+	// +optional
+	// +kubebuilder:validation:MinItems=1
+	Containers []string `json:"containers,omitempty"`
+	// AllContainers selects every container of a Pod, like Containers,
+	// native sidecars included
+	// +optional
+	AllContainers bool `json:"allContainers,omitempty"`
 }
 
 // SnapShotInputPolicy will(TODO:) add support for Replace, etc ...
@@ -54,6 +72,151 @@ type SnapShotInputPolicy string
 // IfNotPresent only creates a SnapShot if it's not already present
 const IfNotPresent SnapShotInputPolicy = "IfNotPresent"
 
+// SnapShotHookExec runs a command in the container through the pods/exec
+// subresource, it succeeds when the command exits 0
+type SnapShotHookExec struct {
+	// +required
+	// +kubebuilder:validation:MinItems=1
+	Command []string `json:"command"`
+}
+
+// SnapShotHookHTTP calls the container on its pod IP, it succeeds on a 2xx
+// response. HTTPS certificates aren't verified, like kubelet probes
+type SnapShotHookHTTP struct {
+	// +required
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port"`
+	// +optional
+	// +kubebuilder:default:="/"
+	Path string `json:"path,omitempty"`
+	// +optional
+	// +kubebuilder:default:=POST
+	// +kubebuilder:validation:Enum=GET;POST;PUT
+	Method string `json:"method,omitempty"`
+	// +optional
+	// +kubebuilder:default:=HTTP
+	// +kubebuilder:validation:Enum=HTTP;HTTPS
+	Scheme string `json:"scheme,omitempty"`
+}
+
+// SnapShotHookSignal sends a signal to the init process of the container,
+// through the daemonset of its node, so the image needs no kill binary
+type SnapShotHookSignal struct {
+	// Name is a signal name, like SIGUSR1
+	// +required
+	// +kubebuilder:validation:Pattern=`^SIG[A-Z0-9]+$`
+	Name string `json:"name"`
+}
+
+// SnapShotHookOnError is what a failing hook does to the snapshot
+// +kubebuilder:validation:Enum=Fail;Continue
+type SnapShotHookOnError string
+
+const (
+	// HookFail fails the snapshot, the following hooks aren't run
+	HookFail SnapShotHookOnError = "Fail"
+	// HookContinue records the failure and runs the following hooks
+	HookContinue SnapShotHookOnError = "Continue"
+)
+
+// SnapShotHook is an action run in the checkpointed pod before or after the
+// kubelet checkpoint
+// +kubebuilder:validation:XValidation:rule="[has(self.exec), has(self.http), has(self.signal)].filter(x, x).size() == 1",message="exactly one of exec, http or signal must be set"
+type SnapShotHook struct {
+	// Name identifies the hook in the status
+	// +required
+	Name string `json:"name"`
+	// Container the hook runs in, it defaults to the checkpointed containers:
+	// when several are, the pre hooks run in each before any is checkpointed
+	// and the post hooks after all are
+	// +optional
+	Container string `json:"container,omitempty"`
+	// +optional
+	Exec *SnapShotHookExec `json:"exec,omitempty"`
+	// +optional
+	HTTP *SnapShotHookHTTP `json:"http,omitempty"`
+	// +optional
+	Signal *SnapShotHookSignal `json:"signal,omitempty"`
+	// +optional
+	// +kubebuilder:default:="30s"
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+	// +optional
+	// +kubebuilder:default:=Fail
+	OnError SnapShotHookOnError `json:"onError,omitempty"`
+}
+
+// SnapShotInputHooks quiesce the application around the checkpoint, they're
+// run in order in every checkpointed pod, which must be in the SnapShot
+// namespace. Signal hooks need the daemonset signals enabled
+type SnapShotInputHooks struct {
+	// Pre hooks run right before the checkpoint, to flush buffers, drop
+	// sockets, pause consumers or shrink the heap
+	// +optional
+	Pre []SnapShotHook `json:"pre,omitempty"`
+	// Post hooks run once the checkpoint is taken, or failed, to resume the pod
+	// +optional
+	Post []SnapShotHook `json:"post,omitempty"`
+}
+
+// SnapShotSlimRuntime is a language runtime whose heap can be compacted
+// +kubebuilder:validation:Enum=JVM;Go
+type SnapShotSlimRuntime string
+
+const (
+	// JVM runs `jcmd 1 GC.run` in the container, the JVM must be its init
+	// process and the image must ship jcmd
+	JVM SnapShotSlimRuntime = "JVM"
+	// Go calls the net/http/pprof heap profile with gc=1 on PprofPort, the
+	// closest to debug.FreeOSMemory pprof offers, the scavenger returns the
+	// freed memory to the OS
+	Go SnapShotSlimRuntime = "Go"
+)
+
+// SnapShotSlimGC triggers the garbage collector of the application, through
+// a signal it handles or an endpoint it serves
+// +kubebuilder:validation:XValidation:rule="has(self.signal) != has(self.http)",message="exactly one of signal or http must be set"
+type SnapShotSlimGC struct {
+	// +optional
+	Signal *SnapShotHookSignal `json:"signal,omitempty"`
+	// +optional
+	HTTP *SnapShotHookHTTP `json:"http,omitempty"`
+}
+
+// SnapShotInputSlim shrinks the memory of the checkpointed containers right
+// before the checkpoint, after the pre hooks, as the checkpoint size tracks
+// the resident memory. Every step is best effort, failures are reported in
+// the status and don't fail the snapshot. Pods outside the SnapShot namespace
+// aren't slimmed
+// +kubebuilder:validation:XValidation:rule="!has(self.runtime) || self.runtime != 'Go' || has(self.pprofPort)",message="pprofPort must be set for the Go runtime"
+type SnapShotInputSlim struct {
+	// +optional
+	GC *SnapShotSlimGC `json:"gc,omitempty"`
+	// Runtime compacts the heap of a known language runtime
+	// +optional
+	Runtime SnapShotSlimRuntime `json:"runtime,omitempty"`
+	// PprofPort serves net/http/pprof, for the Go runtime
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	PprofPort int32 `json:"pprofPort,omitempty"`
+	// DropPageCache reclaims the page cache of the container cgroup through
+	// the daemonset of its node, it needs the daemonset reclaim enabled,
+	// cgroup v2, Linux 5.19 or later and no swap the container may use
+	// +optional
+	DropPageCache bool `json:"dropPageCache,omitempty"`
+	// Settle is waited for before the memory is measured again, the kubelet
+	// samples the container stats every 10s or so. The checkpoint doesn't
+	// wait for it
+	// +optional
+	// +kubebuilder:default:="15s"
+	Settle metav1.Duration `json:"settle,omitempty"`
+	// Timeout bounds each step
+	// +optional
+	// +kubebuilder:default:="30s"
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
 type SnapShotInput struct {
 	// +optional
 	Timeout int `json:"timeout"`
@@ -61,6 +224,10 @@ type SnapShotInput struct {
 	Delay time.Duration `json:"delay"`
 	// +required
 	Policy SnapShotInputPolicy `json:"policy"`
+	// +optional
+	Hooks SnapShotInputHooks `json:"hooks,omitempty"`
+	// +optional
+	Slim *SnapShotInputSlim `json:"slim,omitempty"`
 	// TODO: implement me
 	// Schedule string              `json:"schedule"`
 }
@@ -70,11 +237,269 @@ type SnapShotOutputContainerRegistry struct {
 	ImagePushSecret KindReference `json:"imagePushSecret"`
 	// +required
 	ImageReference string `json:"imageReference"`
+	// MountFrom lists repositories of the same registry the layers are
+	// cross-repository mounted from, instead of being uploaded again
+	// +optional
+	MountFrom []string `json:"mountFrom,omitempty"`
+}
+
+// SnapShotOutputCompressionAlgorithm is the algorithm used to compress the checkpoint layers
+// +kubebuilder:validation:Enum=gzip;zstd;uncompressed;estargz
+type SnapShotOutputCompressionAlgorithm string
+
+const (
+	// Gzip compresses the checkpoint layers using gzip
+	Gzip SnapShotOutputCompressionAlgorithm = "gzip"
+	// Zstd compresses the checkpoint layers using zstd
+	Zstd SnapShotOutputCompressionAlgorithm = "zstd"
+	// Uncompressed pushes the checkpoint layers as plain tar
+	Uncompressed SnapShotOutputCompressionAlgorithm = "uncompressed"
+	// EStargz compresses the checkpoint layers as seekable gzip, so lazy-pulling
+	// snapshotters can start a restore before the whole layer is fetched
+	EStargz SnapShotOutputCompressionAlgorithm = "estargz"
+)
+
+type SnapShotOutputCompression struct {
+	// +optional
+	// +kubebuilder:default:=gzip
+	Algorithm SnapShotOutputCompressionAlgorithm `json:"algorithm,omitempty"`
+	// Level is passed to the compressor as is, 0 uses the algorithm's default.
+	// It's ignored for uncompressed
+	// +optional
+	Level int `json:"level,omitempty"`
+}
+
+// SnapShotOutputLayers controls how the checkpoint archive is split into layers,
+// the metadata, rootfs-diff.tar and CRIU process images always get a layer each
+type SnapShotOutputLayers struct {
+	// PagesSplitThreshold puts every CRIU pages-*.img at least this large in its
+	// own layer, unset keeps them with the rest of the process images
+	// +optional
+	PagesSplitThreshold *resource.Quantity `json:"pagesSplitThreshold,omitempty"`
+}
+
+// SnapShotOutputParent is the checkpoint image an incremental snapshot stacks on
+// +kubebuilder:validation:XValidation:rule="has(self.snapShot) != has(self.imageReference)",message="exactly one of snapShot or imageReference must be set"
+type SnapShotOutputParent struct {
+	// SnapShot is a previous SnapShot in the same namespace, its output image is used
+	// +optional
+	SnapShot string `json:"snapShot,omitempty"`
+	// +optional
+	ImageReference string `json:"imageReference,omitempty"`
+}
+
+type SnapShotOutputRetry struct {
+	// Limit is the number of times a failed blob or manifest upload is retried,
+	// with a jittered exponential backoff, 0 disables retries and unset means 5
+	// +optional
+	// +kubebuilder:default:=5
+	// +kubebuilder:validation:Minimum=0
+	Limit *int32 `json:"limit,omitempty"`
+	// Deadline bounds the whole push, retries included, unset means no deadline
+	// +optional
+	Deadline *metav1.Duration `json:"deadline,omitempty"`
+}
+
+// SnapShotOutputSigning signs the pushed image the way cosign does, along with
+// its provenance, the controller refuses to swap in images without both
+type SnapShotOutputSigning struct {
+	// KeySecret is a Secret as created by `cosign generate-key-pair k8s://<namespace>/<name>`,
+	// the image is signed with its cosign.key and cosign.password
+	// +required
+	KeySecret KindReference `json:"keySecret"`
+	// PublicKeySecret holds the cosign.pub signatures are verified against,
+	// it defaults to KeySecret
+	// +optional
+	PublicKeySecret *KindReference `json:"publicKeySecret,omitempty"`
+}
+
+// SnapShotOutputEncryptionProtocol is how the layer keys are wrapped for a recipient
+// +kubebuilder:validation:Enum=jwe;pkcs7
+type SnapShotOutputEncryptionProtocol string
+
+const (
+	// JWE wraps the layer keys for a public key
+	JWE SnapShotOutputEncryptionProtocol = "jwe"
+	// PKCS7 wraps the layer keys for an x509 certificate
+	PKCS7 SnapShotOutputEncryptionProtocol = "pkcs7"
+)
+
+type SnapShotOutputEncryptionRecipient struct {
+	// +required
+	Protocol SnapShotOutputEncryptionProtocol `json:"protocol"`
+	// PublicKey is a PEM public key for jwe or a PEM x509 certificate for pkcs7
+	// +required
+	PublicKey string `json:"publicKey"`
+}
+
+// SnapShotOutputEncryption encrypts the checkpoint layers with ocicrypt, as they
+// hold the process memory, only the recipients can restore the image
+type SnapShotOutputEncryption struct {
+	// +required
+	// +kubebuilder:validation:MinItems=1
+	Recipients []SnapShotOutputEncryptionRecipient `json:"recipients"`
+	// DecryptionKeySecret holds private keys, and certificates for pkcs7, that
+	// are installed in the container runtime decryption keys directory of the
+	// node before the image is swapped in
+	// +optional
+	DecryptionKeySecret *KindReference `json:"decryptionKeySecret,omitempty"`
+}
+
+// SnapShotOutputFormat is the manifest, config and annotations shape of the output image
+// +kubebuilder:validation:Enum=auto;cri-o;containerd;artifact
+type SnapShotOutputFormat string
+
+const (
+	// Auto picks the restore-from-checkpoint image format of the container
+	// runtime the checkpoint was taken with
+	Auto SnapShotOutputFormat = "auto"
+	// CRIO is the checkpoint image CRI-O (and Podman) restores from
+	CRIO SnapShotOutputFormat = "cri-o"
+	// Containerd is the checkpoint image containerd restores from
+	Containerd SnapShotOutputFormat = "containerd"
+	// Artifact is a generic OCI artifact for archival, runtimes can't pull it
+	// and the pod image isn't swapped
+	Artifact SnapShotOutputFormat = "artifact"
+)
+
+// SnapShotOutputBaseImage keeps the checkpoint restorable when the base rootfs
+// image is garbage-collected or retagged upstream
+type SnapShotOutputBaseImage struct {
+	// Replicate copies the base image by digest into Repository and pins the
+	// restore to that copy, the snapshot fails early when the base image digest
+	// can't be resolved anymore. The copy is the subject of the output image,
+	// which registries only list as its referrer in the same repository
+	// +optional
+	Replicate bool `json:"replicate,omitempty"`
+	// Repository defaults to the repository of the output image, the output
+	// image is also pushed into it when it's another one
+	// +optional
+	Repository string `json:"repository,omitempty"`
+}
+
+// SnapShotOutputOfflineFormat is the on-disk shape of an offline export
+// +kubebuilder:validation:Enum=layout;tarball
+type SnapShotOutputOfflineFormat string
+
+const (
+	// Layout appends the image to an OCI image layout directory
+	Layout SnapShotOutputOfflineFormat = "layout"
+	// Tarball writes a `docker save` compatible tarball
+	Tarball SnapShotOutputOfflineFormat = "tarball"
+)
+
+// SnapShotOutputOffline writes the image to the export volume of the node
+// instead of pushing it, for air-gapped clusters. The image is named after
+// the container registry image reference
+type SnapShotOutputOffline struct {
+	// +optional
+	// +kubebuilder:default:=layout
+	Format SnapShotOutputOfflineFormat `json:"format,omitempty"`
+	// Path is relative to the export volume, it defaults to the job ID
+	// +optional
+	Path string `json:"path,omitempty"`
+}
+
+// SnapShotOutputObjectStoreMode is what is uploaded to the object store
+// +kubebuilder:validation:Enum=archive;layout
+type SnapShotOutputObjectStoreMode string
+
+const (
+	// CheckpointArchive uploads the kubelet checkpoint archive as is, it can't
+	// be signed nor encrypted
+	CheckpointArchive SnapShotOutputObjectStoreMode = "archive"
+	// ImageLayout uploads the checkpoint image, its split layers, signature
+	// and provenance as an OCI image layout
+	ImageLayout SnapShotOutputObjectStoreMode = "layout"
+)
+
+// SnapShotOutputObjectStore uploads the snapshot to an S3 compatible object
+// store instead of pushing it, the objects are written under
+// <prefix>/<namespace>/<name>/ so restores can find them by SnapShot
+type SnapShotOutputObjectStore struct {
+	// Endpoint is the URL of the object store, without a path, the bucket is
+	// addressed path-style
+	// +required
+	Endpoint string `json:"endpoint"`
+	// +optional
+	// +kubebuilder:default:=us-east-1
+	Region string `json:"region,omitempty"`
+	// +required
+	Bucket string `json:"bucket"`
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+	// CredentialsSecret holds the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY,
+	// and optionally AWS_SESSION_TOKEN, entries
+	// +required
+	CredentialsSecret KindReference `json:"credentialsSecret"`
+	// +optional
+	// +kubebuilder:default:=archive
+	Mode SnapShotOutputObjectStoreMode `json:"mode,omitempty"`
+	// PartSize is the multipart upload part size, objects smaller than it are
+	// uploaded in a single request. It defaults to 64Mi and can't be under 5Mi
+	// +optional
+	PartSize *resource.Quantity `json:"partSize,omitempty"`
+}
+
+// SnapShotOutputLocal imports the image into the containerd image store of the
+// node the checkpoint was taken on, for restores on that same node. The pod
+// must not use imagePullPolicy Always, it can't be changed on a running pod
+type SnapShotOutputLocal struct {
+	// Namespace is the containerd namespace, the kubelet uses k8s.io
+	// +optional
+	// +kubebuilder:default:=k8s.io
+	Namespace string `json:"namespace,omitempty"`
+	// Name defaults to the container registry image reference
+	// +optional
+	Name string `json:"name,omitempty"`
 }
 
+// SnapShotOutputPrefetch pulls the pushed image into the container runtime of
+// candidate nodes before the image is swapped in, so the restarted container
+// doesn't cold pull the checkpoint. Nodes failing to prefetch don't block the swap
+type SnapShotOutputPrefetch struct {
+	// NodeSelector selects the candidate nodes, unset means the nodes running
+	// the checkpointed pods
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+}
+
+// +kubebuilder:validation:XValidation:rule="[has(self.offline), has(self.objectStore), has(self.local)].filter(x, x).size() <= 1",message="only one of offline, objectStore and local can be set"
+// +kubebuilder:validation:XValidation:rule="!has(self.objectStore) || (has(self.objectStore.mode) && self.objectStore.mode == 'layout') || (!has(self.signing) && !has(self.encryption))",message="signing and encryption need the layout objectStore mode"
 type SnapShotOutput struct {
+	// ContainerRegistry is where the image is pushed, with Offline, ObjectStore
+	// or Local only its image reference is used to name the image
 	// +required
 	ContainerRegistry SnapShotOutputContainerRegistry `json:"containerRegistry"`
+	// +optional
+	Offline *SnapShotOutputOffline `json:"offline,omitempty"`
+	// +optional
+	ObjectStore *SnapShotOutputObjectStore `json:"objectStore,omitempty"`
+	// +optional
+	Local *SnapShotOutputLocal `json:"local,omitempty"`
+	// Prefetch is ignored unless the image is pushed, and for several containers
+	// +optional
+	Prefetch *SnapShotOutputPrefetch `json:"prefetch,omitempty"`
+	// Parent makes the snapshot incremental, the unchanged layers of the parent
+	// are reused and only the changed files are pushed as a new layer
+	// +optional
+	Parent *SnapShotOutputParent `json:"parent,omitempty"`
+	// +optional
+	// +kubebuilder:default:=auto
+	Format SnapShotOutputFormat `json:"format,omitempty"`
+	// +optional
+	Compression SnapShotOutputCompression `json:"compression,omitempty"`
+	// +optional
+	Layers SnapShotOutputLayers `json:"layers,omitempty"`
+	// +optional
+	// +kubebuilder:default:={}
+	Retry SnapShotOutputRetry `json:"retry,omitempty"`
+	// +optional
+	Signing *SnapShotOutputSigning `json:"signing,omitempty"`
+	// +optional
+	Encryption *SnapShotOutputEncryption `json:"encryption,omitempty"`
+	// +optional
+	BaseImage SnapShotOutputBaseImage `json:"baseImage,omitempty"`
 }
 
 // SnapShotSpec defines the desired state of SnapShot
@@ -123,6 +548,105 @@ type SnapShotStatusNode struct {
 	KubeletPort   int32  `json:"kubeletPort"`
 }
 
+// SnapShotStatusPlatform is the checkpoint of one architecture of a workload
+type SnapShotStatusPlatform struct {
+	Architecture string `json:"architecture"`
+	Pod          string `json:"pod"`
+	// +optional
+	Node SnapShotStatusNode `json:"node,omitempty"`
+	// +optional
+	CheckPointNodePath string `json:"checkpointNodePath,omitempty"`
+	// +optional
+	JobID string `json:"jobId,omitempty"`
+	// +optional
+	Stage SnapShotStatusStage `json:"stage,omitempty"`
+	// +optional
+	State SnapShotStatusState `json:"state,omitempty"`
+	// Digest is the manifest pushed for this architecture
+	// +optional
+	Digest string `json:"digest,omitempty"`
+}
+
+// SnapShotStatusContainer is the checkpoint of one container of a pod-level snapshot
+type SnapShotStatusContainer struct {
+	Name           string `json:"name"`
+	ImageReference string `json:"imageReference"`
+	// +optional
+	CheckPointNodePath string `json:"checkpointNodePath,omitempty"`
+	// +optional
+	JobID string `json:"jobId,omitempty"`
+	// +optional
+	Stage SnapShotStatusStage `json:"stage,omitempty"`
+	// +optional
+	State SnapShotStatusState `json:"state,omitempty"`
+	// Digest is the manifest pushed for this container
+	// +optional
+	Digest string `json:"digest,omitempty"`
+}
+
+// SnapShotHookPhase is when a hook runs
+type SnapShotHookPhase string
+
+const (
+	// HookPre runs before the checkpoint
+	HookPre SnapShotHookPhase = "Pre"
+	// HookPost runs after the checkpoint
+	HookPost SnapShotHookPhase = "Post"
+)
+
+// SnapShotStatusHook is the last run of a hook in a pod
+type SnapShotStatusHook struct {
+	Name  string              `json:"name"`
+	Phase SnapShotHookPhase   `json:"phase"`
+	Pod   string              `json:"pod"`
+	State SnapShotStatusState `json:"state"`
+	// Message is the output of an exec hook, the response of an http one,
+	// truncated, or the error of a failed hook
+	// +optional
+	Message string `json:"message,omitempty"`
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// SnapShotStatusSlim is the memory of a container before and after it was
+// slimmed, in bytes, as the kubelet stats API reports it. The checkpoint
+// doesn't wait for the memory after, it's measured Settle after the steps ran
+type SnapShotStatusSlim struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	// SlimTime is when the steps ran
+	// +optional
+	SlimTime *metav1.Time `json:"slimTime,omitempty"`
+	// MeasureTime is when the memory after was measured
+	// +optional
+	MeasureTime *metav1.Time `json:"measureTime,omitempty"`
+	// +optional
+	RSSBefore int64 `json:"rssBefore,omitempty"`
+	// +optional
+	RSSAfter int64 `json:"rssAfter,omitempty"`
+	// +optional
+	WorkingSetBefore int64 `json:"workingSetBefore,omitempty"`
+	// +optional
+	WorkingSetAfter int64 `json:"workingSetAfter,omitempty"`
+	// Message is the error of the steps that failed
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// SnapShotStatusPrefetch is the pull of the output image on one candidate node
+type SnapShotStatusPrefetch struct {
+	Node string `json:"node"`
+	// +optional
+	JobID string `json:"jobId,omitempty"`
+	// +optional
+	State SnapShotStatusState `json:"state,omitempty"`
+	// ImageID is the image ID the container runtime reports
+	// +optional
+	ImageID string `json:"imageID,omitempty"`
+}
+
 // SnapShotStatus defines the observed state of SnapShot.
 type SnapShotStatus struct {
 	// +kubebuilder:default:=Fromating
@@ -134,6 +658,67 @@ type SnapShotStatus struct {
 	CheckPointNodePath     string             `json:"checkpointNodePath"`
 	JobID                  string             `json:"jobId"`
 	OutPutReferenceIsValid bool               `json:"outputReferenceIsValid"`
+	// CompressedSize is the sum of the pushed layer sizes in bytes
+	// +optional
+	CompressedSize int64 `json:"compressedSize,omitempty"`
+	// DeduplicatedSize is the size in bytes of the parent layers reused as is
+	// +optional
+	DeduplicatedSize int64 `json:"deduplicatedSize,omitempty"`
+	// SkippedSize is the size in bytes of the layers the registry already had
+	// or mounted from another repository, so they weren't uploaded
+	// +optional
+	SkippedSize int64 `json:"skippedSize,omitempty"`
+	// PushRetries is the number of blob and manifest uploads retried
+	// +optional
+	PushRetries int32 `json:"pushRetries,omitempty"`
+	// Digest is the manifest, or the image index of a workload, pushed to the
+	// output image reference. Prefetch pulls it and the swap is pinned to it
+	// +optional
+	Digest string `json:"digest,omitempty"`
+	// VerifiedDigest is the manifest digest whose signature was last verified
+	// +optional
+	VerifiedDigest string `json:"verifiedDigest,omitempty"`
+	// ProvenanceDigest is the manifest digest of the SLSA provenance attached
+	// to the output image as an OCI referrer, its single layer is the DSSE
+	// envelope `cosign verify-blob-attestation --type slsaprovenance1` verifies
+	// +optional
+	ProvenanceDigest string `json:"provenanceDigest,omitempty"`
+	// BaseImageReference is the replicated copy of the base image restores use
+	// +optional
+	BaseImageReference string `json:"baseImageReference,omitempty"`
+	// ExportPath is where the image was exported on the node export volume
+	// +optional
+	ExportPath string `json:"exportPath,omitempty"`
+	// LocalImage is the containerd image name the checkpoint was imported as
+	// +optional
+	LocalImage string `json:"localImage,omitempty"`
+	// ObjectURL is the uploaded checkpoint archive, or index.json of the image layout
+	// +optional
+	ObjectURL string `json:"objectURL,omitempty"`
+	// ObjectETag is the ETag the object store returned for ObjectURL
+	// +optional
+	ObjectETag string `json:"objectETag,omitempty"`
+	// Platforms are the per-architecture checkpoints of a workload, merged
+	// into the image index of the output reference
+	// +optional
+	Platforms []SnapShotStatusPlatform `json:"platforms,omitempty"`
+	// Containers are the per-container checkpoints of a pod-level snapshot
+	// +optional
+	Containers []SnapShotStatusContainer `json:"containers,omitempty"`
+	// Prefetch is the per-node pull of the output image, the image is swapped
+	// in once every node is done
+	// +optional
+	Prefetch []SnapShotStatusPrefetch `json:"prefetch,omitempty"`
+	// Hooks are the results of the pre and post checkpoint hooks
+	// +optional
+	Hooks []SnapShotStatusHook `json:"hooks,omitempty"`
+	// Slim is the memory of the slimmed containers
+	// +optional
+	Slim []SnapShotStatusSlim `json:"slim,omitempty"`
+	// Message explains a Failed state that retrying won't fix, like a selected
+	// container whose image can't be swapped in place
+	// +optional
+	Message string `json:"message,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -0,0 +1,155 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SnapShotGroupSelector selects the member pods of a group
+type SnapShotGroupSelector struct {
+	// Namespace of the pods, it can only be the namespace of the SnapShotGroup:
+	// the members are exec'd into and swapped with the controller rights
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// PodSelector selects the members, every selected pod must be ready
+	// before any of them is checkpointed
+	// +required
+	PodSelector metav1.LabelSelector `json:"podSelector"`
+	// Container is checkpointed in every member, a regular container or a
+	// native sidecar
+	// +required
+	Container string `json:"container"`
+}
+
+// SnapShotGroupQuiesce runs a command in the selected container of every
+// member, through the pods/exec subresource, before any of them is
+// checkpointed, and another once all of them are
+type SnapShotGroupQuiesce struct {
+	// Command quiesces a member, it must exit 0 on every member for the group
+	// to be checkpointed
+	// +required
+	// +kubebuilder:validation:MinItems=1
+	Command []string `json:"command"`
+	// ResumeCommand resumes a quiesced member once the checkpoints are taken,
+	// or the group failed
+	// +optional
+	ResumeCommand []string `json:"resumeCommand,omitempty"`
+	// Timeout bounds each command
+	// +optional
+	// +kubebuilder:default:="30s"
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
+type SnapShotGroupInput struct {
+	// Timeout is passed to the kubelet checkpoint of every member, like
+	// the timeout of a SnapShot
+	// +optional
+	Timeout int `json:"timeout"`
+	// +optional
+	Quiesce *SnapShotGroupQuiesce `json:"quiesce,omitempty"`
+}
+
+// SnapShotGroupSpec defines the desired state of SnapShotGroup
+type SnapShotGroupSpec struct {
+	// +required
+	Selector SnapShotGroupSelector `json:"selector"`
+	// +optional
+	Input SnapShotGroupInput `json:"input,omitempty"`
+	// Output is shared by the members, every member is pushed to the
+	// container registry image reference with its pod name appended to the
+	// tag. The image must be pushed, Parent and Prefetch aren't supported
+	// +required
+	// +kubebuilder:validation:XValidation:rule="!has(self.offline) && !has(self.objectStore) && !has(self.local)",message="the images of a group must be pushed"
+	// +kubebuilder:validation:XValidation:rule="!has(self.parent) && !has(self.prefetch)",message="parent and prefetch aren't supported for groups"
+	Output SnapShotOutput `json:"output"`
+}
+
+// SnapShotGroupStatusMember is the checkpoint of one member pod
+type SnapShotGroupStatusMember struct {
+	Pod            string `json:"pod"`
+	ImageReference string `json:"imageReference"`
+	// +optional
+	Node SnapShotStatusNode `json:"node,omitempty"`
+	// Quiesced is set while the member is quiesced
+	// +optional
+	Quiesced bool `json:"quiesced,omitempty"`
+	// +optional
+	CheckPointNodePath string `json:"checkpointNodePath,omitempty"`
+	// +optional
+	JobID string `json:"jobId,omitempty"`
+	// +optional
+	Stage SnapShotStatusStage `json:"stage,omitempty"`
+	// +optional
+	State SnapShotStatusState `json:"state,omitempty"`
+	// Digest is the manifest pushed for this member
+	// +optional
+	Digest string `json:"digest,omitempty"`
+}
+
+// SnapShotGroupStatus defines the observed state of SnapShotGroup. The group
+// succeeds once every member is pushed, and fails as soon as one member does,
+// the images are only swapped in when all of them succeeded
+type SnapShotGroupStatus struct {
+	// +optional
+	Stage SnapShotStatusStage `json:"stage,omitempty"`
+	// +optional
+	State SnapShotStatusState `json:"state,omitempty"`
+	// +optional
+	Members []SnapShotGroupStatusMember `json:"members,omitempty"`
+	// CompressedSize is the sum of the pushed layer sizes of every member in bytes
+	// +optional
+	CompressedSize int64 `json:"compressedSize,omitempty"`
+	// Message explains a Failed state
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// SnapShotGroup is the Schema for the snapshotgroups API, it checkpoints a set
+// of pods at about the same moment, for distributed services that only
+// restore consistently as a whole
+type SnapShotGroup struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// metadata is a standard object metadata
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitempty,omitzero"`
+
+	// spec defines the desired state of SnapShotGroup
+	// +required
+	Spec SnapShotGroupSpec `json:"spec"`
+
+	// status defines the observed state of SnapShotGroup
+	// +optional
+	Status SnapShotGroupStatus `json:"status,omitempty,omitzero"`
+}
+
+// +kubebuilder:object:root=true
+
+// SnapShotGroupList contains a list of SnapShotGroup
+type SnapShotGroupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SnapShotGroup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SnapShotGroup{}, &SnapShotGroupList{})
+}
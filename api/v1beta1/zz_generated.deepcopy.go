@@ -21,9 +21,25 @@ limitations under the License.
 package v1beta1
 
 import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KindReference) DeepCopyInto(out *KindReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KindReference.
+func (in *KindReference) DeepCopy() *KindReference {
+	if in == nil {
+		return nil
+	}
+	out := new(KindReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ObjectReference) DeepCopyInto(out *ObjectReference) {
 	*out = *in
@@ -44,8 +60,8 @@ func (in *SnapShot) DeepCopyInto(out *SnapShot) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShot.
@@ -66,9 +82,271 @@ func (in *SnapShot) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotGroup) DeepCopyInto(out *SnapShotGroup) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotGroup.
+func (in *SnapShotGroup) DeepCopy() *SnapShotGroup {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotGroup)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *SnapShotGroup) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotGroupInput) DeepCopyInto(out *SnapShotGroupInput) {
+	*out = *in
+	if in.Quiesce != nil {
+		in, out := &in.Quiesce, &out.Quiesce
+		*out = new(SnapShotGroupQuiesce)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotGroupInput.
+func (in *SnapShotGroupInput) DeepCopy() *SnapShotGroupInput {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotGroupInput)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotGroupList) DeepCopyInto(out *SnapShotGroupList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]SnapShotGroup, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotGroupList.
+func (in *SnapShotGroupList) DeepCopy() *SnapShotGroupList {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotGroupList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *SnapShotGroupList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotGroupQuiesce) DeepCopyInto(out *SnapShotGroupQuiesce) {
+	*out = *in
+	if in.Command != nil {
+		in, out := &in.Command, &out.Command
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ResumeCommand != nil {
+		in, out := &in.ResumeCommand, &out.ResumeCommand
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	out.Timeout = in.Timeout
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotGroupQuiesce.
+func (in *SnapShotGroupQuiesce) DeepCopy() *SnapShotGroupQuiesce {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotGroupQuiesce)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotGroupSelector) DeepCopyInto(out *SnapShotGroupSelector) {
+	*out = *in
+	in.PodSelector.DeepCopyInto(&out.PodSelector)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotGroupSelector.
+func (in *SnapShotGroupSelector) DeepCopy() *SnapShotGroupSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotGroupSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotGroupSpec) DeepCopyInto(out *SnapShotGroupSpec) {
+	*out = *in
+	in.Selector.DeepCopyInto(&out.Selector)
+	in.Input.DeepCopyInto(&out.Input)
+	in.Output.DeepCopyInto(&out.Output)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotGroupSpec.
+func (in *SnapShotGroupSpec) DeepCopy() *SnapShotGroupSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotGroupSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotGroupStatus) DeepCopyInto(out *SnapShotGroupStatus) {
+	*out = *in
+	if in.Members != nil {
+		in, out := &in.Members, &out.Members
+		*out = make([]SnapShotGroupStatusMember, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotGroupStatus.
+func (in *SnapShotGroupStatus) DeepCopy() *SnapShotGroupStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotGroupStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotGroupStatusMember) DeepCopyInto(out *SnapShotGroupStatusMember) {
+	*out = *in
+	out.Node = in.Node
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotGroupStatusMember.
+func (in *SnapShotGroupStatusMember) DeepCopy() *SnapShotGroupStatusMember {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotGroupStatusMember)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotHook) DeepCopyInto(out *SnapShotHook) {
+	*out = *in
+	if in.Exec != nil {
+		in, out := &in.Exec, &out.Exec
+		*out = new(SnapShotHookExec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HTTP != nil {
+		in, out := &in.HTTP, &out.HTTP
+		*out = new(SnapShotHookHTTP)
+		**out = **in
+	}
+	if in.Signal != nil {
+		in, out := &in.Signal, &out.Signal
+		*out = new(SnapShotHookSignal)
+		**out = **in
+	}
+	out.Timeout = in.Timeout
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotHook.
+func (in *SnapShotHook) DeepCopy() *SnapShotHook {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotHook)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotHookExec) DeepCopyInto(out *SnapShotHookExec) {
+	*out = *in
+	if in.Command != nil {
+		in, out := &in.Command, &out.Command
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotHookExec.
+func (in *SnapShotHookExec) DeepCopy() *SnapShotHookExec {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotHookExec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotHookHTTP) DeepCopyInto(out *SnapShotHookHTTP) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotHookHTTP.
+func (in *SnapShotHookHTTP) DeepCopy() *SnapShotHookHTTP {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotHookHTTP)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotHookSignal) DeepCopyInto(out *SnapShotHookSignal) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotHookSignal.
+func (in *SnapShotHookSignal) DeepCopy() *SnapShotHookSignal {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotHookSignal)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SnapShotInput) DeepCopyInto(out *SnapShotInput) {
 	*out = *in
+	in.Hooks.DeepCopyInto(&out.Hooks)
+	if in.Slim != nil {
+		in, out := &in.Slim, &out.Slim
+		*out = new(SnapShotInputSlim)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotInput.
@@ -81,6 +359,57 @@ func (in *SnapShotInput) DeepCopy() *SnapShotInput {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotInputHooks) DeepCopyInto(out *SnapShotInputHooks) {
+	*out = *in
+	if in.Pre != nil {
+		in, out := &in.Pre, &out.Pre
+		*out = make([]SnapShotHook, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Post != nil {
+		in, out := &in.Post, &out.Post
+		*out = make([]SnapShotHook, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotInputHooks.
+func (in *SnapShotInputHooks) DeepCopy() *SnapShotInputHooks {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotInputHooks)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotInputSlim) DeepCopyInto(out *SnapShotInputSlim) {
+	*out = *in
+	if in.GC != nil {
+		in, out := &in.GC, &out.GC
+		*out = new(SnapShotSlimGC)
+		(*in).DeepCopyInto(*out)
+	}
+	out.Settle = in.Settle
+	out.Timeout = in.Timeout
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotInputSlim.
+func (in *SnapShotInputSlim) DeepCopy() *SnapShotInputSlim {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotInputSlim)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SnapShotList) DeepCopyInto(out *SnapShotList) {
 	*out = *in
@@ -116,7 +445,46 @@ func (in *SnapShotList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SnapShotOutput) DeepCopyInto(out *SnapShotOutput) {
 	*out = *in
-	out.ContainerRegistry = in.ContainerRegistry
+	in.ContainerRegistry.DeepCopyInto(&out.ContainerRegistry)
+	if in.Offline != nil {
+		in, out := &in.Offline, &out.Offline
+		*out = new(SnapShotOutputOffline)
+		**out = **in
+	}
+	if in.ObjectStore != nil {
+		in, out := &in.ObjectStore, &out.ObjectStore
+		*out = new(SnapShotOutputObjectStore)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Local != nil {
+		in, out := &in.Local, &out.Local
+		*out = new(SnapShotOutputLocal)
+		**out = **in
+	}
+	if in.Prefetch != nil {
+		in, out := &in.Prefetch, &out.Prefetch
+		*out = new(SnapShotOutputPrefetch)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Parent != nil {
+		in, out := &in.Parent, &out.Parent
+		*out = new(SnapShotOutputParent)
+		**out = **in
+	}
+	out.Compression = in.Compression
+	in.Layers.DeepCopyInto(&out.Layers)
+	in.Retry.DeepCopyInto(&out.Retry)
+	if in.Signing != nil {
+		in, out := &in.Signing, &out.Signing
+		*out = new(SnapShotOutputSigning)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Encryption != nil {
+		in, out := &in.Encryption, &out.Encryption
+		*out = new(SnapShotOutputEncryption)
+		(*in).DeepCopyInto(*out)
+	}
+	out.BaseImage = in.BaseImage
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotOutput.
@@ -129,10 +497,45 @@ func (in *SnapShotOutput) DeepCopy() *SnapShotOutput {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotOutputBaseImage) DeepCopyInto(out *SnapShotOutputBaseImage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotOutputBaseImage.
+func (in *SnapShotOutputBaseImage) DeepCopy() *SnapShotOutputBaseImage {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotOutputBaseImage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotOutputCompression) DeepCopyInto(out *SnapShotOutputCompression) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotOutputCompression.
+func (in *SnapShotOutputCompression) DeepCopy() *SnapShotOutputCompression {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotOutputCompression)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SnapShotOutputContainerRegistry) DeepCopyInto(out *SnapShotOutputContainerRegistry) {
 	*out = *in
 	out.ImagePushSecret = in.ImagePushSecret
+	if in.MountFrom != nil {
+		in, out := &in.MountFrom, &out.MountFrom
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotOutputContainerRegistry.
@@ -145,10 +548,209 @@ func (in *SnapShotOutputContainerRegistry) DeepCopy() *SnapShotOutputContainerRe
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotOutputEncryption) DeepCopyInto(out *SnapShotOutputEncryption) {
+	*out = *in
+	if in.Recipients != nil {
+		in, out := &in.Recipients, &out.Recipients
+		*out = make([]SnapShotOutputEncryptionRecipient, len(*in))
+		copy(*out, *in)
+	}
+	if in.DecryptionKeySecret != nil {
+		in, out := &in.DecryptionKeySecret, &out.DecryptionKeySecret
+		*out = new(KindReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotOutputEncryption.
+func (in *SnapShotOutputEncryption) DeepCopy() *SnapShotOutputEncryption {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotOutputEncryption)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotOutputEncryptionRecipient) DeepCopyInto(out *SnapShotOutputEncryptionRecipient) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotOutputEncryptionRecipient.
+func (in *SnapShotOutputEncryptionRecipient) DeepCopy() *SnapShotOutputEncryptionRecipient {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotOutputEncryptionRecipient)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotOutputLayers) DeepCopyInto(out *SnapShotOutputLayers) {
+	*out = *in
+	if in.PagesSplitThreshold != nil {
+		in, out := &in.PagesSplitThreshold, &out.PagesSplitThreshold
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotOutputLayers.
+func (in *SnapShotOutputLayers) DeepCopy() *SnapShotOutputLayers {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotOutputLayers)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotOutputLocal) DeepCopyInto(out *SnapShotOutputLocal) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotOutputLocal.
+func (in *SnapShotOutputLocal) DeepCopy() *SnapShotOutputLocal {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotOutputLocal)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotOutputObjectStore) DeepCopyInto(out *SnapShotOutputObjectStore) {
+	*out = *in
+	out.CredentialsSecret = in.CredentialsSecret
+	if in.PartSize != nil {
+		in, out := &in.PartSize, &out.PartSize
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotOutputObjectStore.
+func (in *SnapShotOutputObjectStore) DeepCopy() *SnapShotOutputObjectStore {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotOutputObjectStore)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotOutputOffline) DeepCopyInto(out *SnapShotOutputOffline) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotOutputOffline.
+func (in *SnapShotOutputOffline) DeepCopy() *SnapShotOutputOffline {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotOutputOffline)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotOutputParent) DeepCopyInto(out *SnapShotOutputParent) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotOutputParent.
+func (in *SnapShotOutputParent) DeepCopy() *SnapShotOutputParent {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotOutputParent)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotOutputPrefetch) DeepCopyInto(out *SnapShotOutputPrefetch) {
+	*out = *in
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotOutputPrefetch.
+func (in *SnapShotOutputPrefetch) DeepCopy() *SnapShotOutputPrefetch {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotOutputPrefetch)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotOutputRetry) DeepCopyInto(out *SnapShotOutputRetry) {
+	*out = *in
+	if in.Limit != nil {
+		in, out := &in.Limit, &out.Limit
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Deadline != nil {
+		in, out := &in.Deadline, &out.Deadline
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotOutputRetry.
+func (in *SnapShotOutputRetry) DeepCopy() *SnapShotOutputRetry {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotOutputRetry)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotOutputSigning) DeepCopyInto(out *SnapShotOutputSigning) {
+	*out = *in
+	out.KeySecret = in.KeySecret
+	if in.PublicKeySecret != nil {
+		in, out := &in.PublicKeySecret, &out.PublicKeySecret
+		*out = new(KindReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotOutputSigning.
+func (in *SnapShotOutputSigning) DeepCopy() *SnapShotOutputSigning {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotOutputSigning)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SnapShotSelector) DeepCopyInto(out *SnapShotSelector) {
 	*out = *in
 	out.Object = in.Object
+	if in.Containers != nil {
+		in, out := &in.Containers, &out.Containers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotSelector.
@@ -161,12 +763,37 @@ func (in *SnapShotSelector) DeepCopy() *SnapShotSelector {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotSlimGC) DeepCopyInto(out *SnapShotSlimGC) {
+	*out = *in
+	if in.Signal != nil {
+		in, out := &in.Signal, &out.Signal
+		*out = new(SnapShotHookSignal)
+		**out = **in
+	}
+	if in.HTTP != nil {
+		in, out := &in.HTTP, &out.HTTP
+		*out = new(SnapShotHookHTTP)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotSlimGC.
+func (in *SnapShotSlimGC) DeepCopy() *SnapShotSlimGC {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotSlimGC)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SnapShotSpec) DeepCopyInto(out *SnapShotSpec) {
 	*out = *in
-	out.Selector = in.Selector
-	out.Input = in.Input
-	out.Output = in.Output
+	in.Selector.DeepCopyInto(&out.Selector)
+	in.Input.DeepCopyInto(&out.Input)
+	in.Output.DeepCopyInto(&out.Output)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotSpec.
@@ -182,6 +809,36 @@ func (in *SnapShotSpec) DeepCopy() *SnapShotSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SnapShotStatus) DeepCopyInto(out *SnapShotStatus) {
 	*out = *in
+	out.Node = in.Node
+	if in.Platforms != nil {
+		in, out := &in.Platforms, &out.Platforms
+		*out = make([]SnapShotStatusPlatform, len(*in))
+		copy(*out, *in)
+	}
+	if in.Containers != nil {
+		in, out := &in.Containers, &out.Containers
+		*out = make([]SnapShotStatusContainer, len(*in))
+		copy(*out, *in)
+	}
+	if in.Prefetch != nil {
+		in, out := &in.Prefetch, &out.Prefetch
+		*out = make([]SnapShotStatusPrefetch, len(*in))
+		copy(*out, *in)
+	}
+	if in.Hooks != nil {
+		in, out := &in.Hooks, &out.Hooks
+		*out = make([]SnapShotStatusHook, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Slim != nil {
+		in, out := &in.Slim, &out.Slim
+		*out = make([]SnapShotStatusSlim, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotStatus.
@@ -193,3 +850,110 @@ func (in *SnapShotStatus) DeepCopy() *SnapShotStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotStatusContainer) DeepCopyInto(out *SnapShotStatusContainer) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotStatusContainer.
+func (in *SnapShotStatusContainer) DeepCopy() *SnapShotStatusContainer {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotStatusContainer)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotStatusHook) DeepCopyInto(out *SnapShotStatusHook) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotStatusHook.
+func (in *SnapShotStatusHook) DeepCopy() *SnapShotStatusHook {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotStatusHook)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotStatusNode) DeepCopyInto(out *SnapShotStatusNode) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotStatusNode.
+func (in *SnapShotStatusNode) DeepCopy() *SnapShotStatusNode {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotStatusNode)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotStatusPlatform) DeepCopyInto(out *SnapShotStatusPlatform) {
+	*out = *in
+	out.Node = in.Node
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotStatusPlatform.
+func (in *SnapShotStatusPlatform) DeepCopy() *SnapShotStatusPlatform {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotStatusPlatform)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotStatusPrefetch) DeepCopyInto(out *SnapShotStatusPrefetch) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotStatusPrefetch.
+func (in *SnapShotStatusPrefetch) DeepCopy() *SnapShotStatusPrefetch {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotStatusPrefetch)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapShotStatusSlim) DeepCopyInto(out *SnapShotStatusSlim) {
+	*out = *in
+	if in.SlimTime != nil {
+		in, out := &in.SlimTime, &out.SlimTime
+		*out = (*in).DeepCopy()
+	}
+	if in.MeasureTime != nil {
+		in, out := &in.MeasureTime, &out.MeasureTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapShotStatusSlim.
+func (in *SnapShotStatusSlim) DeepCopy() *SnapShotStatusSlim {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapShotStatusSlim)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -215,6 +215,15 @@ func main() {
 		setupLog.Error(err, "unable to create controller", "controller", "SnapShot")
 		os.Exit(1)
 	}
+	if err := (&controller.SnapShotGroupReconciler{
+		SnapShotReconciler: controller.SnapShotReconciler{
+			Client: mgr.GetClient(),
+			Scheme: mgr.GetScheme(),
+		},
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "SnapShotGroup")
+		os.Exit(1)
+	}
 	// +kubebuilder:scaffold:builder
 
 	if metricsCertWatcher != nil {
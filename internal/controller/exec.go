@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// execOutputLimit bounds the output of a command kept for the status
+const execOutputLimit = 1024
+
+// +kubebuilder:rbac:groups="",resources=pods/exec,verbs=create
+
+// containerExec runs command in containerName of pod with exec, or podExec
+func (r *SnapShotReconciler) containerExec(
+	ctx context.Context,
+	pod *corev1.Pod,
+	containerName string,
+	command []string,
+	timeout time.Duration,
+) (string, error) {
+	if r.exec != nil {
+		return r.exec(ctx, pod, containerName, command, timeout)
+	}
+	return r.podExec(ctx, pod, containerName, command, timeout)
+}
+
+// podExec runs command in containerName of pod through the pods/exec
+// subresource, like kubectl exec, and returns its combined output truncated
+// to execOutputLimit. A non zero exit status is an error
+func (r *SnapShotReconciler) podExec(
+	ctx context.Context,
+	pod *corev1.Pod,
+	containerName string,
+	command []string,
+	timeout time.Duration,
+) (string, error) {
+	if timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	req := r.clientset.CoreV1().RESTClient().
+		Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: containerName,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(r.restConfig, http.MethodPost, req.URL())
+	if err != nil {
+		return "", err
+	}
+
+	var output syncBuffer
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdout: &output,
+		Stderr: &output,
+	})
+	out := output.String()
+	if len(out) > execOutputLimit {
+		out = out[len(out)-execOutputLimit:]
+	}
+	if err != nil {
+		return out, fmt.Errorf("running %v in %s/%s: %w", command, pod.Name, containerName, err)
+	}
+
+	return out, nil
+}
+
+// syncBuffer is a bytes.Buffer the stdout and stderr streams, copied from
+// their own goroutines, can write to together
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,281 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	"bud.studio/stove8s/internal/daemonset/resources/signals"
+)
+
+// hookedCheckpoint runs the pre hooks in pod and slims containers, then
+// checkpoint unless a hook failed the snapshot, then the post hooks whatever
+// happened so the pod is resumed. Hooks that don't set a container run in
+// every checkpointed one, so they're all quiesced before the first is
+// checkpointed and resumed after the last is
+func (r *SnapShotReconciler) hookedCheckpoint(
+	ctx context.Context,
+	snapshot *stove8sv1beta1.SnapShot,
+	pod *corev1.Pod,
+	containers []string,
+	checkpoint func() error,
+) error {
+	hooks := snapshot.Spec.Input.Hooks
+	err := r.hooksRun(ctx, snapshot, pod, stove8sv1beta1.HookPre, hooks.Pre, containers)
+	if err == nil {
+		r.slim(ctx, snapshot, pod, containers)
+		err = checkpoint()
+	}
+	postErr := r.hooksRun(ctx, snapshot, pod, stove8sv1beta1.HookPost, hooks.Post, containers)
+
+	return errors.Join(err, postErr)
+}
+
+// hooksRun runs hooks in order and records their results in the status, it
+// stops at the first failing hook whose OnError is Fail and returns its error
+func (r *SnapShotReconciler) hooksRun(
+	ctx context.Context,
+	snapshot *stove8sv1beta1.SnapShot,
+	pod *corev1.Pod,
+	phase stove8sv1beta1.SnapShotHookPhase,
+	hooks []stove8sv1beta1.SnapShotHook,
+	containers []string,
+) error {
+	log := logf.FromContext(ctx)
+
+	// NOTE: hooks run as the controller, they'd let the SnapShot creator exec
+	// into pods of namespaces they can't access
+	if len(hooks) != 0 && pod.Namespace != snapshot.Namespace {
+		return fmt.Errorf("%s hooks only run in pods of namespace %s, not in %s/%s",
+			strings.ToLower(string(phase)), snapshot.Namespace, pod.Namespace, pod.Name)
+	}
+
+	for _, hook := range hooks {
+		startTime := metav1.Now()
+		message, err := r.hookRun(ctx, snapshot, pod, hook, containers)
+		completionTime := metav1.Now()
+		result := stove8sv1beta1.SnapShotStatusHook{
+			Name:           hook.Name,
+			Phase:          phase,
+			Pod:            pod.Name,
+			State:          stove8sv1beta1.Success,
+			Message:        message,
+			StartTime:      &startTime,
+			CompletionTime: &completionTime,
+		}
+		if err != nil {
+			result.State = stove8sv1beta1.Failed
+			result.Message = err.Error()
+		}
+		hookStatusSet(snapshot, result)
+
+		if err == nil {
+			log.Info("Ran hook", "hook", hook.Name, "phase", phase, "pod", pod.Name)
+			continue
+		}
+		if hook.OnError == stove8sv1beta1.HookContinue {
+			log.Info("Hook failed, continuing", "hook", hook.Name, "phase", phase, "pod", pod.Name, "reason", err.Error())
+			continue
+		}
+		return fmt.Errorf("%s hook %s failed in %s: %w", strings.ToLower(string(phase)), hook.Name, pod.Name, err)
+	}
+
+	return nil
+}
+
+// hookStatusSet records result, replacing the previous run of the same hook
+func hookStatusSet(snapshot *stove8sv1beta1.SnapShot, result stove8sv1beta1.SnapShotStatusHook) {
+	idx := slices.IndexFunc(snapshot.Status.Hooks, func(hook stove8sv1beta1.SnapShotStatusHook) bool {
+		return hook.Name == result.Name && hook.Phase == result.Phase && hook.Pod == result.Pod
+	})
+	if idx == -1 {
+		snapshot.Status.Hooks = append(snapshot.Status.Hooks, result)
+		return
+	}
+	snapshot.Status.Hooks[idx] = result
+}
+
+// hookRun runs a single hook within its timeout and returns its output, in
+// each of containers unless the hook sets its container
+func (r *SnapShotReconciler) hookRun(
+	ctx context.Context,
+	snapshot *stove8sv1beta1.SnapShot,
+	pod *corev1.Pod,
+	hook stove8sv1beta1.SnapShotHook,
+	containers []string,
+) (string, error) {
+	if hook.Container != "" {
+		containers = []string{hook.Container}
+	}
+	if len(containers) == 0 {
+		return "", errors.New("container must be set")
+	}
+
+	if hook.Timeout.Duration != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hook.Timeout.Duration)
+		defer cancel()
+	}
+
+	// NOTE: http hooks call the pod, not a container
+	if hook.HTTP != nil {
+		return hookHTTP(ctx, pod, hook.HTTP)
+	}
+
+	var messages []string
+	for _, containerName := range containers {
+		var message string
+		var err error
+		switch {
+		case hook.Exec != nil:
+			message, err = r.containerExec(ctx, pod, containerName, hook.Exec.Command, 0)
+		case hook.Signal != nil:
+			err = r.hookSignal(ctx, snapshot, pod, containerName, hook.Signal.Name)
+		default:
+			err = errors.New("one of exec, http or signal must be set")
+		}
+		if len(containers) > 1 && message != "" {
+			message = fmt.Sprintf("%s: %s", containerName, message)
+		}
+		if message != "" {
+			messages = append(messages, message)
+		}
+		if err != nil {
+			return strings.Join(messages, "\n"), fmt.Errorf("in container %s: %w", containerName, err)
+		}
+	}
+	return strings.Join(messages, "\n"), nil
+}
+
+// hookHTTP calls the pod on its IP and returns the response body
+func hookHTTP(ctx context.Context, pod *corev1.Pod, hook *stove8sv1beta1.SnapShotHookHTTP) (string, error) {
+	log := logf.FromContext(ctx)
+
+	if pod.Status.PodIP == "" {
+		return "", fmt.Errorf("pod %s has no IP", pod.Name)
+	}
+	scheme := "http"
+	if hook.Scheme == "HTTPS" {
+		scheme = "https"
+	}
+	path := hook.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	url := fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(int(hook.Port))), path)
+	method := hook.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return "", err
+	}
+	// NOTE: pods serve self-signed certificates, kubelet probes don't verify them either
+	client := http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // nolint: gosec
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			log.Error(err, "Closing response body")
+		}
+	}()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, execOutputLimit))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, body)
+	}
+
+	return string(body), nil
+}
+
+// hookSignal asks the daemonset of the pod node to send signal to the init
+// process of containerName, the daemonset only sends the signals snapshot
+// declares
+func (r *SnapShotReconciler) hookSignal(
+	ctx context.Context,
+	snapshot *stove8sv1beta1.SnapShot,
+	pod *corev1.Pod,
+	containerName string,
+	signal string,
+) error {
+	log := logf.FromContext(ctx)
+
+	containerID, err := podContainerID(pod, containerName)
+	if err != nil {
+		return err
+	}
+	node, err := r.daemonsetNode(ctx, pod.Spec.NodeName)
+	if err != nil {
+		return err
+	}
+	jsonData, err := json.Marshal(signals.CreateReq{
+		Namespace:   snapshot.Namespace,
+		SnapShot:    snapshot.Name,
+		ContainerID: containerID,
+		Signal:      signal,
+	})
+	if err != nil {
+		return err
+	}
+
+	signalsEndpoint := fmt.Sprintf("http://%s:%v/signals", node.DeamonsetAddr, node.DeamonsetPort)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, signalsEndpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	err = r.daemonsetAuth(req)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			log.Error(err, "Closing response body")
+		}
+	}()
+	if resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code for hookSignal: %d: %s", resp.StatusCode, body)
+	}
+
+	return nil
+}
+
+// podContainerID returns the runtime container ID of the running containerName
+func podContainerID(pod *corev1.Pod, containerName string) (string, error) {
+	statuses := slices.Concat(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses)
+	idx := slices.IndexFunc(statuses, func(status corev1.ContainerStatus) bool {
+		return status.Name == containerName
+	})
+	if idx == -1 || statuses[idx].ContainerID == "" || statuses[idx].State.Running == nil {
+		return "", fmt.Errorf("container %s of pod %s isn't running", containerName, pod.Name)
+	}
+	return statuses[idx].ContainerID, nil
+}
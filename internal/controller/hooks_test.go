@@ -0,0 +1,280 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+)
+
+func TestHooksRunOtherNamespace(t *testing.T) {
+	snapshot := &stove8sv1beta1.SnapShot{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "etcd"}}
+	hooks := []stove8sv1beta1.SnapShotHook{{
+		Name: "dump",
+		Exec: &stove8sv1beta1.SnapShotHookExec{Command: []string{"cat", "/etc/kubernetes/pki/etcd/server.key"}},
+	}}
+
+	r := testReconciler(t)
+	err := r.hooksRun(context.Background(), snapshot, pod, stove8sv1beta1.HookPre, hooks, []string{"etcd"})
+	if err == nil || !strings.Contains(err.Error(), "only run in pods of namespace default") {
+		t.Fatalf("expected the hooks to be refused, got %v", err)
+	}
+	if len(snapshot.Status.Hooks) != 0 {
+		t.Errorf("expected no hook to run, got %+v", snapshot.Status.Hooks)
+	}
+
+	err = r.hooksRun(context.Background(), snapshot, pod, stove8sv1beta1.HookPost, nil, []string{"etcd"})
+	if err != nil {
+		t.Errorf("expected no hooks to run anywhere, got %v", err)
+	}
+}
+
+// testHookExec records the commands run, failing the ones starting with fail
+// and blocking the ones starting with sleep until their context is done
+func testHookExec(ran *[]string) func(context.Context, *corev1.Pod, string, []string, time.Duration) (string, error) {
+	return func(ctx context.Context, _ *corev1.Pod, containerName string, command []string, _ time.Duration) (string, error) {
+		*ran = append(*ran, containerName+": "+strings.Join(command, " "))
+		switch command[0] {
+		case "fail":
+			return "", errors.New("command terminated with exit code 1")
+		case "sleep":
+			<-ctx.Done()
+			return "", ctx.Err()
+		}
+		return "ok", nil
+	}
+}
+
+func testHook(name string, command ...string) stove8sv1beta1.SnapShotHook {
+	return stove8sv1beta1.SnapShotHook{
+		Name: name,
+		Exec: &stove8sv1beta1.SnapShotHookExec{Command: command},
+	}
+}
+
+func TestHooksRun(t *testing.T) {
+	continueOnError := func(hook stove8sv1beta1.SnapShotHook) stove8sv1beta1.SnapShotHook {
+		hook.OnError = stove8sv1beta1.HookContinue
+		return hook
+	}
+	timeout := func(hook stove8sv1beta1.SnapShotHook) stove8sv1beta1.SnapShotHook {
+		hook.Timeout = metav1.Duration{Duration: 10 * time.Millisecond}
+		return hook
+	}
+
+	tests := []struct {
+		name   string
+		hooks  []stove8sv1beta1.SnapShotHook
+		err    string
+		ran    []string
+		states []stove8sv1beta1.SnapShotStatusState
+	}{
+		{
+			name:   "success",
+			hooks:  []stove8sv1beta1.SnapShotHook{testHook("flush", "sync"), testHook("freeze", "fsfreeze")},
+			ran:    []string{"app: sync", "app: fsfreeze"},
+			states: []stove8sv1beta1.SnapShotStatusState{stove8sv1beta1.Success, stove8sv1beta1.Success},
+		},
+		{
+			name:   "fail stops",
+			hooks:  []stove8sv1beta1.SnapShotHook{testHook("flush", "fail"), testHook("freeze", "fsfreeze")},
+			err:    "pre hook flush failed in service-a",
+			ran:    []string{"app: fail"},
+			states: []stove8sv1beta1.SnapShotStatusState{stove8sv1beta1.Failed},
+		},
+		{
+			name:   "continue",
+			hooks:  []stove8sv1beta1.SnapShotHook{continueOnError(testHook("flush", "fail")), testHook("freeze", "fsfreeze")},
+			ran:    []string{"app: fail", "app: fsfreeze"},
+			states: []stove8sv1beta1.SnapShotStatusState{stove8sv1beta1.Failed, stove8sv1beta1.Success},
+		},
+		{
+			name:   "timeout",
+			hooks:  []stove8sv1beta1.SnapShotHook{timeout(testHook("flush", "sleep")), testHook("freeze", "fsfreeze")},
+			err:    context.DeadlineExceeded.Error(),
+			ran:    []string{"app: sleep"},
+			states: []stove8sv1beta1.SnapShotStatusState{stove8sv1beta1.Failed},
+		},
+		{
+			name:   "timeout continue",
+			hooks:  []stove8sv1beta1.SnapShotHook{continueOnError(timeout(testHook("flush", "sleep"))), testHook("freeze", "fsfreeze")},
+			ran:    []string{"app: sleep", "app: fsfreeze"},
+			states: []stove8sv1beta1.SnapShotStatusState{stove8sv1beta1.Failed, stove8sv1beta1.Success},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snapshot := &stove8sv1beta1.SnapShot{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "service"}}
+			var ran []string
+			r := testReconciler(t)
+			r.exec = testHookExec(&ran)
+
+			err := r.hooksRun(context.Background(), snapshot, testPod("service-a", "node-a", true), stove8sv1beta1.HookPre, tt.hooks, []string{"app"})
+			if tt.err == "" && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+			}
+			if !slices.Equal(ran, tt.ran) {
+				t.Errorf("expected %v to run, got %v", tt.ran, ran)
+			}
+
+			var states []stove8sv1beta1.SnapShotStatusState
+			for _, hook := range snapshot.Status.Hooks {
+				states = append(states, hook.State)
+				if hook.Phase != stove8sv1beta1.HookPre || hook.Pod != "service-a" || hook.StartTime == nil || hook.CompletionTime == nil {
+					t.Errorf("unexpected hook status %+v", hook)
+				}
+			}
+			if !slices.Equal(states, tt.states) {
+				t.Errorf("expected states %v, got %v", tt.states, states)
+			}
+		})
+	}
+}
+
+func TestHookedCheckpoint(t *testing.T) {
+	tests := []struct {
+		name          string
+		pre           []stove8sv1beta1.SnapShotHook
+		checkpointErr error
+		checkpointed  bool
+		ran           []string
+	}{
+		{
+			name:         "success",
+			pre:          []stove8sv1beta1.SnapShotHook{testHook("freeze", "fsfreeze")},
+			checkpointed: true,
+			ran:          []string{"app: fsfreeze", "app: thaw"},
+		},
+		{
+			name:          "checkpoint failed",
+			pre:           []stove8sv1beta1.SnapShotHook{testHook("freeze", "fsfreeze")},
+			checkpointErr: errors.New("checkpointing is disabled"),
+			checkpointed:  true,
+			ran:           []string{"app: fsfreeze", "app: thaw"},
+		},
+		{
+			name: "pre hook failed",
+			pre:  []stove8sv1beta1.SnapShotHook{testHook("freeze", "fail")},
+			ran:  []string{"app: fail", "app: thaw"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snapshot := &stove8sv1beta1.SnapShot{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "service"}}
+			snapshot.Spec.Input.Hooks = stove8sv1beta1.SnapShotInputHooks{
+				Pre:  tt.pre,
+				Post: []stove8sv1beta1.SnapShotHook{testHook("thaw", "thaw")},
+			}
+			var ran []string
+			r := testReconciler(t)
+			r.exec = testHookExec(&ran)
+
+			var checkpointed bool
+			err := r.hookedCheckpoint(context.Background(), snapshot, testPod("service-a", "node-a", true), []string{"app"}, func() error {
+				checkpointed = true
+				return tt.checkpointErr
+			})
+			if tt.checkpointErr != nil && !errors.Is(err, tt.checkpointErr) {
+				t.Errorf("expected the checkpoint error, got %v", err)
+			}
+			if tt.checkpointed && tt.checkpointErr == nil && err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+			if !tt.checkpointed && err == nil {
+				t.Error("expected the pre hook error")
+			}
+			if checkpointed != tt.checkpointed {
+				t.Errorf("expected checkpointed %v, got %v", tt.checkpointed, checkpointed)
+			}
+			if !slices.Equal(ran, tt.ran) {
+				t.Errorf("expected %v to run, got %v", tt.ran, ran)
+			}
+		})
+	}
+}
+
+func TestHookStatusSet(t *testing.T) {
+	snapshot := &stove8sv1beta1.SnapShot{}
+	hookStatusSet(snapshot, stove8sv1beta1.SnapShotStatusHook{Name: "flush", Phase: stove8sv1beta1.HookPre, Pod: "service-a", State: stove8sv1beta1.Failed})
+	hookStatusSet(snapshot, stove8sv1beta1.SnapShotStatusHook{Name: "flush", Phase: stove8sv1beta1.HookPost, Pod: "service-a", State: stove8sv1beta1.Success})
+	hookStatusSet(snapshot, stove8sv1beta1.SnapShotStatusHook{Name: "flush", Phase: stove8sv1beta1.HookPre, Pod: "service-b", State: stove8sv1beta1.Success})
+	hookStatusSet(snapshot, stove8sv1beta1.SnapShotStatusHook{Name: "flush", Phase: stove8sv1beta1.HookPre, Pod: "service-a", State: stove8sv1beta1.Success})
+
+	if len(snapshot.Status.Hooks) != 3 {
+		t.Fatalf("expected a result per phase and pod, got %+v", snapshot.Status.Hooks)
+	}
+	if snapshot.Status.Hooks[0].Pod != "service-a" || snapshot.Status.Hooks[0].State != stove8sv1beta1.Success {
+		t.Errorf("expected the rerun to replace the failure in place, got %+v", snapshot.Status.Hooks[0])
+	}
+}
+
+func TestHookHTTP(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		tls     bool
+		noIP    bool
+		message string
+		err     string
+	}{
+		{name: "ok", status: http.StatusOK, message: "flushed"},
+		{name: "no content", status: http.StatusNoContent},
+		{name: "https", status: http.StatusOK, tls: true, message: "flushed"},
+		{name: "server error", status: http.StatusInternalServerError, err: "unexpected status code 500: flushed"},
+		{name: "not modified", status: http.StatusNotModified, err: "unexpected status code 304"},
+		{name: "no IP", noIP: true, err: "has no IP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var method, path string
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				method, path = r.Method, r.URL.Path
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte("flushed"))
+			})
+			server := httptest.NewServer(handler)
+			scheme := ""
+			if tt.tls {
+				server.Close()
+				server = httptest.NewTLSServer(handler)
+				scheme = "HTTPS"
+			}
+			t.Cleanup(server.Close)
+			host, port := testHostPort(t, server.URL)
+			pod := testPod("service-a", "node-a", true)
+			if !tt.noIP {
+				pod.Status.PodIP = host
+			}
+
+			message, err := hookHTTP(context.Background(), pod, &stove8sv1beta1.SnapShotHookHTTP{Port: port, Path: "flush", Scheme: scheme})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if message != tt.message {
+				t.Errorf("expected message %q, got %q", tt.message, message)
+			}
+			if method != http.MethodPost || path != "/flush" {
+				t.Errorf("expected POST /flush, got %s %s", method, path)
+			}
+		})
+	}
+}
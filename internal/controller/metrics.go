@@ -0,0 +1,42 @@
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+)
+
+var (
+	snapshotPushedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stove8s_snapshot_pushed_total",
+			Help: "Number of snapshots pushed to the container registry",
+		},
+		[]string{"compression"},
+	)
+	snapshotPushedBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stove8s_snapshot_pushed_bytes_total",
+			Help: "Compressed size of the snapshot layers pushed to the container registry",
+		},
+		[]string{"compression"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		snapshotPushedTotal,
+		snapshotPushedBytesTotal,
+	)
+}
+
+func snapshotPushedMetricsRecord(snapshot *stove8sv1beta1.SnapShot) {
+	compression := snapshot.Spec.Output.Compression.Algorithm
+	if compression == "" {
+		compression = stove8sv1beta1.Gzip
+	}
+
+	snapshotPushedTotal.WithLabelValues(string(compression)).Inc()
+	snapshotPushedBytesTotal.WithLabelValues(string(compression)).Add(float64(snapshot.Status.CompressedSize))
+}
@@ -0,0 +1,356 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	"bud.studio/stove8s/internal/daemonset/resources/reclaim"
+)
+
+// kubeletStatsSummary is the part of the kubelet /stats/summary response
+// the memory of the containers is read from
+type kubeletStatsSummary struct {
+	Pods []struct {
+		PodRef struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"podRef"`
+		Containers []struct {
+			Name   string `json:"name"`
+			Memory *struct {
+				RSSBytes        *uint64 `json:"rssBytes"`
+				WorkingSetBytes *uint64 `json:"workingSetBytes"`
+			} `json:"memory"`
+		} `json:"containers"`
+	} `json:"pods"`
+}
+
+// +kubebuilder:rbac:groups="",resources="nodes/stats",verbs=get
+
+// slim asks containers of pod to shrink their memory, measuring it before,
+// and records the results in the status. The memory after is measured by
+// slimSettle once the kubelet sampled it again. Failures are only reported
+func (r *SnapShotReconciler) slim(
+	ctx context.Context,
+	snapshot *stove8sv1beta1.SnapShot,
+	pod *corev1.Pod,
+	containers []string,
+) {
+	log := logf.FromContext(ctx)
+
+	if snapshot.Spec.Input.Slim == nil {
+		return
+	}
+	// NOTE: the steps run as the controller, like the hooks they'd let the
+	// SnapShot creator exec into and call pods of namespaces they can't access
+	if pod.Namespace != snapshot.Namespace {
+		message := fmt.Sprintf("slim only runs in pods of namespace %s, not in %s", snapshot.Namespace, pod.Namespace)
+		for _, containerName := range containers {
+			slimStatusSet(snapshot, stove8sv1beta1.SnapShotStatusSlim{
+				Pod:       pod.Name,
+				Container: containerName,
+				Message:   message,
+			})
+		}
+		log.Info("Not slimming a pod of another namespace", "pod", pod.Name, "namespace", pod.Namespace)
+		return
+	}
+
+	for _, containerName := range containers {
+		var errs []error
+		result := stove8sv1beta1.SnapShotStatusSlim{
+			Pod:       pod.Name,
+			Container: containerName,
+		}
+		rss, workingSet, err := r.containerMemory(ctx, pod, containerName)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("measuring before: %w", err))
+		}
+		result.RSSBefore, result.WorkingSetBefore = rss, workingSet
+
+		errs = append(errs, r.slimSteps(ctx, snapshot, pod, containerName)...)
+		slimTime := metav1.Now()
+		result.SlimTime = &slimTime
+		if err := errors.Join(errs...); err != nil {
+			result.Message = err.Error()
+		}
+		slimStatusSet(snapshot, result)
+		log.Info("Slimmed container", "pod", pod.Name, "container", containerName,
+			"rssBefore", result.RSSBefore, "errors", result.Message)
+	}
+}
+
+// slimSettle measures the memory after of the containers slimmed Settle ago
+// and updates the status if any was
+func (r *SnapShotReconciler) slimSettle(ctx context.Context, snapshot *stove8sv1beta1.SnapShot) error {
+	log := logf.FromContext(ctx)
+
+	namespace := snapshot.Spec.Selector.Object.Namespace
+	if namespace == "" {
+		namespace = snapshot.Namespace
+	}
+	measured := false
+	for i := range snapshot.Status.Slim {
+		result := &snapshot.Status.Slim[i]
+		if !slimPending(*result) || slimSettleAfter(snapshot, *result) != 0 {
+			continue
+		}
+		pod := &corev1.Pod{}
+		err := r.Get(ctx, apitypes.NamespacedName{Namespace: namespace, Name: result.Pod}, pod)
+		if err == nil {
+			result.RSSAfter, result.WorkingSetAfter, err = r.containerMemory(ctx, pod, result.Container)
+		}
+		if err != nil {
+			message := fmt.Sprintf("measuring after: %v", err)
+			if result.Message != "" {
+				message = result.Message + "\n" + message
+			}
+			result.Message = message
+		}
+		measureTime := metav1.Now()
+		result.MeasureTime = &measureTime
+		measured = true
+		log.Info("Measured slimmed container", "pod", result.Pod, "container", result.Container,
+			"rssBefore", result.RSSBefore, "rssAfter", result.RSSAfter, "errors", result.Message)
+	}
+	if !measured {
+		return nil
+	}
+	return r.Status().Update(ctx, snapshot)
+}
+
+// slimPending reports whether result was slimmed but not measured after yet
+func slimPending(result stove8sv1beta1.SnapShotStatusSlim) bool {
+	return result.SlimTime != nil && result.MeasureTime == nil
+}
+
+// slimSettleAfter returns how long until the memory after of the pending
+// result can be measured, 0 when it's due
+func slimSettleAfter(snapshot *stove8sv1beta1.SnapShot, result stove8sv1beta1.SnapShotStatusSlim) time.Duration {
+	var settle time.Duration
+	if slim := snapshot.Spec.Input.Slim; slim != nil {
+		settle = slim.Settle.Duration
+	}
+	return max(time.Until(result.SlimTime.Add(settle)), 0)
+}
+
+// slimRequeueAfter returns when the next memory after is due, 0 when none is
+// pending
+func slimRequeueAfter(snapshot *stove8sv1beta1.SnapShot) time.Duration {
+	var requeueAfter time.Duration
+	for _, result := range snapshot.Status.Slim {
+		if !slimPending(result) {
+			continue
+		}
+		// NOTE: the reconcile is requeued at least a bit later
+		after := max(slimSettleAfter(snapshot, result), time.Second)
+		if requeueAfter == 0 || after < requeueAfter {
+			requeueAfter = after
+		}
+	}
+	return requeueAfter
+}
+
+// slimSteps runs the configured steps, each within the slim timeout, in the
+// order that frees the most: the garbage collector releases the heap the
+// runtime compaction returns to the OS, then the page cache is dropped
+func (r *SnapShotReconciler) slimSteps(
+	ctx context.Context,
+	snapshot *stove8sv1beta1.SnapShot,
+	pod *corev1.Pod,
+	containerName string,
+) []error {
+	slim := snapshot.Spec.Input.Slim
+	step := func(name string, fn func(ctx context.Context) error) error {
+		// NOTE: each step gets its own timeout, ctx is shared by them
+		stepCtx := ctx
+		if slim.Timeout.Duration != 0 {
+			var cancel context.CancelFunc
+			stepCtx, cancel = context.WithTimeout(ctx, slim.Timeout.Duration)
+			defer cancel()
+		}
+		err := fn(stepCtx)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return nil
+	}
+
+	var errs []error
+	if gc := slim.GC; gc != nil {
+		errs = append(errs, step("gc", func(ctx context.Context) error {
+			if gc.Signal != nil {
+				return r.hookSignal(ctx, snapshot, pod, containerName, gc.Signal.Name)
+			}
+			_, err := hookHTTP(ctx, pod, gc.HTTP)
+			return err
+		}))
+	}
+	switch slim.Runtime {
+	case stove8sv1beta1.JVM:
+		errs = append(errs, step("jcmd", func(ctx context.Context) error {
+			_, err := r.containerExec(ctx, pod, containerName, []string{"jcmd", "1", "GC.run"}, 0)
+			return err
+		}))
+	case stove8sv1beta1.Go:
+		errs = append(errs, step("pprof", func(ctx context.Context) error {
+			_, err := hookHTTP(ctx, pod, &stove8sv1beta1.SnapShotHookHTTP{
+				Port:   slim.PprofPort,
+				Path:   "/debug/pprof/heap?gc=1",
+				Method: http.MethodGet,
+			})
+			return err
+		}))
+	}
+	if slim.DropPageCache {
+		errs = append(errs, step("page cache", func(ctx context.Context) error {
+			return r.pageCacheDrop(ctx, pod, containerName)
+		}))
+	}
+
+	return slices.DeleteFunc(errs, func(err error) bool {
+		return err == nil
+	})
+}
+
+// slimStatusSet records result, replacing the previous one of the same container
+func slimStatusSet(snapshot *stove8sv1beta1.SnapShot, result stove8sv1beta1.SnapShotStatusSlim) {
+	idx := slices.IndexFunc(snapshot.Status.Slim, func(slim stove8sv1beta1.SnapShotStatusSlim) bool {
+		return slim.Pod == result.Pod && slim.Container == result.Container
+	})
+	if idx == -1 {
+		snapshot.Status.Slim = append(snapshot.Status.Slim, result)
+		return
+	}
+	snapshot.Status.Slim[idx] = result
+}
+
+// containerMemory returns the RSS and working set of containerName in bytes,
+// as the kubelet of the pod node reports them
+func (r *SnapShotReconciler) containerMemory(
+	ctx context.Context,
+	pod *corev1.Pod,
+	containerName string,
+) (int64, int64, error) {
+	log := logf.FromContext(ctx)
+
+	_, nodeAddr, kubeletPort, err := r.kubeletEndpointFromPod(ctx, pod)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to kubelet endpoint: %v", err)
+	}
+	url := fmt.Sprintf("https://%v:%v/stats/summary?only_cpu_and_memory=true", nodeAddr, kubeletPort)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("creating http request object: %v", err)
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", r.podToken))
+	resp, err := r.kubeletClient.Do(req)
+	if err != nil {
+		return 0, 0, fmt.Errorf("stats request failed: %v", err)
+	}
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			log.Error(err, "Closing response body")
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, 0, fmt.Errorf("unexpected status code for stats: %d: %s", resp.StatusCode, body)
+	}
+
+	var summary kubeletStatsSummary
+	err = json.NewDecoder(resp.Body).Decode(&summary)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, podStats := range summary.Pods {
+		if podStats.PodRef.Namespace != pod.Namespace || podStats.PodRef.Name != pod.Name {
+			continue
+		}
+		for _, container := range podStats.Containers {
+			if container.Name != containerName || container.Memory == nil {
+				continue
+			}
+			var rss, workingSet int64
+			if container.Memory.RSSBytes != nil {
+				rss = int64(*container.Memory.RSSBytes)
+			}
+			if container.Memory.WorkingSetBytes != nil {
+				workingSet = int64(*container.Memory.WorkingSetBytes)
+			}
+			return rss, workingSet, nil
+		}
+	}
+
+	return 0, 0, fmt.Errorf("no memory stats for container %s of pod %s", containerName, pod.Name)
+}
+
+// pageCacheDrop asks the daemonset of the pod node to reclaim the page cache
+// of containerName, /proc/sys/vm/drop_caches is read-only in containers and
+// global to the node anyway
+func (r *SnapShotReconciler) pageCacheDrop(ctx context.Context, pod *corev1.Pod, containerName string) error {
+	log := logf.FromContext(ctx)
+
+	containerID, err := podContainerID(pod, containerName)
+	if err != nil {
+		return err
+	}
+	node, err := r.daemonsetNode(ctx, pod.Spec.NodeName)
+	if err != nil {
+		return err
+	}
+	jsonData, err := json.Marshal(reclaim.CreateReq{
+		ContainerID: containerID,
+	})
+	if err != nil {
+		return err
+	}
+
+	reclaimEndpoint := fmt.Sprintf("http://%s:%v/reclaim", node.DeamonsetAddr, node.DeamonsetPort)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reclaimEndpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	err = r.daemonsetAuth(req)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			log.Error(err, "Closing response body")
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code for pageCacheDrop: %d: %s", resp.StatusCode, body)
+	}
+
+	var reclaimResp reclaim.CreateResp
+	err = json.NewDecoder(resp.Body).Decode(&reclaimResp)
+	if err != nil {
+		return err
+	}
+	log.Info("Dropped page cache", "pod", pod.Name, "container", containerName, "bytes", reclaimResp.PageCache)
+
+	return nil
+}
@@ -0,0 +1,267 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+)
+
+const testStatsSummary = `{
+	"pods": [
+		{
+			"podRef": {"name": "service-a", "namespace": "other"},
+			"containers": [{"name": "app", "memory": {"rssBytes": 1, "workingSetBytes": 1}}]
+		},
+		{
+			"podRef": {"name": "service-a", "namespace": "default"},
+			"containers": [
+				{"name": "app", "memory": {"rssBytes": 4096, "workingSetBytes": 8192}},
+				{"name": "sidecar", "memory": {"workingSetBytes": 2048}},
+				{"name": "starting"}
+			]
+		}
+	]
+}`
+
+// testKubeletStats serves testStatsSummary as the kubelet of a node
+func testKubeletStats(t *testing.T) *httptest.Server {
+	t.Helper()
+	kubelet := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testPodToken {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/stats/summary" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(testStatsSummary))
+	}))
+	t.Cleanup(kubelet.Close)
+	return kubelet
+}
+
+func TestContainerMemory(t *testing.T) {
+	kubelet := testKubeletStats(t)
+	r := testReconciler(t, testNode(t, "node-a", "amd64", kubelet.URL))
+	r.kubeletClient = *kubelet.Client()
+
+	for _, tc := range []struct {
+		name       string
+		pod        *corev1.Pod
+		container  string
+		rss        int64
+		workingSet int64
+		fails      bool
+	}{
+		{
+			name:       "container",
+			pod:        testPod("service-a", "node-a", true),
+			container:  "app",
+			rss:        4096,
+			workingSet: 8192,
+		},
+		{
+			name:       "no rss",
+			pod:        testPod("service-a", "node-a", true),
+			container:  "sidecar",
+			workingSet: 2048,
+		},
+		{
+			name:      "no memory",
+			pod:       testPod("service-a", "node-a", true),
+			container: "starting",
+			fails:     true,
+		},
+		{
+			name:      "missing container",
+			pod:       testPod("service-a", "node-a", true),
+			container: "missing",
+			fails:     true,
+		},
+		{
+			name:      "missing pod",
+			pod:       testPod("service-b", "node-a", true),
+			container: "app",
+			fails:     true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rss, workingSet, err := r.containerMemory(context.Background(), tc.pod, tc.container)
+			if tc.fails {
+				if err == nil {
+					t.Fatal("expected no memory stats")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rss != tc.rss || workingSet != tc.workingSet {
+				t.Errorf("expected rss %d and working set %d, got %d and %d", tc.rss, tc.workingSet, rss, workingSet)
+			}
+		})
+	}
+}
+
+func TestSlimSteps(t *testing.T) {
+	var paths []string
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.RequestURI())
+		if r.URL.Path == "/gc" {
+			http.Error(w, "gc disabled", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(app.Close)
+	host, port := testHostPort(t, app.URL)
+	pod := testPod("service-a", "node-a", true)
+	pod.Status.PodIP = host
+
+	snapshot := &stove8sv1beta1.SnapShot{}
+	r := testReconciler(t)
+	snapshot.Spec.Input.Slim = &stove8sv1beta1.SnapShotInputSlim{}
+	errs := r.slimSteps(context.Background(), snapshot, pod, "app")
+	if len(errs) != 0 {
+		t.Errorf("expected no step to run, got %v", errs)
+	}
+
+	snapshot.Spec.Input.Slim = &stove8sv1beta1.SnapShotInputSlim{
+		GC: &stove8sv1beta1.SnapShotSlimGC{
+			HTTP: &stove8sv1beta1.SnapShotHookHTTP{Port: port, Path: "/gc"},
+		},
+		Runtime:       stove8sv1beta1.Go,
+		PprofPort:     port,
+		DropPageCache: true,
+		Timeout:       metav1.Duration{Duration: time.Second},
+	}
+	errs = r.slimSteps(context.Background(), snapshot, pod, "app")
+	if len(errs) != 2 {
+		t.Fatalf("expected the gc and page cache steps to fail, got %v", errs)
+	}
+	if !strings.HasPrefix(errs[0].Error(), "gc: ") || !strings.Contains(errs[0].Error(), "gc disabled") {
+		t.Errorf("unexpected gc error %v", errs[0])
+	}
+	// NOTE: the container isn't running in the pod status
+	if !strings.HasPrefix(errs[1].Error(), "page cache: ") {
+		t.Errorf("unexpected page cache error %v", errs[1])
+	}
+	if len(paths) != 2 || paths[0] != "/gc" || paths[1] != "/debug/pprof/heap?gc=1" {
+		t.Errorf("expected the gc and pprof steps to call the pod in order, got %v", paths)
+	}
+}
+
+func TestSlimStatusSet(t *testing.T) {
+	snapshot := &stove8sv1beta1.SnapShot{}
+	slimStatusSet(snapshot, stove8sv1beta1.SnapShotStatusSlim{Pod: "service-a", Container: "app", RSSBefore: 1})
+	slimStatusSet(snapshot, stove8sv1beta1.SnapShotStatusSlim{Pod: "service-a", Container: "sidecar", RSSBefore: 2})
+	slimStatusSet(snapshot, stove8sv1beta1.SnapShotStatusSlim{Pod: "service-b", Container: "app", RSSBefore: 3})
+	slimStatusSet(snapshot, stove8sv1beta1.SnapShotStatusSlim{Pod: "service-a", Container: "app", RSSBefore: 4})
+
+	if len(snapshot.Status.Slim) != 3 {
+		t.Fatalf("expected 3 results, got %+v", snapshot.Status.Slim)
+	}
+	for i, rss := range []int64{4, 2, 3} {
+		if snapshot.Status.Slim[i].RSSBefore != rss {
+			t.Errorf("expected result %d to have rss %d, got %+v", i, rss, snapshot.Status.Slim[i])
+		}
+	}
+}
+
+func TestSlimSettle(t *testing.T) {
+	kubelet := testKubeletStats(t)
+	longAgo := metav1.NewTime(time.Now().Add(-time.Minute))
+	now := metav1.Now()
+	snapshot := &stove8sv1beta1.SnapShot{
+		ObjectMeta: metav1.ObjectMeta{Name: "service", Namespace: "default"},
+	}
+	snapshot.Spec.Input.Slim = &stove8sv1beta1.SnapShotInputSlim{
+		Settle: metav1.Duration{Duration: 15 * time.Second},
+	}
+	snapshot.Status.Slim = []stove8sv1beta1.SnapShotStatusSlim{
+		{Pod: "service-a", Container: "app", SlimTime: &longAgo, RSSBefore: 8192},
+		{Pod: "service-a", Container: "missing", SlimTime: &longAgo, Message: "gc: failed"},
+		{Pod: "service-a", Container: "sidecar", SlimTime: &now},
+		{Pod: "service-a", Container: "measured", SlimTime: &longAgo, MeasureTime: &longAgo},
+	}
+	r := testReconciler(t, testNode(t, "node-a", "amd64", kubelet.URL), testPod("service-a", "node-a", true), snapshot)
+	r.kubeletClient = *kubelet.Client()
+
+	requeueAfter := slimRequeueAfter(snapshot)
+	if requeueAfter != time.Second {
+		t.Errorf("expected the due results to be requeued right away, got %v", requeueAfter)
+	}
+
+	err := r.slimSettle(context.Background(), snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := &stove8sv1beta1.SnapShot{}
+	err = r.Get(context.Background(), client.ObjectKeyFromObject(snapshot), stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := stored.Status.Slim
+	if results[0].MeasureTime == nil || results[0].RSSAfter != 4096 || results[0].WorkingSetAfter != 8192 {
+		t.Errorf("expected the app to be measured, got %+v", results[0])
+	}
+	if results[1].MeasureTime == nil || results[1].Message != "gc: failed\nmeasuring after: no memory stats for container missing of pod service-a" {
+		t.Errorf("expected the missing container to be reported, got %+v", results[1])
+	}
+	if results[2].MeasureTime != nil {
+		t.Errorf("expected the sidecar to still settle, got %+v", results[2])
+	}
+
+	requeueAfter = slimRequeueAfter(stored)
+	if requeueAfter <= 10*time.Second || requeueAfter > 15*time.Second {
+		t.Errorf("expected a requeue once the sidecar settled, got %v", requeueAfter)
+	}
+}
+
+func TestSlimOtherNamespace(t *testing.T) {
+	var calls int
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(app.Close)
+	host, port := testHostPort(t, app.URL)
+	pod := testPod("etcd", "node-a", true)
+	pod.Namespace = "kube-system"
+	pod.Status.PodIP = host
+
+	snapshot := &stove8sv1beta1.SnapShot{ObjectMeta: metav1.ObjectMeta{Name: "service", Namespace: "default"}}
+	snapshot.Spec.Input.Slim = &stove8sv1beta1.SnapShotInputSlim{
+		GC: &stove8sv1beta1.SnapShotSlimGC{
+			HTTP: &stove8sv1beta1.SnapShotHookHTTP{Port: port, Path: "/gc"},
+		},
+		Runtime:   stove8sv1beta1.Go,
+		PprofPort: port,
+	}
+
+	r := testReconciler(t)
+	r.slim(context.Background(), snapshot, pod, []string{"app"})
+	if calls != 0 {
+		t.Errorf("expected no step to call the pod, got %d calls", calls)
+	}
+	if len(snapshot.Status.Slim) != 1 {
+		t.Fatalf("expected the refusal to be reported, got %+v", snapshot.Status.Slim)
+	}
+	result := snapshot.Status.Slim[0]
+	if result.SlimTime != nil || !strings.Contains(result.Message, "only runs in pods of namespace default") {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if slimRequeueAfter(snapshot) != 0 {
+		t.Error("expected nothing to be measured after")
+	}
+}
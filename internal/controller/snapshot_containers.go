@@ -0,0 +1,414 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	oci_utils "bud.studio/stove8s/internal/oci"
+)
+
+// reconcileContainers checkpoints several containers of a pod together, every
+// container is pushed to its own image and they're all swapped in at once
+// nolint: gocyclo
+func (r *SnapShotReconciler) reconcileContainers(
+	ctx context.Context,
+	snapshot *stove8sv1beta1.SnapShot,
+	pod *corev1.Pod,
+) (ctrl.Result, error) {
+	log := logf.FromContext(ctx)
+
+	if !outputIsPushed(snapshot.Spec.Output) {
+		log.Info("Offline export, object store upload and local import aren't supported for several containers, only for one")
+		return ctrl.Result{}, nil
+	}
+
+	if snapshot.Status.OutPutReferenceIsValid {
+		err := r.podImagesSwap(ctx, snapshot, pod)
+		if err != nil {
+			log.Error(err, "unable to swap the container images")
+		}
+		return ctrl.Result{}, err
+	}
+
+	containerRegistrySecret, secretNamespace, err := r.imagePushSecret(ctx, snapshot)
+	if err != nil {
+		log.Error(err, "Failed to get image push secret")
+		return ctrl.Result{}, err
+	}
+
+	if len(snapshot.Status.Containers) == 0 {
+		containers, err := containersPick(snapshot, pod)
+		if errors.Is(err, errContainerNotFound) && !containerStatusesReported(pod) {
+			log.Info("Container statuses not reported yet, waiting", "Pod", pod.Name, "reason", err.Error())
+			return ctrl.Result{RequeueAfter: workloadRequeueAfter}, nil
+		}
+		if err != nil {
+			log.Info("Containers can't be checkpointed", "reason", err.Error())
+			return ctrl.Result{}, r.unswappableReport(ctx, snapshot, err)
+		}
+
+		valid := true
+		for _, container := range containers {
+			valid, err = oci_utils.ReferenceIsValid(container.ImageReference, containerRegistrySecret)
+			if err != nil {
+				log.Error(err, "unable to check output image existence")
+				return ctrl.Result{}, err
+			}
+			if !valid {
+				break
+			}
+		}
+		if valid {
+			snapshot.Status.Containers = containers
+			snapshot.Status.OutPutReferenceIsValid = true
+			if err := r.Status().Update(ctx, snapshot); err != nil {
+				log.Error(err, "unable to update Snapshot status")
+				return ctrl.Result{}, err
+			}
+			err := r.podImagesSwap(ctx, snapshot, pod)
+			if err != nil {
+				log.Error(err, "unable to swap the container images")
+			}
+			return ctrl.Result{}, err
+		}
+
+		if !slices.ContainsFunc(pod.Status.Conditions, func(condition corev1.PodCondition) bool {
+			return condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue
+		}) {
+			log.Info("Pod not in ready status, waiting for events", "Pod", pod.Name)
+			return ctrl.Result{}, nil
+		}
+
+		snapshot.Status.Containers = containers
+		snapshot.Status.Stage = stove8sv1beta1.CriuDumping
+		snapshot.Status.State = stove8sv1beta1.Started
+		if err := r.Status().Update(ctx, snapshot); err != nil {
+			log.Error(err, "unable to update Snapshot status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if snapshot.Status.Node == (stove8sv1beta1.SnapShotStatusNode{}) {
+		node, err := r.daemonsetNode(ctx, pod.Spec.NodeName)
+		if err != nil {
+			log.Error(err, "unable to get deamonset endpoint for pod")
+			return ctrl.Result{}, err
+		}
+		_, _, node.KubeletPort, err = r.kubeletEndpointFromPod(ctx, pod)
+		if err != nil {
+			log.Error(err, "unable to get kubelet endpoint for pod")
+			return ctrl.Result{}, err
+		}
+		snapshot.Status.Node = node
+		if err := r.Status().Update(ctx, snapshot); err != nil {
+			log.Error(err, "unable to update Snapshot status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if slices.ContainsFunc(snapshot.Status.Containers, func(container stove8sv1beta1.SnapShotStatusContainer) bool {
+		return container.CheckPointNodePath == "" && container.State != stove8sv1beta1.Failed
+	}) {
+		var names []string
+		for _, container := range snapshot.Status.Containers {
+			if container.CheckPointNodePath == "" && container.State != stove8sv1beta1.Failed {
+				names = append(names, container.Name)
+			}
+		}
+		err := r.hookedCheckpoint(ctx, snapshot, pod, names, func() error {
+			return r.containersCheckpoint(ctx, snapshot, pod)
+		})
+		if err != nil {
+			snapshot.Status.State = stove8sv1beta1.Failed
+		}
+		if err := r.Status().Update(ctx, snapshot); err != nil {
+			log.Error(err, "unable to update Snapshot status")
+			return ctrl.Result{}, err
+		}
+		if err != nil {
+			log.Error(err, "unable to checkpoint the containers")
+			return ctrl.Result{}, err
+		}
+	}
+
+	parentImageReference, err := r.parentImageReference(ctx, snapshot)
+	if err != nil {
+		log.Error(err, "unable to resolve parent image")
+		return ctrl.Result{}, err
+	}
+	nodeInfo, err := r.nodeInfo(ctx, snapshot.Status.Node.Name)
+	if err != nil {
+		log.Error(err, "unable to get node info")
+		return ctrl.Result{}, err
+	}
+
+	var errs []error
+	for i := range snapshot.Status.Containers {
+		container := &snapshot.Status.Containers[i]
+		if container.State == stove8sv1beta1.Failed {
+			continue
+		}
+		err := r.containerReconcile(ctx, snapshot, container, nodeInfo, secretNamespace, parentImageReference)
+		if err != nil {
+			// NOTE: containers that didn't fail are polled again on the next
+			// reconcile
+			log.Error(err, "unable to snapshot container", "container", container.Name)
+			errs = append(errs, err)
+		}
+		if err := r.Status().Update(ctx, snapshot); err != nil {
+			log.Error(err, "unable to update Snapshot status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	for _, container := range snapshot.Status.Containers {
+		if container.State == stove8sv1beta1.Failed {
+			snapshot.Status.State = stove8sv1beta1.Failed
+			if err := r.Status().Update(ctx, snapshot); err != nil {
+				log.Error(err, "unable to update Snapshot status")
+			}
+			return ctrl.Result{}, fmt.Errorf("snapshot of container %s failed", container.Name)
+		}
+	}
+	if len(errs) > 0 {
+		return ctrl.Result{}, errors.Join(errs...)
+	}
+	for _, container := range snapshot.Status.Containers {
+		if container.Stage != stove8sv1beta1.Pushing || container.State != stove8sv1beta1.Success {
+			return ctrl.Result{RequeueAfter: workloadRequeueAfter}, nil
+		}
+	}
+
+	for _, container := range snapshot.Status.Containers {
+		valid, err := oci_utils.ReferenceIsValid(container.ImageReference, containerRegistrySecret)
+		if err != nil {
+			log.Error(err, "unable to check output image existence")
+			return ctrl.Result{}, err
+		}
+		if !valid {
+			err := errors.New("invalid reference")
+			log.Error(err, "image push is not reflected in container registry", "image", container.ImageReference)
+			return ctrl.Result{}, err
+		}
+	}
+
+	snapshot.Status.Stage = stove8sv1beta1.Pushing
+	snapshot.Status.State = stove8sv1beta1.Success
+	snapshot.Status.OutPutReferenceIsValid = true
+	if err := r.Status().Update(ctx, snapshot); err != nil {
+		log.Error(err, "unable to update Snapshot status")
+		return ctrl.Result{}, err
+	}
+	snapshotPushedMetricsRecord(snapshot)
+
+	err = r.podImagesSwap(ctx, snapshot, pod)
+	if err != nil {
+		log.Error(err, "unable to swap the container images")
+	}
+	return ctrl.Result{}, err
+}
+
+// containersPick resolves the selected containers and their output images
+func containersPick(snapshot *stove8sv1beta1.SnapShot, pod *corev1.Pod) ([]stove8sv1beta1.SnapShotStatusContainer, error) {
+	names := snapshot.Spec.Selector.Containers
+	if snapshot.Spec.Selector.AllContainers {
+		names = nil
+		for _, container := range pod.Spec.InitContainers {
+			if container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways {
+				names = append(names, container.Name)
+			}
+		}
+		for _, container := range pod.Spec.Containers {
+			names = append(names, container.Name)
+		}
+	}
+
+	var containers []stove8sv1beta1.SnapShotStatusContainer
+	for _, containerName := range names {
+		if _, err := podContainer(pod, containerName); err != nil {
+			return nil, err
+		}
+		imageReference, err := tagSuffixed(snapshot.Spec.Output.ContainerRegistry.ImageReference, containerName)
+		if err != nil {
+			return nil, err
+		}
+		containers = append(containers, stove8sv1beta1.SnapShotStatusContainer{
+			Name:           containerName,
+			ImageReference: imageReference,
+		})
+	}
+
+	return containers, nil
+}
+
+// tagSuffixed appends suffix, a container or member pod name, to the tag of
+// imageReference, so several checkpoints share one output image reference
+func tagSuffixed(imageReference string, suffix string) (string, error) {
+	ref, err := name.ParseReference(imageReference)
+	if err != nil {
+		return "", err
+	}
+	tag, ok := ref.(name.Tag)
+	if !ok {
+		return "", fmt.Errorf("%s must be a tag to checkpoint several containers or pods", imageReference)
+	}
+	return tag.Context().Tag(tag.TagStr() + "-" + suffix).String(), nil
+}
+
+// containersCheckpoint checkpoints the containers that weren't yet
+func (r *SnapShotReconciler) containersCheckpoint(
+	ctx context.Context,
+	snapshot *stove8sv1beta1.SnapShot,
+	pod *corev1.Pod,
+) error {
+	// NOTE: the kubelet checkpoints a single container per request and CRIU
+	// freezes each container on its own. They're sent together between the
+	// pre and post hooks, which quiesce every container, see hookedCheckpoint
+	var wg sync.WaitGroup
+	errs := make([]error, len(snapshot.Status.Containers))
+	for i := range snapshot.Status.Containers {
+		container := &snapshot.Status.Containers[i]
+		if container.CheckPointNodePath != "" || container.State == stove8sv1beta1.Failed {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkPointNodePath, err := r.checkpoint(ctx, pod, container.Name, snapshot.Spec.Input.Timeout)
+			if err != nil {
+				errs[i] = fmt.Errorf("checkpointing %s: %w", container.Name, err)
+				container.State = stove8sv1beta1.Failed
+				return
+			}
+			container.CheckPointNodePath = checkPointNodePath
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// containerReconcile moves the image of a single container forward, the same
+// way Reconcile does for a single container snapshot. The container only
+// fails when the daemonset lost its job, the other daemonset and API errors
+// are retried on the next reconcile
+func (r *SnapShotReconciler) containerReconcile(
+	ctx context.Context,
+	snapshot *stove8sv1beta1.SnapShot,
+	container *stove8sv1beta1.SnapShotStatusContainer,
+	nodeInfo corev1.NodeSystemInfo,
+	secretNamespace string,
+	parentImageReference string,
+) error {
+	if container.JobID == "" {
+		output := snapshot.Spec.Output
+		output.ContainerRegistry.ImageReference = container.ImageReference
+		if parentImageReference != "" {
+			// NOTE: the parent is a pod-level snapshot of the same containers
+			var err error
+			parentImageReference, err = tagSuffixed(parentImageReference, container.Name)
+			if err != nil {
+				return err
+			}
+		}
+		jobID, err := r.daemonsetInit(
+			ctx,
+			output,
+			nodeInfo,
+			parentImageReference,
+			container.CheckPointNodePath,
+			snapshot.Status.Node,
+			secretNamespace,
+			snapshot.Namespace,
+			snapshot.Name,
+			false,
+		)
+		if err != nil {
+			return fmt.Errorf("unable init daemonset job: %w", err)
+		}
+		container.JobID = jobID
+		container.Stage = stove8sv1beta1.Fromating
+		container.State = stove8sv1beta1.Started
+	}
+
+	if container.Stage != stove8sv1beta1.Pushing || container.State != stove8sv1beta1.Success {
+		ociStatus, err := r.daemonsetStausFetch(ctx, container.JobID, snapshot.Status.Node)
+		if errors.Is(err, errDaemonsetJobNotFound) {
+			container.State = stove8sv1beta1.Failed
+		}
+		if err != nil {
+			return fmt.Errorf("unable fetch daemonset job status: %w", err)
+		}
+		container.Stage = ociStatus.Stage
+		container.State = ociStatus.State
+		container.Digest = ociStatus.Digest
+		if container.Stage == stove8sv1beta1.Pushing && container.State == stove8sv1beta1.Success {
+			snapshot.Status.CompressedSize += ociStatus.CompressedSize
+			snapshot.Status.DeduplicatedSize += ociStatus.DeduplicatedSize
+			snapshot.Status.SkippedSize += ociStatus.SkippedSize
+			snapshot.Status.PushRetries += ociStatus.Retries
+		}
+	}
+
+	return nil
+}
+
+// podImagesSwap swaps the images of every checkpointed container in a single
+// pod update, so they restart from their checkpoints together. They aren't
+// prefetched, prefetch only pulls the output image reference
+func (r *SnapShotReconciler) podImagesSwap(
+	ctx context.Context,
+	snapshot *stove8sv1beta1.SnapShot,
+	pod *corev1.Pod,
+) error {
+	if snapshot.Spec.Output.Format == stove8sv1beta1.Artifact {
+		// NOTE: archival artifacts can't be pulled by the container runtime
+		logf.FromContext(ctx).Info("Output is an archival artifact, keeping the container images")
+		return nil
+	}
+
+	swapped := false
+	for _, container := range snapshot.Status.Containers {
+		target, err := podContainer(pod, container.Name)
+		if err != nil {
+			return err
+		}
+		if imageSwapped(target.Image, container.ImageReference) {
+			continue
+		}
+		image := container.ImageReference
+		if snapshot.Spec.Output.Signing != nil {
+			digest, err := r.signatureVerify(ctx, snapshot, container.ImageReference)
+			if err != nil {
+				return fmt.Errorf("refusing unverified image %s: %w", container.ImageReference, err)
+			}
+			image, err = digestReference(container.ImageReference, digest)
+			if err != nil {
+				return err
+			}
+		}
+		target.Image = image
+		swapped = true
+	}
+	if !swapped {
+		return nil
+	}
+
+	if encryption := snapshot.Spec.Output.Encryption; encryption != nil && encryption.DecryptionKeySecret != nil {
+		err := r.decryptionKeyInstall(ctx, snapshot, pod.Spec.NodeName)
+		if err != nil {
+			return fmt.Errorf("installing decryption keys: %w", err)
+		}
+	}
+
+	return r.Update(ctx, pod)
+}
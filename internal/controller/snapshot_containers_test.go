@@ -0,0 +1,301 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	"bud.studio/stove8s/internal/daemonset/resources/oci"
+)
+
+// testContainersPod is testPod with an envoy native sidecar
+func testContainersPod() *corev1.Pod {
+	pod := testPod("service-a", "node-a", true)
+	pod.Spec.InitContainers = []corev1.Container{{
+		Name:          "envoy",
+		Image:         "docker.io/envoyproxy/envoy:v1.34.0",
+		RestartPolicy: ptr.To(corev1.ContainerRestartPolicyAlways),
+	}}
+	return pod
+}
+
+// testContainersSnapShot checkpoints the app and envoy containers of
+// testContainersPod to registryHost
+func testContainersSnapShot(registryHost string, node stove8sv1beta1.SnapShotStatusNode) *stove8sv1beta1.SnapShot {
+	snapshot := &stove8sv1beta1.SnapShot{
+		ObjectMeta: metav1.ObjectMeta{Name: "service", Namespace: "default"},
+	}
+	snapshot.Spec.Selector.Object = stove8sv1beta1.ObjectReference{Kind: "Pod", Name: "service-a"}
+	snapshot.Spec.Selector.Containers = []string{"app", "envoy"}
+	snapshot.Spec.Output.ContainerRegistry.ImageReference = registryHost + "/checkpoint/service:latest"
+	snapshot.Spec.Output.ContainerRegistry.ImagePushSecret.Name = "push"
+	snapshot.Status.Node = node
+	for _, containerName := range []string{"app", "envoy"} {
+		snapshot.Status.Containers = append(snapshot.Status.Containers, stove8sv1beta1.SnapShotStatusContainer{
+			Name:               containerName,
+			ImageReference:     registryHost + "/checkpoint/service:latest-" + containerName,
+			CheckPointNodePath: "/var/lib/kubelet/checkpoints/" + containerName + ".tar",
+			JobID:              "job",
+			Stage:              stove8sv1beta1.CriuDumping,
+			State:              stove8sv1beta1.Started,
+		})
+	}
+	return snapshot
+}
+
+func testPushSecret() *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "push", Namespace: "default"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+	}
+}
+
+// testRegistry is an in-memory registry holding a random image at each of tags
+func testRegistry(t *testing.T, tags ...string) string {
+	t.Helper()
+	server := httptest.NewServer(registry.New())
+	t.Cleanup(server.Close)
+	parsed, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range tags {
+		ref, err := name.ParseReference(parsed.Host + "/checkpoint/service:" + tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = remote.Write(ref, img)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return parsed.Host
+}
+
+func TestReconcileContainers(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		ociStatus oci.Status
+		tags      []string
+		err       bool
+		requeue   bool
+		state     stove8sv1beta1.SnapShotStatusState
+		failed    bool
+		swapped   bool
+	}{
+		{
+			name:   "daemonset unavailable",
+			status: http.StatusServiceUnavailable,
+			err:    true,
+		},
+		{
+			name:   "job lost",
+			status: http.StatusNotFound,
+			err:    true,
+			state:  stove8sv1beta1.Failed,
+			failed: true,
+		},
+		{
+			name:      "job failed",
+			status:    http.StatusOK,
+			ociStatus: oci.Status{Stage: stove8sv1beta1.Pushing, State: stove8sv1beta1.Failed},
+			err:       true,
+			state:     stove8sv1beta1.Failed,
+			failed:    true,
+		},
+		{
+			name:      "pushing",
+			status:    http.StatusOK,
+			ociStatus: oci.Status{Stage: stove8sv1beta1.Pushing, State: stove8sv1beta1.Started},
+			requeue:   true,
+		},
+		{
+			name:      "push not in the registry",
+			status:    http.StatusOK,
+			ociStatus: oci.Status{Stage: stove8sv1beta1.Pushing, State: stove8sv1beta1.Success, Digest: testDigest},
+			tags:      []string{"latest-app"},
+			err:       true,
+		},
+		{
+			name:      "pushed",
+			status:    http.StatusOK,
+			ociStatus: oci.Status{Stage: stove8sv1beta1.Pushing, State: stove8sv1beta1.Success, Digest: testDigest},
+			tags:      []string{"latest-app", "latest-envoy"},
+			state:     stove8sv1beta1.Success,
+			swapped:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registryHost := testRegistry(t, test.tags...)
+			node := testDaemonset(t, test.status, test.ociStatus)
+			pod := testContainersPod()
+			snapshot := testContainersSnapShot(registryHost, node)
+			r := testReconciler(t, testNode(t, "node-a", "amd64", ""), testPushSecret(), pod, snapshot)
+
+			result, err := r.reconcileContainers(context.Background(), snapshot, pod)
+			if (err != nil) != test.err {
+				t.Fatalf("expected an error: %v, got %v", test.err, err)
+			}
+			if (result.RequeueAfter != 0) != test.requeue {
+				t.Errorf("expected a requeue: %v, got %v", test.requeue, result.RequeueAfter)
+			}
+
+			stored := &stove8sv1beta1.SnapShot{}
+			err = r.Get(context.Background(), client.ObjectKeyFromObject(snapshot), stored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status.State != test.state {
+				t.Errorf("expected state %q, got %q", test.state, stored.Status.State)
+			}
+			for _, container := range stored.Status.Containers {
+				if (container.State == stove8sv1beta1.Failed) != test.failed {
+					t.Errorf("expected %s to fail: %v, got state %s", container.Name, test.failed, container.State)
+				}
+			}
+
+			storedPod := &corev1.Pod{}
+			err = r.Get(context.Background(), client.ObjectKeyFromObject(pod), storedPod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			swapped := storedPod.Spec.Containers[0].Image == registryHost+"/checkpoint/service:latest-app" &&
+				storedPod.Spec.InitContainers[0].Image == registryHost+"/checkpoint/service:latest-envoy"
+			if swapped != test.swapped {
+				t.Errorf("expected the images to be swapped: %v, got %s and %s", test.swapped,
+					storedPod.Spec.Containers[0].Image, storedPod.Spec.InitContainers[0].Image)
+			}
+			if test.swapped && !stored.Status.OutPutReferenceIsValid {
+				t.Error("expected the output references to be valid")
+			}
+		})
+	}
+}
+
+func TestPodImagesSwap(t *testing.T) {
+	const registryHost = "registry.example.com"
+
+	t.Run("together", func(t *testing.T) {
+		pod := testContainersPod()
+		snapshot := testContainersSnapShot(registryHost, stove8sv1beta1.SnapShotStatusNode{})
+		r := testReconciler(t, pod)
+
+		err := r.podImagesSwap(context.Background(), snapshot, pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored := &corev1.Pod{}
+		err = r.Get(context.Background(), client.ObjectKeyFromObject(pod), stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Spec.Containers[0].Image != registryHost+"/checkpoint/service:latest-app" {
+			t.Errorf("unexpected app image %s", stored.Spec.Containers[0].Image)
+		}
+		if stored.Spec.InitContainers[0].Image != registryHost+"/checkpoint/service:latest-envoy" {
+			t.Errorf("unexpected envoy image %s", stored.Spec.InitContainers[0].Image)
+		}
+
+		// NOTE: the pod is left alone once every image is swapped
+		resourceVersion := stored.ResourceVersion
+		err = r.podImagesSwap(context.Background(), snapshot, stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = r.Get(context.Background(), client.ObjectKeyFromObject(pod), stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.ResourceVersion != resourceVersion {
+			t.Error("expected no update of a swapped pod")
+		}
+	})
+
+	t.Run("artifact", func(t *testing.T) {
+		pod := testContainersPod()
+		snapshot := testContainersSnapShot(registryHost, stove8sv1beta1.SnapShotStatusNode{})
+		snapshot.Spec.Output.Format = stove8sv1beta1.Artifact
+		r := testReconciler(t, pod)
+
+		err := r.podImagesSwap(context.Background(), snapshot, pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pod.Spec.Containers[0].Image != "docker.io/library/service:latest" {
+			t.Errorf("expected an artifact not to be swapped in, got %s", pod.Spec.Containers[0].Image)
+		}
+	})
+
+	t.Run("missing container", func(t *testing.T) {
+		pod := testContainersPod()
+		pod.Spec.InitContainers = nil
+		snapshot := testContainersSnapShot(registryHost, stove8sv1beta1.SnapShotStatusNode{})
+		r := testReconciler(t, pod)
+
+		err := r.podImagesSwap(context.Background(), snapshot, pod)
+		if err == nil {
+			t.Fatal("expected a missing container to fail")
+		}
+		stored := &corev1.Pod{}
+		err = r.Get(context.Background(), client.ObjectKeyFromObject(pod), stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Spec.Containers[0].Image != "docker.io/library/service:latest" {
+			t.Errorf("expected no container to be swapped alone, got %s", stored.Spec.Containers[0].Image)
+		}
+	})
+}
+
+func TestReconcileContainersMissing(t *testing.T) {
+	for _, reported := range []bool{false, true} {
+		pod := testContainersPod()
+		if reported {
+			pod.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: "app"}}
+			pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{Name: "envoy"}}
+		}
+		snapshot := testContainersSnapShot("registry.example.com", stove8sv1beta1.SnapShotStatusNode{})
+		snapshot.Spec.Selector.Containers = []string{"app", "other"}
+		snapshot.Status = stove8sv1beta1.SnapShotStatus{}
+		r := testReconciler(t, testPushSecret(), pod, snapshot)
+
+		result, err := r.reconcileContainers(context.Background(), snapshot, pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored := &stove8sv1beta1.SnapShot{}
+		err = r.Get(context.Background(), client.ObjectKeyFromObject(snapshot), stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reported {
+			if stored.Status.State != stove8sv1beta1.Failed {
+				t.Errorf("expected a missing container to fail the snapshot, got %q", stored.Status.State)
+			}
+			continue
+		}
+		if result.RequeueAfter == 0 || stored.Status.State == stove8sv1beta1.Failed {
+			t.Errorf("expected to wait for the container statuses, got %v and state %q", result, stored.Status.State)
+		}
+	}
+}
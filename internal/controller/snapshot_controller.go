@@ -27,22 +27,30 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path"
 	"slices"
 	"strconv"
+	"time"
 
+	"github.com/google/go-containerregistry/pkg/name"
 	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
 	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	"bud.studio/stove8s/internal/daemonset/resources/keys"
 	"bud.studio/stove8s/internal/daemonset/resources/oci"
+	"bud.studio/stove8s/internal/k8s"
 	oci_utils "bud.studio/stove8s/internal/oci"
 )
 
@@ -60,7 +68,34 @@ type SnapShotReconciler struct {
 	Scheme *runtime.Scheme
 
 	kubeletClient http.Client
-	podToken      string
+	// podToken is the mounted service account token, only sent to the
+	// kubelets over TLS
+	podToken string
+	// daemonsetTokens are the short-lived tokens the daemonsets are called
+	// with, they're only valid for k8s.DaemonsetAudience
+	daemonsetTokens tokener
+	restConfig      *rest.Config
+	clientset       kubernetes.Interface
+
+	// exec runs the hook, slim and quiesce commands, podExec when unset
+	exec func(ctx context.Context, pod *corev1.Pod, containerName string, command []string, timeout time.Duration) (string, error)
+}
+
+// tokener returns a bearer token, requesting a new one when needed
+type tokener interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// daemonsetAuth sets the token the daemonsets authenticate the controller
+// with on req. The daemonsets are called over plain HTTP, so it's scoped to
+// them and short-lived rather than the mounted token
+func (r *SnapShotReconciler) daemonsetAuth(req *http.Request) error {
+	token, err := r.daemonsetTokens.Token(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	return nil
 }
 
 type CheckPointResp struct {
@@ -82,11 +117,11 @@ type CheckPointResp struct {
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.21.0/pkg/reconcile
 // NOTE: breaking down the Reconcile() function furhter will reduce the readability
 // nolint: gocyclo
-func (r *SnapShotReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *SnapShotReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
 	log := logf.FromContext(ctx)
 
 	snapshot := &stove8sv1beta1.SnapShot{}
-	err := r.Get(ctx, req.NamespacedName, snapshot)
+	err = r.Get(ctx, req.NamespacedName, snapshot)
 	if err != nil {
 		if apierrors.IsNotFound(err) {
 			// If the custom resource is not found then it usually means that it was deleted or not created
@@ -98,6 +133,24 @@ func (r *SnapShotReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		return ctrl.Result{}, err
 	}
 
+	// NOTE: the memory of the slimmed containers is measured again once the
+	// kubelet sampled it, instead of holding the checkpoint for it
+	err = r.slimSettle(ctx, snapshot)
+	if err != nil {
+		log.Error(err, "unable to update Snapshot status")
+		return ctrl.Result{}, err
+	}
+	defer func() {
+		requeueAfter := slimRequeueAfter(snapshot)
+		if err == nil && requeueAfter != 0 && (result.RequeueAfter == 0 || requeueAfter < result.RequeueAfter) {
+			result.RequeueAfter = requeueAfter
+		}
+	}()
+
+	if snapshot.Spec.Selector.Object.Kind != "Pod" {
+		return r.reconcileWorkload(ctx, snapshot)
+	}
+
 	pod, requeue, err := r.podFromObjectRef(ctx, snapshot.Spec.Selector.Object, snapshot.Namespace)
 	if err != nil {
 		if requeue {
@@ -111,57 +164,49 @@ func (r *SnapShotReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		log.Error(err, "unable to set controller reference")
 		return ctrl.Result{}, err
 	}
+	if snapshot.Spec.Selector.Container == "" {
+		return r.reconcileContainers(ctx, snapshot, pod)
+	}
 
-	containerIdx := slices.IndexFunc(pod.Spec.Containers, func(container corev1.Container) bool {
-		return container.Name == snapshot.Spec.Selector.Container
-	})
-	if containerIdx == -1 {
-		log.Info("Container not found in Pod", "container", snapshot.Spec.Selector.Container)
-		return ctrl.Result{}, nil
+	container, err := podContainer(pod, snapshot.Spec.Selector.Container)
+	if errors.Is(err, errContainerNotFound) && !containerStatusesReported(pod) {
+		log.Info("Container statuses not reported yet, waiting", "Pod", pod.Name, "reason", err.Error())
+		return ctrl.Result{RequeueAfter: workloadRequeueAfter}, nil
+	}
+	if err != nil {
+		log.Info("Container can't be checkpointed", "reason", err.Error())
+		return ctrl.Result{}, r.unswappableReport(ctx, snapshot, err)
 	}
-	if pod.Spec.Containers[containerIdx].Image == snapshot.Spec.Output.ContainerRegistry.ImageReference {
+	if outputImageRunning(snapshot, container.Image) {
 		// pod already running snapshot image
 		return ctrl.Result{}, nil
 	}
 
 	// NOTE: stateless till here
 
-	if snapshot.Status.OutPutReferenceIsValid {
-		err := r.PodImageUpdate(
-			ctx,
-			pod,
-			snapshot.Spec.Output.ContainerRegistry.ImageReference,
-			containerIdx,
-			snapshot.Spec.Output.ContainerRegistry.ImagePushSecret.Name,
-		)
-		if err != nil {
-			log.Error(err, "unable to swap the container image")
-		}
-		return ctrl.Result{}, err
+	pushed := outputIsPushed(snapshot.Spec.Output)
+	if !pushed && (snapshot.Status.ExportPath != "" || snapshot.Status.ObjectURL != "") {
+		// NOTE: exports and uploads are restored out of band, the pod keeps its image
+		return ctrl.Result{}, nil
 	}
 
-	secretNamespace := snapshot.Spec.Output.ContainerRegistry.ImagePushSecret.Namespace
-	if secretNamespace == "" {
-		secretNamespace = snapshot.Namespace
+	if snapshot.Status.OutPutReferenceIsValid {
+		return r.outputImageSwap(ctx, snapshot, pod, container)
 	}
-	containerRegistrySecret := corev1.Secret{}
-	err = r.Get(
-		ctx,
-		apitypes.NamespacedName{
-			Name:      snapshot.Spec.Output.ContainerRegistry.ImagePushSecret.Name,
-			Namespace: secretNamespace,
-		},
-		&containerRegistrySecret,
-	)
+
+	containerRegistrySecret, secretNamespace, err := r.imagePushSecret(ctx, snapshot)
 	if err != nil {
 		log.Error(err, "Failed to get image push secret")
 		return ctrl.Result{}, err
 	}
 
-	valid, err := oci_utils.ReferenceIsValid(snapshot.Spec.Output.ContainerRegistry.ImageReference, &containerRegistrySecret)
-	if err != nil {
-		log.Error(err, "unable to check output image existence")
-		return ctrl.Result{}, err
+	var valid bool
+	if pushed {
+		valid, err = oci_utils.ReferenceIsValid(snapshot.Spec.Output.ContainerRegistry.ImageReference, containerRegistrySecret)
+		if err != nil {
+			log.Error(err, "unable to check output image existence")
+			return ctrl.Result{}, err
+		}
 	}
 	if valid {
 		snapshot.Status.OutPutReferenceIsValid = true
@@ -169,17 +214,7 @@ func (r *SnapShotReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 			log.Error(err, "unable to update Snapshot status")
 			return ctrl.Result{}, err
 		}
-		err := r.PodImageUpdate(
-			ctx,
-			pod,
-			snapshot.Spec.Output.ContainerRegistry.ImageReference,
-			containerIdx,
-			snapshot.Spec.Output.ContainerRegistry.ImagePushSecret.Name,
-		)
-		if err != nil {
-			log.Error(err, "unable to swap the container image")
-		}
-		return ctrl.Result{}, err
+		return r.outputImageSwap(ctx, snapshot, pod, container)
 	}
 
 	readyIdx := slices.IndexFunc(pod.Status.Conditions, func(condition corev1.PodCondition) bool {
@@ -235,12 +270,17 @@ func (r *SnapShotReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	}
 
 	if snapshot.Status.CheckPointNodePath == "" {
-		checkPointNodePath, err := r.checkpoint(
-			ctx,
-			pod,
-			snapshot.Spec.Selector.Container,
-			snapshot.Spec.Input.Timeout,
-		)
+		var checkPointNodePath string
+		err := r.hookedCheckpoint(ctx, snapshot, pod, []string{snapshot.Spec.Selector.Container}, func() error {
+			var err error
+			checkPointNodePath, err = r.checkpoint(
+				ctx,
+				pod,
+				snapshot.Spec.Selector.Container,
+				snapshot.Spec.Input.Timeout,
+			)
+			return err
+		})
 		if err != nil {
 			snapshot.Status.State = stove8sv1beta1.Failed
 			if err := r.Status().Update(ctx, snapshot); err != nil {
@@ -256,12 +296,27 @@ func (r *SnapShotReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	}
 
 	if snapshot.Status.JobID == "" {
-		jobID, err := daemonsetInit(
+		parentImageReference, err := r.parentImageReference(ctx, snapshot)
+		if err != nil {
+			log.Error(err, "unable to resolve parent image")
+			return ctrl.Result{}, err
+		}
+		nodeInfo, err := r.nodeInfo(ctx, snapshot.Status.Node.Name)
+		if err != nil {
+			log.Error(err, "unable to get node info")
+			return ctrl.Result{}, err
+		}
+		jobID, err := r.daemonsetInit(
 			ctx,
-			snapshot.Spec.Output.ContainerRegistry,
+			snapshot.Spec.Output,
+			nodeInfo,
+			parentImageReference,
 			snapshot.Status.CheckPointNodePath,
 			snapshot.Status.Node,
 			secretNamespace,
+			snapshot.Namespace,
+			snapshot.Name,
+			false,
 		)
 		if err != nil {
 			log.Error(err, "unable init daemonset job")
@@ -275,13 +330,24 @@ func (r *SnapShotReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	}
 
 	if snapshot.Status.Stage != stove8sv1beta1.Pushing || snapshot.Status.State != stove8sv1beta1.Success {
-		ociStatus, err := daemonsetStausFetch(ctx, snapshot.Status.JobID, snapshot.Status.Node)
+		ociStatus, err := r.daemonsetStausFetch(ctx, snapshot.Status.JobID, snapshot.Status.Node)
 		if err != nil {
 			log.Error(err, "unable fetch daemonset job status")
 			return ctrl.Result{}, err
 		}
 		snapshot.Status.Stage = ociStatus.Stage
 		snapshot.Status.State = ociStatus.State
+		snapshot.Status.CompressedSize = ociStatus.CompressedSize
+		snapshot.Status.DeduplicatedSize = ociStatus.DeduplicatedSize
+		snapshot.Status.SkippedSize = ociStatus.SkippedSize
+		snapshot.Status.PushRetries = ociStatus.Retries
+		snapshot.Status.Digest = ociStatus.Digest
+		snapshot.Status.ProvenanceDigest = ociStatus.ProvenanceDigest
+		snapshot.Status.BaseImageReference = ociStatus.BaseImageReference
+		snapshot.Status.ExportPath = ociStatus.ExportPath
+		snapshot.Status.ObjectURL = ociStatus.ObjectURL
+		snapshot.Status.ObjectETag = ociStatus.ObjectETag
+		snapshot.Status.LocalImage = ociStatus.LocalImage
 		if err := r.Status().Update(ctx, snapshot); err != nil {
 			log.Error(err, "unable to update Snapshot status with daemonset status")
 			return ctrl.Result{}, err
@@ -290,8 +356,29 @@ func (r *SnapShotReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	if snapshot.Status.Stage != stove8sv1beta1.Pushing || snapshot.Status.State != stove8sv1beta1.Success {
 		return ctrl.Result{}, nil
 	}
+	if snapshot.Spec.Output.Local != nil {
+		snapshot.Status.OutPutReferenceIsValid = true
+		if err := r.Status().Update(ctx, snapshot); err != nil {
+			log.Error(err, "unable to update Snapshot status")
+			return ctrl.Result{}, err
+		}
+		err = r.podImageSwap(ctx, snapshot, pod, container)
+		if err != nil {
+			log.Error(err, "unable to swap the container image")
+		}
+		return ctrl.Result{}, err
+	}
+	if !pushed {
+		log.Info(
+			"Snapshot stored",
+			"path", snapshot.Status.ExportPath,
+			"node", snapshot.Status.Node.Name,
+			"url", snapshot.Status.ObjectURL,
+		)
+		return ctrl.Result{}, nil
+	}
 
-	valid, err = oci_utils.ReferenceIsValid(snapshot.Spec.Output.ContainerRegistry.ImageReference, &containerRegistrySecret)
+	valid, err = oci_utils.ReferenceIsValid(snapshot.Spec.Output.ContainerRegistry.ImageReference, containerRegistrySecret)
 	if err != nil {
 		log.Error(err, "unable to check output image existence")
 		return ctrl.Result{}, err
@@ -307,28 +394,332 @@ func (r *SnapShotReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		log.Error(err, "unable to update Snapshot status")
 		return ctrl.Result{}, err
 	}
-	err = r.PodImageUpdate(
+	snapshotPushedMetricsRecord(snapshot)
+
+	return r.outputImageSwap(ctx, snapshot, pod, container)
+}
+
+// outputImageSwap swaps the output image in once it's prefetched
+func (r *SnapShotReconciler) outputImageSwap(
+	ctx context.Context,
+	snapshot *stove8sv1beta1.SnapShot,
+	pod *corev1.Pod,
+	container *corev1.Container,
+) (ctrl.Result, error) {
+	result, err := r.prefetchedSwap(ctx, snapshot, []corev1.Pod{*pod}, func() error {
+		return r.podImageSwap(ctx, snapshot, pod, container)
+	})
+	if err != nil {
+		logf.FromContext(ctx).Error(err, "unable to swap the container image")
+	}
+	return result, err
+}
+
+// outputIsPushed reports whether the output image ends up in the container
+// registry, offline exports, object store uploads and local imports only name it
+func outputIsPushed(output stove8sv1beta1.SnapShotOutput) bool {
+	return output.Offline == nil && output.ObjectStore == nil && output.Local == nil
+}
+
+// outputImageName is the image the pod is swapped to
+func outputImageName(snapshot *stove8sv1beta1.SnapShot) string {
+	if snapshot.Spec.Output.Local != nil && snapshot.Status.LocalImage != "" {
+		return snapshot.Status.LocalImage
+	}
+	return snapshot.Spec.Output.ContainerRegistry.ImageReference
+}
+
+// outputImageRunning reports whether image is the output image, either as
+// named or pinned to its verified or pushed digest
+func outputImageRunning(snapshot *stove8sv1beta1.SnapShot, image string) bool {
+	if image == outputImageName(snapshot) {
+		return true
+	}
+	for _, digest := range []string{snapshot.Status.VerifiedDigest, snapshot.Status.Digest} {
+		if digest == "" {
+			continue
+		}
+		pinned, err := digestReference(outputImageName(snapshot), digest)
+		if err == nil && image == pinned {
+			return true
+		}
+	}
+	return false
+}
+
+// imageSwapped reports whether image is imageReference, or a digest of its
+// repository it was pinned to when swapped in
+func imageSwapped(image string, imageReference string) bool {
+	if image == imageReference {
+		return true
+	}
+	ref, err := name.ParseReference(imageReference)
+	if err != nil {
+		return false
+	}
+	digest, err := name.NewDigest(image)
+	return err == nil && digest.Context() == ref.Context()
+}
+
+// digestReference is imageReference pinned to digest, in the same repository
+func digestReference(imageReference string, digest string) (string, error) {
+	ref, err := name.ParseReference(imageReference)
+	if err != nil {
+		return "", err
+	}
+	return ref.Context().Digest(digest).String(), nil
+}
+
+// podImageSwap swaps the output image in, when signing is enabled it's refused
+// unless the image carries a valid signature
+func (r *SnapShotReconciler) podImageSwap(
+	ctx context.Context,
+	snapshot *stove8sv1beta1.SnapShot,
+	pod *corev1.Pod,
+	container *corev1.Container,
+) error {
+	if snapshot.Spec.Output.Format == stove8sv1beta1.Artifact {
+		// NOTE: archival artifacts can't be pulled by the container runtime
+		logf.FromContext(ctx).Info("Output is an archival artifact, keeping the container image")
+		return nil
+	}
+	imageRef := outputImageName(snapshot)
+	// NOTE: local imports never leave the node, there is no signature to fetch
+	if snapshot.Spec.Output.Local != nil {
+		// NOTE: the pull policy can't be changed on a running pod
+		if container.ImagePullPolicy == corev1.PullAlways {
+			return fmt.Errorf("imagePullPolicy Always would pull the local image %s from a registry", snapshot.Status.LocalImage)
+		}
+	} else if snapshot.Spec.Output.Signing != nil {
+		digest, err := r.signatureVerify(ctx, snapshot, imageRef)
+		if err != nil {
+			return fmt.Errorf("refusing unverified image: %w", err)
+		}
+		// NOTE: pinned to the verified digest, the tag could be moved between
+		// the verification and the pull
+		imageRef, err = digestReference(imageRef, digest)
+		if err != nil {
+			return err
+		}
+		if snapshot.Status.VerifiedDigest != digest {
+			snapshot.Status.VerifiedDigest = digest
+			if err := r.Status().Update(ctx, snapshot); err != nil {
+				return err
+			}
+		}
+	} else if snapshot.Spec.Output.Prefetch != nil && snapshot.Status.Digest != "" {
+		// NOTE: the nodes prefetched the digest, the tag isn't in their store
+		var err error
+		imageRef, err = digestReference(imageRef, snapshot.Status.Digest)
+		if err != nil {
+			return err
+		}
+	}
+	if encryption := snapshot.Spec.Output.Encryption; encryption != nil && encryption.DecryptionKeySecret != nil {
+		err := r.decryptionKeyInstall(ctx, snapshot, pod.Spec.NodeName)
+		if err != nil {
+			return fmt.Errorf("installing decryption keys: %w", err)
+		}
+	}
+
+	return r.PodImageUpdate(
 		ctx,
 		pod,
-		snapshot.Spec.Output.ContainerRegistry.ImageReference,
-		containerIdx,
+		imageRef,
+		container,
 		snapshot.Spec.Output.ContainerRegistry.ImagePushSecret.Name,
 	)
+}
+
+// signatureVerify checks the signature and the provenance of imageReference,
+// an output image, against the configured cosign public key and returns the
+// verified digest
+func (r *SnapShotReconciler) signatureVerify(
+	ctx context.Context,
+	snapshot *stove8sv1beta1.SnapShot,
+	imageReference string,
+) (string, error) {
+	signing := snapshot.Spec.Output.Signing
+	publicKeySecretRef := signing.KeySecret
+	if signing.PublicKeySecret != nil {
+		publicKeySecretRef = *signing.PublicKeySecret
+	}
+	publicKeySecret := corev1.Secret{}
+	err := r.Get(ctx, apitypes.NamespacedName{
+		Name:      publicKeySecretRef.Name,
+		Namespace: kindReferenceNamespace(publicKeySecretRef, snapshot.Namespace),
+	}, &publicKeySecret)
 	if err != nil {
-		log.Error(err, "unable to swap the container image")
-		return ctrl.Result{}, err
+		return "", fmt.Errorf("failed to get public key secret: %w", err)
+	}
+	pub, err := oci_utils.PublicKeyFromK8sSecret(&publicKeySecret)
+	if err != nil {
+		return "", err
 	}
 
-	return ctrl.Result{}, nil
+	pushSecretRef := snapshot.Spec.Output.ContainerRegistry.ImagePushSecret
+	pushSecret := corev1.Secret{}
+	err = r.Get(ctx, apitypes.NamespacedName{
+		Name:      pushSecretRef.Name,
+		Namespace: kindReferenceNamespace(pushSecretRef, snapshot.Namespace),
+	}, &pushSecret)
+	if err != nil {
+		return "", fmt.Errorf("failed to get image push secret: %w", err)
+	}
+	ref, err := name.ParseReference(imageReference)
+	if err != nil {
+		return "", err
+	}
+	auth, err := oci_utils.AuthFromK8sSecret(&pushSecret, ref.Context().RegistryStr())
+	if err != nil {
+		return "", err
+	}
+
+	digest, err := oci_utils.Verify(ref, pub, auth)
+	if err != nil {
+		return "", err
+	}
+	// NOTE: the provenance is signed with the same key, an image whose origin
+	// can't be shown isn't swapped in either
+	err = oci_utils.ProvenanceVerify(ref.Context().Digest(digest.String()), pub, auth)
+	if err != nil {
+		return "", fmt.Errorf("verifying provenance: %w", err)
+	}
+	return digest.String(), nil
+}
+
+// decryptionKeyInstall asks the daemonset of nodeName to install the
+// decryption keys, so its container runtime can pull the encrypted image
+func (r *SnapShotReconciler) decryptionKeyInstall(
+	ctx context.Context,
+	snapshot *stove8sv1beta1.SnapShot,
+	nodeName string,
+) error {
+	log := logf.FromContext(ctx)
+
+	node := snapshot.Status.Node
+	if node.Name != nodeName || node.DeamonsetAddr == "" {
+		var err error
+		node, err = r.daemonsetNode(ctx, nodeName)
+		if err != nil {
+			return err
+		}
+	}
+
+	secretRef := *snapshot.Spec.Output.Encryption.DecryptionKeySecret
+	jsonData, err := json.Marshal(keys.CreateReq{
+		Secret: keys.CreateReqSecret{
+			Name:      secretRef.Name,
+			Namespace: kindReferenceNamespace(secretRef, snapshot.Namespace),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	keysEndpoint := fmt.Sprintf("http://%s:%v/keys", node.DeamonsetAddr, node.DeamonsetPort)
+	req, err := http.NewRequest(http.MethodPost, keysEndpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	err = r.daemonsetAuth(req)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			log.Error(err, "Closing response body")
+		}
+	}()
+	if resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code for decryptionKeyInstall: %d: %s", resp.StatusCode, body)
+	}
+
+	return nil
+}
+
+// unswappableReport fails snapshot with the reason its container can't be
+// swapped or its spec can't be taken, neither changes on its own so there is
+// nothing to retry
+func (r *SnapShotReconciler) unswappableReport(ctx context.Context, snapshot *stove8sv1beta1.SnapShot, reason error) error {
+	if snapshot.Status.State == stove8sv1beta1.Failed && snapshot.Status.Message == reason.Error() {
+		return nil
+	}
+	snapshot.Status.State = stove8sv1beta1.Failed
+	snapshot.Status.Message = reason.Error()
+	return r.Status().Update(ctx, snapshot)
+}
+
+// errContainerNotFound is returned for containers that aren't in the pod spec
+var errContainerNotFound = errors.New("container not found")
+
+// containerStatusesReported tells whether the kubelet reported the status of
+// every container of pod, the containers it lacks aren't known to be missing
+// until then
+func containerStatusesReported(pod *corev1.Pod) bool {
+	statuses := slices.Concat(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses)
+	for _, container := range slices.Concat(pod.Spec.InitContainers, pod.Spec.Containers) {
+		if !slices.ContainsFunc(statuses, func(status corev1.ContainerStatus) bool {
+			return status.Name == container.Name
+		}) {
+			return false
+		}
+	}
+	return len(statuses) > 0
+}
+
+// podContainer returns the container of pod named containerName, a regular
+// container or a native sidecar, init containers with restartPolicy Always.
+// Other init containers have exited, there is nothing left to checkpoint
+func podContainer(pod *corev1.Pod, containerName string) (*corev1.Container, error) {
+	idx := slices.IndexFunc(pod.Spec.Containers, func(container corev1.Container) bool {
+		return container.Name == containerName
+	})
+	if idx != -1 {
+		return &pod.Spec.Containers[idx], nil
+	}
+
+	idx = slices.IndexFunc(pod.Spec.InitContainers, func(container corev1.Container) bool {
+		return container.Name == containerName
+	})
+	if idx == -1 {
+		if slices.ContainsFunc(pod.Spec.EphemeralContainers, func(container corev1.EphemeralContainer) bool {
+			return container.Name == containerName
+		}) {
+			return nil, fmt.Errorf("%s is an ephemeral container of pod %s, its image can't be swapped", containerName, pod.Name)
+		}
+		return nil, fmt.Errorf("%w: %s in pod %s", errContainerNotFound, containerName, pod.Name)
+	}
+	container := &pod.Spec.InitContainers[idx]
+	if container.RestartPolicy == nil || *container.RestartPolicy != corev1.ContainerRestartPolicyAlways {
+		return nil, fmt.Errorf("%s is an init container of pod %s that ran to completion, only native sidecars (restartPolicy: Always) can be checkpointed", containerName, pod.Name)
+	}
+	return container, nil
+}
+
+func kindReferenceNamespace(ref stove8sv1beta1.KindReference, defaultNamespace string) string {
+	if ref.Namespace == "" {
+		return defaultNamespace
+	}
+	return ref.Namespace
 }
 
 func (r *SnapShotReconciler) PodImageUpdate(
 	ctx context.Context,
 	pod *corev1.Pod, imageRef string,
-	containerIdx int,
+	container *corev1.Container,
 	imagePullSecret string,
 ) error {
-	pod.Spec.Containers[containerIdx].Image = imageRef
+	// NOTE: container points into pod, native sidecars are restarted by the
+	// kubelet on image change like regular containers
+	container.Image = imageRef
 	// Forbidden: pod updates may not change fields other than `spec.containers[*].image`
 	// pod.Spec.ImagePullSecrets = append(
 	// 	pod.Spec.ImagePullSecrets,
@@ -337,7 +728,11 @@ func (r *SnapShotReconciler) PodImageUpdate(
 	return r.Update(ctx, pod)
 }
 
-func daemonsetStausFetch(
+// errDaemonsetJobNotFound is returned for jobs the daemonset doesn't know,
+// they were lost when its pod restarted and won't come back
+var errDaemonsetJobNotFound = errors.New("daemonset job not found")
+
+func (r *SnapShotReconciler) daemonsetStausFetch(
 	ctx context.Context,
 	jobID string,
 	node stove8sv1beta1.SnapShotStatusNode,
@@ -349,6 +744,10 @@ func daemonsetStausFetch(
 	if err != nil {
 		return nil, err
 	}
+	err = r.daemonsetAuth(req)
+	if err != nil {
+		return nil, err
+	}
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
@@ -359,6 +758,9 @@ func daemonsetStausFetch(
 			log.Error(err, "Closing response body")
 		}
 	}()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", errDaemonsetJobNotFound, jobID)
+	}
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		return nil, fmt.Errorf("unexpected status code for daemonsetStausFetch: %d: %s", resp.StatusCode, body)
@@ -373,22 +775,103 @@ func daemonsetStausFetch(
 	return &ociStatus, nil
 }
 
-func daemonsetInit(
+// retryLimit is the upload retry limit of retry, the API server only defaults
+// it when the retry object is given
+func retryLimit(retry stove8sv1beta1.SnapShotOutputRetry) int {
+	if retry.Limit == nil {
+		return 5
+	}
+	return int(*retry.Limit)
+}
+
+func (r *SnapShotReconciler) daemonsetInit(
 	ctx context.Context,
-	output stove8sv1beta1.SnapShotOutputContainerRegistry,
+	output stove8sv1beta1.SnapShotOutput,
+	nodeInfo corev1.NodeSystemInfo,
+	parentImageReference string,
 	checkPointNodePath string,
 	node stove8sv1beta1.SnapShotStatusNode,
 	secretNamespace string,
+	snapshotNamespace string,
+	snapshotName string,
+	pushByDigest bool,
 ) (string, error) {
 	log := logf.FromContext(ctx)
 
 	data := oci.CreateReq{
 		CheckpointDumpPath: checkPointNodePath,
 		ImagePushSecret: oci.CreateReqImagePushSecret{
-			Name:      output.ImagePushSecret.Name,
+			Name:      output.ContainerRegistry.ImagePushSecret.Name,
 			Namespace: secretNamespace,
 		},
-		ImageReference: output.ImageReference,
+		ImageReference:       output.ContainerRegistry.ImageReference,
+		MountFrom:            output.ContainerRegistry.MountFrom,
+		ParentImageReference: parentImageReference,
+		RetryLimit:           retryLimit(output.Retry),
+		Format:               output.Format,
+		Architecture:         nodeInfo.Architecture,
+		PushByDigest:         pushByDigest,
+		BaseImage: oci.CreateReqBaseImage{
+			Replicate:  output.BaseImage.Replicate,
+			Repository: output.BaseImage.Repository,
+		},
+		Provenance: oci.CreateReqProvenance{
+			NodeName:                node.Name,
+			KubeletVersion:          nodeInfo.KubeletVersion,
+			ContainerRuntimeVersion: nodeInfo.ContainerRuntimeVersion,
+		},
+		Compression: oci.CreateReqCompression{
+			Algorithm: output.Compression.Algorithm,
+			Level:     output.Compression.Level,
+		},
+	}
+	if output.Layers.PagesSplitThreshold != nil {
+		data.PagesSplitThreshold = output.Layers.PagesSplitThreshold.Value()
+	}
+	if output.Retry.Deadline != nil {
+		data.Deadline = output.Retry.Deadline.Duration
+	}
+	if output.Encryption != nil {
+		for _, recipient := range output.Encryption.Recipients {
+			data.EncryptionRecipients = append(data.EncryptionRecipients, oci.CreateReqEncryptionRecipient{
+				Protocol:  recipient.Protocol,
+				PublicKey: recipient.PublicKey,
+			})
+		}
+	}
+	if output.Offline != nil {
+		data.Offline = &oci.CreateReqOffline{
+			Format: output.Offline.Format,
+			Path:   output.Offline.Path,
+		}
+	}
+	if output.ObjectStore != nil {
+		data.ObjectStore = &oci.CreateReqObjectStore{
+			Endpoint: output.ObjectStore.Endpoint,
+			Region:   output.ObjectStore.Region,
+			Bucket:   output.ObjectStore.Bucket,
+			Prefix:   path.Join(output.ObjectStore.Prefix, snapshotNamespace, snapshotName),
+			CredentialsSecret: oci.CreateReqObjectStoreCredentialsSecret{
+				Name:      output.ObjectStore.CredentialsSecret.Name,
+				Namespace: kindReferenceNamespace(output.ObjectStore.CredentialsSecret, snapshotNamespace),
+			},
+			Mode: output.ObjectStore.Mode,
+		}
+		if output.ObjectStore.PartSize != nil {
+			data.ObjectStore.PartSize = output.ObjectStore.PartSize.Value()
+		}
+	}
+	if output.Local != nil {
+		data.Local = &oci.CreateReqLocal{
+			Namespace: output.Local.Namespace,
+			Name:      output.Local.Name,
+		}
+	}
+	if output.Signing != nil {
+		data.SigningKeySecret = &oci.CreateReqSigningKeySecret{
+			Name:      output.Signing.KeySecret.Name,
+			Namespace: kindReferenceNamespace(output.Signing.KeySecret, snapshotNamespace),
+		}
 	}
 	jsonData, err := json.Marshal(data)
 	if err != nil {
@@ -401,6 +884,10 @@ func daemonsetInit(
 		return "", err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	err = r.daemonsetAuth(req)
+	if err != nil {
+		return "", err
+	}
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return "", err
@@ -425,6 +912,45 @@ func daemonsetInit(
 	return createResp.JobID, nil
 }
 
+// parentImageReference resolves spec.output.parent to an image reference, it's
+// empty when the snapshot isn't incremental
+func (r *SnapShotReconciler) parentImageReference(
+	ctx context.Context,
+	snapshot *stove8sv1beta1.SnapShot,
+) (string, error) {
+	parent := snapshot.Spec.Output.Parent
+	if parent == nil {
+		return "", nil
+	}
+	if parent.SnapShot == "" {
+		return parent.ImageReference, nil
+	}
+
+	parentSnapshot := &stove8sv1beta1.SnapShot{}
+	err := r.Get(ctx, apitypes.NamespacedName{
+		Namespace: snapshot.Namespace,
+		Name:      parent.SnapShot,
+	}, parentSnapshot)
+	if err != nil {
+		return "", fmt.Errorf("failed to get parent snapshot %s: %w", parent.SnapShot, err)
+	}
+	if !parentSnapshot.Status.OutPutReferenceIsValid {
+		return "", fmt.Errorf("parent snapshot %s has not been pushed yet", parent.SnapShot)
+	}
+
+	return parentSnapshot.Spec.Output.ContainerRegistry.ImageReference, nil
+}
+
+func (r *SnapShotReconciler) nodeInfo(ctx context.Context, nodeName string) (corev1.NodeSystemInfo, error) {
+	node := corev1.Node{}
+	err := r.Get(ctx, apitypes.NamespacedName{Name: nodeName}, &node)
+	if err != nil {
+		return corev1.NodeSystemInfo{}, fmt.Errorf("failed to get node: %v", err)
+	}
+
+	return node.Status.NodeInfo, nil
+}
+
 func (r *SnapShotReconciler) kubeletEndpointFromPod(ctx context.Context, pod *corev1.Pod) (string, string, int32, error) {
 	node := corev1.Node{}
 
@@ -591,6 +1117,22 @@ func (r *SnapShotReconciler) podFromObjectRef(
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *SnapShotReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	err := r.clientsSetup(mgr)
+	if err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&stove8sv1beta1.SnapShot{}).
+		Owns(&corev1.Pod{}).
+		Named("snapshot").
+		Complete(r)
+}
+
+// clientsSetup sets up the kubelet client, authenticated with the pod service
+// account token, the clientset pods/exec goes through and the requester of
+// the daemonset tokens
+func (r *SnapShotReconciler) clientsSetup(mgr ctrl.Manager) error {
 	caCert, err := os.ReadFile(podCaCertPath)
 	if err != nil {
 		return err
@@ -614,9 +1156,23 @@ func (r *SnapShotReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	}
 	r.podToken = string(podToken)
 
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&stove8sv1beta1.SnapShot{}).
-		Owns(&corev1.Pod{}).
-		Named("snapshot").
-		Complete(r)
+	r.restConfig = mgr.GetConfig()
+	r.clientset, err = kubernetes.NewForConfig(r.restConfig)
+	if err != nil {
+		return err
+	}
+
+	// NOTE: the service account of the controller is the one the daemonsets expect
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	review, err := r.clientset.AuthenticationV1().SelfSubjectReviews().Create(ctx, &authenticationv1.SelfSubjectReview{}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("reviewing the controller identity: %w", err)
+	}
+	namespace, name, ok := k8s.ServiceAccountFromUsername(review.Status.UserInfo.Username)
+	if !ok {
+		return fmt.Errorf("the controller runs as %s, not as a service account", review.Status.UserInfo.Username)
+	}
+	r.daemonsetTokens = k8s.NewTokenRequester(r.clientset, namespace, name, k8s.DaemonsetAudience)
+	return nil
 }
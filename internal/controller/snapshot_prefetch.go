@@ -0,0 +1,290 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	"bud.studio/stove8s/internal/daemonset/resources/prefetch"
+)
+
+// prefetchRequeueAfter polls the prefetch jobs, nothing else triggers a
+// reconcile while the nodes are pulling
+const prefetchRequeueAfter = 5 * time.Second
+
+// prefetchedSwap runs swap once the output image is prefetched on the
+// candidate nodes, the nodes of pods unless a node selector is set
+func (r *SnapShotReconciler) prefetchedSwap(
+	ctx context.Context,
+	snapshot *stove8sv1beta1.SnapShot,
+	pods []corev1.Pod,
+	swap func() error,
+) (ctrl.Result, error) {
+	done, err := r.prefetchReconcile(ctx, snapshot, pods)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("prefetching the output image: %w", err)
+	}
+	if !done {
+		return ctrl.Result{RequeueAfter: prefetchRequeueAfter}, nil
+	}
+
+	return ctrl.Result{}, swap()
+}
+
+// prefetchReconcile starts and polls the prefetch job of every candidate node,
+// it's done once every node either pulled the image or failed to
+func (r *SnapShotReconciler) prefetchReconcile(
+	ctx context.Context,
+	snapshot *stove8sv1beta1.SnapShot,
+	pods []corev1.Pod,
+) (bool, error) {
+	log := logf.FromContext(ctx)
+
+	output := snapshot.Spec.Output
+	if output.Prefetch == nil || !outputIsPushed(output) || output.Format == stove8sv1beta1.Artifact {
+		return true, nil
+	}
+
+	if len(snapshot.Status.Prefetch) == 0 {
+		nodeNames, err := r.prefetchNodes(ctx, output.Prefetch, pods)
+		if err != nil {
+			return false, err
+		}
+		if len(nodeNames) == 0 {
+			log.Info("No node to prefetch the output image on")
+			return true, nil
+		}
+		for _, nodeName := range nodeNames {
+			snapshot.Status.Prefetch = append(snapshot.Status.Prefetch, stove8sv1beta1.SnapShotStatusPrefetch{
+				Node:  nodeName,
+				State: stove8sv1beta1.Idle,
+			})
+		}
+	}
+
+	_, secretNamespace, err := r.imagePushSecret(ctx, snapshot)
+	if err != nil {
+		return false, err
+	}
+
+	done := true
+	for i := range snapshot.Status.Prefetch {
+		nodePrefetch := &snapshot.Status.Prefetch[i]
+		if nodePrefetch.State == stove8sv1beta1.Success || nodePrefetch.State == stove8sv1beta1.Failed {
+			continue
+		}
+		err := r.nodePrefetchReconcile(ctx, snapshot, nodePrefetch, secretNamespace)
+		if err != nil {
+			// NOTE: the node pulls the image on restore like it would have without prefetch
+			log.Error(err, "unable to prefetch the output image", "node", nodePrefetch.Node)
+			nodePrefetch.State = stove8sv1beta1.Failed
+		}
+		if nodePrefetch.State != stove8sv1beta1.Success && nodePrefetch.State != stove8sv1beta1.Failed {
+			done = false
+		}
+	}
+	if err := r.Status().Update(ctx, snapshot); err != nil {
+		return false, err
+	}
+
+	return done, nil
+}
+
+// prefetchNodes are the nodes matching the prefetch node selector, or the
+// nodes pods run on, sorted by name
+func (r *SnapShotReconciler) prefetchNodes(
+	ctx context.Context,
+	prefetch *stove8sv1beta1.SnapShotOutputPrefetch,
+	pods []corev1.Pod,
+) ([]string, error) {
+	var nodeNames []string
+	if prefetch.NodeSelector == nil {
+		for _, pod := range pods {
+			if pod.Spec.NodeName != "" && !slices.Contains(nodeNames, pod.Spec.NodeName) {
+				nodeNames = append(nodeNames, pod.Spec.NodeName)
+			}
+		}
+	} else {
+		nodeList := &corev1.NodeList{}
+		err := r.List(ctx, nodeList, client.MatchingLabels(prefetch.NodeSelector))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list nodes: %w", err)
+		}
+		for _, node := range nodeList.Items {
+			nodeNames = append(nodeNames, node.Name)
+		}
+	}
+
+	slices.Sort(nodeNames)
+	return nodeNames, nil
+}
+
+// nodePrefetchReconcile starts the prefetch job on the daemonset of the node,
+// or updates its state from the job status
+func (r *SnapShotReconciler) nodePrefetchReconcile(
+	ctx context.Context,
+	snapshot *stove8sv1beta1.SnapShot,
+	nodePrefetch *stove8sv1beta1.SnapShotStatusPrefetch,
+	secretNamespace string,
+) error {
+	node, err := r.daemonsetNode(ctx, nodePrefetch.Node)
+	if err != nil {
+		return err
+	}
+
+	if nodePrefetch.JobID == "" {
+		if encryption := snapshot.Spec.Output.Encryption; encryption != nil && encryption.DecryptionKeySecret != nil {
+			err := r.decryptionKeyInstall(ctx, snapshot, nodePrefetch.Node)
+			if err != nil {
+				return fmt.Errorf("installing decryption keys: %w", err)
+			}
+		}
+		nodePrefetch.JobID, err = r.prefetchInit(ctx, snapshot, node, secretNamespace)
+		if err != nil {
+			return err
+		}
+		nodePrefetch.State = stove8sv1beta1.Started
+		return nil
+	}
+
+	prefetchStatus, err := r.prefetchStatusFetch(ctx, nodePrefetch.JobID, node)
+	if err != nil {
+		return err
+	}
+	nodePrefetch.State = prefetchStatus.State
+	nodePrefetch.ImageID = prefetchStatus.ImageID
+
+	return nil
+}
+
+// daemonsetNode is the daemonset endpoint of nodeName
+func (r *SnapShotReconciler) daemonsetNode(ctx context.Context, nodeName string) (stove8sv1beta1.SnapShotStatusNode, error) {
+	stove8sNamespace, err := os.ReadFile(podNameSpacePath)
+	if err != nil {
+		return stove8sv1beta1.SnapShotStatusNode{}, err
+	}
+	daemonSetPodIP, err := r.getDaemonSetPodIPOnNode(ctx, string(stove8sNamespace), daemonsetName, nodeName)
+	if err != nil {
+		return stove8sv1beta1.SnapShotStatusNode{}, err
+	}
+
+	return stove8sv1beta1.SnapShotStatusNode{
+		Name:          nodeName,
+		DeamonsetAddr: daemonSetPodIP,
+		DeamonsetPort: daemonsetPort,
+	}, nil
+}
+
+// prefetchInit starts pulling the pushed digest of the output image on node
+func (r *SnapShotReconciler) prefetchInit(
+	ctx context.Context,
+	snapshot *stove8sv1beta1.SnapShot,
+	node stove8sv1beta1.SnapShotStatusNode,
+	secretNamespace string,
+) (string, error) {
+	log := logf.FromContext(ctx)
+
+	if snapshot.Status.Digest == "" {
+		return "", errors.New("no pushed digest to prefetch")
+	}
+	imageReference, err := digestReference(snapshot.Spec.Output.ContainerRegistry.ImageReference, snapshot.Status.Digest)
+	if err != nil {
+		return "", err
+	}
+	jsonData, err := json.Marshal(prefetch.CreateReq{
+		ImageReference: imageReference,
+		ImagePullSecret: prefetch.CreateReqImagePullSecret{
+			Name:      snapshot.Spec.Output.ContainerRegistry.ImagePushSecret.Name,
+			Namespace: secretNamespace,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	prefetchEndpoint := fmt.Sprintf("http://%s:%v/prefetch", node.DeamonsetAddr, node.DeamonsetPort)
+	req, err := http.NewRequest(http.MethodPost, prefetchEndpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	err = r.daemonsetAuth(req)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			log.Error(err, "Closing response body")
+		}
+	}()
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("unexpected status code for prefetchInit: %d: %s", resp.StatusCode, body)
+	}
+
+	var createResp prefetch.CreateResp
+	err = json.NewDecoder(resp.Body).Decode(&createResp)
+	if err != nil {
+		return "", err
+	}
+
+	return createResp.JobID, nil
+}
+
+func (r *SnapShotReconciler) prefetchStatusFetch(
+	ctx context.Context,
+	jobID string,
+	node stove8sv1beta1.SnapShotStatusNode,
+) (*prefetch.Status, error) {
+	log := logf.FromContext(ctx)
+
+	prefetchEndpoint := fmt.Sprintf("http://%s:%v/prefetch/%s", node.DeamonsetAddr, node.DeamonsetPort, jobID)
+	req, err := http.NewRequest(http.MethodGet, prefetchEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = r.daemonsetAuth(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			log.Error(err, "Closing response body")
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status code for prefetchStatusFetch: %d: %s", resp.StatusCode, body)
+	}
+
+	var prefetchStatus prefetch.Status
+	err = json.NewDecoder(resp.Body).Decode(&prefetchStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	return &prefetchStatus, nil
+}
@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	"bud.studio/stove8s/internal/daemonset/resources/prefetch"
+)
+
+func TestPrefetchInit(t *testing.T) {
+	var received prefetch.CreateReq
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testDaemonsetToken {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(prefetch.CreateResp{JobID: "job"})
+	}))
+	t.Cleanup(server.Close)
+	host, port := testHostPort(t, server.URL)
+	node := stove8sv1beta1.SnapShotStatusNode{Name: "node-a", DeamonsetAddr: host, DeamonsetPort: port}
+
+	snapshot := &stove8sv1beta1.SnapShot{}
+	snapshot.Spec.Output.ContainerRegistry.ImageReference = "registry.local/checkpoint/service:latest"
+	snapshot.Spec.Output.ContainerRegistry.ImagePushSecret.Name = "push"
+
+	r := testReconciler(t)
+	_, err := r.prefetchInit(context.Background(), snapshot, node, "default")
+	if err == nil {
+		t.Fatal("expected an error without a pushed digest")
+	}
+
+	snapshot.Status.Digest = testDigest
+	jobID, err := r.prefetchInit(context.Background(), snapshot, node, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobID != "job" {
+		t.Errorf("unexpected job %q", jobID)
+	}
+	if want := "registry.local/checkpoint/service@" + testDigest; received.ImageReference != want {
+		t.Errorf("expected %s to be prefetched, got %s", want, received.ImageReference)
+	}
+	if received.ImagePullSecret.Name != "push" || received.ImagePullSecret.Namespace != "default" {
+		t.Errorf("unexpected pull secret %+v", received.ImagePullSecret)
+	}
+}
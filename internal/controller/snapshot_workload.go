@@ -0,0 +1,445 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	oci_utils "bud.studio/stove8s/internal/oci"
+)
+
+// workloadRequeueAfter polls the daemonset jobs, workload pods aren't owned by
+// the SnapShot so their events don't trigger a reconcile
+const workloadRequeueAfter = 5 * time.Second
+
+var (
+	errWorkloadOutput     = errors.New("offline, objectStore and local outputs aren't supported for workloads, only for pods")
+	errWorkloadContainers = errors.New("containers and allContainers aren't supported for workloads, only for pods")
+)
+
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;replicasets,verbs=get;list;watch
+
+// reconcileWorkload checkpoints one ready pod per node architecture of a
+// workload, every checkpoint is pushed by digest and they're merged into an
+// image index under the output reference
+// nolint: gocyclo
+func (r *SnapShotReconciler) reconcileWorkload(ctx context.Context, snapshot *stove8sv1beta1.SnapShot) (ctrl.Result, error) {
+	log := logf.FromContext(ctx)
+
+	if !outputIsPushed(snapshot.Spec.Output) {
+		// NOTE: an image index can't be assembled out of exports on several nodes
+		log.Info("Offline export, object store upload and local import aren't supported for workloads, only for pods")
+		return ctrl.Result{}, r.unswappableReport(ctx, snapshot, errWorkloadOutput)
+	}
+
+	if snapshot.Spec.Selector.Container == "" {
+		// NOTE: the per-container images can't be merged into a single index
+		log.Info("Containers and allContainers aren't supported for workloads, only for pods")
+		return ctrl.Result{}, r.unswappableReport(ctx, snapshot, errWorkloadContainers)
+	}
+
+	pods, err := r.podsFromWorkload(ctx, snapshot.Spec.Selector, snapshot.Namespace)
+	if err != nil {
+		log.Error(err, "unable to list workload pods")
+		return ctrl.Result{}, err
+	}
+
+	if snapshot.Status.OutPutReferenceIsValid {
+		return r.prefetchedSwap(ctx, snapshot, pods, func() error {
+			return r.platformPodsImageSwap(ctx, snapshot, pods)
+		})
+	}
+
+	containerRegistrySecret, secretNamespace, err := r.imagePushSecret(ctx, snapshot)
+	if err != nil {
+		log.Error(err, "Failed to get image push secret")
+		return ctrl.Result{}, err
+	}
+
+	if len(snapshot.Status.Platforms) == 0 {
+		valid, err := oci_utils.ReferenceIsValid(snapshot.Spec.Output.ContainerRegistry.ImageReference, containerRegistrySecret)
+		if err != nil {
+			log.Error(err, "unable to check output image existence")
+			return ctrl.Result{}, err
+		}
+		if valid {
+			// NOTE: the pods can't be swapped, which pod was checkpointed isn't known
+			log.Info("Output image already present, skipping", "image", snapshot.Spec.Output.ContainerRegistry.ImageReference)
+			snapshot.Status.OutPutReferenceIsValid = true
+			return ctrl.Result{}, r.Status().Update(ctx, snapshot)
+		}
+
+		platforms, err := r.platformsPick(ctx, pods)
+		if err != nil {
+			log.Error(err, "unable to pick a pod per architecture")
+			return ctrl.Result{}, err
+		}
+		if len(platforms) == 0 {
+			log.Info("No ready pod in workload, waiting", "Object", snapshot.Spec.Selector.Object.Name)
+			return ctrl.Result{RequeueAfter: workloadRequeueAfter}, nil
+		}
+		snapshot.Status.Platforms = platforms
+		snapshot.Status.Stage = stove8sv1beta1.CriuDumping
+		snapshot.Status.State = stove8sv1beta1.Started
+		if err := r.Status().Update(ctx, snapshot); err != nil {
+			log.Error(err, "unable to update Snapshot status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	stove8sNamespace, err := os.ReadFile(podNameSpacePath)
+	if err != nil {
+		log.Error(err, "Failed to get image stove8s namespace")
+		return ctrl.Result{}, err
+	}
+	parentImageReference, err := r.parentImageReference(ctx, snapshot)
+	if err != nil {
+		log.Error(err, "unable to resolve parent image")
+		return ctrl.Result{}, err
+	}
+
+	for i := range snapshot.Status.Platforms {
+		platform := &snapshot.Status.Platforms[i]
+		if platform.State == stove8sv1beta1.Failed {
+			continue
+		}
+		err := r.platformReconcile(
+			ctx,
+			snapshot,
+			platform,
+			pods,
+			string(stove8sNamespace),
+			secretNamespace,
+			parentImageReference,
+		)
+		if err != nil {
+			// NOTE: platforms that didn't fail are polled again below
+			log.Error(err, "unable to snapshot platform", "architecture", platform.Architecture)
+		}
+		if err := r.Status().Update(ctx, snapshot); err != nil {
+			log.Error(err, "unable to update Snapshot status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	var digests []string
+	for _, platform := range snapshot.Status.Platforms {
+		switch {
+		case platform.State == stove8sv1beta1.Failed:
+			snapshot.Status.State = stove8sv1beta1.Failed
+			if err := r.Status().Update(ctx, snapshot); err != nil {
+				log.Error(err, "unable to update Snapshot status")
+			}
+			return ctrl.Result{}, fmt.Errorf("snapshot of %s failed", platform.Architecture)
+		case platform.Stage != stove8sv1beta1.Pushing || platform.State != stove8sv1beta1.Success:
+			return ctrl.Result{RequeueAfter: workloadRequeueAfter}, nil
+		}
+		digests = append(digests, platform.Digest)
+	}
+
+	ref, err := name.ParseReference(snapshot.Spec.Output.ContainerRegistry.ImageReference)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	auth, err := oci_utils.AuthFromK8sSecret(containerRegistrySecret, ref.Context().RegistryStr())
+	if err != nil {
+		log.Error(err, "unable to get registry credentials")
+		return ctrl.Result{}, err
+	}
+	indexDigest, err := oci_utils.IndexPush(ref, digests, remote.WithAuth(auth), remote.WithContext(ctx))
+	if err != nil {
+		log.Error(err, "unable to push image index")
+		return ctrl.Result{}, err
+	}
+	log.Info("Pushed image index", "digest", indexDigest.String(), "platforms", len(digests))
+
+	snapshot.Status.Stage = stove8sv1beta1.Pushing
+	snapshot.Status.State = stove8sv1beta1.Success
+	snapshot.Status.Digest = indexDigest.String()
+	snapshot.Status.OutPutReferenceIsValid = true
+	if err := r.Status().Update(ctx, snapshot); err != nil {
+		log.Error(err, "unable to update Snapshot status")
+		return ctrl.Result{}, err
+	}
+	snapshotPushedMetricsRecord(snapshot)
+
+	return r.prefetchedSwap(ctx, snapshot, pods, func() error {
+		return r.platformPodsImageSwap(ctx, snapshot, pods)
+	})
+}
+
+// platformReconcile moves the checkpoint of a single architecture forward,
+// the same way Reconcile does for a single pod. The platform only fails when
+// its pod is gone, can't be checkpointed or the daemonset lost its job, the
+// other daemonset and API errors are retried on the next reconcile
+func (r *SnapShotReconciler) platformReconcile(
+	ctx context.Context,
+	snapshot *stove8sv1beta1.SnapShot,
+	platform *stove8sv1beta1.SnapShotStatusPlatform,
+	pods []corev1.Pod,
+	stove8sNamespace string,
+	secretNamespace string,
+	parentImageReference string,
+) error {
+	podIdx := slices.IndexFunc(pods, func(pod corev1.Pod) bool {
+		return pod.Name == platform.Pod
+	})
+
+	if platform.CheckPointNodePath == "" {
+		if podIdx == -1 {
+			platform.State = stove8sv1beta1.Failed
+			return fmt.Errorf("pod %s isn't ready anymore", platform.Pod)
+		}
+		pod := &pods[podIdx]
+
+		daemonSetPodIP, err := r.getDaemonSetPodIPOnNode(ctx, stove8sNamespace, daemonsetName, pod.Spec.NodeName)
+		if err != nil {
+			return fmt.Errorf("unable to get deamonset endpoint for pod: %w", err)
+		}
+		nodeName, _, kubeletPort, err := r.kubeletEndpointFromPod(ctx, pod)
+		if err != nil {
+			return fmt.Errorf("unable to get kubelet endpoint for pod: %w", err)
+		}
+		platform.Node = stove8sv1beta1.SnapShotStatusNode{
+			Name:          nodeName,
+			DeamonsetAddr: daemonSetPodIP,
+			DeamonsetPort: daemonsetPort,
+			KubeletPort:   kubeletPort,
+		}
+		platform.Stage = stove8sv1beta1.CriuDumping
+		platform.State = stove8sv1beta1.Started
+
+		err = r.hookedCheckpoint(ctx, snapshot, pod, []string{snapshot.Spec.Selector.Container}, func() error {
+			var err error
+			platform.CheckPointNodePath, err = r.checkpoint(
+				ctx,
+				pod,
+				snapshot.Spec.Selector.Container,
+				snapshot.Spec.Input.Timeout,
+			)
+			return err
+		})
+		if err != nil {
+			platform.State = stove8sv1beta1.Failed
+			return err
+		}
+	}
+
+	if platform.JobID == "" {
+		nodeInfo, err := r.nodeInfo(ctx, platform.Node.Name)
+		if err != nil {
+			return err
+		}
+		platform.JobID, err = r.daemonsetInit(
+			ctx,
+			snapshot.Spec.Output,
+			nodeInfo,
+			parentImageReference,
+			platform.CheckPointNodePath,
+			platform.Node,
+			secretNamespace,
+			snapshot.Namespace,
+			snapshot.Name,
+			true,
+		)
+		if err != nil {
+			return fmt.Errorf("unable init daemonset job: %w", err)
+		}
+	}
+
+	if platform.Stage != stove8sv1beta1.Pushing || platform.State != stove8sv1beta1.Success {
+		ociStatus, err := r.daemonsetStausFetch(ctx, platform.JobID, platform.Node)
+		if errors.Is(err, errDaemonsetJobNotFound) {
+			platform.State = stove8sv1beta1.Failed
+		}
+		if err != nil {
+			return fmt.Errorf("unable fetch daemonset job status: %w", err)
+		}
+		platform.Stage = ociStatus.Stage
+		platform.State = ociStatus.State
+		platform.Digest = ociStatus.Digest
+		if platform.Stage == stove8sv1beta1.Pushing && platform.State == stove8sv1beta1.Success {
+			snapshot.Status.CompressedSize += ociStatus.CompressedSize
+			snapshot.Status.DeduplicatedSize += ociStatus.DeduplicatedSize
+			snapshot.Status.SkippedSize += ociStatus.SkippedSize
+			snapshot.Status.PushRetries += ociStatus.Retries
+		}
+	}
+
+	return nil
+}
+
+// platformsPick picks the first ready pod, by name, of every node architecture
+func (r *SnapShotReconciler) platformsPick(ctx context.Context, pods []corev1.Pod) ([]stove8sv1beta1.SnapShotStatusPlatform, error) {
+	var platforms []stove8sv1beta1.SnapShotStatusPlatform
+	for _, pod := range pods {
+		nodeInfo, err := r.nodeInfo(ctx, pod.Spec.NodeName)
+		if err != nil {
+			return nil, err
+		}
+		if slices.ContainsFunc(platforms, func(platform stove8sv1beta1.SnapShotStatusPlatform) bool {
+			return platform.Architecture == nodeInfo.Architecture
+		}) {
+			continue
+		}
+		platforms = append(platforms, stove8sv1beta1.SnapShotStatusPlatform{
+			Architecture: nodeInfo.Architecture,
+			Pod:          pod.Name,
+		})
+	}
+
+	slices.SortFunc(platforms, func(a, b stove8sv1beta1.SnapShotStatusPlatform) int {
+		return strings.Compare(a.Architecture, b.Architecture)
+	})
+	return platforms, nil
+}
+
+// platformPodsImageSwap swaps the image index in the checkpointed pods, each
+// node's runtime picks the checkpoint of its architecture
+func (r *SnapShotReconciler) platformPodsImageSwap(
+	ctx context.Context,
+	snapshot *stove8sv1beta1.SnapShot,
+	pods []corev1.Pod,
+) error {
+	var errs []error
+	for _, platform := range snapshot.Status.Platforms {
+		podIdx := slices.IndexFunc(pods, func(pod corev1.Pod) bool {
+			return pod.Name == platform.Pod
+		})
+		if podIdx == -1 {
+			continue
+		}
+		pod := &pods[podIdx]
+		container, err := podContainer(pod, snapshot.Spec.Selector.Container)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if outputImageRunning(snapshot, container.Image) {
+			continue
+		}
+
+		err = r.podImageSwap(ctx, snapshot, pod, container)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("swapping the image of %s: %w", pod.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// podsFromWorkload lists the running and ready pods of the workload selector
+// points to that have the selected container, sorted by name
+func (r *SnapShotReconciler) podsFromWorkload(
+	ctx context.Context,
+	selector stove8sv1beta1.SnapShotSelector,
+	snapShotNamespace string,
+) ([]corev1.Pod, error) {
+	obj := selector.Object
+	namespace := obj.Namespace
+	if namespace == "" {
+		namespace = snapShotNamespace
+	}
+	key := apitypes.NamespacedName{Namespace: namespace, Name: obj.Name}
+
+	var labelSelector *metav1.LabelSelector
+	switch obj.Kind {
+	case "Deployment":
+		workload := &appsv1.Deployment{}
+		if err := r.Get(ctx, key, workload); err != nil {
+			return nil, err
+		}
+		labelSelector = workload.Spec.Selector
+	case "StatefulSet":
+		workload := &appsv1.StatefulSet{}
+		if err := r.Get(ctx, key, workload); err != nil {
+			return nil, err
+		}
+		labelSelector = workload.Spec.Selector
+	case "DaemonSet":
+		workload := &appsv1.DaemonSet{}
+		if err := r.Get(ctx, key, workload); err != nil {
+			return nil, err
+		}
+		labelSelector = workload.Spec.Selector
+	case "ReplicaSet":
+		workload := &appsv1.ReplicaSet{}
+		if err := r.Get(ctx, key, workload); err != nil {
+			return nil, err
+		}
+		labelSelector = workload.Spec.Selector
+	default:
+		return nil, fmt.Errorf("unsupported kind: %v", obj.Kind)
+	}
+
+	podSelector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector in %s %s: %w", obj.Kind, key, err)
+	}
+	podList := &corev1.PodList{}
+	err = r.List(ctx, podList,
+		client.InNamespace(namespace),
+		client.MatchingLabelsSelector{Selector: podSelector},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var pods []corev1.Pod
+	for _, pod := range podList.Items {
+		if pod.DeletionTimestamp != nil || pod.Spec.NodeName == "" {
+			continue
+		}
+		if _, err := podContainer(&pod, selector.Container); err != nil {
+			continue
+		}
+		if !slices.ContainsFunc(pod.Status.Conditions, func(condition corev1.PodCondition) bool {
+			return condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue
+		}) {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+
+	slices.SortFunc(pods, func(a, b corev1.Pod) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return pods, nil
+}
+
+// imagePushSecret returns the output image push secret and its namespace
+func (r *SnapShotReconciler) imagePushSecret(
+	ctx context.Context,
+	snapshot *stove8sv1beta1.SnapShot,
+) (*corev1.Secret, string, error) {
+	secretNamespace := kindReferenceNamespace(snapshot.Spec.Output.ContainerRegistry.ImagePushSecret, snapshot.Namespace)
+	secret := &corev1.Secret{}
+	err := r.Get(
+		ctx,
+		apitypes.NamespacedName{
+			Name:      snapshot.Spec.Output.ContainerRegistry.ImagePushSecret.Name,
+			Namespace: secretNamespace,
+		},
+		secret,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return secret, secretNamespace, nil
+}
@@ -0,0 +1,601 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+)
+
+// groupRequeueAfter polls the daemonset jobs and waits for the members to be
+// ready, member pods aren't owned by the SnapShotGroup
+const groupRequeueAfter = 5 * time.Second
+
+// SnapShotGroupReconciler reconciles a SnapShotGroup object, it shares the
+// kubelet, daemonset and registry helpers of SnapShotReconciler
+type SnapShotGroupReconciler struct {
+	SnapShotReconciler
+}
+
+// +kubebuilder:rbac:groups=stove8s.bud.studio,resources=snapshotgroups,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=stove8s.bud.studio,resources=snapshotgroups/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=stove8s.bud.studio,resources=snapshotgroups/finalizers,verbs=update
+
+// Reconcile checkpoints every member of the group at about the same moment,
+// quiescing them first when asked to, and swaps their images once all of them
+// are pushed. A failed member fails the whole group, which isn't retried
+// nolint: gocyclo
+func (r *SnapShotGroupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := logf.FromContext(ctx)
+
+	group := &stove8sv1beta1.SnapShotGroup{}
+	err := r.Get(ctx, req.NamespacedName, group)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("SnapshotGroup resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "failed to get snapshot group")
+		return ctrl.Result{}, err
+	}
+	snapshot := groupSnapShot(group)
+
+	if group.Status.State != stove8sv1beta1.Failed && groupNamespace(group) != group.Namespace {
+		err := fmt.Errorf("pods of namespace %s can't be selected from namespace %s", groupNamespace(group), group.Namespace)
+		log.Info("Group can't be checkpointed", "reason", err.Error())
+		return ctrl.Result{}, r.groupFail(ctx, group, err)
+	}
+
+	switch group.Status.State {
+	case stove8sv1beta1.Failed:
+		// NOTE: retrying would checkpoint the members that succeeded again,
+		// at a different moment than the others
+		return ctrl.Result{}, nil
+	case stove8sv1beta1.Success:
+		err := r.membersImageSwap(ctx, group, snapshot)
+		if err != nil {
+			log.Error(err, "unable to swap the member images")
+		}
+		return ctrl.Result{}, err
+	}
+
+	if len(group.Status.Members) == 0 {
+		members, ready, err := r.membersPick(ctx, group)
+		if err != nil {
+			log.Info("Group can't be checkpointed", "reason", err.Error())
+			return ctrl.Result{}, r.groupFail(ctx, group, err)
+		}
+		if !ready {
+			log.Info("Group members aren't all ready, waiting")
+			return ctrl.Result{RequeueAfter: groupRequeueAfter}, nil
+		}
+		group.Status.Members = members
+		group.Status.Stage = stove8sv1beta1.CriuDumping
+		group.Status.State = stove8sv1beta1.Started
+		if err := r.Status().Update(ctx, group); err != nil {
+			log.Error(err, "unable to update SnapshotGroup status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if slices.ContainsFunc(group.Status.Members, func(member stove8sv1beta1.SnapShotGroupStatusMember) bool {
+		return member.CheckPointNodePath == ""
+	}) {
+		pods, err := r.memberPods(ctx, group)
+		if err != nil {
+			log.Error(err, "unable to get the member pods")
+			return ctrl.Result{}, r.groupFail(ctx, group, err)
+		}
+
+		retry, err := r.membersQuiescedCheckpoint(ctx, group, pods)
+		if retry {
+			log.Error(err, "unable to update SnapshotGroup status")
+			return ctrl.Result{}, err
+		}
+		if err != nil {
+			log.Error(err, "unable to checkpoint the group")
+			return ctrl.Result{}, r.groupFail(ctx, group, err)
+		}
+		if err := r.Status().Update(ctx, group); err != nil {
+			log.Error(err, "unable to update SnapshotGroup status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	_, secretNamespace, err := r.imagePushSecret(ctx, snapshot)
+	if err != nil {
+		log.Error(err, "Failed to get image push secret")
+		return ctrl.Result{}, err
+	}
+
+	var errs []error
+	for i := range group.Status.Members {
+		member := &group.Status.Members[i]
+		if member.State == stove8sv1beta1.Failed {
+			continue
+		}
+		err := r.memberReconcile(ctx, group, snapshot, member, secretNamespace)
+		if err != nil {
+			// NOTE: members that didn't fail are polled again on the next
+			// reconcile
+			log.Error(err, "unable to snapshot member", "pod", member.Pod)
+			errs = append(errs, err)
+		}
+		if err := r.Status().Update(ctx, group); err != nil {
+			log.Error(err, "unable to update SnapshotGroup status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	for _, member := range group.Status.Members {
+		if member.State == stove8sv1beta1.Failed {
+			err := fmt.Errorf("snapshot of member %s failed", member.Pod)
+			log.Error(err, "unable to snapshot the group")
+			return ctrl.Result{}, r.groupFail(ctx, group, err)
+		}
+	}
+	if len(errs) > 0 {
+		return ctrl.Result{}, errors.Join(errs...)
+	}
+	for _, member := range group.Status.Members {
+		if member.Stage != stove8sv1beta1.Pushing || member.State != stove8sv1beta1.Success {
+			return ctrl.Result{RequeueAfter: groupRequeueAfter}, nil
+		}
+	}
+
+	group.Status.Stage = stove8sv1beta1.Pushing
+	group.Status.State = stove8sv1beta1.Success
+	if err := r.Status().Update(ctx, group); err != nil {
+		log.Error(err, "unable to update SnapshotGroup status")
+		return ctrl.Result{}, err
+	}
+	snapshot.Status.CompressedSize = group.Status.CompressedSize
+	snapshotPushedMetricsRecord(snapshot)
+
+	err = r.membersImageSwap(ctx, group, snapshot)
+	if err != nil {
+		log.Error(err, "unable to swap the member images")
+	}
+	return ctrl.Result{}, err
+}
+
+// groupSnapShot is the SnapShot the helpers shared with SnapShotReconciler
+// expect, it's never persisted
+func groupSnapShot(group *stove8sv1beta1.SnapShotGroup) *stove8sv1beta1.SnapShot {
+	return &stove8sv1beta1.SnapShot{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: group.Namespace,
+			Name:      group.Name,
+		},
+		Spec: stove8sv1beta1.SnapShotSpec{
+			Input: stove8sv1beta1.SnapShotInput{
+				Timeout: group.Spec.Input.Timeout,
+			},
+			Output: group.Spec.Output,
+		},
+	}
+}
+
+// groupFail fails the whole group with reason
+func (r *SnapShotGroupReconciler) groupFail(ctx context.Context, group *stove8sv1beta1.SnapShotGroup, reason error) error {
+	group.Status.State = stove8sv1beta1.Failed
+	group.Status.Message = reason.Error()
+	return r.Status().Update(ctx, group)
+}
+
+// groupNamespace is the namespace of the member pods
+func groupNamespace(group *stove8sv1beta1.SnapShotGroup) string {
+	if group.Spec.Selector.Namespace == "" {
+		return group.Namespace
+	}
+	return group.Spec.Selector.Namespace
+}
+
+// membersPick lists the member pods, sorted by name, and resolves their
+// output images and daemonsets. It isn't ready until every member is
+func (r *SnapShotGroupReconciler) membersPick(
+	ctx context.Context,
+	group *stove8sv1beta1.SnapShotGroup,
+) ([]stove8sv1beta1.SnapShotGroupStatusMember, bool, error) {
+	podSelector, err := metav1.LabelSelectorAsSelector(&group.Spec.Selector.PodSelector)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid pod selector: %w", err)
+	}
+	podList := &corev1.PodList{}
+	err = r.List(ctx, podList,
+		client.InNamespace(groupNamespace(group)),
+		client.MatchingLabelsSelector{Selector: podSelector},
+	)
+	if err != nil {
+		return nil, false, err
+	}
+
+	pods := slices.DeleteFunc(podList.Items, func(pod corev1.Pod) bool {
+		return pod.DeletionTimestamp != nil
+	})
+	if len(pods) == 0 {
+		return nil, false, nil
+	}
+	slices.SortFunc(pods, func(a, b corev1.Pod) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	var members []stove8sv1beta1.SnapShotGroupStatusMember
+	for _, pod := range pods {
+		if _, err := podContainer(&pod, group.Spec.Selector.Container); err != nil {
+			return nil, false, err
+		}
+		if pod.Spec.NodeName == "" || !slices.ContainsFunc(pod.Status.Conditions, func(condition corev1.PodCondition) bool {
+			return condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue
+		}) {
+			return nil, false, nil
+		}
+
+		imageReference, err := tagSuffixed(group.Spec.Output.ContainerRegistry.ImageReference, pod.Name)
+		if err != nil {
+			return nil, false, err
+		}
+		node, err := r.daemonsetNode(ctx, pod.Spec.NodeName)
+		if err != nil {
+			return nil, false, fmt.Errorf("unable to get deamonset endpoint for pod %s: %w", pod.Name, err)
+		}
+		_, _, node.KubeletPort, err = r.kubeletEndpointFromPod(ctx, &pod)
+		if err != nil {
+			return nil, false, fmt.Errorf("unable to get kubelet endpoint for pod %s: %w", pod.Name, err)
+		}
+		members = append(members, stove8sv1beta1.SnapShotGroupStatusMember{
+			Pod:            pod.Name,
+			ImageReference: imageReference,
+			Node:           node,
+		})
+	}
+
+	return members, true, nil
+}
+
+// memberPods gets the member pods, in the order of the members
+func (r *SnapShotGroupReconciler) memberPods(ctx context.Context, group *stove8sv1beta1.SnapShotGroup) ([]corev1.Pod, error) {
+	pods := make([]corev1.Pod, len(group.Status.Members))
+	for i, member := range group.Status.Members {
+		err := r.Get(ctx, apitypes.NamespacedName{
+			Namespace: groupNamespace(group),
+			Name:      member.Pod,
+		}, &pods[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to get member pod %s: %w", member.Pod, err)
+		}
+	}
+	return pods, nil
+}
+
+// membersParallel runs fn for every member fn is selected for, together
+func membersParallel(
+	group *stove8sv1beta1.SnapShotGroup,
+	pods []corev1.Pod,
+	selected func(member *stove8sv1beta1.SnapShotGroupStatusMember) bool,
+	fn func(member *stove8sv1beta1.SnapShotGroupStatusMember, pod *corev1.Pod) error,
+) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(group.Status.Members))
+	for i := range group.Status.Members {
+		member := &group.Status.Members[i]
+		if !selected(member) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(member, &pods[i])
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// membersQuiescedCheckpoint quiesces the members, records it in the status
+// and checkpoints them. The quiesced members are resumed whatever fails in
+// between. It returns true with a status update error, which is retried, the
+// other errors fail the group
+func (r *SnapShotGroupReconciler) membersQuiescedCheckpoint(
+	ctx context.Context,
+	group *stove8sv1beta1.SnapShotGroup,
+	pods []corev1.Pod,
+) (bool, error) {
+	defer r.membersResume(ctx, group, pods)
+
+	err := r.membersQuiesce(ctx, group, pods)
+	if updateErr := r.Status().Update(ctx, group); updateErr != nil {
+		return true, updateErr
+	}
+	if err != nil {
+		return false, err
+	}
+	return false, r.membersCheckpoint(ctx, group, pods)
+}
+
+// memberExec runs command in the selected container of pod
+func (r *SnapShotGroupReconciler) memberExec(
+	ctx context.Context,
+	group *stove8sv1beta1.SnapShotGroup,
+	pod *corev1.Pod,
+	command []string,
+	timeout time.Duration,
+) (string, error) {
+	return r.containerExec(ctx, pod, group.Spec.Selector.Container, command, timeout)
+}
+
+// membersQuiesce runs the quiesce command in the members that aren't yet
+func (r *SnapShotGroupReconciler) membersQuiesce(
+	ctx context.Context,
+	group *stove8sv1beta1.SnapShotGroup,
+	pods []corev1.Pod,
+) error {
+	quiesce := group.Spec.Input.Quiesce
+	if quiesce == nil {
+		return nil
+	}
+
+	return membersParallel(group, pods, func(member *stove8sv1beta1.SnapShotGroupStatusMember) bool {
+		return !member.Quiesced
+	}, func(member *stove8sv1beta1.SnapShotGroupStatusMember, pod *corev1.Pod) error {
+		output, err := r.memberExec(ctx, group, pod, quiesce.Command, quiesce.Timeout.Duration)
+		if err != nil {
+			return fmt.Errorf("quiescing %s: %w: %s", member.Pod, err, output)
+		}
+		member.Quiesced = true
+		return nil
+	})
+}
+
+// membersCheckpoint checkpoints the members that weren't yet
+func (r *SnapShotGroupReconciler) membersCheckpoint(
+	ctx context.Context,
+	group *stove8sv1beta1.SnapShotGroup,
+	pods []corev1.Pod,
+) error {
+	// NOTE: the requests are sent together so the members are frozen at about
+	// the same moment, the kubelets checkpoint them in parallel
+	return membersParallel(group, pods, func(member *stove8sv1beta1.SnapShotGroupStatusMember) bool {
+		return member.CheckPointNodePath == ""
+	}, func(member *stove8sv1beta1.SnapShotGroupStatusMember, pod *corev1.Pod) error {
+		member.Stage = stove8sv1beta1.CriuDumping
+		checkPointNodePath, err := r.checkpoint(ctx, pod, group.Spec.Selector.Container, group.Spec.Input.Timeout)
+		if err != nil {
+			member.State = stove8sv1beta1.Failed
+			return fmt.Errorf("checkpointing %s: %w", member.Pod, err)
+		}
+		member.CheckPointNodePath = checkPointNodePath
+		return nil
+	})
+}
+
+// membersResume runs the resume command in the quiesced members. It's tried
+// once, the checkpoints are taken by then and a member failing to resume
+// doesn't fail the group
+func (r *SnapShotGroupReconciler) membersResume(
+	ctx context.Context,
+	group *stove8sv1beta1.SnapShotGroup,
+	pods []corev1.Pod,
+) {
+	quiesce := group.Spec.Input.Quiesce
+	if quiesce == nil {
+		return
+	}
+
+	err := membersParallel(group, pods, func(member *stove8sv1beta1.SnapShotGroupStatusMember) bool {
+		return member.Quiesced
+	}, func(member *stove8sv1beta1.SnapShotGroupStatusMember, pod *corev1.Pod) error {
+		member.Quiesced = false
+		if len(quiesce.ResumeCommand) == 0 {
+			return nil
+		}
+		output, err := r.memberExec(ctx, group, pod, quiesce.ResumeCommand, quiesce.Timeout.Duration)
+		if err != nil {
+			return fmt.Errorf("resuming %s: %w: %s", member.Pod, err, output)
+		}
+		return nil
+	})
+	if err != nil {
+		logf.FromContext(ctx).Error(err, "unable to resume the group members")
+	}
+}
+
+// memberReconcile moves the image of a single member forward, the same way
+// Reconcile does for a single pod snapshot. The member only fails when its
+// daemonset job does or was lost, the other daemonset and API errors are
+// retried on the next reconcile
+func (r *SnapShotGroupReconciler) memberReconcile(
+	ctx context.Context,
+	group *stove8sv1beta1.SnapShotGroup,
+	snapshot *stove8sv1beta1.SnapShot,
+	member *stove8sv1beta1.SnapShotGroupStatusMember,
+	secretNamespace string,
+) error {
+	if member.JobID == "" {
+		nodeInfo, err := r.nodeInfo(ctx, member.Node.Name)
+		if err != nil {
+			return err
+		}
+		output := snapshot.Spec.Output
+		output.ContainerRegistry.ImageReference = member.ImageReference
+		member.JobID, err = r.daemonsetInit(
+			ctx,
+			output,
+			nodeInfo,
+			"",
+			member.CheckPointNodePath,
+			member.Node,
+			secretNamespace,
+			group.Namespace,
+			group.Name,
+			false,
+		)
+		if err != nil {
+			return fmt.Errorf("unable init daemonset job: %w", err)
+		}
+		member.Stage = stove8sv1beta1.Fromating
+		member.State = stove8sv1beta1.Started
+	}
+
+	if member.Stage != stove8sv1beta1.Pushing || member.State != stove8sv1beta1.Success {
+		ociStatus, err := r.daemonsetStausFetch(ctx, member.JobID, member.Node)
+		if errors.Is(err, errDaemonsetJobNotFound) {
+			member.State = stove8sv1beta1.Failed
+		}
+		if err != nil {
+			return fmt.Errorf("unable fetch daemonset job status: %w", err)
+		}
+		member.Stage = ociStatus.Stage
+		member.State = ociStatus.State
+		member.Digest = ociStatus.Digest
+		if member.Stage == stove8sv1beta1.Pushing && member.State == stove8sv1beta1.Success {
+			group.Status.CompressedSize += ociStatus.CompressedSize
+		}
+	}
+
+	return nil
+}
+
+// membersImageSwap swaps the member images in. Every signature is verified
+// and every decryption key installed before any pod is updated, and the pods
+// already swapped are swapped back when another can't be, so a group isn't
+// left half swapped
+func (r *SnapShotGroupReconciler) membersImageSwap(
+	ctx context.Context,
+	group *stove8sv1beta1.SnapShotGroup,
+	snapshot *stove8sv1beta1.SnapShot,
+) error {
+	if snapshot.Spec.Output.Format == stove8sv1beta1.Artifact {
+		// NOTE: archival artifacts can't be pulled by the container runtime
+		logf.FromContext(ctx).Info("Output is an archival artifact, keeping the member images")
+		return nil
+	}
+
+	pods, err := r.memberPods(ctx, group)
+	if err != nil {
+		return err
+	}
+	containers := make([]*corev1.Container, len(pods))
+	images := make([]string, len(pods))
+	var nodes []string
+	for i, member := range group.Status.Members {
+		container, err := podContainer(&pods[i], group.Spec.Selector.Container)
+		if err != nil {
+			return err
+		}
+		if imageSwapped(container.Image, member.ImageReference) {
+			continue
+		}
+		images[i] = member.ImageReference
+		if snapshot.Spec.Output.Signing != nil {
+			digest, err := r.signatureVerify(ctx, snapshot, member.ImageReference)
+			if err != nil {
+				return fmt.Errorf("refusing unverified image %s: %w", member.ImageReference, err)
+			}
+			images[i], err = digestReference(member.ImageReference, digest)
+			if err != nil {
+				return err
+			}
+		}
+		containers[i] = container
+		if !slices.Contains(nodes, pods[i].Spec.NodeName) {
+			nodes = append(nodes, pods[i].Spec.NodeName)
+		}
+	}
+
+	if encryption := snapshot.Spec.Output.Encryption; encryption != nil && encryption.DecryptionKeySecret != nil {
+		for _, node := range nodes {
+			err := r.decryptionKeyInstall(ctx, snapshot, node)
+			if err != nil {
+				return fmt.Errorf("installing decryption keys on %s: %w", node, err)
+			}
+		}
+	}
+
+	previous := make([]string, len(pods))
+	var swapped []int
+	for i := range group.Status.Members {
+		if containers[i] == nil {
+			continue
+		}
+		pod := &pods[i]
+		previous[i] = containers[i].Image
+		err := r.PodImageUpdate(
+			ctx,
+			pod,
+			images[i],
+			containers[i],
+			snapshot.Spec.Output.ContainerRegistry.ImagePushSecret.Name,
+		)
+		if err == nil {
+			swapped = append(swapped, i)
+			continue
+		}
+
+		errs := []error{fmt.Errorf("swapping the image of %s: %w", pod.Name, err)}
+		// NOTE: the members swapped back restart from their original image,
+		// the swap is tried again on the next reconcile
+		for _, j := range swapped {
+			// NOTE: the update decoded the pod again, the container moved
+			container, err := podContainer(&pods[j], group.Spec.Selector.Container)
+			if err == nil {
+				err = r.PodImageUpdate(
+					ctx,
+					&pods[j],
+					previous[j],
+					container,
+					snapshot.Spec.Output.ContainerRegistry.ImagePushSecret.Name,
+				)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("swapping the image of %s back: %w", pods[j].Name, err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *SnapShotGroupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	err := r.clientsSetup(mgr)
+	if err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&stove8sv1beta1.SnapShotGroup{}).
+		Named("snapshotgroup").
+		Complete(r)
+}
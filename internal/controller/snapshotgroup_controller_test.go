@@ -0,0 +1,100 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+)
+
+var _ = Describe("SnapShotGroup Controller", func() {
+	Context("When reconciling a resource", func() {
+		const resourceName = "test-resource"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+		snapshotgroup := &stove8sv1beta1.SnapShotGroup{}
+
+		BeforeEach(func() {
+			By("creating the custom resource for the Kind SnapShotGroup")
+			err := k8sClient.Get(ctx, typeNamespacedName, snapshotgroup)
+			if err != nil && errors.IsNotFound(err) {
+				resource := &stove8sv1beta1.SnapShotGroup{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      resourceName,
+						Namespace: "default",
+					},
+
+					Spec: stove8sv1beta1.SnapShotGroupSpec{
+						Selector: stove8sv1beta1.SnapShotGroupSelector{
+							PodSelector: metav1.LabelSelector{
+								MatchLabels: map[string]string{"app": "raft"},
+							},
+							Container: "raft",
+						},
+						Output: stove8sv1beta1.SnapShotOutput{
+							ContainerRegistry: stove8sv1beta1.SnapShotOutputContainerRegistry{
+								ImageReference: "registry.local/raft:checkpoint",
+							},
+						},
+					},
+				}
+				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			resource := &stove8sv1beta1.SnapShotGroup{}
+			err := k8sClient.Get(ctx, typeNamespacedName, resource)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Cleanup the specific resource instance SnapShotGroup")
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+		})
+		It("should wait for the members without failing", func() {
+			By("Reconciling the created resource")
+			controllerReconciler := &SnapShotGroupReconciler{
+				SnapShotReconciler: SnapShotReconciler{
+					Client: k8sClient,
+					Scheme: k8sClient.Scheme(),
+				},
+			}
+
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(groupRequeueAfter))
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, snapshotgroup)).To(Succeed())
+			Expect(snapshotgroup.Status.State).NotTo(Equal(stove8sv1beta1.Failed))
+		})
+	})
+})
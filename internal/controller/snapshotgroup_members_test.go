@@ -0,0 +1,337 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	"bud.studio/stove8s/internal/daemonset/resources/oci"
+)
+
+// testEvents records the quiesce, checkpoint and resume of the members in order
+type testEvents struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (e *testEvents) add(event string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = append(e.events, event)
+}
+
+func (e *testEvents) list() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.Clone(e.events)
+}
+
+// index is the index of the first or last event starting with prefix
+func (e *testEvents) index(prefix string, last bool) int {
+	events := e.list()
+	indexes := []int{}
+	for i, event := range events {
+		if strings.HasPrefix(event, prefix) {
+			indexes = append(indexes, i)
+		}
+	}
+	if len(indexes) == 0 {
+		return -1
+	}
+	if last {
+		return indexes[len(indexes)-1]
+	}
+	return indexes[0]
+}
+
+// testGroup quiesces and checkpoints the app container of service-a and
+// service-b
+func testGroup() *stove8sv1beta1.SnapShotGroup {
+	group := &stove8sv1beta1.SnapShotGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "service", Namespace: "default"},
+	}
+	group.Spec.Selector.PodSelector.MatchLabels = map[string]string{"app": "service"}
+	group.Spec.Selector.Container = "app"
+	group.Spec.Input.Quiesce = &stove8sv1beta1.SnapShotGroupQuiesce{
+		Command:       []string{"quiesce"},
+		ResumeCommand: []string{"resume"},
+	}
+	group.Spec.Output.ContainerRegistry.ImageReference = "registry.example.com/checkpoint/service:latest"
+	group.Status.Stage = stove8sv1beta1.CriuDumping
+	group.Status.State = stove8sv1beta1.Started
+	for _, pod := range []string{"service-a", "service-b"} {
+		group.Status.Members = append(group.Status.Members, stove8sv1beta1.SnapShotGroupStatusMember{
+			Pod:            pod,
+			ImageReference: "registry.example.com/checkpoint/service:latest-" + pod,
+			Node:           stove8sv1beta1.SnapShotStatusNode{Name: "node-a"},
+		})
+	}
+	return group
+}
+
+// testGroupReconciler records the member events, the quiesce command fails
+// in failQuiesce and the checkpoint of failCheckpoint fails
+func testGroupReconciler(t *testing.T, events *testEvents, failQuiesce, failCheckpoint string) *SnapShotGroupReconciler {
+	t.Helper()
+	kubelet := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pod := strings.Split(r.URL.Path, "/")[3]
+		events.add("checkpoint " + pod)
+		if pod == failCheckpoint {
+			http.Error(w, "checkpointing is disabled", http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(CheckPointResp{Items: []string{"/var/lib/kubelet/checkpoints/" + pod + ".tar"}})
+	}))
+	t.Cleanup(kubelet.Close)
+
+	r := &SnapShotGroupReconciler{
+		SnapShotReconciler: *testReconciler(t,
+			testNode(t, "node-a", "amd64", kubelet.URL),
+			testPod("service-a", "node-a", true),
+			testPod("service-b", "node-a", true),
+			testGroup(),
+		),
+	}
+	r.kubeletClient = *kubelet.Client()
+	r.exec = func(_ context.Context, pod *corev1.Pod, _ string, command []string, _ time.Duration) (string, error) {
+		events.add(command[0] + " " + pod.Name)
+		if command[0] == "quiesce" && pod.Name == failQuiesce {
+			return "busy", errors.New("exit status 1")
+		}
+		return "", nil
+	}
+	return r
+}
+
+func TestMembersQuiescedCheckpoint(t *testing.T) {
+	tests := []struct {
+		name           string
+		failQuiesce    string
+		failCheckpoint string
+		conflict       bool
+		retry          bool
+		err            bool
+		checkpoints    int
+		resumed        []string
+	}{
+		{
+			name:        "quiesced",
+			checkpoints: 2,
+			resumed:     []string{"resume service-a", "resume service-b"},
+		},
+		{
+			name:        "quiesce failed",
+			failQuiesce: "service-b",
+			err:         true,
+			resumed:     []string{"resume service-a"},
+		},
+		{
+			name:           "checkpoint failed",
+			failCheckpoint: "service-b",
+			err:            true,
+			checkpoints:    2,
+			resumed:        []string{"resume service-a", "resume service-b"},
+		},
+		{
+			name:     "status conflict",
+			conflict: true,
+			retry:    true,
+			err:      true,
+			resumed:  []string{"resume service-a", "resume service-b"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			events := &testEvents{}
+			r := testGroupReconciler(t, events, test.failQuiesce, test.failCheckpoint)
+			if test.conflict {
+				r.Client = interceptor.NewClient(r.Client.(client.WithWatch), interceptor.Funcs{
+					SubResourceUpdate: func(_ context.Context, _ client.Client, _ string, obj client.Object, _ ...client.SubResourceUpdateOption) error {
+						return apierrors.NewConflict(schema.GroupResource{Resource: "snapshotgroups"}, obj.GetName(), errors.New("modified"))
+					},
+				})
+			}
+			group := &stove8sv1beta1.SnapShotGroup{}
+			err := r.Get(context.Background(), client.ObjectKeyFromObject(testGroup()), group)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pods, err := r.memberPods(context.Background(), group)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			retry, err := r.membersQuiescedCheckpoint(context.Background(), group, pods)
+			if retry != test.retry {
+				t.Errorf("expected a retry: %v, got %v", test.retry, retry)
+			}
+			if (err != nil) != test.err {
+				t.Fatalf("expected an error: %v, got %v", test.err, err)
+			}
+
+			var checkpoints, resumed []string
+			for _, event := range events.list() {
+				if strings.HasPrefix(event, "checkpoint ") {
+					checkpoints = append(checkpoints, event)
+				}
+				if strings.HasPrefix(event, "resume ") {
+					resumed = append(resumed, event)
+				}
+			}
+			slices.Sort(resumed)
+			if len(checkpoints) != test.checkpoints {
+				t.Errorf("expected %d checkpoints, got %v", test.checkpoints, events.list())
+			}
+			if !slices.Equal(resumed, test.resumed) {
+				t.Errorf("expected %v to be resumed, got %v", test.resumed, events.list())
+			}
+			if test.checkpoints > 0 {
+				if events.index("quiesce ", true) > events.index("checkpoint ", false) {
+					t.Errorf("expected every member to be quiesced before the first checkpoint, got %v", events.list())
+				}
+				if events.index("checkpoint ", true) > events.index("resume ", false) {
+					t.Errorf("expected every member to be checkpointed before the first resume, got %v", events.list())
+				}
+			}
+			for _, member := range group.Status.Members {
+				if member.Quiesced {
+					t.Errorf("expected %s to be resumed", member.Pod)
+				}
+			}
+		})
+	}
+}
+
+func TestMembersImageSwap(t *testing.T) {
+	for _, failPod := range []string{"", "service-b"} {
+		events := &testEvents{}
+		r := testGroupReconciler(t, events, "", "")
+		r.Client = interceptor.NewClient(r.Client.(client.WithWatch), interceptor.Funcs{
+			Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+				if obj.GetName() == failPod {
+					return apierrors.NewConflict(schema.GroupResource{Resource: "pods"}, obj.GetName(), errors.New("modified"))
+				}
+				return c.Update(ctx, obj, opts...)
+			},
+		})
+		group := testGroup()
+
+		err := r.membersImageSwap(context.Background(), group, groupSnapShot(group))
+		if (err != nil) != (failPod != "") {
+			t.Fatalf("expected an error: %v, got %v", failPod != "", err)
+		}
+
+		for _, member := range group.Status.Members {
+			pod := &corev1.Pod{}
+			err := r.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: member.Pod}, pod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := member.ImageReference
+			if failPod != "" {
+				expected = "docker.io/library/service:latest"
+			}
+			if pod.Spec.Containers[0].Image != expected {
+				t.Errorf("expected %s to run %s, got %s", member.Pod, expected, pod.Spec.Containers[0].Image)
+			}
+		}
+	}
+}
+
+func TestGroupOtherNamespace(t *testing.T) {
+	events := &testEvents{}
+	r := testGroupReconciler(t, events, "", "")
+	group := testGroup()
+	group.Name = "other"
+	group.Spec.Selector.Namespace = "kube-system"
+	err := r.Create(context.Background(), group)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.Reconcile(context.Background(), reconcile.Request{NamespacedName: client.ObjectKeyFromObject(group)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Get(context.Background(), client.ObjectKeyFromObject(group), group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group.Status.State != stove8sv1beta1.Failed {
+		t.Errorf("expected the group to fail, got %q", group.Status.State)
+	}
+	if len(events.list()) != 0 {
+		t.Errorf("expected no member to be touched, got %v", events.list())
+	}
+}
+
+func TestMemberReconcile(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		ociStatus oci.Status
+		err       bool
+		state     stove8sv1beta1.SnapShotStatusState
+	}{
+		{
+			name:   "daemonset unavailable",
+			status: http.StatusServiceUnavailable,
+			err:    true,
+			state:  stove8sv1beta1.Started,
+		},
+		{
+			name:   "job lost",
+			status: http.StatusNotFound,
+			err:    true,
+			state:  stove8sv1beta1.Failed,
+		},
+		{
+			name:      "job failed",
+			status:    http.StatusOK,
+			ociStatus: oci.Status{Stage: stove8sv1beta1.Pushing, State: stove8sv1beta1.Failed},
+			state:     stove8sv1beta1.Failed,
+		},
+		{
+			name:      "pushed",
+			status:    http.StatusOK,
+			ociStatus: oci.Status{Stage: stove8sv1beta1.Pushing, State: stove8sv1beta1.Success, Digest: testDigest},
+			state:     stove8sv1beta1.Success,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			group := testGroup()
+			member := &group.Status.Members[0]
+			member.Node = testDaemonset(t, test.status, test.ociStatus)
+			member.CheckPointNodePath = "/var/lib/kubelet/checkpoints/service-a.tar"
+			member.JobID = "job"
+			member.Stage = stove8sv1beta1.Fromating
+			member.State = stove8sv1beta1.Started
+
+			r := &SnapShotGroupReconciler{SnapShotReconciler: *testReconciler(t)}
+			err := r.memberReconcile(context.Background(), group, groupSnapShot(group), member, "default")
+			if (err != nil) != test.err {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if member.State != test.state {
+				t.Errorf("expected the member to be %s, got %s", test.state, member.State)
+			}
+		})
+	}
+}
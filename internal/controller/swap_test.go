@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+)
+
+const testDigest = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+
+func TestDigestReference(t *testing.T) {
+	pinned, err := digestReference("registry.example.com/checkpoint/service:latest", testDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "registry.example.com/checkpoint/service@" + testDigest; pinned != expected {
+		t.Errorf("expected %s, got %s", expected, pinned)
+	}
+}
+
+func TestImageSwapped(t *testing.T) {
+	const imageReference = "registry.example.com/checkpoint/service:latest"
+	for _, tc := range []struct {
+		image   string
+		swapped bool
+	}{
+		{imageReference, true},
+		{"registry.example.com/checkpoint/service@" + testDigest, true},
+		{"registry.example.com/checkpoint/other@" + testDigest, false},
+		{"registry.example.com/checkpoint/service:v1", false},
+		{"docker.io/library/nginx:latest", false},
+	} {
+		if swapped := imageSwapped(tc.image, imageReference); swapped != tc.swapped {
+			t.Errorf("%s: expected %v, got %v", tc.image, tc.swapped, swapped)
+		}
+	}
+}
+
+func TestOutputImageRunning(t *testing.T) {
+	snapshot := &stove8sv1beta1.SnapShot{}
+	snapshot.Spec.Output.ContainerRegistry.ImageReference = "registry.example.com/checkpoint/service:latest"
+	pinned := "registry.example.com/checkpoint/service@" + testDigest
+
+	if outputImageRunning(snapshot, pinned) {
+		t.Error("expected a digest that wasn't verified nor pushed not to be running")
+	}
+	snapshot.Status.Digest = testDigest
+	if !outputImageRunning(snapshot, pinned) {
+		t.Error("expected the pushed digest to be running")
+	}
+	snapshot.Status.Digest = ""
+	snapshot.Status.VerifiedDigest = testDigest
+	if !outputImageRunning(snapshot, pinned) {
+		t.Error("expected the verified digest to be running")
+	}
+	if !outputImageRunning(snapshot, snapshot.Spec.Output.ContainerRegistry.ImageReference) {
+		t.Error("expected the tag to be running")
+	}
+}
+
+func TestPodContainer(t *testing.T) {
+	pod := &corev1.Pod{}
+	pod.Name = "service-a"
+	pod.Spec.InitContainers = []corev1.Container{
+		{Name: "migrate", Image: "migrate:v1"},
+		{Name: "envoy", Image: "envoy:v1", RestartPolicy: ptr.To(corev1.ContainerRestartPolicyAlways)},
+	}
+	pod.Spec.Containers = []corev1.Container{{Name: "app", Image: "app:v1"}}
+	pod.Spec.EphemeralContainers = []corev1.EphemeralContainer{{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger", Image: "busybox"},
+	}}
+
+	for _, tc := range []struct {
+		name      string
+		container string
+		image     string
+		notFound  bool
+	}{
+		{name: "regular", container: "app", image: "app:v1"},
+		{name: "native sidecar", container: "envoy", image: "envoy:v1"},
+		{name: "init", container: "migrate"},
+		{name: "ephemeral", container: "debugger"},
+		{name: "missing", container: "other", notFound: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			container, err := podContainer(pod, tc.container)
+			if tc.image == "" {
+				if err == nil {
+					t.Fatalf("expected %s not to be swappable", tc.container)
+				}
+				if errors.Is(err, errContainerNotFound) != tc.notFound {
+					t.Errorf("expected errContainerNotFound: %v, got %v", tc.notFound, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if container.Image != tc.image {
+				t.Errorf("expected image %s, got %s", tc.image, container.Image)
+			}
+			// NOTE: the image is swapped through the returned container
+			container.Image = "swapped"
+			swapped, _ := podContainer(pod, tc.container)
+			if swapped.Image != "swapped" {
+				t.Error("expected the container of the pod spec")
+			}
+			swapped.Image = tc.image
+		})
+	}
+}
+
+func TestContainerStatusesReported(t *testing.T) {
+	pod := &corev1.Pod{}
+	pod.Spec.InitContainers = []corev1.Container{{Name: "envoy", RestartPolicy: ptr.To(corev1.ContainerRestartPolicyAlways)}}
+	pod.Spec.Containers = []corev1.Container{{Name: "app"}}
+
+	if containerStatusesReported(pod) {
+		t.Error("expected a pending pod not to have reported its statuses")
+	}
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: "app"}}
+	if containerStatusesReported(pod) {
+		t.Error("expected the init container status to be missing")
+	}
+	pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{Name: "envoy"}}
+	if !containerStatusesReported(pod) {
+		t.Error("expected every status to be reported")
+	}
+}
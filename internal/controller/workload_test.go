@@ -0,0 +1,402 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	"bud.studio/stove8s/internal/daemonset/resources/oci"
+)
+
+const (
+	testPodToken       = "controller-token"
+	testDaemonsetToken = "daemonset-token"
+)
+
+// testTokens always returns the same token
+type testTokens string
+
+func (token testTokens) Token(context.Context) (string, error) {
+	return string(token), nil
+}
+
+func testReconciler(t *testing.T, objs ...client.Object) *SnapShotReconciler {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := stove8sv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	return &SnapShotReconciler{
+		Client: fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(objs...).
+			WithStatusSubresource(&stove8sv1beta1.SnapShot{}, &stove8sv1beta1.SnapShotGroup{}).
+			Build(),
+		Scheme:          scheme,
+		podToken:        testPodToken,
+		daemonsetTokens: testTokens(testDaemonsetToken),
+	}
+}
+
+// testNode is a node of architecture whose kubelet listens on kubeletURL
+func testNode(t *testing.T, name, architecture, kubeletURL string) *corev1.Node {
+	t.Helper()
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	node.Status.NodeInfo.Architecture = architecture
+	if kubeletURL == "" {
+		return node
+	}
+
+	host, port := testHostPort(t, kubeletURL)
+	node.Status.Addresses = []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: host}}
+	node.Status.DaemonEndpoints.KubeletEndpoint.Port = port
+	return node
+}
+
+func testHostPort(t *testing.T, rawURL string) (string, int32) {
+	t.Helper()
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, rawPort, err := net.SplitHostPort(parsed.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(rawPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return host, int32(port)
+}
+
+// testPod is a pod of nodeName running the app container
+func testPod(name, nodeName string, ready bool) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{"app": "service"},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:   nodeName,
+			Containers: []corev1.Container{{Name: "app", Image: "docker.io/library/service:latest"}},
+		},
+	}
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}
+	return pod
+}
+
+func TestPodsFromWorkload(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "service", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "service"}},
+		},
+	}
+	deleting := testPod("service-d", "node-a", true)
+	deleting.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	deleting.Finalizers = []string{"test"}
+	sidecarless := testPod("service-e", "node-a", true)
+	sidecarless.Spec.Containers[0].Name = "other"
+	unscheduled := testPod("service-f", "", true)
+	other := testPod("other-a", "node-a", true)
+	other.Labels = map[string]string{"app": "other"}
+
+	r := testReconciler(t,
+		deployment,
+		testPod("service-b", "node-a", true),
+		testPod("service-a", "node-b", true),
+		testPod("service-c", "node-a", false),
+		deleting,
+		sidecarless,
+		unscheduled,
+		other,
+	)
+
+	pods, err := r.podsFromWorkload(context.Background(), stove8sv1beta1.SnapShotSelector{
+		Object:    stove8sv1beta1.ObjectReference{Kind: "Deployment", Name: "service"},
+		Container: "app",
+	}, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+	if !slices.Equal(names, []string{"service-a", "service-b"}) {
+		t.Errorf("expected the ready pods sorted by name, got %v", names)
+	}
+
+	_, err = r.podsFromWorkload(context.Background(), stove8sv1beta1.SnapShotSelector{
+		Object: stove8sv1beta1.ObjectReference{Kind: "CronJob", Name: "service"},
+	}, "default")
+	if err == nil {
+		t.Error("expected an unsupported kind to fail")
+	}
+}
+
+func TestPlatformsPick(t *testing.T) {
+	r := testReconciler(t,
+		testNode(t, "node-a", "amd64", ""),
+		testNode(t, "node-b", "amd64", ""),
+		testNode(t, "node-c", "arm64", ""),
+	)
+
+	platforms, err := r.platformsPick(context.Background(), []corev1.Pod{
+		*testPod("service-a", "node-c", true),
+		*testPod("service-b", "node-a", true),
+		*testPod("service-c", "node-b", true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []stove8sv1beta1.SnapShotStatusPlatform{
+		{Architecture: "amd64", Pod: "service-b"},
+		{Architecture: "arm64", Pod: "service-a"},
+	}
+	if !slices.Equal(platforms, expected) {
+		t.Errorf("expected %v, got %v", expected, platforms)
+	}
+
+	_, err = r.platformsPick(context.Background(), []corev1.Pod{*testPod("service-d", "node-d", true)})
+	if err == nil {
+		t.Error("expected a missing node to fail")
+	}
+}
+
+// testDaemonset serves the status of a single daemonset job
+func testDaemonset(t *testing.T, status int, ociStatus oci.Status) stove8sv1beta1.SnapShotStatusNode {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testDaemonsetToken {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/oci/job" || status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(ociStatus)
+	}))
+	t.Cleanup(server.Close)
+
+	host, port := testHostPort(t, server.URL)
+	return stove8sv1beta1.SnapShotStatusNode{Name: "node-a", DeamonsetAddr: host, DeamonsetPort: port}
+}
+
+func TestPlatformReconcile(t *testing.T) {
+	kubelet := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "checkpointing is disabled", http.StatusInternalServerError)
+	}))
+	t.Cleanup(kubelet.Close)
+	node := testNode(t, "node-a", "amd64", kubelet.URL)
+	daemonset := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: daemonsetName, Namespace: "stove8s-system"},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"control-plane": "daemonset"}},
+		},
+	}
+	daemonsetPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "stove8s-daemonset-a",
+			Namespace: "stove8s-system",
+			Labels:    map[string]string{"control-plane": "daemonset"},
+		},
+		Spec:   corev1.PodSpec{NodeName: "node-a"},
+		Status: corev1.PodStatus{PodIP: "10.0.0.1"},
+	}
+	pod := testPod("service-a", "node-a", true)
+
+	unreachable := testDaemonset(t, http.StatusOK, oci.Status{})
+	unreachable.DeamonsetPort = 1
+
+	tests := []struct {
+		name     string
+		platform stove8sv1beta1.SnapShotStatusPlatform
+		failed   bool
+		err      bool
+		digest   string
+	}{
+		{
+			name:     "pod gone",
+			platform: stove8sv1beta1.SnapShotStatusPlatform{Architecture: "amd64", Pod: "service-z"},
+			failed:   true,
+			err:      true,
+		},
+		{
+			name:     "checkpoint failed",
+			platform: stove8sv1beta1.SnapShotStatusPlatform{Architecture: "amd64", Pod: "service-a"},
+			failed:   true,
+			err:      true,
+		},
+		{
+			name: "daemonset unreachable",
+			platform: stove8sv1beta1.SnapShotStatusPlatform{
+				Architecture:       "amd64",
+				Pod:                "service-a",
+				Node:               unreachable,
+				CheckPointNodePath: "/var/lib/kubelet/checkpoints/service-a.tar",
+				JobID:              "job",
+				Stage:              stove8sv1beta1.CriuDumping,
+				State:              stove8sv1beta1.Started,
+			},
+			err: true,
+		},
+		{
+			name: "daemonset unavailable",
+			platform: stove8sv1beta1.SnapShotStatusPlatform{
+				Architecture:       "amd64",
+				Pod:                "service-a",
+				Node:               testDaemonset(t, http.StatusServiceUnavailable, oci.Status{}),
+				CheckPointNodePath: "/var/lib/kubelet/checkpoints/service-a.tar",
+				JobID:              "job",
+				Stage:              stove8sv1beta1.CriuDumping,
+				State:              stove8sv1beta1.Started,
+			},
+			err: true,
+		},
+		{
+			name: "job lost",
+			platform: stove8sv1beta1.SnapShotStatusPlatform{
+				Architecture:       "amd64",
+				Pod:                "service-a",
+				Node:               testDaemonset(t, http.StatusNotFound, oci.Status{}),
+				CheckPointNodePath: "/var/lib/kubelet/checkpoints/service-a.tar",
+				JobID:              "job",
+				Stage:              stove8sv1beta1.CriuDumping,
+				State:              stove8sv1beta1.Started,
+			},
+			failed: true,
+			err:    true,
+		},
+		{
+			name: "pushed",
+			platform: stove8sv1beta1.SnapShotStatusPlatform{
+				Architecture: "amd64",
+				Pod:          "service-a",
+				Node: testDaemonset(t, http.StatusOK, oci.Status{
+					Stage:  stove8sv1beta1.Pushing,
+					State:  stove8sv1beta1.Success,
+					Digest: testDigest,
+				}),
+				CheckPointNodePath: "/var/lib/kubelet/checkpoints/service-a.tar",
+				JobID:              "job",
+				Stage:              stove8sv1beta1.CriuDumping,
+				State:              stove8sv1beta1.Started,
+			},
+			digest: testDigest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := testReconciler(t, node, daemonset, daemonsetPod, pod)
+			r.kubeletClient = *kubelet.Client()
+			snapshot := &stove8sv1beta1.SnapShot{
+				ObjectMeta: metav1.ObjectMeta{Name: "service", Namespace: "default"},
+			}
+			snapshot.Spec.Selector.Container = "app"
+			platform := test.platform
+
+			err := r.platformReconcile(
+				context.Background(),
+				snapshot,
+				&platform,
+				[]corev1.Pod{*pod},
+				"stove8s-system",
+				"default",
+				"",
+			)
+			if (err != nil) != test.err {
+				t.Fatalf("expected an error: %v, got %v", test.err, err)
+			}
+			if (platform.State == stove8sv1beta1.Failed) != test.failed {
+				t.Errorf("expected the platform to fail: %v, got state %s", test.failed, platform.State)
+			}
+			if platform.Digest != test.digest {
+				t.Errorf("expected digest %q, got %q", test.digest, platform.Digest)
+			}
+		})
+	}
+}
+
+func TestDaemonsetStatusFetchNotFound(t *testing.T) {
+	_, err := (&SnapShotReconciler{daemonsetTokens: testTokens(testDaemonsetToken)}).daemonsetStausFetch(context.Background(), "job", testDaemonset(t, http.StatusNotFound, oci.Status{}))
+	if !errors.Is(err, errDaemonsetJobNotFound) {
+		t.Errorf("expected errDaemonsetJobNotFound, got %v", err)
+	}
+}
+
+func TestReconcileWorkloadUnsupported(t *testing.T) {
+	tests := []struct {
+		name     string
+		snapshot func(*stove8sv1beta1.SnapShot)
+		message  string
+	}{
+		{
+			name: "offline output",
+			snapshot: func(snapshot *stove8sv1beta1.SnapShot) {
+				snapshot.Spec.Output.Offline = &stove8sv1beta1.SnapShotOutputOffline{}
+			},
+			message: errWorkloadOutput.Error(),
+		},
+		{
+			name: "all containers",
+			snapshot: func(snapshot *stove8sv1beta1.SnapShot) {
+				snapshot.Spec.Selector.Container = ""
+				snapshot.Spec.Selector.AllContainers = true
+			},
+			message: errWorkloadContainers.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snapshot := &stove8sv1beta1.SnapShot{ObjectMeta: metav1.ObjectMeta{Name: "service", Namespace: "default"}}
+			snapshot.Spec.Selector.Object = stove8sv1beta1.ObjectReference{Kind: "Deployment", Name: "service"}
+			snapshot.Spec.Selector.Container = "app"
+			snapshot.Spec.Output.ContainerRegistry.ImageReference = "registry.example.com/service:checkpoint"
+			tt.snapshot(snapshot)
+
+			r := testReconciler(t, snapshot)
+			_, err := r.reconcileWorkload(context.Background(), snapshot)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := &stove8sv1beta1.SnapShot{}
+			err = r.Get(context.Background(), client.ObjectKeyFromObject(snapshot), got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status.State != stove8sv1beta1.Failed || got.Status.Message != tt.message {
+				t.Errorf("expected the snapshot to fail with %q, got %s %q", tt.message, got.Status.State, got.Status.Message)
+			}
+		})
+	}
+}
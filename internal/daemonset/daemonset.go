@@ -15,52 +15,322 @@ import (
 	"syscall"
 	"time"
 
+	"bud.studio/stove8s/internal/daemonset/resources/checkpoints"
+	"bud.studio/stove8s/internal/daemonset/resources/keys"
+	"bud.studio/stove8s/internal/daemonset/resources/mirror"
 	"bud.studio/stove8s/internal/daemonset/resources/oci"
+	"bud.studio/stove8s/internal/daemonset/resources/prefetch"
+	"bud.studio/stove8s/internal/daemonset/resources/reclaim"
+	"bud.studio/stove8s/internal/daemonset/resources/signals"
+	"bud.studio/stove8s/internal/k8s"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	podNameSpacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	daemonsetName    = "stove8s-daemonset"
 )
 
 type Config struct {
 	Host string `toml:"host"`
 	Port uint   `toml:"port"`
+	// RegistryPort is where /v2 is served, on its own listener so only the
+	// registry is exposed on the node hostPort
+	RegistryPort uint `toml:"registryPort"`
+	// DecryptionKeysPath is where the container runtime looks for ocicrypt keys
+	DecryptionKeysPath string `toml:"decryptionKeysPath"`
+	// HostRootPath is where the node files the daemonset reads are mounted,
+	// at their node paths: the os-release, the CRI socket and the decryption
+	// keys directories
+	HostRootPath string `toml:"hostRootPath"`
+	// ExportPath is where offline exports are written, usually a PVC or hostPath
+	ExportPath string `toml:"exportPath"`
+	// ContainerdSocketPath is the node containerd socket, for local imports
+	ContainerdSocketPath string `toml:"containerdSocketPath"`
+	// CRISocketPath is the node CRI socket images are prefetched through and
+	// container PIDs are looked up with, it's detected under HostRootPath when empty
+	CRISocketPath string `toml:"criSocketPath"`
+	// Mirror serves /v2 as a registry mirror of the node container runtime,
+	// blobs are shared with the other daemonsets
+	Mirror bool `toml:"mirror"`
+	// BlobCachePath is where the mirrored blobs are cached
+	BlobCachePath string `toml:"blobCachePath"`
+	// BlobCacheMaxSize is the quantity the least recently used blobs are
+	// evicted past, 0 never evicts
+	BlobCacheMaxSize string `toml:"blobCacheMaxSize"`
+	// MirrorPullSecret is a Secret of the daemonset namespace the upstream
+	// registries are pulled with. The mirror serves the private images to
+	// anyone reaching it, it must be kept node-local
+	MirrorPullSecret string `toml:"mirrorPullSecret"`
+	// CheckpointRegistry serves the checkpoint archives the SnapShots of the
+	// node exported as images under /v2/checkpoints, read-only, to the tokens
+	// allowed to get the SnapShot
+	CheckpointRegistry bool `toml:"checkpointRegistry"`
+	// KubeletCheckpointPath is the kubelet checkpoint directory
+	KubeletCheckpointPath string `toml:"kubeletCheckpointPath"`
+	// NodeName is the node the daemonset runs on, the checkpoint registry
+	// serves the SnapShots taken on it
+	NodeName string `toml:"nodeName"`
+	// Signals serves /signals, the daemonset must then share the host PID
+	// namespace and have the KILL capability
+	Signals bool `toml:"signals"`
+	// Reclaim serves /reclaim, the node cgroup v2 filesystem must then be
+	// mounted writable at CgroupPath
+	Reclaim bool `toml:"reclaim"`
+	// CgroupPath is where the node cgroup v2 filesystem is mounted writable,
+	// the page cache of containers is reclaimed through it
+	CgroupPath string `toml:"cgroupPath"`
+	// ControllerServiceAccount is the service account of the controller in the
+	// daemonset namespace, only its tokens are let through the node resources
+	ControllerServiceAccount string `toml:"controllerServiceAccount"`
+	// DaemonsetServiceAccount is the service account of the daemonset, the
+	// mirror peers authenticate with its tokens
+	DaemonsetServiceAccount string `toml:"daemonsetServiceAccount"`
 }
 
-func routerInit() (*chi.Mux, error) {
+// +kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// routerInit returns the router of the node resources, and the one of /v2
+// when the mirror or the checkpoint registry is enabled
+func routerInit(config *Config) (*chi.Mux, *chi.Mux, error) {
 	router := chi.NewRouter()
 
-	router.Use(middleware.Timeout(time.Second))
 	router.Use(middlewareServerHeader)
 	router.Use(middleware.Recoverer)
 
-	ociHandler, err := oci.Resource{}.Init()
+	namespace, err := os.ReadFile(podNameSpacePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	k8sClient, err := k8s.ClientInit()
+	if err != nil {
+		return nil, nil, err
+	}
+	reviewer := k8s.NewTokenReviewer(k8sClient)
+	// NOTE: the node resources and the mirror peers are served over plain
+	// HTTP, they're called with tokens scoped to the daemonsets the API
+	// server refuses
+	daemonsetReviewer := k8s.NewTokenReviewer(k8sClient, k8s.DaemonsetAudience)
+	controllerAuth := middlewareControllerAuth(
+		daemonsetReviewer,
+		k8s.ServiceAccountUsername(string(namespace), config.ControllerServiceAccount),
+	)
+
+	ociHandler, err := oci.Resource{
+		HostRoot:         config.HostRootPath,
+		ExportDir:        config.ExportPath,
+		ContainerdSocket: config.ContainerdSocketPath,
+		CRISocket:        config.CRISocketPath,
+	}.Init()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	router.Route("/oci", func(r chi.Router) {
 		r.Use(middleware.Logger)
+		r.Use(controllerAuth)
 		r.Mount("/", ociHandler)
 	})
 
-	router.HandleFunc("/healthz", func(rw http.ResponseWriter, req *http.Request) {
-		rw.Header().Set("Content-Type", "text/plain")
-		rw.WriteHeader(http.StatusOK)
-		_, err := rw.Write([]byte("OK"))
+	keysHandler, err := keys.Resource{
+		Dir:      config.DecryptionKeysPath,
+		HostRoot: config.HostRootPath,
+	}.Init()
+	if err != nil {
+		return nil, nil, err
+	}
+	router.Route("/keys", func(r chi.Router) {
+		r.Use(middleware.Timeout(time.Second))
+		r.Use(middleware.Logger)
+		r.Use(controllerAuth)
+		r.Mount("/", keysHandler)
+	})
+
+	prefetchHandler, err := prefetch.Resource{
+		HostRoot:  config.HostRootPath,
+		CRISocket: config.CRISocketPath,
+	}.Init()
+	if err != nil {
+		return nil, nil, err
+	}
+	router.Route("/prefetch", func(r chi.Router) {
+		r.Use(middleware.Timeout(time.Second))
+		r.Use(middleware.Logger)
+		r.Use(controllerAuth)
+		r.Mount("/", prefetchHandler)
+	})
+
+	if config.Signals {
+		snapshotClient, err := k8s.SnapShotClientInit()
+		if err != nil {
+			return nil, nil, err
+		}
+		signalsHandler, err := signals.Resource{
+			HostRoot:  config.HostRootPath,
+			CRISocket: config.CRISocketPath,
+			SnapShots: snapshotClient,
+		}.Init()
 		if err != nil {
-			slog.Error("Writing response", "err", err.Error())
+			return nil, nil, err
+		}
+		router.Route("/signals", func(r chi.Router) {
+			r.Use(middleware.Timeout(10 * time.Second))
+			r.Use(middleware.Logger)
+			r.Use(controllerAuth)
+			r.Mount("/", signalsHandler)
+		})
+	}
+
+	if config.Reclaim {
+		reclaimHandler, err := reclaim.Resource{CgroupRoot: config.CgroupPath}.Init()
+		if err != nil {
+			return nil, nil, err
+		}
+		router.Route("/reclaim", func(r chi.Router) {
+			r.Use(middleware.Timeout(30 * time.Second))
+			r.Use(middleware.Logger)
+			r.Use(controllerAuth)
+			r.Mount("/", reclaimHandler)
+		})
+	}
+
+	router.With(middleware.Timeout(time.Second)).HandleFunc("/healthz", healthz)
+
+	var mirrorHandler, checkpointsHandler chi.Router
+	var checkpointsCatalog http.HandlerFunc
+	if config.Mirror {
+		cacheMaxSize, err := resource.ParseQuantity(config.BlobCacheMaxSize)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing the blob cache max size: %w", err)
+		}
+		mirrorHandler, err = mirror.Resource{
+			CacheDir:     config.BlobCachePath,
+			CacheMaxSize: cacheMaxSize.Value(),
+			Namespace:    string(namespace),
+			DaemonSet:    daemonsetName,
+			Port:         config.RegistryPort,
+			PullSecret:   config.MirrorPullSecret,
+			Reviewer:     daemonsetReviewer,
+			PeerUsername: k8s.ServiceAccountUsername(string(namespace), config.DaemonsetServiceAccount),
+			Tokens: k8s.NewTokenRequester(
+				k8sClient,
+				string(namespace),
+				config.DaemonsetServiceAccount,
+				k8s.DaemonsetAudience,
+			),
+		}.Init()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if config.CheckpointRegistry {
+		if config.NodeName == "" {
+			return nil, nil, fmt.Errorf("the checkpoint registry needs the node name")
+		}
+		snapshotClient, err := k8s.SnapShotClientInit()
+		if err != nil {
+			return nil, nil, err
+		}
+		checkpointsResource := checkpoints.Resource{
+			Dir:            config.KubeletCheckpointPath,
+			HostRoot:       config.HostRootPath,
+			CRISocket:      config.CRISocketPath,
+			NodeName:       config.NodeName,
+			SnapShots:      snapshotClient,
+			Reviewer:       reviewer,
+			AccessReviewer: k8s.NewAccessReviewer(k8sClient),
+		}
+		checkpointsHandler, err = checkpointsResource.Init()
+		if err != nil {
+			return nil, nil, err
+		}
+		checkpointsCatalog = checkpointsResource.Catalog
+	}
+	if mirrorHandler == nil && checkpointsHandler == nil {
+		return router, nil, nil
+	}
+
+	registryRouter := chi.NewRouter()
+	registryRouter.Use(middlewareServerHeader)
+	registryRouter.Use(middleware.Recoverer)
+	// NOTE: blobs are streamed for as long as they take to fetch and send
+	registryRouter.Route("/v2", func(r chi.Router) {
+		r.Use(middleware.Logger)
+		if mirrorHandler == nil {
+			// NOTE: the container runtimes pull from the mirror anonymously
+			r.With(checkpoints.Challenge).Get("/", registryVersion)
+		} else {
+			r.Get("/", registryVersion)
+		}
+		if checkpointsHandler != nil {
+			r.Get("/_catalog", checkpointsCatalog)
+			r.Mount("/checkpoints", checkpointsHandler)
+		}
+		if mirrorHandler != nil {
+			r.Mount("/", mirrorHandler)
 		}
 	})
 
-	return router, nil
+	return router, registryRouter, nil
+}
+
+func healthz(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain")
+	rw.WriteHeader(http.StatusOK)
+	_, err := rw.Write([]byte("OK"))
+	if err != nil {
+		slog.Error("Writing response", "err", err.Error())
+	}
+}
+
+// registryVersion is the OCI distribution API version check clients start with
+func registryVersion(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	rw.Header().Set("Content-Type", "application/json")
+	_, err := rw.Write([]byte("{}"))
+	if err != nil {
+		slog.Error("Writing response", "err", err.Error())
+	}
 }
 
 func configInit() *Config {
 	config := Config{
-		Host: "::",
-		Port: 8008,
+		Host:                     "::",
+		Port:                     8008,
+		RegistryPort:             5000,
+		HostRootPath:             "/host",
+		ExportPath:               "/var/lib/stove8s/exports",
+		ContainerdSocketPath:     "/run/containerd/containerd.sock",
+		BlobCachePath:            "/var/lib/stove8s/blobs",
+		BlobCacheMaxSize:         "20Gi",
+		KubeletCheckpointPath:    "/var/lib/kubelet/checkpoints",
+		CgroupPath:               "/sys/fs/cgroup",
+		ControllerServiceAccount: "stove8s-controller-manager",
+		DaemonsetServiceAccount:  "stove8s-daemonset",
 	}
 
 	flag.StringVar(&config.Host, "host", config.Host, "Bind host")
 	flag.UintVar(&config.Port, "port", config.Port, "Bind port")
+	flag.UintVar(&config.RegistryPort, "registry-port", config.RegistryPort, "Registry mirror and checkpoint registry bind port")
+	flag.StringVar(&config.DecryptionKeysPath, "decryption-keys-path", config.DecryptionKeysPath, "Container runtime decryption keys directory, detected under the host root when empty")
+	flag.StringVar(&config.HostRootPath, "host-root-path", config.HostRootPath, "Where the node os-release, CRI socket and decryption keys directories are mounted")
+	flag.StringVar(&config.ExportPath, "export-path", config.ExportPath, "Offline exports directory")
+	flag.StringVar(&config.ContainerdSocketPath, "containerd-socket-path", config.ContainerdSocketPath, "Node containerd socket")
+	flag.StringVar(&config.CRISocketPath, "cri-socket-path", config.CRISocketPath, "Node CRI socket, detected under the host root when empty")
+	flag.BoolVar(&config.Mirror, "mirror", config.Mirror, "Serve a registry mirror sharing blobs with the other daemonsets")
+	flag.StringVar(&config.BlobCachePath, "blob-cache-path", config.BlobCachePath, "Registry mirror blob cache directory")
+	flag.StringVar(&config.BlobCacheMaxSize, "blob-cache-max-size", config.BlobCacheMaxSize, "Registry mirror blob cache size the least recently used blobs are evicted past, 0 never evicts")
+	flag.StringVar(&config.MirrorPullSecret, "mirror-pull-secret", config.MirrorPullSecret, "Secret the registry mirror pulls upstream images with")
+	flag.BoolVar(&config.CheckpointRegistry, "checkpoint-registry", config.CheckpointRegistry, "Serve the checkpoint archives of the node SnapShots as images")
+	flag.StringVar(&config.KubeletCheckpointPath, "kubelet-checkpoint-path", config.KubeletCheckpointPath, "Kubelet checkpoint directory")
+	flag.StringVar(&config.NodeName, "node-name", config.NodeName, "Node the daemonset runs on, the checkpoint registry serves the SnapShots taken on it")
+	flag.BoolVar(&config.Signals, "signals", config.Signals, "Serve the signal hooks, through the host PID namespace")
+	flag.BoolVar(&config.Reclaim, "reclaim", config.Reclaim, "Serve the page cache reclaim, through the writable node cgroup filesystem")
+	flag.StringVar(&config.CgroupPath, "cgroup-path", config.CgroupPath, "Node cgroup v2 filesystem mount")
+	flag.StringVar(&config.ControllerServiceAccount, "controller-service-account", config.ControllerServiceAccount, "Controller service account allowed to use the node resources")
+	flag.StringVar(&config.DaemonsetServiceAccount, "daemonset-service-account", config.DaemonsetServiceAccount, "Daemonset service account the registry mirror peers authenticate with")
 	flag.Parse()
 
 	return &config
@@ -70,16 +340,24 @@ func Run() {
 	config := configInit()
 	serverCtx, serverCtxCancel := context.WithCancel(context.Background())
 
-	router, err := routerInit()
+	router, registryRouter, err := routerInit(config)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	srv := &http.Server{
+	servers := []*http.Server{{
 		Addr:         net.JoinHostPort(config.Host, fmt.Sprint(config.Port)),
 		WriteTimeout: 4 * time.Second,
 		ReadTimeout:  4 * time.Second,
 		Handler:      router,
+	}}
+	if registryRouter != nil {
+		servers = append(servers, &http.Server{
+			Addr:         net.JoinHostPort(config.Host, fmt.Sprint(config.RegistryPort)),
+			WriteTimeout: 4 * time.Second,
+			ReadTimeout:  4 * time.Second,
+			Handler:      registryRouter,
+		})
 	}
 
 	sig := make(chan os.Signal, 1)
@@ -95,19 +373,25 @@ func Run() {
 			}
 		}()
 
-		err := srv.Shutdown(shutdownCtx)
-		if err != nil {
-			log.Fatal(err)
+		for _, srv := range servers {
+			err := srv.Shutdown(shutdownCtx)
+			if err != nil {
+				log.Fatal(err)
+			}
 		}
 
 		shutdownCtxCancel()
 		serverCtxCancel()
 	}()
 
-	slog.Info("stove8s-daemonset HTTP server starting", "addr", srv.Addr)
-	err = srv.ListenAndServe()
-	if err != nil && err != http.ErrServerClosed {
-		log.Fatal(err)
+	for _, srv := range servers {
+		go func() {
+			slog.Info("stove8s-daemonset HTTP server starting", "addr", srv.Addr)
+			err := srv.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
 	}
 	<-serverCtx.Done()
 }
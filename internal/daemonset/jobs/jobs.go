@@ -0,0 +1,58 @@
+package jobs
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Store holds the statuses of the jobs of a resource, they're updated by the
+// job goroutines while the handlers read them
+type Store[T any] struct {
+	mu       sync.RWMutex
+	statuses map[uuid.UUID]*T
+}
+
+func NewStore[T any]() *Store[T] {
+	return &Store[T]{statuses: make(map[uuid.UUID]*T)}
+}
+
+// Set adds or replaces the status of id
+func (s *Store[T]) Set(id uuid.UUID, status T) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[id] = &status
+}
+
+// Update runs fn on the status of id, it's a no-op for unknown jobs
+func (s *Store[T]) Update(id uuid.UUID, fn func(*T)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status, ok := s.statuses[id]
+	if ok {
+		fn(status)
+	}
+}
+
+// Get returns a copy of the status of id
+func (s *Store[T]) Get(id uuid.UUID) (T, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status, ok := s.statuses[id]
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	return *status, true
+}
+
+// List returns a copy of every status
+func (s *Store[T]) List() map[uuid.UUID]T {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make(map[uuid.UUID]T, len(s.statuses))
+	for id, status := range s.statuses {
+		res[id] = *status
+	}
+	return res
+}
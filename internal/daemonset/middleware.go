@@ -1,8 +1,12 @@
 package daemonset
 
 import (
+	"errors"
+	"log/slog"
 	"net/http"
+	"strings"
 
+	"bud.studio/stove8s/internal/k8s"
 	"bud.studio/stove8s/internal/version"
 )
 
@@ -12,3 +16,34 @@ func middlewareServerHeader(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// middlewareControllerAuth only lets through requests bearing a token of the
+// controller service account, the resources behind it act on any pod and
+// secret of the node
+func middlewareControllerAuth(reviewer *k8s.TokenReviewer, username string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			user, err := reviewer.Review(r.Context(), token)
+			if errors.Is(err, k8s.ErrUnauthenticated) {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				slog.Error("Authenticating request", "err", err)
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+			if user.Username != username {
+				slog.Warn("Refusing request", "user", user.Username, "path", r.URL.Path)
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
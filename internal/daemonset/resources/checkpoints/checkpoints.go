@@ -0,0 +1,363 @@
+package checkpoints
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	"bud.studio/stove8s/internal/k8s"
+	"bud.studio/stove8s/internal/oci"
+	"github.com/go-chi/chi/v5"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"golang.org/x/sync/singleflight"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Tag is the only tag of every checkpoint repository
+const Tag = "latest"
+
+// Resource is a read-only OCI distribution API serving the checkpoint
+// archives the SnapShots of this node exported as images, built on the fly
+// the way checkpoints are built before a push. The repositories are
+// checkpoints/<namespace>/<snapshot>, with /containers/<container> appended
+// for the containers of a pod and /platforms/<pod> for the platforms of a
+// workload, they're pulled with a token allowed to get the SnapShot
+type Resource struct {
+	// Dir is the kubelet checkpoint directory
+	Dir string
+	// HostRoot is where the node paths are mounted, the os-release and CRI
+	// socket are looked up under it
+	HostRoot string
+	// CRISocket is the node CRI socket the engine version is asked to, it's
+	// detected under HostRoot when unset
+	CRISocket string
+	// NodeName is the node the daemonset runs on
+	NodeName string
+	// SnapShots is the client the SnapShots are listed with
+	SnapShots client.Reader
+	// Reviewer authenticates the pull tokens
+	Reviewer *k8s.TokenReviewer
+	// AccessReviewer checks the pull tokens can get the SnapShot
+	AccessReviewer *k8s.AccessReviewer
+
+	mu     *sync.Mutex
+	images map[string]*image
+	builds *singleflight.Group
+}
+
+// image is built once per archive and SnapShot generation, the layers are
+// read from the archive, or from tempDir when encrypted, every time they're
+// served
+type image struct {
+	archivePath string
+	modTime     time.Time
+	generation  int64
+	tempDir     string
+	img         v1.Image
+}
+
+// checkpoint is an archive a SnapShot exported on this node
+type checkpoint struct {
+	namespace   string
+	archivePath string
+	generation  int64
+	encryption  oci.EncryptionRecipients
+}
+
+func (rs Resource) Init() (chi.Router, error) {
+	rs.mu = &sync.Mutex{}
+	rs.images = make(map[string]*image)
+	rs.builds = &singleflight.Group{}
+
+	r := chi.NewRouter()
+
+	// NOTE: the repository names have several components
+	r.Get("/*", rs.Get)
+	r.Head("/*", rs.Get)
+
+	return r, nil
+}
+
+type registryErrors struct {
+	Errors []registryError `json:"errors"`
+}
+
+type registryError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func errorWrite(rw http.ResponseWriter, status int, code string, message string) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(registryErrors{
+		Errors: []registryError{{Code: code, Message: message}},
+	})
+}
+
+// Challenge asks the clients without credentials for them, registry clients
+// pick the auth scheme from the /v2/ version check
+func Challenge(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") == "" {
+			unauthorizedWrite(rw)
+			return
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+func unauthorizedWrite(rw http.ResponseWriter) {
+	rw.Header().Set("WWW-Authenticate", `Basic realm="stove8s"`)
+	errorWrite(rw, http.StatusUnauthorized, "UNAUTHORIZED", "a token allowed to get the SnapShot is required")
+}
+
+// authenticate returns the user of the request token, sent as bearer or as
+// the basic auth password, it writes the error response when it fails
+func (rs Resource) authenticate(rw http.ResponseWriter, req *http.Request) (authenticationv1.UserInfo, bool) {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, token, _ = req.BasicAuth()
+	}
+	user, err := rs.Reviewer.Review(req.Context(), token)
+	if errors.Is(err, k8s.ErrUnauthenticated) {
+		unauthorizedWrite(rw)
+		return user, false
+	}
+	if err != nil {
+		slog.Error("Authenticating request", "err", err)
+		errorWrite(rw, http.StatusServiceUnavailable, "UNAVAILABLE", "authentication is unavailable")
+		return user, false
+	}
+	return user, true
+}
+
+// authorize checks user can get the SnapShots of namespace
+func (rs Resource) authorize(ctx context.Context, user authenticationv1.UserInfo, namespace string) error {
+	return rs.AccessReviewer.Review(ctx, user, authorizationv1.ResourceAttributes{
+		Namespace: namespace,
+		Verb:      "get",
+		Group:     stove8sv1beta1.GroupVersion.Group,
+		Resource:  "snapshots",
+	})
+}
+
+// repositories maps the repository names of the checkpoints snapshot exported
+// on nodeName to their node paths. SnapShot names are unique in a namespace
+// and can't hold a '/', the per-container and per-platform repositories are
+// two components deeper so they can't collide with another SnapShot
+func repositories(snapshot *stove8sv1beta1.SnapShot, nodeName string) map[string]string {
+	prefix := snapshot.Namespace + "/" + snapshot.Name
+	res := make(map[string]string)
+	if snapshot.Status.Node.Name == nodeName {
+		if snapshot.Status.CheckPointNodePath != "" {
+			res[prefix] = snapshot.Status.CheckPointNodePath
+		}
+		for _, container := range snapshot.Status.Containers {
+			if container.CheckPointNodePath != "" {
+				res[prefix+"/containers/"+container.Name] = container.CheckPointNodePath
+			}
+		}
+	}
+	for _, platform := range snapshot.Status.Platforms {
+		if platform.Node.Name == nodeName && platform.CheckPointNodePath != "" {
+			res[prefix+"/platforms/"+platform.Pod] = platform.CheckPointNodePath
+		}
+	}
+	return res
+}
+
+// checkpoints maps the repository names to the archives the SnapShots
+// exported on this node
+func (rs Resource) checkpoints(ctx context.Context) (map[string]checkpoint, error) {
+	var snapshots stove8sv1beta1.SnapShotList
+	err := rs.SnapShots.List(ctx, &snapshots)
+	if err != nil {
+		return nil, fmt.Errorf("listing SnapShots: %w", err)
+	}
+
+	res := make(map[string]checkpoint)
+	for i := range snapshots.Items {
+		snapshot := &snapshots.Items[i]
+		var encryption oci.EncryptionRecipients
+		if snapshot.Spec.Output.Encryption != nil {
+			for _, recipient := range snapshot.Spec.Output.Encryption.Recipients {
+				switch recipient.Protocol {
+				case stove8sv1beta1.JWE:
+					encryption.JWE = append(encryption.JWE, []byte(recipient.PublicKey))
+				case stove8sv1beta1.PKCS7:
+					encryption.PKCS7 = append(encryption.PKCS7, []byte(recipient.PublicKey))
+				}
+			}
+		}
+		for name, nodePath := range repositories(snapshot, rs.NodeName) {
+			// NOTE: only the archives of the kubelet checkpoint directory are
+			// served, wherever the status says they are
+			res[name] = checkpoint{
+				namespace:   snapshot.Namespace,
+				archivePath: filepath.Join(rs.Dir, filepath.Base(nodePath)),
+				generation:  snapshot.Generation,
+				encryption:  encryption,
+			}
+		}
+	}
+	return res, nil
+}
+
+// evict drops the images of the checkpoints that aren't served anymore
+func (rs Resource) evict(checkpoints map[string]checkpoint) {
+	rs.mu.Lock()
+	var evicted []*image
+	for name, cached := range rs.images {
+		if _, ok := checkpoints[name]; !ok {
+			delete(rs.images, name)
+			evicted = append(evicted, cached)
+		}
+	}
+	rs.mu.Unlock()
+
+	for _, cached := range evicted {
+		cached.remove()
+	}
+}
+
+// remove deletes the encrypted layers of the image, the ones being served
+// are still read from their open files
+func (cached *image) remove() {
+	if cached.tempDir == "" {
+		return
+	}
+	err := os.RemoveAll(cached.tempDir)
+	if err != nil {
+		slog.Error("Removing encrypted checkpoint layers", "err", err)
+	}
+}
+
+// image returns the image of the checkpoint named name, it's rebuilt when the
+// archive or the SnapShot changed since
+func (rs Resource) image(ctx context.Context, name string) (v1.Image, error) {
+	checkpoints, err := rs.checkpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rs.evict(checkpoints)
+	checkpoint, ok := checkpoints[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	stat, err := os.Stat(checkpoint.archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	rs.mu.Lock()
+	cached, ok := rs.images[name]
+	rs.mu.Unlock()
+	if ok && cached.archivePath == checkpoint.archivePath &&
+		cached.modTime.Equal(stat.ModTime()) && cached.generation == checkpoint.generation {
+		return cached.img, nil
+	}
+
+	built, err, _ := rs.builds.Do(name, func() (any, error) {
+		encrypted := len(checkpoint.encryption.JWE) > 0 || len(checkpoint.encryption.PKCS7) > 0
+		var tempDir string
+		if encrypted {
+			dir, err := os.MkdirTemp("", "stove8s-checkpoint-")
+			if err != nil {
+				return nil, err
+			}
+			tempDir = dir
+		}
+		// NOTE: uncompressed layers are digested without compressing the
+		// whole archive first, this API is meant for nearby nodes
+		img, err := oci.BuildImage(checkpoint.archivePath, oci.BuildOptions{
+			Compression: stove8sv1beta1.SnapShotOutputCompression{
+				Algorithm: stove8sv1beta1.Uncompressed,
+			},
+			Encryption: checkpoint.encryption,
+			TempDir:    tempDir,
+			Host:       oci.HostInfoCollect(rs.HostRoot, rs.CRISocket, ""),
+		})
+		built := &image{
+			archivePath: checkpoint.archivePath,
+			modTime:     stat.ModTime(),
+			generation:  checkpoint.generation,
+			tempDir:     tempDir,
+			img:         img,
+		}
+		if err != nil {
+			built.remove()
+			return nil, err
+		}
+
+		rs.mu.Lock()
+		previous := rs.images[name]
+		rs.images[name] = built
+		rs.mu.Unlock()
+		if previous != nil {
+			previous.remove()
+		}
+		return img, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return built.(v1.Image), nil
+}
+
+type catalogResp struct {
+	Repositories []string `json:"repositories"`
+}
+
+// Catalog lists the checkpoint repositories the token can pull, it's served
+// at /v2/_catalog
+func (rs Resource) Catalog(rw http.ResponseWriter, req *http.Request) {
+	user, ok := rs.authenticate(rw, req)
+	if !ok {
+		return
+	}
+	checkpoints, err := rs.checkpoints(req.Context())
+	if err != nil {
+		slog.Error("Listing checkpoints", "err", err)
+		errorWrite(rw, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+
+	allowed := make(map[string]bool)
+	resp := catalogResp{Repositories: []string{}}
+	for name, checkpoint := range checkpoints {
+		namespaceAllowed, ok := allowed[checkpoint.namespace]
+		if !ok {
+			err := rs.authorize(req.Context(), user, checkpoint.namespace)
+			if err != nil && !errors.Is(err, k8s.ErrForbidden) {
+				slog.Error("Authorizing request", "err", err)
+				errorWrite(rw, http.StatusServiceUnavailable, "UNAVAILABLE", "authorization is unavailable")
+				return
+			}
+			namespaceAllowed = err == nil
+			allowed[checkpoint.namespace] = namespaceAllowed
+		}
+		if namespaceAllowed {
+			resp.Repositories = append(resp.Repositories, "checkpoints/"+name)
+		}
+	}
+	slices.Sort(resp.Repositories)
+
+	rw.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(rw).Encode(resp)
+	if err != nil {
+		slog.Error("Writing response", "err", err.Error())
+	}
+}
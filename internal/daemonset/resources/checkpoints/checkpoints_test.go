@@ -0,0 +1,287 @@
+package checkpoints
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	"bud.studio/stove8s/internal/k8s"
+	"github.com/google/go-containerregistry/pkg/name"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testSnapShot(namespace, snapshotName string, status stove8sv1beta1.SnapShotStatus) *stove8sv1beta1.SnapShot {
+	return &stove8sv1beta1.SnapShot{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: snapshotName},
+		Status:     status,
+	}
+}
+
+func TestRepositories(t *testing.T) {
+	node := stove8sv1beta1.SnapShotStatusNode{Name: "node-a"}
+	for _, tc := range []struct {
+		name     string
+		snapshot *stove8sv1beta1.SnapShot
+		expected map[string]string
+	}{
+		{
+			name: "pod",
+			snapshot: testSnapShot("default", "web", stove8sv1beta1.SnapShotStatus{
+				Node:               node,
+				CheckPointNodePath: "/var/lib/kubelet/checkpoints/checkpoint-web.tar",
+			}),
+			expected: map[string]string{"default/web": "/var/lib/kubelet/checkpoints/checkpoint-web.tar"},
+		},
+		{
+			name: "other node",
+			snapshot: testSnapShot("default", "web", stove8sv1beta1.SnapShotStatus{
+				Node:               stove8sv1beta1.SnapShotStatusNode{Name: "node-b"},
+				CheckPointNodePath: "/var/lib/kubelet/checkpoints/checkpoint-web.tar",
+			}),
+			expected: map[string]string{},
+		},
+		{
+			name:     "not checkpointed yet",
+			snapshot: testSnapShot("default", "web", stove8sv1beta1.SnapShotStatus{Node: node}),
+			expected: map[string]string{},
+		},
+		{
+			name: "containers",
+			snapshot: testSnapShot("default", "web", stove8sv1beta1.SnapShotStatus{
+				Node: node,
+				Containers: []stove8sv1beta1.SnapShotStatusContainer{
+					{Name: "app", CheckPointNodePath: "/checkpoints/app.tar"},
+					{Name: "envoy", CheckPointNodePath: "/checkpoints/envoy.tar"},
+					{Name: "pending"},
+				},
+			}),
+			expected: map[string]string{
+				"default/web/containers/app":   "/checkpoints/app.tar",
+				"default/web/containers/envoy": "/checkpoints/envoy.tar",
+			},
+		},
+		{
+			name: "platforms",
+			snapshot: testSnapShot("prod", "api", stove8sv1beta1.SnapShotStatus{
+				Platforms: []stove8sv1beta1.SnapShotStatusPlatform{
+					{Architecture: "amd64", Pod: "api-x", Node: node, CheckPointNodePath: "/checkpoints/amd64.tar"},
+					{Architecture: "arm64", Pod: "api-y", Node: stove8sv1beta1.SnapShotStatusNode{Name: "node-b"}, CheckPointNodePath: "/checkpoints/arm64.tar"},
+				},
+			}),
+			expected: map[string]string{"prod/api/platforms/api-x": "/checkpoints/amd64.tar"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res := repositories(tc.snapshot, "node-a")
+			if !maps.Equal(res, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, res)
+			}
+			for repo := range res {
+				_, err := name.NewRepository("localhost:5000/checkpoints/" + repo)
+				if err != nil {
+					t.Errorf("invalid repository %s: %v", repo, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRepositoriesCollision(t *testing.T) {
+	// NOTE: archives of the same pod and time used to map to the same name
+	seen := map[string]string{}
+	for _, snapshot := range []*stove8sv1beta1.SnapShot{
+		testSnapShot("a-b", "c", stove8sv1beta1.SnapShotStatus{CheckPointNodePath: "/1.tar"}),
+		testSnapShot("a", "b-c", stove8sv1beta1.SnapShotStatus{CheckPointNodePath: "/2.tar"}),
+		testSnapShot("a", "b", stove8sv1beta1.SnapShotStatus{CheckPointNodePath: "/3.tar", Containers: []stove8sv1beta1.SnapShotStatusContainer{
+			{Name: "c", CheckPointNodePath: "/4.tar"},
+		}}),
+		testSnapShot("a", "containers", stove8sv1beta1.SnapShotStatus{CheckPointNodePath: "/5.tar"}),
+	} {
+		for repo, nodePath := range repositories(snapshot, "") {
+			if other, ok := seen[repo]; ok {
+				t.Errorf("%s and %s are both %s", other, nodePath, repo)
+			}
+			seen[repo] = nodePath
+		}
+	}
+	if len(seen) != 5 {
+		t.Errorf("expected 5 repositories, got %v", seen)
+	}
+}
+
+func testResource(t *testing.T) http.Handler {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	err := stove8sv1beta1.AddToScheme(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshots := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		testSnapShot("team-a", "web", stove8sv1beta1.SnapShotStatus{
+			Node:               stove8sv1beta1.SnapShotStatusNode{Name: "node-a"},
+			CheckPointNodePath: "/var/lib/kubelet/checkpoints/checkpoint-web.tar",
+		}),
+		testSnapShot("team-b", "db", stove8sv1beta1.SnapShotStatus{
+			Node:               stove8sv1beta1.SnapShotStatusNode{Name: "node-a"},
+			CheckPointNodePath: "/var/lib/kubelet/checkpoints/checkpoint-db.tar",
+		}),
+	).Build()
+
+	clientset := kubefake.NewClientset()
+	clientset.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == "alice-token" {
+			review.Status.Authenticated = true
+			review.Status.User.Username = "alice"
+		}
+		return true, review, nil
+	})
+	clientset.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attributes := review.Spec.ResourceAttributes
+		review.Status.Allowed = review.Spec.User == "alice" && attributes.Namespace == "team-a" &&
+			attributes.Verb == "get" && attributes.Resource == "snapshots"
+		return true, review, nil
+	})
+
+	rs := Resource{
+		// NOTE: empty, the archives aren't built
+		Dir:            t.TempDir(),
+		NodeName:       "node-a",
+		SnapShots:      snapshots,
+		Reviewer:       k8s.NewTokenReviewer(clientset),
+		AccessReviewer: k8s.NewAccessReviewer(clientset),
+	}
+	handler, err := rs.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/v2/checkpoints/", http.StripPrefix("/v2/checkpoints", handler))
+	mux.HandleFunc("/v2/_catalog", rs.Catalog)
+	return mux
+}
+
+func TestAccess(t *testing.T) {
+	handler := testResource(t)
+	for _, tc := range []struct {
+		name     string
+		path     string
+		auth     func(req *http.Request)
+		expected int
+	}{
+		{
+			name:     "anonymous",
+			path:     "/v2/checkpoints/team-a/web/tags/list",
+			auth:     func(req *http.Request) {},
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name:     "unknown token",
+			path:     "/v2/checkpoints/team-a/web/tags/list",
+			auth:     func(req *http.Request) { req.Header.Set("Authorization", "Bearer forged") },
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name:     "bearer",
+			path:     "/v2/checkpoints/team-a/web/tags/list",
+			auth:     func(req *http.Request) { req.Header.Set("Authorization", "Bearer alice-token") },
+			expected: http.StatusOK,
+		},
+		{
+			name:     "basic",
+			path:     "/v2/checkpoints/team-a/web/tags/list",
+			auth:     func(req *http.Request) { req.SetBasicAuth("alice", "alice-token") },
+			expected: http.StatusOK,
+		},
+		{
+			name:     "other namespace",
+			path:     "/v2/checkpoints/team-b/db/tags/list",
+			auth:     func(req *http.Request) { req.SetBasicAuth("alice", "alice-token") },
+			expected: http.StatusForbidden,
+		},
+		{
+			name:     "unknown snapshot",
+			path:     "/v2/checkpoints/team-a/api/tags/list",
+			auth:     func(req *http.Request) { req.SetBasicAuth("alice", "alice-token") },
+			expected: http.StatusNotFound,
+		},
+		{
+			name:     "missing archive",
+			path:     "/v2/checkpoints/team-a/web/manifests/latest",
+			auth:     func(req *http.Request) { req.SetBasicAuth("alice", "alice-token") },
+			expected: http.StatusNotFound,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			tc.auth(req)
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, req)
+			if rw.Code != tc.expected {
+				t.Errorf("expected %d, got %d: %s", tc.expected, rw.Code, rw.Body.String())
+			}
+			if rw.Code == http.StatusUnauthorized && rw.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected a challenge")
+			}
+		})
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	handler := testResource(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/_catalog", nil)
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusUnauthorized {
+		t.Errorf("expected an anonymous catalog to be refused, got %d", rw.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v2/_catalog", nil)
+	req.SetBasicAuth("alice", "alice-token")
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	var resp catalogResp
+	err := json.NewDecoder(rw.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(resp.Repositories, []string{"checkpoints/team-a/web"}) {
+		t.Errorf("expected only the allowed namespace, got %v", resp.Repositories)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	tempDir := t.TempDir()
+	rs := Resource{
+		mu: &sync.Mutex{},
+		images: map[string]*image{
+			"team-a/web": {archivePath: "/web.tar"},
+			"team-a/old": {archivePath: "/old.tar", tempDir: tempDir},
+		},
+	}
+	rs.evict(map[string]checkpoint{"team-a/web": {archivePath: "/web.tar"}})
+
+	if _, ok := rs.images["team-a/web"]; !ok {
+		t.Error("expected the served checkpoint to be kept")
+	}
+	if _, ok := rs.images["team-a/old"]; ok {
+		t.Error("expected the deleted checkpoint to be evicted")
+	}
+	if _, err := os.Stat(tempDir); !os.IsNotExist(err) {
+		t.Errorf("expected the encrypted layers to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package checkpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"bud.studio/stove8s/internal/k8s"
+	"github.com/go-chi/chi/v5"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+type tagsResp struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+// Get routes the requests of a repository, its name is cut at the last
+// manifests or blobs component as references and digests have no '/'
+func (rs Resource) Get(rw http.ResponseWriter, req *http.Request) {
+	path := chi.URLParam(req, "*")
+	if name, ok := strings.CutSuffix(path, "/tags/list"); ok {
+		rs.Tags(rw, req, name)
+		return
+	}
+	if i := strings.LastIndex(path, "/manifests/"); i >= 0 {
+		rs.Manifest(rw, req, path[:i], path[i+len("/manifests/"):])
+		return
+	}
+	if i := strings.LastIndex(path, "/blobs/"); i >= 0 {
+		rs.Blob(rw, req, path[:i], path[i+len("/blobs/"):])
+		return
+	}
+	errorWrite(rw, http.StatusNotFound, "UNSUPPORTED", "only manifests, blobs and tags are served")
+}
+
+// access authenticates the request and checks it can get the SnapShots of
+// the namespace of name, before anything about name is disclosed
+func (rs Resource) access(rw http.ResponseWriter, req *http.Request, name string) bool {
+	user, ok := rs.authenticate(rw, req)
+	if !ok {
+		return false
+	}
+	namespace, _, _ := strings.Cut(name, "/")
+	err := rs.authorize(req.Context(), user, namespace)
+	if errors.Is(err, k8s.ErrForbidden) {
+		slog.Warn("Refusing request", "user", user.Username, "name", name)
+		errorWrite(rw, http.StatusForbidden, "DENIED", fmt.Sprintf("%s can't pull %s", user.Username, name))
+		return false
+	}
+	if err != nil {
+		slog.Error("Authorizing request", "err", err)
+		errorWrite(rw, http.StatusServiceUnavailable, "UNAVAILABLE", "authorization is unavailable")
+		return false
+	}
+	return true
+}
+
+func (rs Resource) imageGet(rw http.ResponseWriter, req *http.Request, name string) (v1.Image, bool) {
+	if !rs.access(rw, req, name) {
+		return nil, false
+	}
+	img, err := rs.image(req.Context(), name)
+	if os.IsNotExist(err) {
+		errorWrite(rw, http.StatusNotFound, "NAME_UNKNOWN", fmt.Sprintf("no checkpoint %s", name))
+		return nil, false
+	}
+	if err != nil {
+		slog.Error("Building checkpoint image", "name", name, "err", err)
+		errorWrite(rw, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return nil, false
+	}
+	return img, true
+}
+
+func (rs Resource) Tags(rw http.ResponseWriter, req *http.Request, name string) {
+	if !rs.access(rw, req, name) {
+		return
+	}
+	checkpoints, err := rs.checkpoints(req.Context())
+	if err != nil {
+		slog.Error("Listing checkpoints", "err", err)
+		errorWrite(rw, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	if _, ok := checkpoints[name]; !ok {
+		errorWrite(rw, http.StatusNotFound, "NAME_UNKNOWN", fmt.Sprintf("no checkpoint %s", name))
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(rw).Encode(tagsResp{
+		Name: "checkpoints/" + name,
+		Tags: []string{Tag},
+	})
+	if err != nil {
+		slog.Error("Writing response", "err", err.Error())
+	}
+}
+
+func (rs Resource) Manifest(rw http.ResponseWriter, req *http.Request, name string, reference string) {
+	img, ok := rs.imageGet(rw, req, name)
+	if !ok {
+		return
+	}
+	rawManifest, err := img.RawManifest()
+	if err != nil {
+		errorWrite(rw, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		errorWrite(rw, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	mediaType, err := img.MediaType()
+	if err != nil {
+		errorWrite(rw, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	if reference != Tag && reference != digest.String() {
+		errorWrite(rw, http.StatusNotFound, "MANIFEST_UNKNOWN", fmt.Sprintf("unknown manifest %s", reference))
+		return
+	}
+
+	rw.Header().Set("Content-Type", string(mediaType))
+	rw.Header().Set("Docker-Content-Digest", digest.String())
+	http.ServeContent(rw, req, "", time.Time{}, bytes.NewReader(rawManifest))
+}
+
+func (rs Resource) Blob(rw http.ResponseWriter, req *http.Request, name string, rawDigest string) {
+	img, ok := rs.imageGet(rw, req, name)
+	if !ok {
+		return
+	}
+	digest, err := v1.NewHash(rawDigest)
+	if err != nil {
+		errorWrite(rw, http.StatusBadRequest, "DIGEST_INVALID", err.Error())
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.Header().Set("Docker-Content-Digest", digest.String())
+
+	configName, err := img.ConfigName()
+	if err != nil {
+		errorWrite(rw, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	if digest == configName {
+		rawConfig, err := img.RawConfigFile()
+		if err != nil {
+			errorWrite(rw, http.StatusInternalServerError, "UNKNOWN", err.Error())
+			return
+		}
+		http.ServeContent(rw, req, "", time.Time{}, bytes.NewReader(rawConfig))
+		return
+	}
+
+	layer, err := img.LayerByDigest(digest)
+	if err != nil {
+		errorWrite(rw, http.StatusNotFound, "BLOB_UNKNOWN", err.Error())
+		return
+	}
+	size, err := layer.Size()
+	if err != nil {
+		errorWrite(rw, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	rw.Header().Set("Content-Length", fmt.Sprint(size))
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	rc, err := layer.Compressed()
+	if err != nil {
+		errorWrite(rw, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+	// NOTE: checkpoint layers are larger than the server write timeout allows
+	err = http.NewResponseController(rw).SetWriteDeadline(time.Time{})
+	if err != nil {
+		slog.Warn("Clearing write deadline", "err", err)
+	}
+	_, err = io.Copy(rw, rc)
+	if err != nil {
+		// NOTE: the status is already sent, the client fails the digest check
+		slog.Error("Writing checkpoint layer", "err", err)
+	}
+}
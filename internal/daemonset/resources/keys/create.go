@@ -0,0 +1,90 @@
+package keys
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"bud.studio/stove8s/internal/k8s"
+	"bud.studio/stove8s/internal/oci"
+	"github.com/go-playground/validator/v10"
+)
+
+type CreateReqSecret struct {
+	Name      string `json:"name" validate:"required"`
+	Namespace string `json:"namespace" validate:"required"`
+}
+
+type CreateReq struct {
+	Secret CreateReqSecret `json:"secret" validate:"required"`
+}
+
+func (rs Resource) Create(rw http.ResponseWriter, req *http.Request) {
+	var data CreateReq
+	err := json.NewDecoder(req.Body).Decode(&data)
+	if err != nil {
+		http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	err = validator.New().Struct(data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	secret, err := k8s.DecryptionKeySecretGet(rs.k8sClient, data.Secret.Namespace, data.Secret.Name)
+	if err != nil {
+		slog.Error("Getting decryption key secret", "err", err)
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dir := rs.Dir
+	if dir == "" {
+		dir, err = oci.DecryptionKeysDirDetect(rs.HostRoot)
+		if err != nil {
+			slog.Error("Detecting decryption keys directory", "err", err)
+			http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	for key, value := range secret.Data {
+		// NOTE: '_' isn't allowed in k8s names, so files of different secrets can't collide
+		name := fmt.Sprintf("%s_%s_%s", secret.Namespace, secret.Name, filepath.Base(key))
+		err := keyWrite(filepath.Join(dir, name), value)
+		if err != nil {
+			slog.Error("Writing decryption key", "err", err)
+			http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	slog.Info("Installed decryption keys", "secret", secret.Namespace+"/"+secret.Name)
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// keyWrite replaces path atomically, the runtime may read the directory anytime
+func keyWrite(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), ".key-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(file.Name())
+	}()
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// NOTE: os.CreateTemp already restricts the file to its owner
+	return os.Rename(file.Name(), path)
+}
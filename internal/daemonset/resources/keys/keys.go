@@ -0,0 +1,32 @@
+package keys
+
+import (
+	"bud.studio/stove8s/internal/k8s"
+	"github.com/go-chi/chi/v5"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Resource installs decryption keys in the directory the container runtime
+// reads them from, so encrypted checkpoint images can be restored on the node
+type Resource struct {
+	// Dir is the directory the container runtime reads the keys from, the
+	// default one of the runtime found under HostRoot is used when it's unset
+	Dir       string
+	HostRoot  string
+	k8sClient *kubernetes.Clientset
+}
+
+func (rs Resource) Init() (chi.Router, error) {
+	k8sClient, err := k8s.ClientInit()
+	if err != nil {
+		return nil, err
+	}
+
+	rs.k8sClient = k8sClient
+
+	r := chi.NewRouter()
+
+	r.Post("/", rs.Create)
+
+	return r, nil
+}
@@ -0,0 +1,244 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"bud.studio/stove8s/internal/k8s"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Get serves /<name>/blobs/<digest> and /<name>/manifests/<reference>,
+// manifests by tag always go to the upstream registry as tags move
+func (rs Resource) Get(rw http.ResponseWriter, req *http.Request) {
+	fromPeer := req.Header.Get(peerHeader) != ""
+	if fromPeer {
+		err := rs.peerAuthenticate(req)
+		if errors.Is(err, k8s.ErrUnauthenticated) {
+			slog.Warn("Refusing peer request", "err", err)
+			errorWrite(rw, http.StatusUnauthorized, "UNAUTHORIZED", "peer authentication required")
+			return
+		}
+		if err != nil {
+			slog.Error("Authenticating peer", "err", err)
+			http.Error(rw, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	path := chi.URLParam(req, "*")
+	kind := "blobs"
+	repoPath, reference, ok := strings.Cut(path, "/blobs/")
+	if !ok {
+		kind = "manifests"
+		repoPath, reference, ok = strings.Cut(path, "/manifests/")
+	}
+	if !ok {
+		errorWrite(rw, http.StatusNotFound, "UNSUPPORTED", "only blobs and manifests are mirrored")
+		return
+	}
+	repo, err := repository(repoPath, req.URL.Query().Get("ns"))
+	if err != nil {
+		errorWrite(rw, http.StatusNotFound, "NAME_UNKNOWN", err.Error())
+		return
+	}
+
+	digest, err := v1.NewHash(reference)
+	if err != nil {
+		if kind == "blobs" {
+			errorWrite(rw, http.StatusBadRequest, "DIGEST_INVALID", err.Error())
+			return
+		}
+		rs.manifestProxy(rw, req, repo.Tag(reference))
+		return
+	}
+
+	file, err := rs.cache.Open(digest)
+	if os.IsNotExist(err) {
+		err = rs.fetch(req.Context(), repo, kind, digest, fromPeer)
+		if err != nil {
+			slog.Error("Fetching blob", "digest", digest, "err", err)
+			errorWrite(rw, http.StatusNotFound, strings.ToUpper(strings.TrimSuffix(kind, "s"))+"_UNKNOWN", err.Error())
+			return
+		}
+		file, err = rs.cache.Open(digest)
+	}
+	if err != nil {
+		slog.Error("Opening cached blob", "err", err)
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	stat, err := file.Stat()
+	if err != nil {
+		slog.Error("Getting cached blob", "err", err)
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	contentType := "application/octet-stream"
+	if kind == "manifests" {
+		var manifest struct {
+			MediaType string `json:"mediaType"`
+		}
+		err := json.NewDecoder(file).Decode(&manifest)
+		if err == nil && manifest.MediaType != "" {
+			contentType = manifest.MediaType
+		}
+		_, err = file.Seek(0, io.SeekStart)
+		if err != nil {
+			slog.Error("Seeking cached manifest", "err", err)
+			http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// NOTE: checkpoint blobs are larger than the server write timeout allows
+	err = http.NewResponseController(rw).SetWriteDeadline(time.Time{})
+	if err != nil {
+		slog.Warn("Clearing write deadline", "err", err)
+	}
+	rw.Header().Set("Content-Type", contentType)
+	rw.Header().Set("Docker-Content-Digest", digest.String())
+	http.ServeContent(rw, req, "", stat.ModTime(), file)
+}
+
+// manifestProxy resolves a tag against the upstream registry, the manifest is
+// cached by digest so the following pulls by digest are served locally
+func (rs Resource) manifestProxy(rw http.ResponseWriter, req *http.Request, ref name.Tag) {
+	options, err := rs.remoteOptions(req.Context())
+	if err != nil {
+		slog.Error("Getting mirror pull secret", "err", err)
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if req.Method == http.MethodHead {
+		desc, err := remote.Head(ref, options...)
+		if err != nil {
+			errorWrite(rw, http.StatusNotFound, "MANIFEST_UNKNOWN", err.Error())
+			return
+		}
+		rw.Header().Set("Content-Type", string(desc.MediaType))
+		rw.Header().Set("Docker-Content-Digest", desc.Digest.String())
+		rw.Header().Set("Content-Length", fmt.Sprint(desc.Size))
+		return
+	}
+
+	desc, err := remote.Get(ref, options...)
+	if err != nil {
+		errorWrite(rw, http.StatusNotFound, "MANIFEST_UNKNOWN", err.Error())
+		return
+	}
+	err = rs.cache.Write(desc.Digest, bytes.NewReader(desc.Manifest))
+	if err != nil {
+		slog.Warn("Caching manifest", "digest", desc.Digest, "err", err)
+	}
+	rw.Header().Set("Content-Type", string(desc.MediaType))
+	rw.Header().Set("Docker-Content-Digest", desc.Digest.String())
+	_, err = rw.Write(desc.Manifest)
+	if err != nil {
+		slog.Error("Writing response", "err", err)
+	}
+}
+
+// fetch caches digest, from its owner peer unless fromPeer, falling back to
+// the upstream registry. Concurrent fetches of the same digest are shared
+func (rs Resource) fetch(ctx context.Context, repo name.Repository, kind string, digest v1.Hash, fromPeer bool) error {
+	// NOTE: the fetch outlives the request it was started by, other
+	// requests may be waiting for it
+	ctx = context.WithoutCancel(ctx)
+	_, err, _ := rs.fetches.Do(digest.String(), func() (any, error) {
+		if !fromPeer {
+			owner, err := rs.owner(ctx, digest)
+			if err != nil {
+				slog.Warn("Discovering peers", "err", err)
+			}
+			if owner != "" {
+				err := rs.peerFetch(ctx, owner, repo, kind, digest)
+				if err == nil {
+					return nil, nil
+				}
+				slog.Warn("Fetching from peer, falling back to upstream", "peer", owner, "digest", digest, "err", err)
+			}
+		}
+		return nil, rs.upstreamFetch(ctx, repo, kind, digest)
+	})
+	return err
+}
+
+func (rs Resource) peerFetch(ctx context.Context, peer string, repo name.Repository, kind string, digest v1.Hash) error {
+	peerURL := url.URL{
+		Scheme:   "http",
+		Host:     peer,
+		Path:     fmt.Sprintf("/v2/%s/%s/%s", repo.RepositoryStr(), kind, digest),
+		RawQuery: url.Values{"ns": {repo.RegistryStr()}}.Encode(),
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peerURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	token, err := rs.Tokens.Token(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(peerHeader, "1")
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code for peerFetch: %d: %s", resp.StatusCode, body)
+	}
+
+	return rs.cache.Write(digest, resp.Body)
+}
+
+func (rs Resource) upstreamFetch(ctx context.Context, repo name.Repository, kind string, digest v1.Hash) error {
+	options, err := rs.remoteOptions(ctx)
+	if err != nil {
+		return err
+	}
+	ref := repo.Digest(digest.String())
+
+	if kind == "manifests" {
+		desc, err := remote.Get(ref, options...)
+		if err != nil {
+			return err
+		}
+		return rs.cache.Write(digest, bytes.NewReader(desc.Manifest))
+	}
+
+	layer, err := remote.Layer(ref, options...)
+	if err != nil {
+		return err
+	}
+	rc, err := layer.Compressed()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+	return rs.cache.Write(digest, rc)
+}
@@ -0,0 +1,186 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"bud.studio/stove8s/internal/k8s"
+	"bud.studio/stove8s/internal/oci"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"golang.org/x/sync/singleflight"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// peerHeader marks requests of other daemonsets, they're served from the
+// cache or the upstream registry but never forwarded to another peer
+const peerHeader = "X-Stove8s-Peer"
+
+// Resource is a read-only OCI distribution API acting as a pull-through cache
+// of the node container runtime registry mirror. Blobs missing from the cache
+// are fetched from the daemonset owning their digest, so a blob is pulled from
+// the upstream registry once however many nodes restore the same snapshot
+type Resource struct {
+	// CacheDir is where the verified blobs are kept
+	CacheDir string
+	// Namespace and DaemonSet are where the peers are discovered
+	Namespace string
+	DaemonSet string
+	// Port is the port the peers listen on
+	Port uint
+	// PullSecret is a dockerconfigjson Secret in Namespace the upstream
+	// registries are pulled with, anonymous when empty. The mirror then serves
+	// private images to anyone reaching it, it must be kept node-local
+	PullSecret string
+	// CacheMaxSize is the size in bytes the least recently used blobs are
+	// evicted past, 0 never evicts
+	CacheMaxSize int64
+	// Reviewer authenticates the peers, their requests bear a token of
+	// PeerUsername, the service account of the daemonset, scoped to the
+	// daemonsets as they're called over plain HTTP
+	Reviewer     *k8s.TokenReviewer
+	PeerUsername string
+	// Tokens requests the tokens of PeerUsername sent to the peers
+	Tokens *k8s.TokenRequester
+
+	cache     oci.BlobCache
+	hostname  string
+	fetches   *singleflight.Group
+	k8sClient *kubernetes.Clientset
+}
+
+func (rs Resource) Init() (chi.Router, error) {
+	k8sClient, err := k8s.ClientInit()
+	if err != nil {
+		return nil, err
+	}
+	// NOTE: the pod name, peers are told apart by it
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	rs.k8sClient = k8sClient
+	rs.hostname = hostname
+	rs.cache = oci.BlobCache{Dir: rs.CacheDir, MaxSize: rs.CacheMaxSize}
+	rs.fetches = &singleflight.Group{}
+
+	r := chi.NewRouter()
+
+	r.Get("/*", rs.Get)
+	r.Head("/*", rs.Get)
+
+	return r, nil
+}
+
+type registryErrors struct {
+	Errors []registryError `json:"errors"`
+}
+
+type registryError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func errorWrite(rw http.ResponseWriter, status int, code string, message string) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(registryErrors{
+		Errors: []registryError{{Code: code, Message: message}},
+	})
+}
+
+// peerAuthenticate checks the token of a peer request, peers are answered
+// from the cache or upstream without being forwarded again
+func (rs Resource) peerAuthenticate(req *http.Request) error {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return k8s.ErrUnauthenticated
+	}
+	user, err := rs.Reviewer.Review(req.Context(), token)
+	if err != nil {
+		return err
+	}
+	if user.Username != rs.PeerUsername {
+		return fmt.Errorf("%w: %s isn't a peer", k8s.ErrUnauthenticated, user.Username)
+	}
+	return nil
+}
+
+// repository is the upstream repository of a mirrored name, containerd tells
+// the registry in the ns parameter, CRI-O mirrors prefix the name with it
+func repository(repoPath string, registry string) (name.Repository, error) {
+	if registry == "" {
+		domain, rest, ok := strings.Cut(repoPath, "/")
+		if !ok || (!strings.ContainsAny(domain, ".:") && domain != "localhost") {
+			return name.Repository{}, fmt.Errorf("no registry in %s", repoPath)
+		}
+		registry, repoPath = domain, rest
+	}
+	return name.NewRepository(registry + "/" + repoPath)
+}
+
+func (rs Resource) remoteOptions(ctx context.Context) ([]remote.Option, error) {
+	options := []remote.Option{remote.WithContext(ctx)}
+	if rs.PullSecret != "" {
+		keychain, err := k8s.ImagePullKeychainGet(rs.k8sClient, rs.Namespace, rs.PullSecret)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, remote.WithAuthFromKeychain(keychain))
+	}
+	return options, nil
+}
+
+// owner is the address of the peer in charge of fetching digest from the
+// upstream registry, picked by rendezvous hashing so peers agree on it
+// without talking to each other. It's empty when this daemonset is the owner
+func (rs Resource) owner(ctx context.Context, digest v1.Hash) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 8*time.Second)
+	defer cancel()
+	ds, err := rs.k8sClient.AppsV1().DaemonSets(rs.Namespace).Get(ctx, rs.DaemonSet, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	selector, err := metav1.LabelSelectorAsSelector(ds.Spec.Selector)
+	if err != nil {
+		return "", err
+	}
+	pods, err := rs.k8sClient.CoreV1().Pods(rs.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var owner *corev1.Pod
+	var ownerScore []byte
+	for idx := range pods.Items {
+		pod := &pods.Items[idx]
+		if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" || pod.DeletionTimestamp != nil {
+			continue
+		}
+		score := sha256.Sum256([]byte(pod.Name + digest.String()))
+		if owner == nil || bytes.Compare(score[:], ownerScore) > 0 {
+			owner = pod
+			ownerScore = score[:]
+		}
+	}
+	if owner == nil || owner.Name == rs.hostname {
+		return "", nil
+	}
+
+	return net.JoinHostPort(owner.Status.PodIP, fmt.Sprint(rs.Port)), nil
+}
@@ -0,0 +1,78 @@
+package oci
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	"bud.studio/stove8s/internal/oci"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Archive streams the offline export of a job as a tar, the OCI image layout
+// directory is archived on the fly and tarballs are sent as is. Like the rest
+// of /oci it's only served to the controller service account
+func (rs Resource) Archive(rw http.ResponseWriter, req *http.Request) {
+	idString := chi.URLParam(req, "id")
+	if idString == "" {
+		http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	id, err := uuid.Parse(idString)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	job, ok := rs.jobs.Get(id)
+	if !ok || job.ExportPath == "" {
+		http.Error(rw, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if job.Stage != stove8sv1beta1.Pushing || job.State != stove8sv1beta1.Success {
+		http.Error(rw, "export not completed", http.StatusConflict)
+		return
+	}
+
+	path := filepath.Join(rs.ExportDir, job.ExportPath)
+	stat, err := os.Stat(path)
+	if err != nil {
+		slog.Error("Getting export", "err", err)
+		http.Error(rw, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	// NOTE: exports are larger than the server write timeout allows
+	err = http.NewResponseController(rw).SetWriteDeadline(time.Time{})
+	if err != nil {
+		slog.Warn("Clearing write deadline", "err", err)
+	}
+	rw.Header().Set("Content-Type", "application/x-tar")
+
+	if !stat.IsDir() {
+		file, err := os.Open(path)
+		if err != nil {
+			slog.Error("Opening export", "err", err)
+			http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+		_, err = io.Copy(rw, file)
+		if err != nil {
+			slog.Error("Writing response", "err", err)
+		}
+		return
+	}
+
+	err = oci.DirTarWrite(rw, path)
+	if err != nil {
+		// NOTE: the status is already sent, the client gets a truncated tar
+		slog.Error("Writing export archive", "err", err)
+	}
+}
@@ -1,16 +1,25 @@
 package oci
 
 import (
+	"context"
+	"crypto"
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"time"
 
 	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
 	"bud.studio/stove8s/internal/k8s"
 	"bud.studio/stove8s/internal/oci"
 	"github.com/go-playground/validator/v10"
+	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/uuid"
 )
@@ -20,10 +29,97 @@ type CreateReqImagePushSecret struct {
 	Namespace string `json:"namespace" validate:"required"`
 }
 
+type CreateReqSigningKeySecret struct {
+	Name      string `json:"name" validate:"required"`
+	Namespace string `json:"namespace" validate:"required"`
+}
+
+type CreateReqEncryptionRecipient struct {
+	Protocol  stove8sv1beta1.SnapShotOutputEncryptionProtocol `json:"protocol" validate:"oneof=jwe pkcs7"`
+	PublicKey string                                          `json:"public_key" validate:"required"`
+}
+
+// CreateReqProvenance is recorded in the provenance attached to the image
+type CreateReqProvenance struct {
+	NodeName                string `json:"node_name"`
+	KubeletVersion          string `json:"kubelet_version"`
+	ContainerRuntimeVersion string `json:"container_runtime_version"`
+}
+
+type CreateReqBaseImage struct {
+	Replicate bool `json:"replicate"`
+	// Repository defaults to the repository of the image reference
+	Repository string `json:"repository"`
+}
+
+// CreateReqOffline exports the image under the export directory instead of
+// pushing it
+type CreateReqOffline struct {
+	Format stove8sv1beta1.SnapShotOutputOfflineFormat `json:"format" validate:"omitempty,oneof=layout tarball"`
+	// Path is relative to the export directory, it defaults to the job ID
+	Path string `json:"path"`
+}
+
+type CreateReqObjectStoreCredentialsSecret struct {
+	Name      string `json:"name" validate:"required"`
+	Namespace string `json:"namespace" validate:"required"`
+}
+
+// CreateReqObjectStore uploads the snapshot to an S3 compatible object store
+// instead of pushing it
+type CreateReqObjectStore struct {
+	Endpoint          string                                       `json:"endpoint" validate:"required,url"`
+	Region            string                                       `json:"region"`
+	Bucket            string                                       `json:"bucket" validate:"required"`
+	Prefix            string                                       `json:"prefix"`
+	CredentialsSecret CreateReqObjectStoreCredentialsSecret        `json:"credentials_secret"`
+	Mode              stove8sv1beta1.SnapShotOutputObjectStoreMode `json:"mode" validate:"omitempty,oneof=archive layout"`
+	// PartSize is in bytes, 0 uses the default
+	PartSize int64 `json:"part_size" validate:"gte=0"`
+}
+
+// CreateReqLocal imports the image into the node containerd instead of
+// pushing it
+type CreateReqLocal struct {
+	Namespace string `json:"namespace"`
+	// Name defaults to the image reference
+	Name string `json:"name"`
+}
+
+type CreateReqCompression struct {
+	Algorithm stove8sv1beta1.SnapShotOutputCompressionAlgorithm `json:"algorithm" validate:"omitempty,oneof=gzip zstd uncompressed estargz"`
+	Level     int                                               `json:"level"`
+}
+
 type CreateReq struct {
 	CheckpointDumpPath string                   `json:"checkpoint_dump_path" validate:"required,filepath"`
 	ImagePushSecret    CreateReqImagePushSecret `json:"image_push_secret" validate:"required"`
 	ImageReference     string                   `json:"image_reference" validate:"required"`
+	MountFrom          []string                 `json:"mount_from"`
+	Compression        CreateReqCompression     `json:"compression"`
+	// PagesSplitThreshold is in bytes
+	PagesSplitThreshold int64 `json:"pages_split_threshold" validate:"gte=0"`
+	// ParentImageReference makes the snapshot incremental on top of it
+	ParentImageReference string `json:"parent_image_reference"`
+	RetryLimit           int    `json:"retry_limit" validate:"gte=0"`
+	// Deadline bounds the push, 0 means no deadline
+	Deadline time.Duration `json:"deadline" validate:"gte=0"`
+	// SigningKeySecret signs the pushed image with cosign, unset skips signing
+	SigningKeySecret *CreateReqSigningKeySecret `json:"signing_key_secret" validate:"omitempty"`
+	// EncryptionRecipients encrypts the layers, unset pushes them as is
+	EncryptionRecipients []CreateReqEncryptionRecipient      `json:"encryption_recipients" validate:"dive"`
+	Provenance           CreateReqProvenance                 `json:"provenance"`
+	BaseImage            CreateReqBaseImage                  `json:"base_image"`
+	Format               stove8sv1beta1.SnapShotOutputFormat `json:"format" validate:"omitempty,oneof=auto cri-o containerd artifact"`
+	// Architecture is the node architecture, it defaults to the daemonset one
+	Architecture string `json:"architecture"`
+	// PushByDigest leaves the image untagged, it's merged into an index later
+	PushByDigest bool `json:"push_by_digest"`
+	// Offline exports the image, the push secret is still used for the
+	// parent and base images
+	Offline     *CreateReqOffline     `json:"offline" validate:"omitempty"`
+	ObjectStore *CreateReqObjectStore `json:"object_store" validate:"omitempty,excluded_with=Offline"`
+	Local       *CreateReqLocal       `json:"local" validate:"omitempty,excluded_with=Offline ObjectStore"`
 }
 
 type CreateResp struct {
@@ -31,25 +127,83 @@ type CreateResp struct {
 }
 
 func (rs Resource) CreateAsync(id uuid.UUID, data *CreateReq) {
-	status := Status{
-		Stage: stove8sv1beta1.Fromating,
-		State: stove8sv1beta1.Started,
+	on_err_exit := func() {
+		rs.jobs.Update(id, func(status *Status) { status.State = stove8sv1beta1.Failed })
 	}
-	rs.jobs[id] = &status
 
-	var dumpFile *os.File
-	on_err_exit := func() {
-		status.State = stove8sv1beta1.Failed
+	var parent v1.Image
+	if data.ParentImageReference != "" {
+		parentRef, err := name.ParseReference(data.ParentImageReference)
+		if err != nil {
+			slog.Error("Creating parent reference", "err", err)
+			on_err_exit()
+			return
+		}
+		parentAuth, err := k8s.ImagePushSecretGet(
+			rs.k8sClient,
+			data.ImagePushSecret.Namespace,
+			data.ImagePushSecret.Name,
+			parentRef.Context().RegistryStr(),
+		)
+		if err != nil {
+			slog.Error("Getting image push secret for parent", "err", err)
+			on_err_exit()
+			return
+		}
+		parentOpts := []remote.Option{remote.WithAuth(parentAuth)}
+		if data.Architecture != "" {
+			// NOTE: the parent of a multi-architecture snapshot is an index
+			parentOpts = append(parentOpts, remote.WithPlatform(v1.Platform{
+				OS:           "linux",
+				Architecture: data.Architecture,
+			}))
+		}
+		parent, err = remote.Image(parentRef, parentOpts...)
+		if err != nil {
+			slog.Error("Fetching parent image", "err", err)
+			on_err_exit()
+			return
+		}
+	}
 
-		err := dumpFile.Close()
+	// NOTE: encrypted layers are kept here until they're pushed
+	tempDir, err := os.MkdirTemp("", "stove8s-"+id.String())
+	if err != nil {
+		slog.Error("Creating temporary directory", "err", err)
+		on_err_exit()
+		return
+	}
+	defer func() {
+		err := os.RemoveAll(tempDir)
 		if err != nil {
-			slog.Error("Closing checkpointDump file", "err", err)
+			slog.Error("Removing temporary directory", "err", err)
+		}
+	}()
+
+	var encryption oci.EncryptionRecipients
+	for _, recipient := range data.EncryptionRecipients {
+		switch recipient.Protocol {
+		case stove8sv1beta1.JWE:
+			encryption.JWE = append(encryption.JWE, []byte(recipient.PublicKey))
+		case stove8sv1beta1.PKCS7:
+			encryption.PKCS7 = append(encryption.PKCS7, []byte(recipient.PublicKey))
 		}
 	}
 
-	img, dumpFile, err := oci.BuildImage(data.CheckpointDumpPath)
+	keychain, err := k8s.ImagePullKeychainGet(
+		rs.k8sClient,
+		data.ImagePushSecret.Namespace,
+		data.ImagePushSecret.Name,
+	)
 	if err != nil {
-		slog.Error("Building oci image", "err", err)
+		slog.Error("Getting image push secret for base image", "err", err)
+		on_err_exit()
+		return
+	}
+
+	containerConfig, err := oci.ContainerConfigInspect(data.CheckpointDumpPath)
+	if err != nil {
+		slog.Error("Inspecting checkpoint container config", "err", err)
 		on_err_exit()
 		return
 	}
@@ -60,8 +214,165 @@ func (rs Resource) CreateAsync(id uuid.UUID, data *CreateReq) {
 		return
 	}
 
-	status.Stage = stove8sv1beta1.Pushing
-	status.State = stove8sv1beta1.Started
+	repoDigests := rs.baseImageRepoDigests(containerConfig.RootfsImageRef)
+	source, subject, err := oci.SubjectResolve(
+		containerConfig.RootfsImageName,
+		containerConfig.RootfsImageRef,
+		repoDigests,
+		keychain,
+	)
+	if err != nil {
+		if data.BaseImage.Replicate {
+			slog.Error("Resolving base image", "err", err)
+			on_err_exit()
+			return
+		}
+		// NOTE: the checkpoint is still usable, the base image is in the annotations
+		slog.Warn("Resolving base image, the subject won't be set", "err", err)
+	}
+
+	var baseImageName string
+	if data.BaseImage.Replicate {
+		source, err = rs.baseImageReplicate(data, ref, source, keychain)
+		if err != nil {
+			slog.Error("Replicating base image", "err", err)
+			on_err_exit()
+			return
+		}
+		baseImageName = source.String()
+		rs.jobs.Update(id, func(status *Status) { status.BaseImageReference = baseImageName })
+	}
+	// NOTE: registries only list the referrers of a manifest in its own
+	// repository, the checkpoint is also pushed next to a copy kept elsewhere
+	pushed := data.Offline == nil && data.ObjectStore == nil && data.Local == nil
+	var referrerRepo *name.Repository
+	if subject != nil && source.Context() != ref.Context() {
+		if data.BaseImage.Replicate && pushed {
+			repo := source.Context()
+			referrerRepo = &repo
+		} else {
+			slog.Info("Base image isn't in the checkpoint repository, the subject won't be set", "base", source.String())
+			subject = nil
+		}
+	}
+
+	host := oci.HostInfoCollect(rs.HostRoot, rs.CRISocket, data.Provenance.ContainerRuntimeVersion)
+	if data.Architecture != "" {
+		host.Arch = data.Architecture
+	}
+
+	img, err := oci.BuildImage(data.CheckpointDumpPath, oci.BuildOptions{
+		Compression: stove8sv1beta1.SnapShotOutputCompression{
+			Algorithm: data.Compression.Algorithm,
+			Level:     data.Compression.Level,
+		},
+		PagesSplitThreshold: data.PagesSplitThreshold,
+		Parent:              parent,
+		Encryption:          encryption,
+		TempDir:             tempDir,
+		Subject:             subject,
+		BaseImageName:       baseImageName,
+		Host:                host,
+		Format:              data.Format,
+	})
+	if err != nil {
+		slog.Error("Building oci image", "err", err)
+		on_err_exit()
+		return
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		slog.Error("Getting image digest", "err", err)
+		on_err_exit()
+		return
+	}
+	rs.jobs.Update(id, func(status *Status) { status.Digest = digest.String() })
+	if data.PushByDigest {
+		ref = ref.Context().Digest(digest.String())
+	}
+
+	size, err := oci.ImageSize(img)
+	if err != nil {
+		slog.Error("Getting image size", "err", err)
+		on_err_exit()
+		return
+	}
+	rs.jobs.Update(id, func(status *Status) { status.CompressedSize = size })
+	if parent != nil {
+		deduplicatedSize, err := oci.SharedSize(img, parent)
+		if err != nil {
+			slog.Error("Getting deduplicated size", "err", err)
+			on_err_exit()
+			return
+		}
+		rs.jobs.Update(id, func(status *Status) { status.DeduplicatedSize = deduplicatedSize })
+	}
+
+	rs.jobs.Update(id, func(status *Status) {
+		status.Stage = stove8sv1beta1.Pushing
+		status.State = stove8sv1beta1.Started
+	})
+
+	ctx := context.Background()
+	if data.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, data.Deadline)
+		defer cancel()
+	}
+	uploadOpts := oci.UploadOptions{
+		Retries: data.RetryLimit,
+		OnRetry: func(err error) {
+			slog.Warn("Retrying push", "err", err)
+			rs.jobs.Update(id, func(status *Status) { status.Retries++ })
+		},
+	}
+
+	if data.Local != nil {
+		imageName, err := rs.containerdImport(ctx, data, ref, img)
+		if err != nil {
+			slog.Error("Importing into containerd", "err", err)
+			on_err_exit()
+			return
+		}
+		slog.Info("Import Completed", "image", imageName, "namespace", data.Local.Namespace)
+		rs.jobs.Update(id, func(status *Status) {
+			status.LocalImage = imageName
+			status.State = stove8sv1beta1.Success
+		})
+		return
+	}
+
+	if data.ObjectStore != nil {
+		info, err := rs.objectStoreUpload(ctx, id, data, ref, img, tempDir, uploadOpts)
+		if err != nil {
+			slog.Error("Uploading to object store", "err", err)
+			on_err_exit()
+			return
+		}
+		slog.Info("Upload Completed", "image", data.ImageReference, "url", info.URL)
+		rs.jobs.Update(id, func(status *Status) {
+			status.ObjectURL = info.URL
+			status.ObjectETag = info.ETag
+			status.State = stove8sv1beta1.Success
+		})
+		return
+	}
+
+	if data.Offline != nil {
+		// NOTE: the export is reported as the Pushing stage
+		exportPath, err := rs.export(id, data, ref, img)
+		if err != nil {
+			slog.Error("Exporting image", "err", err)
+			on_err_exit()
+			return
+		}
+		slog.Info("Export Completed", "image", data.ImageReference, "path", exportPath)
+		rs.jobs.Update(id, func(status *Status) {
+			status.ExportPath = exportPath
+			status.State = stove8sv1beta1.Success
+		})
+		return
+	}
 
 	auth, err := k8s.ImagePushSecretGet(
 		rs.k8sClient,
@@ -74,11 +385,29 @@ func (rs Resource) CreateAsync(id uuid.UUID, data *CreateReq) {
 		on_err_exit()
 		return
 	}
-	err = remote.Write(
-		ref,
-		img,
+
+	skippedSize, err := oci.BlobsPreflight(ctx, ref, img, data.MountFrom, auth)
+	if err != nil {
+		slog.Error("Checking existing blobs", "err", err)
+		on_err_exit()
+		return
+	}
+	rs.jobs.Update(id, func(status *Status) { status.SkippedSize = skippedSize })
+
+	err = oci.BlobsUpload(ctx, ref, img, auth, uploadOpts)
+	if err != nil {
+		slog.Error("Uploading blobs", "err", err)
+		on_err_exit()
+		return
+	}
+	pushOpts := append([]remote.Option{
 		remote.WithAuth(auth),
-	)
+		remote.WithContext(ctx),
+	}, uploadOpts.RemoteOptions()...)
+
+	err = uploadOpts.Retry(ctx, func() error {
+		return remote.Write(ref, img, pushOpts...)
+	})
 	if err != nil {
 		slog.Error("Pushing to remote", "err", err)
 		on_err_exit()
@@ -86,8 +415,256 @@ func (rs Resource) CreateAsync(id uuid.UUID, data *CreateReq) {
 	} else {
 		slog.Info("Push Completed", "image", data.ImageReference)
 	}
+	if referrerRepo != nil {
+		err = uploadOpts.Retry(ctx, func() error {
+			return oci.ReferrerReplicate(
+				ref.Context().Digest(digest.String()),
+				*referrerRepo,
+				append([]remote.Option{
+					remote.WithAuthFromKeychain(keychain),
+					remote.WithContext(ctx),
+				}, uploadOpts.RemoteOptions()...)...,
+			)
+		})
+		if err != nil {
+			slog.Error("Pushing to the base image repository", "err", err)
+			on_err_exit()
+			return
+		}
+		slog.Info("Pushed next to the base image", "repository", referrerRepo.String())
+	}
+
+	var signer crypto.Signer
+	if data.SigningKeySecret != nil {
+		signer, err = k8s.SigningKeyGet(
+			rs.k8sClient,
+			data.SigningKeySecret.Namespace,
+			data.SigningKeySecret.Name,
+		)
+		if err != nil {
+			slog.Error("Getting signing key secret", "err", err)
+			on_err_exit()
+			return
+		}
+		err = uploadOpts.Retry(ctx, func() error {
+			return oci.Sign(ref, img, signer, pushOpts...)
+		})
+		if err != nil {
+			slog.Error("Signing image", "err", err)
+			on_err_exit()
+			return
+		}
+		slog.Info("Signing Completed", "image", data.ImageReference)
+	}
+
+	statement, err := oci.ProvenanceStatementBuild(ref, img, oci.ProvenanceInfo{
+		Node:                    data.Provenance.NodeName,
+		KubeletVersion:          data.Provenance.KubeletVersion,
+		ContainerRuntimeVersion: data.Provenance.ContainerRuntimeVersion,
+		InvocationID:            id.String(),
+	})
+	if err != nil {
+		slog.Error("Building provenance statement", "err", err)
+		on_err_exit()
+		return
+	}
+	var provenanceDigest v1.Hash
+	err = uploadOpts.Retry(ctx, func() error {
+		var err error
+		provenanceDigest, err = oci.ProvenanceAttach(ref, img, statement, signer, pushOpts...)
+		return err
+	})
+	if err != nil {
+		slog.Error("Attaching provenance", "err", err)
+		on_err_exit()
+		return
+	}
+	rs.jobs.Update(id, func(status *Status) {
+		status.ProvenanceDigest = provenanceDigest.String()
+		status.State = stove8sv1beta1.Success
+	})
+}
+
+// export writes img under the export directory with its signature and
+// provenance, it returns the path relative to the export directory
+func (rs Resource) export(id uuid.UUID, data *CreateReq, ref name.Reference, img v1.Image) (string, error) {
+	opts, err := rs.exportOptions(id, data, ref, img)
+	if err != nil {
+		return "", err
+	}
+
+	exportPath := data.Offline.Path
+	if exportPath == "" {
+		exportPath = id.String()
+	}
+	dest := filepath.Join(rs.ExportDir, exportPath)
+	err = os.MkdirAll(filepath.Dir(dest), 0o755)
+	if err != nil {
+		return "", err
+	}
 
-	status.State = stove8sv1beta1.Success
+	switch data.Offline.Format {
+	case stove8sv1beta1.Tarball:
+		err = oci.TarballExport(dest, ref, img, opts)
+	default:
+		err = oci.LayoutExport(dest, ref, img, opts)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return exportPath, nil
+}
+
+// objectStoreUpload uploads the checkpoint archive, or the image as an OCI
+// image layout, under the object store prefix
+func (rs Resource) objectStoreUpload(
+	ctx context.Context,
+	id uuid.UUID,
+	data *CreateReq,
+	ref name.Reference,
+	img v1.Image,
+	tempDir string,
+	uploadOpts oci.UploadOptions,
+) (oci.ObjectInfo, error) {
+	endpoint, err := url.Parse(data.ObjectStore.Endpoint)
+	if err != nil {
+		return oci.ObjectInfo{}, err
+	}
+	credentials, err := k8s.ObjectStoreCredentialsGet(
+		rs.k8sClient,
+		data.ObjectStore.CredentialsSecret.Namespace,
+		data.ObjectStore.CredentialsSecret.Name,
+	)
+	if err != nil {
+		return oci.ObjectInfo{}, fmt.Errorf("getting object store credentials: %v", err)
+	}
+	region := data.ObjectStore.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	store := &oci.ObjectStore{
+		Endpoint:    endpoint,
+		Region:      region,
+		Bucket:      data.ObjectStore.Bucket,
+		Credentials: credentials,
+		PartSize:    data.ObjectStore.PartSize,
+	}
+
+	if data.ObjectStore.Mode != stove8sv1beta1.ImageLayout {
+		key := path.Join(data.ObjectStore.Prefix, filepath.Base(data.CheckpointDumpPath))
+		return store.Upload(ctx, key, data.CheckpointDumpPath, uploadOpts)
+	}
+
+	opts, err := rs.exportOptions(id, data, ref, img)
+	if err != nil {
+		return oci.ObjectInfo{}, err
+	}
+	layoutDir := filepath.Join(tempDir, "layout")
+	err = oci.LayoutExport(layoutDir, ref, img, opts)
+	if err != nil {
+		return oci.ObjectInfo{}, err
+	}
+	return store.LayoutUpload(ctx, data.ObjectStore.Prefix, layoutDir, uploadOpts)
+}
+
+// containerdImport imports img into the node containerd, it returns the
+// normalized image name the kubelet finds it by
+func (rs Resource) containerdImport(ctx context.Context, data *CreateReq, ref name.Reference, img v1.Image) (string, error) {
+	if data.Local.Name != "" {
+		var err error
+		ref, err = name.ParseReference(data.Local.Name)
+		if err != nil {
+			return "", fmt.Errorf("parsing local image name: %v", err)
+		}
+	}
+	namespace := data.Local.Namespace
+	if namespace == "" {
+		namespace = oci.ContainerdDefaultNamespace
+	}
+
+	imageName := oci.ContainerdImageName(ref)
+	err := oci.ContainerdImport(ctx, rs.ContainerdSocket, namespace, imageName, img)
+	if err != nil {
+		return "", err
+	}
+	return imageName, nil
+}
+
+// exportOptions signs the exports like pushed images and adds their provenance
+func (rs Resource) exportOptions(id uuid.UUID, data *CreateReq, ref name.Reference, img v1.Image) (oci.ExportOptions, error) {
+	var opts oci.ExportOptions
+	if data.SigningKeySecret != nil {
+		signer, err := k8s.SigningKeyGet(
+			rs.k8sClient,
+			data.SigningKeySecret.Namespace,
+			data.SigningKeySecret.Name,
+		)
+		if err != nil {
+			return opts, fmt.Errorf("getting signing key secret: %v", err)
+		}
+		opts.Signer = signer
+	}
+
+	statement, err := oci.ProvenanceStatementBuild(ref, img, oci.ProvenanceInfo{
+		Node:                    data.Provenance.NodeName,
+		KubeletVersion:          data.Provenance.KubeletVersion,
+		ContainerRuntimeVersion: data.Provenance.ContainerRuntimeVersion,
+		InvocationID:            id.String(),
+	})
+	if err != nil {
+		return opts, fmt.Errorf("building provenance statement: %v", err)
+	}
+	opts.Provenance = statement
+
+	return opts, nil
+}
+
+// baseImageRepoDigests returns the manifest digests the container runtime
+// pulled the base image imageID by, none when it can't tell
+func (rs Resource) baseImageRepoDigests(imageID string) []string {
+	socket := rs.CRISocket
+	if socket == "" {
+		var err error
+		socket, err = oci.CRISocketDetect(rs.HostRoot)
+		if err != nil {
+			slog.Warn("Detecting CRI socket, the base image digests are unknown", "err", err)
+			return nil
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	repoDigests, err := oci.CRIImageRepoDigests(ctx, socket, imageID)
+	if err != nil {
+		slog.Warn("Getting the base image digests", "err", err)
+		return nil
+	}
+	return repoDigests
+}
+
+// baseImageReplicate copies the base image source resolved to next to the
+// checkpoint ref points to, or into the requested repository
+func (rs Resource) baseImageReplicate(
+	data *CreateReq,
+	ref name.Reference,
+	source name.Digest,
+	keychain authn.Keychain,
+) (name.Digest, error) {
+	repo := ref.Context()
+	if data.BaseImage.Repository != "" {
+		var err error
+		repo, err = name.NewRepository(data.BaseImage.Repository)
+		if err != nil {
+			return name.Digest{}, err
+		}
+	}
+
+	copyRef, err := oci.BaseImageReplicate(source, repo, keychain)
+	if err != nil {
+		return name.Digest{}, err
+	}
+	slog.Info("Replicated base image", "source", source.String(), "copy", copyRef.String())
+	return copyRef, nil
 }
 
 func (rs Resource) Create(rw http.ResponseWriter, req *http.Request) {
@@ -102,6 +679,17 @@ func (rs Resource) Create(rw http.ResponseWriter, req *http.Request) {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if data.Offline != nil && data.Offline.Path != "" && !filepath.IsLocal(data.Offline.Path) {
+		http.Error(rw, "offline path must be relative to the export directory", http.StatusBadRequest)
+		return
+	}
+	// NOTE: the archive is uploaded as the kubelet wrote it, it can't be
+	// signed nor encrypted
+	if data.ObjectStore != nil && data.ObjectStore.Mode != stove8sv1beta1.ImageLayout &&
+		(data.SigningKeySecret != nil || len(data.EncryptionRecipients) > 0) {
+		http.Error(rw, "signing and encryption need the layout object store mode", http.StatusBadRequest)
+		return
+	}
 
 	id, err := uuid.NewV7()
 	if err != nil {
@@ -119,6 +707,11 @@ func (rs Resource) Create(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// NOTE: the job is known before the response, the first poll can't miss it
+	rs.jobs.Set(id, Status{
+		Stage: stove8sv1beta1.Fromating,
+		State: stove8sv1beta1.Started,
+	})
 	go rs.CreateAsync(id, &data)
 
 	rw.WriteHeader(http.StatusCreated)
@@ -10,12 +10,12 @@ import (
 )
 
 type listResp struct {
-	Jobs map[uuid.UUID]*Status `json:"jobs"`
+	Jobs map[uuid.UUID]Status `json:"jobs"`
 }
 
 func (rs Resource) List(rw http.ResponseWriter, req *http.Request) {
 	resp, err := json.Marshal(listResp{
-		Jobs: rs.jobs,
+		Jobs: rs.jobs.List(),
 	})
 	if err != nil {
 		slog.Error("Marshaling response json", "err", err.Error())
@@ -40,7 +40,7 @@ func (rs Resource) Get(rw http.ResponseWriter, req *http.Request) {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
-	job, ok := rs.jobs[id]
+	job, ok := rs.jobs.Get(id)
 	if !ok {
 		http.Error(rw, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		return
@@ -1,62 +1,74 @@
 package oci
 
 import (
-	"fmt"
-	"os"
+	"time"
 
 	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	"bud.studio/stove8s/internal/daemonset/jobs"
+	"bud.studio/stove8s/internal/k8s"
 	"github.com/go-chi/chi/v5"
-	"github.com/google/uuid"
+	"github.com/go-chi/chi/v5/middleware"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
 type Status struct {
 	Stage stove8sv1beta1.SnapShotStatusStage `json:"stage"`
 	State stove8sv1beta1.SnapShotStatusState `json:"state"`
-}
 
-type Resource struct {
-	jobs      map[uuid.UUID]*Status
-	k8sClient *kubernetes.Clientset
+	CompressedSize   int64 `json:"compressed_size"`
+	DeduplicatedSize int64 `json:"deduplicated_size"`
+	SkippedSize      int64 `json:"skipped_size"`
+	Retries          int32 `json:"retries"`
+	// ProvenanceDigest is the manifest digest of the attached provenance
+	ProvenanceDigest string `json:"provenance_digest"`
+	// BaseImageReference is the replicated copy of the base image
+	BaseImageReference string `json:"base_image_reference"`
+	// Digest is the manifest digest of the image
+	Digest string `json:"digest"`
+	// ExportPath is relative to the export directory, set for offline exports
+	ExportPath string `json:"export_path"`
+	// ObjectURL and ObjectETag are set for object store uploads
+	ObjectURL  string `json:"object_url"`
+	ObjectETag string `json:"object_etag"`
+	// LocalImage is the containerd image name of local imports
+	LocalImage string `json:"local_image"`
 }
 
-func k8sClientInit() (*kubernetes.Clientset, error) {
-	k8sConfig, err := rest.InClusterConfig()
-	if err != nil {
-		kubeconfig := os.Getenv("HOME") + "/.kube/config"
-		if kubeconfigPath := os.Getenv("KUBECONFIG"); kubeconfigPath != "" {
-			kubeconfig = kubeconfigPath
-		}
-
-		k8sConfig, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
-		if err != nil {
-			return nil, fmt.Errorf("failed to build config: %w", err)
-		}
-	}
+type Resource struct {
+	// HostRoot is where the node paths are mounted, the os-release and CRI
+	// socket are looked up under it
+	HostRoot string
+	// ExportDir is where offline exports are written
+	ExportDir string
+	// ContainerdSocket is the node containerd socket local imports go through
+	ContainerdSocket string
+	// CRISocket is the node CRI socket the base image digests are looked up
+	// with, it's detected under HostRoot when unset
+	CRISocket string
 
-	clientset, err := kubernetes.NewForConfig(k8sConfig)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create clientset: %w", err)
-	}
-	return clientset, nil
+	jobs      *jobs.Store[Status]
+	k8sClient *kubernetes.Clientset
 }
 
 func (rs Resource) Init() (chi.Router, error) {
-	k8sClient, err := k8sClientInit()
+	k8sClient, err := k8s.ClientInit()
 	if err != nil {
 		return nil, err
 	}
 
 	rs.k8sClient = k8sClient
-	rs.jobs = make(map[uuid.UUID]*Status)
+	rs.jobs = jobs.NewStore[Status]()
 
 	r := chi.NewRouter()
 
-	r.Get("/{id}", rs.Get)
-	r.Get("/", rs.List)
-	r.Post("/", rs.Create)
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(time.Second))
+		r.Get("/{id}", rs.Get)
+		r.Get("/", rs.List)
+		r.Post("/", rs.Create)
+	})
+	// NOTE: archives are streamed for as long as the export takes to read
+	r.Get("/{id}/archive", rs.Archive)
 
 	return r, nil
 }
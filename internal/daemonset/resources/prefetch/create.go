@@ -0,0 +1,131 @@
+package prefetch
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	"bud.studio/stove8s/internal/k8s"
+	"bud.studio/stove8s/internal/oci"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/uuid"
+)
+
+// pullTimeout bounds a single pull, checkpoint images can be several GB
+const pullTimeout = time.Hour
+
+type CreateReqImagePullSecret struct {
+	Name      string `json:"name" validate:"required"`
+	Namespace string `json:"namespace" validate:"required"`
+}
+
+type CreateReq struct {
+	// ImageReference is pinned to a digest, a tag could be moved to another
+	// image between the prefetch and the swap
+	ImageReference  string                   `json:"image_reference" validate:"required"`
+	ImagePullSecret CreateReqImagePullSecret `json:"image_pull_secret" validate:"required"`
+}
+
+type CreateResp struct {
+	JobID string `json:"job_id"`
+}
+
+func (rs Resource) CreateAsync(id uuid.UUID, data *CreateReq) {
+	on_err_exit := func() {
+		rs.jobs.Update(id, func(status *Status) { status.State = stove8sv1beta1.Failed })
+	}
+
+	ref, err := name.NewDigest(data.ImageReference)
+	if err != nil {
+		slog.Error("Creating reference", "err", err)
+		on_err_exit()
+		return
+	}
+	auth, err := k8s.ImagePushSecretGet(
+		rs.k8sClient,
+		data.ImagePullSecret.Namespace,
+		data.ImagePullSecret.Name,
+		ref.Context().RegistryStr(),
+	)
+	if err != nil {
+		slog.Error("Getting image pull secret", "err", err)
+		on_err_exit()
+		return
+	}
+
+	socket := rs.CRISocket
+	if socket == "" {
+		socket, err = oci.CRISocketDetect(rs.HostRoot)
+		if err != nil {
+			slog.Error("Detecting CRI socket", "err", err)
+			on_err_exit()
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pullTimeout)
+	defer cancel()
+	imageID, err := oci.CRIImagePull(ctx, socket, data.ImageReference, auth)
+	if err != nil {
+		slog.Error("Pulling image", "err", err)
+		on_err_exit()
+		return
+	}
+	slog.Info("Prefetch Completed", "image", data.ImageReference, "id", imageID)
+
+	rs.jobs.Update(id, func(status *Status) {
+		status.ImageID = imageID
+		status.State = stove8sv1beta1.Success
+	})
+}
+
+func (rs Resource) Create(rw http.ResponseWriter, req *http.Request) {
+	var data CreateReq
+	err := json.NewDecoder(req.Body).Decode(&data)
+	if err != nil {
+		http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	err = validator.New().Struct(data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, err = name.NewDigest(data.ImageReference)
+	if err != nil {
+		http.Error(rw, "image reference must be pinned to a digest", http.StatusBadRequest)
+		return
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		slog.Error("Generating uuid", "err", err.Error())
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(CreateResp{
+		JobID: id.String(),
+	})
+	if err != nil {
+		slog.Error("Marshaling response json", "err", err.Error())
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// NOTE: the job is known before the response, the first poll can't miss it
+	rs.jobs.Set(id, Status{State: stove8sv1beta1.Started})
+	go rs.CreateAsync(id, &data)
+
+	rw.WriteHeader(http.StatusCreated)
+	_, err = rw.Write(resp)
+	if err != nil {
+		slog.Error("Writing response", "err", err.Error())
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,44 @@
+package prefetch
+
+import (
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	"bud.studio/stove8s/internal/daemonset/jobs"
+	"bud.studio/stove8s/internal/k8s"
+	"github.com/go-chi/chi/v5"
+	"k8s.io/client-go/kubernetes"
+)
+
+type Status struct {
+	State stove8sv1beta1.SnapShotStatusState `json:"state"`
+	// ImageID is the image ID the container runtime reports once pulled
+	ImageID string `json:"image_id"`
+}
+
+// Resource pulls images through the container runtime of the node, so the
+// kubelet finds them in the runtime's store when the pod image is swapped
+type Resource struct {
+	// HostRoot is where the node paths are mounted, the CRI socket
+	// is looked up under it when CRISocket is unset
+	HostRoot  string
+	CRISocket string
+
+	jobs      *jobs.Store[Status]
+	k8sClient *kubernetes.Clientset
+}
+
+func (rs Resource) Init() (chi.Router, error) {
+	k8sClient, err := k8s.ClientInit()
+	if err != nil {
+		return nil, err
+	}
+
+	rs.k8sClient = k8sClient
+	rs.jobs = jobs.NewStore[Status]()
+
+	r := chi.NewRouter()
+
+	r.Get("/{id}", rs.Get)
+	r.Post("/", rs.Create)
+
+	return r, nil
+}
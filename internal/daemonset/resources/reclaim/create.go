@@ -0,0 +1,63 @@
+package reclaim
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"bud.studio/stove8s/internal/oci"
+	"github.com/go-playground/validator/v10"
+)
+
+type CreateReq struct {
+	// ContainerID is the container ID of the pod container statuses
+	ContainerID string `json:"container_id" validate:"required"`
+}
+
+type CreateResp struct {
+	// PageCache is the page cache size before it was reclaimed, in bytes
+	PageCache int64 `json:"page_cache"`
+}
+
+func (rs Resource) Create(rw http.ResponseWriter, req *http.Request) {
+	var data CreateReq
+	err := json.NewDecoder(req.Body).Decode(&data)
+	if err != nil {
+		http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	err = validator.New().Struct(data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dir, err := oci.CgroupFind(rs.CgroupRoot, data.ContainerID)
+	if err != nil {
+		slog.Error("Finding container cgroup", "err", err)
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pageCache, err := oci.CgroupPageCacheReclaim(dir)
+	if err != nil {
+		slog.Error("Reclaiming page cache", "cgroup", dir, "err", err)
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Reclaimed page cache", "container", data.ContainerID, "bytes", pageCache)
+
+	resp, err := json.Marshal(CreateResp{
+		PageCache: pageCache,
+	})
+	if err != nil {
+		slog.Error("Marshaling response json", "err", err.Error())
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	_, err = rw.Write(resp)
+	if err != nil {
+		slog.Error("Writing response", "err", err.Error())
+	}
+}
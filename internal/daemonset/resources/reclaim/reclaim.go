@@ -0,0 +1,20 @@
+package reclaim
+
+import (
+	"github.com/go-chi/chi/v5"
+)
+
+// Resource drops the page cache of the containers of the node, through
+// memory.reclaim of their cgroup, which can't be done from inside them
+type Resource struct {
+	// CgroupRoot is where the node cgroup v2 filesystem is mounted, writable
+	CgroupRoot string
+}
+
+func (rs Resource) Init() (chi.Router, error) {
+	r := chi.NewRouter()
+
+	r.Post("/", rs.Create)
+
+	return r, nil
+}
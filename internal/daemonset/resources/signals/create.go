@@ -0,0 +1,93 @@
+package signals
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"syscall"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	"bud.studio/stove8s/internal/oci"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/sys/unix"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type CreateReq struct {
+	// Namespace and SnapShot name the SnapShot declaring Signal
+	Namespace string `json:"namespace" validate:"required"`
+	SnapShot  string `json:"snapshot" validate:"required"`
+	// ContainerID is the container ID of the pod container statuses
+	ContainerID string `json:"container_id" validate:"required"`
+	// Signal is a signal name, like SIGUSR1
+	Signal string `json:"signal" validate:"required"`
+}
+
+func (rs Resource) Create(rw http.ResponseWriter, req *http.Request) {
+	var data CreateReq
+	err := json.NewDecoder(req.Body).Decode(&data)
+	if err != nil {
+		http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	err = validator.New().Struct(data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	signal := unix.SignalNum(data.Signal)
+	if signal == 0 {
+		http.Error(rw, "unknown signal "+data.Signal, http.StatusBadRequest)
+		return
+	}
+
+	var snapshot stove8sv1beta1.SnapShot
+	err = rs.SnapShots.Get(req.Context(), types.NamespacedName{Namespace: data.Namespace, Name: data.SnapShot}, &snapshot)
+	if apierrors.IsNotFound(err) {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.Error("Getting snapshot", "namespace", data.Namespace, "snapshot", data.SnapShot, "err", err)
+		http.Error(rw, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	if !Declared(&snapshot, data.Signal) {
+		slog.Warn("Refusing undeclared signal", "namespace", data.Namespace, "snapshot", data.SnapShot, "signal", data.Signal)
+		http.Error(rw, fmt.Sprintf("snapshot %s doesn't declare %s", data.SnapShot, data.Signal), http.StatusForbidden)
+		return
+	}
+
+	socket := rs.CRISocket
+	if socket == "" {
+		socket, err = oci.CRISocketDetect(rs.HostRoot)
+		if err != nil {
+			slog.Error("Detecting CRI socket", "err", err)
+			http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	pid, namespace, err := oci.CRIContainerPID(req.Context(), socket, data.ContainerID)
+	if err != nil {
+		slog.Error("Getting container pid", "err", err)
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if namespace != data.Namespace {
+		slog.Warn("Refusing signal to another namespace", "container", data.ContainerID, "namespace", namespace, "snapshot", data.SnapShot)
+		http.Error(rw, fmt.Sprintf("container %s isn't in namespace %s", data.ContainerID, data.Namespace), http.StatusForbidden)
+		return
+	}
+
+	err = syscall.Kill(pid, signal)
+	if err != nil {
+		slog.Error("Sending signal", "pid", pid, "signal", data.Signal, "err", err)
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Sent signal", "container", data.ContainerID, "pid", pid, "signal", data.Signal)
+
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,43 @@
+package signals
+
+import (
+	"slices"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	"github.com/go-chi/chi/v5"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Resource sends signals to the containers of the node, through their host
+// PID, so images without a shell or kill binary can be signaled too. The
+// daemonset must share the host PID namespace. Only the signals the SnapShot
+// declares are sent, to the containers of its namespace
+type Resource struct {
+	// HostRoot is where the node paths are mounted, the CRI socket
+	// is looked up under it when CRISocket is unset
+	HostRoot  string
+	CRISocket string
+	// SnapShots reads the SnapShot the signals are sent for
+	SnapShots client.Reader
+}
+
+func (rs Resource) Init() (chi.Router, error) {
+	r := chi.NewRouter()
+
+	r.Post("/", rs.Create)
+
+	return r, nil
+}
+
+// Declared reports whether snapshot sends signal, in its hooks or to slim
+// its containers
+func Declared(snapshot *stove8sv1beta1.SnapShot, signal string) bool {
+	hooks := snapshot.Spec.Input.Hooks
+	for _, hook := range slices.Concat(hooks.Pre, hooks.Post) {
+		if hook.Signal != nil && hook.Signal.Name == signal {
+			return true
+		}
+	}
+	slim := snapshot.Spec.Input.Slim
+	return slim != nil && slim.GC != nil && slim.GC.Signal != nil && slim.GC.Signal.Name == signal
+}
@@ -0,0 +1,94 @@
+package signals
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testSnapShot() *stove8sv1beta1.SnapShot {
+	snapshot := &stove8sv1beta1.SnapShot{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+	}
+	snapshot.Spec.Input.Hooks.Pre = []stove8sv1beta1.SnapShotHook{
+		{Name: "flush", Signal: &stove8sv1beta1.SnapShotHookSignal{Name: "SIGUSR1"}},
+		{Name: "sync", Exec: &stove8sv1beta1.SnapShotHookExec{Command: []string{"sync"}}},
+	}
+	snapshot.Spec.Input.Hooks.Post = []stove8sv1beta1.SnapShotHook{
+		{Name: "resume", Signal: &stove8sv1beta1.SnapShotHookSignal{Name: "SIGCONT"}},
+	}
+	snapshot.Spec.Input.Slim = &stove8sv1beta1.SnapShotInputSlim{
+		GC: &stove8sv1beta1.SnapShotSlimGC{Signal: &stove8sv1beta1.SnapShotHookSignal{Name: "SIGUSR2"}},
+	}
+	return snapshot
+}
+
+func TestDeclared(t *testing.T) {
+	snapshot := testSnapShot()
+	for signal, expected := range map[string]bool{
+		"SIGUSR1": true,
+		"SIGCONT": true,
+		"SIGUSR2": true,
+		"SIGKILL": false,
+		"SIGTERM": false,
+	} {
+		if Declared(snapshot, signal) != expected {
+			t.Errorf("expected %s declared to be %v", signal, expected)
+		}
+	}
+
+	if Declared(&stove8sv1beta1.SnapShot{}, "SIGUSR1") {
+		t.Error("expected a SnapShot without hooks to declare no signal")
+	}
+}
+
+func TestCreateRefused(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := stove8sv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	rs := Resource{
+		SnapShots: fake.NewClientBuilder().WithScheme(scheme).WithObjects(testSnapShot()).Build(),
+	}
+
+	for _, tc := range []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{
+			name:     "missing snapshot",
+			body:     `{"container_id":"containerd://abc","signal":"SIGUSR1"}`,
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "unknown snapshot",
+			body:     `{"namespace":"default","snapshot":"api","container_id":"containerd://abc","signal":"SIGUSR1"}`,
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "other namespace",
+			body:     `{"namespace":"kube-system","snapshot":"web","container_id":"containerd://abc","signal":"SIGUSR1"}`,
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "undeclared signal",
+			body:     `{"namespace":"default","snapshot":"web","container_id":"containerd://abc","signal":"SIGKILL"}`,
+			expected: http.StatusForbidden,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			rs.Create(rw, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body)))
+			if rw.Code != tc.expected {
+				t.Errorf("expected status %d, got %d: %s", tc.expected, rw.Code, rw.Body)
+			}
+		})
+	}
+}
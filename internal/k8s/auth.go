@@ -0,0 +1,267 @@
+package k8s
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// tokenReviewTTL bounds how long a reviewed token is trusted without asking
+// the API server again, a revoked token keeps working for that long
+const tokenReviewTTL = time.Minute
+
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+type tokenReviewed struct {
+	user    authenticationv1.UserInfo
+	expires time.Time
+}
+
+// DaemonsetAudience is the audience of the tokens the controller calls the
+// daemonsets with, they're refused by the API server and every other service
+const DaemonsetAudience = "stove8s.bud.studio/daemonset"
+
+// TokenReviewer authenticates bearer tokens with TokenReviews, caching the
+// results for tokenReviewTTL. Tokens must be issued for one of audiences when
+// it's set, for the API server otherwise
+type TokenReviewer struct {
+	client    kubernetes.Interface
+	audiences []string
+	now       func() time.Time
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]tokenReviewed
+}
+
+func NewTokenReviewer(client kubernetes.Interface, audiences ...string) *TokenReviewer {
+	return &TokenReviewer{
+		client:    client,
+		audiences: audiences,
+		now:       time.Now,
+		cache:     map[[sha256.Size]byte]tokenReviewed{},
+	}
+}
+
+// Review returns the user token belongs to, ErrUnauthenticated when the API
+// server doesn't know it
+func (tr *TokenReviewer) Review(ctx context.Context, token string) (authenticationv1.UserInfo, error) {
+	if token == "" {
+		return authenticationv1.UserInfo{}, ErrUnauthenticated
+	}
+	// NOTE: hashed so the tokens aren't kept in memory
+	key := sha256.Sum256([]byte(token))
+
+	tr.mu.Lock()
+	reviewed, ok := tr.cache[key]
+	tr.mu.Unlock()
+	if ok && tr.now().Before(reviewed.expires) {
+		return reviewed.user, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 8*time.Second)
+	defer cancel()
+	review, err := tr.client.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token, Audiences: tr.audiences},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return authenticationv1.UserInfo{}, fmt.Errorf("reviewing token: %w", err)
+	}
+	if !review.Status.Authenticated {
+		return authenticationv1.UserInfo{}, ErrUnauthenticated
+	}
+	// NOTE: the API server answers the audiences of the token among the
+	// asked ones, authenticators not supporting audiences answer none
+	if len(tr.audiences) != 0 && !slices.ContainsFunc(review.Status.Audiences, func(audience string) bool {
+		return slices.Contains(tr.audiences, audience)
+	}) {
+		return authenticationv1.UserInfo{}, ErrUnauthenticated
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	now := tr.now()
+	for cached, reviewed := range tr.cache {
+		if !now.Before(reviewed.expires) {
+			delete(tr.cache, cached)
+		}
+	}
+	tr.cache[key] = tokenReviewed{
+		user:    review.Status.User,
+		expires: now.Add(tokenReviewTTL),
+	}
+	return review.Status.User, nil
+}
+
+// tokenRequestExpiration is the lifetime of the requested tokens, the
+// shortest the API server issues
+const tokenRequestExpiration = 10 * time.Minute
+
+// TokenRequester requests short-lived tokens of a service account for an
+// audience with TokenRequests, renewing them once 80% of their lifetime passed
+type TokenRequester struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	audience  string
+	now       func() time.Time
+
+	mu    sync.Mutex
+	token string
+	renew time.Time
+}
+
+func NewTokenRequester(client kubernetes.Interface, namespace, name, audience string) *TokenRequester {
+	return &TokenRequester{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+		audience:  audience,
+		now:       time.Now,
+	}
+}
+
+// Token returns a token of the service account for the audience
+func (tr *TokenRequester) Token(ctx context.Context) (string, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if tr.token != "" && tr.now().Before(tr.renew) {
+		return tr.token, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 8*time.Second)
+	defer cancel()
+	requested := tr.now()
+	expirationSeconds := int64(tokenRequestExpiration.Seconds())
+	request, err := tr.client.CoreV1().ServiceAccounts(tr.namespace).CreateToken(ctx, tr.name, &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences:         []string{tr.audience},
+			ExpirationSeconds: &expirationSeconds,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("requesting a token of %s/%s: %w", tr.namespace, tr.name, err)
+	}
+	lifetime := tokenRequestExpiration
+	if expires := request.Status.ExpirationTimestamp; !expires.IsZero() {
+		lifetime = expires.Sub(requested)
+	}
+	tr.token = request.Status.Token
+	tr.renew = requested.Add(lifetime * 8 / 10)
+	return tr.token, nil
+}
+
+// ServiceAccountUsername is the username tokens of the service account name
+// of namespace authenticate as
+func ServiceAccountUsername(namespace, name string) string {
+	return fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name)
+}
+
+// ServiceAccountFromUsername returns the namespace and name of the service
+// account username is, ok is false for other users
+func ServiceAccountFromUsername(username string) (string, string, bool) {
+	serviceAccount, ok := strings.CutPrefix(username, "system:serviceaccount:")
+	if !ok {
+		return "", "", false
+	}
+	namespace, name, ok := strings.Cut(serviceAccount, ":")
+	if !ok || namespace == "" || name == "" || strings.Contains(name, ":") {
+		return "", "", false
+	}
+	return namespace, name, true
+}
+
+// accessReviewTTL bounds how long an access decision is kept, a revoked role
+// binding keeps working for that long
+const accessReviewTTL = time.Minute
+
+var ErrForbidden = errors.New("forbidden")
+
+type accessReviewed struct {
+	allowed bool
+	expires time.Time
+}
+
+// AccessReviewer authorizes users with SubjectAccessReviews, caching the
+// decisions for accessReviewTTL
+type AccessReviewer struct {
+	client kubernetes.Interface
+	now    func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]accessReviewed
+}
+
+func NewAccessReviewer(client kubernetes.Interface) *AccessReviewer {
+	return &AccessReviewer{
+		client: client,
+		now:    time.Now,
+		cache:  map[string]accessReviewed{},
+	}
+}
+
+// Review returns ErrForbidden when user isn't allowed the attributes
+func (ar *AccessReviewer) Review(ctx context.Context, user authenticationv1.UserInfo, attributes authorizationv1.ResourceAttributes) error {
+	rawKey, err := json.Marshal(struct {
+		User       authenticationv1.UserInfo
+		Attributes authorizationv1.ResourceAttributes
+	}{user, attributes})
+	if err != nil {
+		return err
+	}
+	key := string(rawKey)
+
+	ar.mu.Lock()
+	reviewed, ok := ar.cache[key]
+	ar.mu.Unlock()
+	if !ok || !ar.now().Before(reviewed.expires) {
+		extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+		for name, value := range user.Extra {
+			extra[name] = authorizationv1.ExtraValue(value)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, 8*time.Second)
+		defer cancel()
+		review, err := ar.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				ResourceAttributes: &attributes,
+				User:               user.Username,
+				Groups:             user.Groups,
+				UID:                user.UID,
+				Extra:              extra,
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("reviewing access: %w", err)
+		}
+
+		ar.mu.Lock()
+		now := ar.now()
+		for cached, reviewed := range ar.cache {
+			if !now.Before(reviewed.expires) {
+				delete(ar.cache, cached)
+			}
+		}
+		reviewed = accessReviewed{
+			allowed: review.Status.Allowed && !review.Status.Denied,
+			expires: now.Add(accessReviewTTL),
+		}
+		ar.cache[key] = reviewed
+		ar.mu.Unlock()
+	}
+
+	if !reviewed.allowed {
+		return fmt.Errorf("%w: %s can't %s %s in %s", ErrForbidden, user.Username, attributes.Verb, attributes.Resource, attributes.Namespace)
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestTokenReviewerReview(t *testing.T) {
+	client := fake.NewClientset()
+	var reviews int
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == "controller" {
+			review.Status.Authenticated = true
+			review.Status.User.Username = ServiceAccountUsername("stove8s-system", "stove8s-controller-manager")
+		}
+		return true, review, nil
+	})
+
+	now := time.Now()
+	reviewer := NewTokenReviewer(client)
+	reviewer.now = func() time.Time {
+		return now
+	}
+
+	for range 2 {
+		user, err := reviewer.Review(context.Background(), "controller")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Username != "system:serviceaccount:stove8s-system:stove8s-controller-manager" {
+			t.Errorf("unexpected user %s", user.Username)
+		}
+	}
+	if reviews != 1 {
+		t.Errorf("expected the review to be cached, got %d reviews", reviews)
+	}
+
+	now = now.Add(tokenReviewTTL)
+	_, err := reviewer.Review(context.Background(), "controller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reviews != 2 {
+		t.Errorf("expected the expired review to be renewed, got %d reviews", reviews)
+	}
+
+	_, err = reviewer.Review(context.Background(), "forged")
+	if !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expected ErrUnauthenticated, got %v", err)
+	}
+	_, err = reviewer.Review(context.Background(), "")
+	if !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expected ErrUnauthenticated for an empty token, got %v", err)
+	}
+}
+
+func TestAccessReviewerReview(t *testing.T) {
+	client := fake.NewClientset()
+	var reviews int
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		review.Status.Allowed = review.Spec.User == "alice" && review.Spec.ResourceAttributes.Namespace == "team-a"
+		return true, review, nil
+	})
+
+	now := time.Now()
+	reviewer := NewAccessReviewer(client)
+	reviewer.now = func() time.Time {
+		return now
+	}
+	attributes := func(namespace string) authorizationv1.ResourceAttributes {
+		return authorizationv1.ResourceAttributes{
+			Namespace: namespace,
+			Verb:      "get",
+			Group:     "stove8s.bud.studio",
+			Resource:  "snapshots",
+		}
+	}
+	alice := authenticationv1.UserInfo{Username: "alice"}
+
+	for range 2 {
+		err := reviewer.Review(context.Background(), alice, attributes("team-a"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reviews != 1 {
+		t.Errorf("expected the review to be cached, got %d reviews", reviews)
+	}
+
+	err := reviewer.Review(context.Background(), alice, attributes("team-b"))
+	if !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden in another namespace, got %v", err)
+	}
+	err = reviewer.Review(context.Background(), authenticationv1.UserInfo{Username: "bob"}, attributes("team-a"))
+	if !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden for another user, got %v", err)
+	}
+	if reviews != 3 {
+		t.Errorf("expected every user and namespace to be reviewed, got %d reviews", reviews)
+	}
+
+	now = now.Add(accessReviewTTL)
+	err = reviewer.Review(context.Background(), alice, attributes("team-a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reviews != 4 {
+		t.Errorf("expected the expired review to be renewed, got %d reviews", reviews)
+	}
+}
+
+func TestTokenReviewerAudiences(t *testing.T) {
+	client := fake.NewClientset()
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		review.Status.Authenticated = true
+		review.Status.User.Username = ServiceAccountUsername("stove8s-system", "stove8s-controller-manager")
+		// NOTE: the API server intersects the token audiences with the asked ones
+		if review.Spec.Token == "scoped" && slices.Contains(review.Spec.Audiences, DaemonsetAudience) {
+			review.Status.Audiences = []string{DaemonsetAudience}
+		}
+		return true, review, nil
+	})
+
+	reviewer := NewTokenReviewer(client, DaemonsetAudience)
+	_, err := reviewer.Review(context.Background(), "scoped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = reviewer.Review(context.Background(), "api-server")
+	if !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expected a token of another audience to be refused, got %v", err)
+	}
+}
+
+func TestTokenRequesterToken(t *testing.T) {
+	client := fake.NewClientset()
+	var requests []*authenticationv1.TokenRequest
+	client.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		create := action.(k8stesting.CreateAction)
+		if create.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		request := create.GetObject().(*authenticationv1.TokenRequest)
+		requests = append(requests, request)
+		request.Status.Token = fmt.Sprintf("token-%d", len(requests))
+		request.Status.ExpirationTimestamp = metav1.NewTime(time.Now().Add(time.Duration(*request.Spec.ExpirationSeconds) * time.Second))
+		return true, request, nil
+	})
+
+	now := time.Now()
+	requester := NewTokenRequester(client, "stove8s-system", "stove8s-controller-manager", DaemonsetAudience)
+	requester.now = func() time.Time {
+		return now
+	}
+
+	for range 2 {
+		token, err := requester.Token(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "token-1" {
+			t.Errorf("unexpected token %s", token)
+		}
+	}
+	if len(requests) != 1 {
+		t.Fatalf("expected the token to be reused, got %d requests", len(requests))
+	}
+	if !slices.Equal(requests[0].Spec.Audiences, []string{DaemonsetAudience}) || *requests[0].Spec.ExpirationSeconds != 600 {
+		t.Errorf("unexpected token request %+v", requests[0].Spec)
+	}
+
+	now = now.Add(9 * time.Minute)
+	token, err := requester.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "token-2" {
+		t.Errorf("expected the token to be renewed before it expires, got %s", token)
+	}
+}
+
+func TestServiceAccountFromUsername(t *testing.T) {
+	for username, expected := range map[string][2]string{
+		"system:serviceaccount:stove8s-system:stove8s-controller-manager": {"stove8s-system", "stove8s-controller-manager"},
+		"system:serviceaccount:stove8s-system":                            {},
+		"system:serviceaccount::name":                                     {},
+		"alice":                                                           {},
+	} {
+		namespace, name, ok := ServiceAccountFromUsername(username)
+		if ok != (expected[0] != "") || namespace != expected[0] || name != expected[1] {
+			t.Errorf("unexpected service account %s/%s (%v) for %s", namespace, name, ok, username)
+		}
+	}
+}
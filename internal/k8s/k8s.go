@@ -2,14 +2,71 @@ package k8s
 
 import (
 	"context"
+	"crypto"
+	"fmt"
+	"os"
 	"time"
 
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
 	"bud.studio/stove8s/internal/oci"
 	"github.com/google/go-containerregistry/pkg/authn"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+func configInit() (*rest.Config, error) {
+	k8sConfig, err := rest.InClusterConfig()
+	if err != nil {
+		kubeconfig := os.Getenv("HOME") + "/.kube/config"
+		if kubeconfigPath := os.Getenv("KUBECONFIG"); kubeconfigPath != "" {
+			kubeconfig = kubeconfigPath
+		}
+
+		k8sConfig, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build config: %w", err)
+		}
+	}
+	return k8sConfig, nil
+}
+
+func ClientInit() (*kubernetes.Clientset, error) {
+	k8sConfig, err := configInit()
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(k8sConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clientset: %w", err)
+	}
+	return clientset, nil
+}
+
+// SnapShotClientInit returns a client of the stove8s resources
+func SnapShotClientInit() (client.Client, error) {
+	k8sConfig, err := configInit()
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := runtime.NewScheme()
+	err = stove8sv1beta1.AddToScheme(scheme)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build scheme: %w", err)
+	}
+	k8sClient, err := client.New(k8sConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	return k8sClient, nil
+}
+
 func ImagePushSecretGet(k8sClient *kubernetes.Clientset, namespace, secretName, registry string) (authn.Authenticator, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
 	defer cancel()
@@ -20,3 +77,53 @@ func ImagePushSecretGet(k8sClient *kubernetes.Clientset, namespace, secretName,
 
 	return oci.AuthFromK8sSecret(secret, registry)
 }
+
+func ImagePullKeychainGet(k8sClient *kubernetes.Clientset, namespace, secretName string) (authn.Keychain, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	secret, err := k8sClient.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return oci.KeychainFromK8sSecret(secret), nil
+}
+
+func SigningKeyGet(k8sClient *kubernetes.Clientset, namespace, secretName string) (crypto.Signer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	secret, err := k8sClient.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return oci.SignerFromK8sSecret(secret)
+}
+
+func ObjectStoreCredentialsGet(k8sClient *kubernetes.Clientset, namespace, secretName string) (oci.ObjectStoreCredentials, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	secret, err := k8sClient.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		return oci.ObjectStoreCredentials{}, err
+	}
+
+	return oci.ObjectStoreCredentialsFromK8sSecret(secret)
+}
+
+// DecryptionKeySecretGet returns the secret once its entries are known to be
+// usable as ocicrypt decryption keys
+func DecryptionKeySecretGet(k8sClient *kubernetes.Clientset, namespace, secretName string) (*corev1.Secret, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	secret, err := k8sClient.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = oci.DecryptConfigFromK8sSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
@@ -64,3 +64,22 @@ const (
 	// CheckpointAnnotationDistributionVersion specifies the version of the host distribution on which the checkpoint was created.
 	CheckpointAnnotationDistributionVersion = "org.criu.checkpoint.distribution.version"
 )
+
+const (
+	// Stove8sAnnotationCompression specifies the compression used for the checkpoint layers.
+	Stove8sAnnotationCompression = "studio.bud.stove8s.compression"
+
+	// Stove8sAnnotationLayerContent specifies the part of the checkpoint archive a layer holds.
+	Stove8sAnnotationLayerContent = "studio.bud.stove8s.layer.content"
+
+	// Stove8sAnnotationLayerFiles specifies the digest of every file in a layer as a JSON object,
+	// omitted on encrypted layers.
+	Stove8sAnnotationLayerFiles = "studio.bud.stove8s.layer.files"
+
+	// Stove8sAnnotationParent specifies the digest of the checkpoint image an incremental checkpoint stacks on.
+	Stove8sAnnotationParent = "studio.bud.stove8s.parent"
+
+	// Stove8sAnnotationBaseImageSource specifies the base image the checkpoint was taken from, when
+	// CheckpointAnnotationRootfsImageName has been pinned to a replicated copy of it.
+	Stove8sAnnotationBaseImageSource = "studio.bud.stove8s.base.source"
+)
@@ -0,0 +1,144 @@
+package oci
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// BlobCache is a content-addressed store of blobs on disk, a blob is only
+// stored under its digest once its content is verified against it
+type BlobCache struct {
+	Dir string
+	// MaxSize is the size in bytes the least recently used blobs are evicted
+	// past, 0 never evicts
+	MaxSize int64
+}
+
+func (cache BlobCache) path(digest v1.Hash) string {
+	return filepath.Join(cache.Dir, digest.Algorithm, digest.Hex)
+}
+
+// Open returns the cached blob, the error satisfies os.IsNotExist when it
+// isn't cached. Its modification time is bumped, blobs are evicted by it
+func (cache BlobCache) Open(digest v1.Hash) (*os.File, error) {
+	file, err := os.Open(cache.path(digest))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	err = os.Chtimes(file.Name(), now, now)
+	if err != nil {
+		slog.Warn("Touching cached blob", "digest", digest, "err", err)
+	}
+	return file, nil
+}
+
+// Write stores the content of r under digest, nothing is stored when the
+// content doesn't match it
+func (cache BlobCache) Write(digest v1.Hash, r io.Reader) error {
+	if digest.Algorithm != "sha256" {
+		return fmt.Errorf("unsupported digest algorithm %s", digest.Algorithm)
+	}
+	path := cache.path(digest)
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), ".blob-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(file.Name())
+	}()
+
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hasher), r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(hasher.Sum(nil)); sum != digest.Hex {
+		return fmt.Errorf("blob digest mismatch, expected %s got sha256:%s", digest, sum)
+	}
+
+	// NOTE: concurrent writes of the same blob are identical, the last rename wins
+	err = os.Rename(file.Name(), path)
+	if err != nil {
+		return err
+	}
+	err = cache.evict(path)
+	if err != nil {
+		slog.Warn("Evicting cached blobs", "err", err)
+	}
+	return nil
+}
+
+// evict removes the least recently used blobs but keep until the cache fits
+// MaxSize. Blobs being served stay readable, only their name is removed
+func (cache BlobCache) evict(keep string) error {
+	if cache.MaxSize <= 0 {
+		return nil
+	}
+
+	type blob struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var blobs []blob
+	var size int64
+	err := filepath.WalkDir(cache.Dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// NOTE: dotfiles are blobs still being written
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		if path != keep {
+			blobs = append(blobs, blob{path: path, size: info.Size(), modTime: info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	slices.SortFunc(blobs, func(a, b blob) int {
+		return a.modTime.Compare(b.modTime)
+	})
+	for _, blob := range blobs {
+		if size <= cache.MaxSize {
+			break
+		}
+		err := os.Remove(blob.path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		size -= blob.size
+	}
+	return nil
+}
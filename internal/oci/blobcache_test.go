@@ -0,0 +1,101 @@
+package oci
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+func TestBlobCache(t *testing.T) {
+	cache := BlobCache{Dir: t.TempDir()}
+	blob := []byte("checkpoint layer")
+	digest, _, err := v1.SHA256(bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = cache.Open(digest)
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected the blob not to be cached, got %v", err)
+	}
+
+	err = cache.Write(digest, bytes.NewReader([]byte("tampered layer")))
+	if err == nil {
+		t.Fatal("expected a digest mismatch")
+	}
+	entries, err := os.ReadDir(filepath.Join(cache.Dir, "sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("mismatched blob left behind: %v", entries)
+	}
+
+	err = cache.Write(digest, bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := cache.Open(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	cached, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cached, blob) {
+		t.Error("cached blob differs")
+	}
+}
+
+func TestBlobCacheEvict(t *testing.T) {
+	cache := BlobCache{Dir: t.TempDir(), MaxSize: 25}
+	var digests []v1.Hash
+	for _, blob := range []string{"first layer", "second one", "third one!"} {
+		digest, _, err := v1.SHA256(bytes.NewReader([]byte(blob)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests = append(digests, digest)
+	}
+	write := func(idx int, blob string, used time.Time) {
+		t.Helper()
+		err := cache.Write(digests[idx], bytes.NewReader([]byte(blob)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chtimes(cache.path(digests[idx]), used, used)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	past := time.Now().Add(-time.Hour)
+	write(0, "first layer", past)
+	write(1, "second one", past.Add(time.Minute))
+	// NOTE: the first blob is now the most recently used
+	file, err := cache.Open(digests[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+	write(2, "third one!", past.Add(2*time.Minute))
+
+	for idx, cached := range []bool{true, false, true} {
+		file, err := os.Open(cache.path(digests[idx]))
+		if err == nil {
+			_ = file.Close()
+		}
+		if cached == os.IsNotExist(err) {
+			t.Errorf("blob %d: expected cached %v, got %v", idx, cached, err)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package oci
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// BlobsPreflight makes sure the layers of img that the registry already has
+// aren't uploaded again, they're either present in the repository of ref or
+// cross-repository mounted from one of mountFrom. It returns the size of the
+// layers that won't be uploaded by remote.Write
+func BlobsPreflight(
+	ctx context.Context,
+	ref name.Reference,
+	img v1.Image,
+	mountFrom []string,
+	auth authn.Authenticator,
+) (int64, error) {
+	repo := ref.Context()
+	scopes := []string{repo.Scope(transport.PushScope)}
+
+	var sources []name.Repository
+	for _, source := range mountFrom {
+		sourceRepo, err := name.NewRepository(source)
+		if err != nil {
+			return 0, fmt.Errorf("parsing mount source %s: %v", source, err)
+		}
+		// NOTE: blobs can only be mounted across repositories of the same registry
+		if sourceRepo.RegistryStr() != repo.RegistryStr() || sourceRepo.String() == repo.String() {
+			slog.Warn("Ignoring mount source", "source", source, "destination", repo.String())
+			continue
+		}
+		sources = append(sources, sourceRepo)
+		scopes = append(scopes, sourceRepo.Scope(transport.PullScope))
+	}
+
+	rt, err := transport.NewWithContext(ctx, repo.Registry, auth, http.DefaultTransport, scopes)
+	if err != nil {
+		return 0, err
+	}
+	client := &http.Client{Transport: rt}
+
+	manifest, err := img.Manifest()
+	if err != nil {
+		return 0, err
+	}
+
+	var skipped int64
+	for _, desc := range manifest.Layers {
+		exists, err := blobExists(ctx, client, repo, desc.Digest)
+		if err != nil {
+			return skipped, err
+		}
+		if exists {
+			skipped += desc.Size
+			continue
+		}
+
+		for _, source := range sources {
+			mounted, err := blobMount(ctx, client, repo, source, desc.Digest)
+			if err != nil {
+				return skipped, err
+			}
+			if mounted {
+				slog.Info("Mounted blob", "digest", desc.Digest.String(), "from", source.String())
+				skipped += desc.Size
+				break
+			}
+		}
+	}
+
+	return skipped, nil
+}
+
+func blobURL(repo name.Repository, path string) url.URL {
+	return url.URL{
+		Scheme: repo.Scheme(),
+		Host:   repo.RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/blobs/%s", repo.RepositoryStr(), path),
+	}
+}
+
+func blobExists(ctx context.Context, client *http.Client, repo name.Repository, digest v1.Hash) (bool, error) {
+	u := blobURL(repo, digest.String())
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	err = transport.CheckError(resp, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return false, err
+	}
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// blobMount asks the registry to mount digest from source into repo, when the
+// registry can't it starts a regular upload instead, which is cancelled here
+// as remote.Write starts its own
+func blobMount(
+	ctx context.Context,
+	client *http.Client,
+	repo name.Repository,
+	source name.Repository,
+	digest v1.Hash,
+) (bool, error) {
+	u := blobURL(repo, "uploads/")
+	u.RawQuery = url.Values{
+		"mount": []string{digest.String()},
+		"from":  []string{source.RepositoryStr()},
+	}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	err = transport.CheckError(resp, http.StatusCreated, http.StatusAccepted)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode == http.StatusCreated {
+		return true, nil
+	}
+
+	location, err := resp.Location()
+	if err != nil {
+		return false, nil
+	}
+	cancelReq, err := http.NewRequestWithContext(ctx, http.MethodDelete, location.String(), nil)
+	if err != nil {
+		return false, nil
+	}
+	cancelResp, err := client.Do(cancelReq)
+	if err != nil {
+		slog.Warn("Cancelling blob upload", "err", err)
+		return false, nil
+	}
+	_ = cancelResp.Body.Close()
+
+	return false, nil
+}
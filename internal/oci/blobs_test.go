@@ -0,0 +1,176 @@
+package oci
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestBlobsPreflight(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pushed, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushedRef, err := name.ParseReference(u.Host + "/sibling/service:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = remote.Write(pushedRef, pushed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := name.ParseReference(u.Host + "/checkpoint/service:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mountFrom := []string{pushedRef.Context().String()}
+
+	skipped, err := BlobsPreflight(context.Background(), ref, pushed, mountFrom, authn.Anonymous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := ImageSize(pushed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != size {
+		t.Errorf("expected %d skipped bytes for existing blobs, got %d", size, skipped)
+	}
+
+	fresh, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skipped, err = BlobsPreflight(context.Background(), ref, fresh, mountFrom, authn.Anonymous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != 0 {
+		t.Errorf("expected no skipped bytes for new blobs, got %d", skipped)
+	}
+}
+
+// mountRegistry keeps blobs per repository, the ggcr registry shares them
+// across every repository so a blob is never missing from the destination,
+// and it records the mounts and the uploads started
+type mountRegistry struct {
+	mu      sync.Mutex
+	blobs   map[string]bool
+	mounts  []string
+	uploads int
+}
+
+func (mr *mountRegistry) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	repo, rest, _ := strings.Cut(path, "/blobs/")
+	switch {
+	case path == "":
+		rw.WriteHeader(http.StatusOK)
+	case req.Method == http.MethodHead:
+		if !mr.blobs[repo+"@"+rest] {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	case req.Method == http.MethodPost && rest == "uploads/":
+		digest := req.URL.Query().Get("mount")
+		from := req.URL.Query().Get("from")
+		if mr.blobs[from+"@"+digest] {
+			mr.blobs[repo+"@"+digest] = true
+			mr.mounts = append(mr.mounts, digest)
+			rw.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repo, digest))
+			rw.WriteHeader(http.StatusCreated)
+			return
+		}
+		mr.uploads++
+		rw.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%d", repo, mr.uploads))
+		rw.WriteHeader(http.StatusAccepted)
+	case req.Method == http.MethodDelete:
+		rw.WriteHeader(http.StatusNoContent)
+	default:
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestBlobsPreflightMount(t *testing.T) {
+	reg := &mountRegistry{blobs: make(map[string]bool)}
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, desc := range manifest.Layers {
+		reg.blobs["sibling/service@"+desc.Digest.String()] = true
+	}
+
+	ref, err := name.ParseReference(u.Host + "/checkpoint/service:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mountFrom := []string{u.Host + "/sibling/service"}
+
+	skipped, err := BlobsPreflight(context.Background(), ref, img, mountFrom, authn.Anonymous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := ImageSize(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != size {
+		t.Errorf("expected %d skipped bytes for mounted blobs, got %d", size, skipped)
+	}
+	if len(reg.mounts) != len(manifest.Layers) {
+		t.Errorf("expected %d mounts, got %d", len(manifest.Layers), len(reg.mounts))
+	}
+	if reg.uploads != 0 {
+		t.Errorf("expected no upload for mounted blobs, got %d", reg.uploads)
+	}
+
+	fresh, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skipped, err = BlobsPreflight(context.Background(), ref, fresh, mountFrom, authn.Anonymous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != 0 {
+		t.Errorf("expected no skipped bytes for blobs missing from the source, got %d", skipped)
+	}
+	if len(reg.mounts) != len(manifest.Layers) {
+		t.Errorf("expected no new mount, got %d", len(reg.mounts)-len(manifest.Layers))
+	}
+}
@@ -0,0 +1,145 @@
+package oci
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// cgroupContainerID is the 64 hex characters ID of a container
+var cgroupContainerID = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// CgroupFind returns the cgroup v2 directory of containerID under the kubepods
+// cgroup of root, the node cgroup filesystem. containerID may carry the
+// <runtime>:// prefix. The directory must be named exactly as the systemd
+// driver of containerd (cri-containerd-<id>.scope) or CRI-O (crio-<id>.scope)
+// names it, or after the bare ID with the cgroupfs driver
+func CgroupFind(root, containerID string) (string, error) {
+	if _, id, ok := strings.Cut(containerID, "://"); ok {
+		containerID = id
+	}
+	if !cgroupContainerID.MatchString(containerID) {
+		return "", fmt.Errorf("invalid container ID %q", containerID)
+	}
+	names := []string{
+		"cri-containerd-" + containerID + ".scope",
+		"crio-" + containerID + ".scope",
+		containerID,
+	}
+
+	var found string
+	for _, kubepods := range []string{"kubepods.slice", "kubepods"} {
+		err := filepath.WalkDir(filepath.Join(root, kubepods), func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				// NOTE: cgroups come and go while walking
+				if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+					return nil
+				}
+				return err
+			}
+			if !entry.IsDir() {
+				return nil
+			}
+			if slices.Contains(names, entry.Name()) {
+				found = path
+				return fs.SkipAll
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+		if found != "" {
+			return found, nil
+		}
+	}
+	return "", fmt.Errorf("no cgroup found for container %s under %s", containerID, root)
+}
+
+// CgroupPageCacheReclaim asks the kernel to reclaim the page cache of the
+// cgroup v2 directory dir through memory.reclaim, Linux 5.19 and later. It
+// returns the page cache size before, in bytes. memory.reclaim swaps the
+// anonymous memory out as well when the node has swap the cgroup may use,
+// which would only slow the checkpoint down, so it's refused then
+func CgroupPageCacheReclaim(dir string) (int64, error) {
+	file, err := os.Open(filepath.Join(dir, "memory.stat"))
+	if err != nil {
+		return 0, fmt.Errorf("reading memory.stat, cgroup v2 is required: %v", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	var pageCache int64 = -1
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || key != "file" {
+			continue
+		}
+		pageCache, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parsing memory.stat: %v", err)
+		}
+		break
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	if pageCache == -1 {
+		return 0, errors.New("no file entry in memory.stat")
+	}
+	if pageCache == 0 {
+		return 0, nil
+	}
+	swap, err := cgroupSwapUsable(dir)
+	if err != nil {
+		return pageCache, err
+	}
+	if swap {
+		return pageCache, errors.New("the cgroup may use the node swap, memory.reclaim would swap its memory out")
+	}
+
+	// NOTE: EAGAIN means less than asked was reclaimed, which is fine
+	err = os.WriteFile(filepath.Join(dir, "memory.reclaim"), []byte(strconv.FormatInt(pageCache, 10)), 0)
+	if err != nil && !errors.Is(err, syscall.EAGAIN) {
+		return pageCache, fmt.Errorf("writing memory.reclaim: %v", err)
+	}
+	return pageCache, nil
+}
+
+// procSwapsPath lists the active swap areas of the node, /proc/swaps isn't
+// namespaced
+var procSwapsPath = "/proc/swaps"
+
+// cgroupSwapUsable reports whether the node has active swap and the cgroup v2
+// directory dir isn't kept out of it by memory.swap.max
+func cgroupSwapUsable(dir string) (bool, error) {
+	swaps, err := os.ReadFile(procSwapsPath)
+	if err != nil {
+		return false, fmt.Errorf("reading %s: %v", procSwapsPath, err)
+	}
+	// NOTE: the first line is the header
+	lines := strings.Split(strings.TrimSpace(string(swaps)), "\n")
+	if len(lines) <= 1 {
+		return false, nil
+	}
+
+	swapMax, err := os.ReadFile(filepath.Join(dir, "memory.swap.max"))
+	if errors.Is(err, fs.ErrNotExist) {
+		// NOTE: the kernel was built without swap accounting
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("reading memory.swap.max: %v", err)
+	}
+	return strings.TrimSpace(string(swapMax)) != "0", nil
+}
@@ -0,0 +1,103 @@
+package oci
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCgroupPageCacheReclaim(t *testing.T) {
+	containerID := strings.Repeat("0123abcd", 8)
+	root := t.TempDir()
+	pod := filepath.Join(root, "kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1234.slice")
+	dir := filepath.Join(pod, "cri-containerd-"+containerID+".scope")
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll(filepath.Join(pod, "cri-containerd-"+strings.Repeat("4567ef01", 8)+".scope"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// NOTE: same ID, but outside kubepods or not named after the container
+	for _, other := range []string{
+		"system.slice/cri-containerd-" + containerID + ".scope",
+		"kubepods.slice/kubepods-besteffort.slice/" + containerID + "-init",
+	} {
+		err = os.MkdirAll(filepath.Join(root, other), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = os.WriteFile(filepath.Join(dir, "memory.stat"), []byte("anon 1048576\nfile 4096\nkernel 512\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "memory.reclaim"), nil, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "memory.swap.max"), []byte("max\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	procSwapsPath = filepath.Join(root, "swaps")
+	t.Cleanup(func() {
+		procSwapsPath = "/proc/swaps"
+	})
+	err = os.WriteFile(procSwapsPath, []byte("Filename\tType\tSize\tUsed\tPriority\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := CgroupFind(root, "containerd://"+containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != dir {
+		t.Errorf("unexpected cgroup %s", found)
+	}
+	for _, invalid := range []string{"cri-o://89", "0123abcd", "../" + containerID, strings.ToUpper(containerID)} {
+		_, err = CgroupFind(root, invalid)
+		if err == nil {
+			t.Errorf("expected no cgroup to be found for %s", invalid)
+		}
+	}
+	_, err = CgroupFind(root, "cri-o://"+strings.Repeat("89", 32))
+	if err == nil {
+		t.Error("expected no cgroup to be found for an unknown container")
+	}
+
+	pageCache, err := CgroupPageCacheReclaim(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pageCache != 4096 {
+		t.Errorf("unexpected page cache size %d", pageCache)
+	}
+	reclaim, err := os.ReadFile(filepath.Join(dir, "memory.reclaim"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reclaim) != "4096" {
+		t.Errorf("unexpected memory.reclaim write %q", reclaim)
+	}
+
+	err = os.WriteFile(procSwapsPath, []byte("Filename\tType\tSize\tUsed\tPriority\n/swapfile\tfile\t1048572\t0\t-2\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CgroupPageCacheReclaim(dir)
+	if err == nil {
+		t.Error("expected the reclaim to be refused with swap")
+	}
+	err = os.WriteFile(filepath.Join(dir, "memory.swap.max"), []byte("0\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CgroupPageCacheReclaim(dir)
+	if err != nil {
+		t.Errorf("expected the reclaim of a cgroup without swap to succeed, got %v", err)
+	}
+}
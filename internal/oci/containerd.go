@@ -0,0 +1,274 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	contentapi "github.com/containerd/containerd/api/services/content/v1"
+	imagesapi "github.com/containerd/containerd/api/services/images/v1"
+	leasesapi "github.com/containerd/containerd/api/services/leases/v1"
+	containerdtypes "github.com/containerd/containerd/api/types"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+const (
+	// ContainerdDefaultNamespace is the namespace the CRI plugin, so the kubelet, uses
+	ContainerdDefaultNamespace = "k8s.io"
+
+	containerdNamespaceHeader = "containerd-namespace"
+	containerdLeaseHeader     = "containerd-lease"
+	// containerdCRIImageLabel makes the CRI plugin list the image as its own
+	containerdCRIImageLabel  = "io.cri-containerd.image"
+	containerdWriteChunkSize = 1 << 20
+)
+
+// ContainerdImageName is the name the CRI plugin looks the image of a pod up
+// by, containerd doesn't normalize names itself
+func ContainerdImageName(ref name.Reference) string {
+	registry := ref.Context().RegistryStr()
+	if registry == name.DefaultRegistry {
+		registry = "docker.io"
+	}
+	separator := ":"
+	if _, ok := ref.(name.Digest); ok {
+		separator = "@"
+	}
+	return registry + "/" + ref.Context().RepositoryStr() + separator + ref.Identifier()
+}
+
+// ContainerdImport writes img into the content store of the containerd
+// listening on socket and names it imageName in namespace, so the kubelet can
+// run it without pulling. Blobs containerd already has aren't written again
+func ContainerdImport(ctx context.Context, socket, namespace, imageName string, img v1.Image) error {
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	ctx = metadata.AppendToOutgoingContext(ctx, containerdNamespaceHeader, namespace)
+
+	// NOTE: the blobs aren't referenced by an image until the end, the lease
+	// keeps the garbage collector off them meanwhile
+	leases := leasesapi.NewLeasesClient(conn)
+	lease, err := leases.Create(ctx, &leasesapi.CreateRequest{
+		Labels: map[string]string{
+			"containerd.io/gc.expire": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("creating lease: %v", err)
+	}
+	defer func() {
+		_, err := leases.Delete(context.WithoutCancel(ctx), &leasesapi.DeleteRequest{ID: lease.Lease.ID})
+		if err != nil {
+			slog.Warn("Deleting containerd lease", "lease", lease.Lease.ID, "err", err)
+		}
+	}()
+	ctx = metadata.AppendToOutgoingContext(ctx, containerdLeaseHeader, lease.Lease.ID)
+
+	content := contentapi.NewContentClient(conn)
+	manifest, err := img.Manifest()
+	if err != nil {
+		return err
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return err
+	}
+
+	// NOTE: the manifest labels tell the garbage collector about its children
+	manifestLabels := map[string]string{
+		"containerd.io/gc.ref.content.config": manifest.Config.Digest.String(),
+	}
+	for idx, layer := range layers {
+		desc := manifest.Layers[idx]
+		err = containerdBlobWrite(ctx, content, desc, nil, layer.Compressed)
+		if err != nil {
+			return fmt.Errorf("writing layer %s: %w", desc.Digest, err)
+		}
+		manifestLabels[fmt.Sprintf("containerd.io/gc.ref.content.l.%d", idx)] = desc.Digest.String()
+	}
+
+	rawConfig, err := img.RawConfigFile()
+	if err != nil {
+		return err
+	}
+	err = containerdBlobWrite(ctx, content, manifest.Config, nil, bytesOpener(rawConfig))
+	if err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+
+	rawManifest, err := img.RawManifest()
+	if err != nil {
+		return err
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return err
+	}
+	mediaType, err := img.MediaType()
+	if err != nil {
+		return err
+	}
+	target := v1.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest,
+		Size:      int64(len(rawManifest)),
+	}
+	err = containerdBlobWrite(ctx, content, target, manifestLabels, bytesOpener(rawManifest))
+	if err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+
+	image := &imagesapi.Image{
+		Name: imageName,
+		Labels: map[string]string{
+			containerdCRIImageLabel: "managed",
+		},
+		Target: &containerdtypes.Descriptor{
+			MediaType: string(target.MediaType),
+			Digest:    target.Digest.String(),
+			Size:      target.Size,
+		},
+	}
+	images := imagesapi.NewImagesClient(conn)
+	_, err = images.Create(ctx, &imagesapi.CreateImageRequest{Image: image})
+	if status.Code(err) == codes.AlreadyExists {
+		_, err = images.Update(ctx, &imagesapi.UpdateImageRequest{
+			Image:      image,
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"target", "labels." + containerdCRIImageLabel}},
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("creating image %s: %v", imageName, err)
+	}
+
+	return nil
+}
+
+func bytesOpener(raw []byte) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(raw)), nil
+	}
+}
+
+// containerdBlobWrite streams a blob to the content store unless it's
+// already there, containerd checks the size and digest on commit
+func containerdBlobWrite(
+	ctx context.Context,
+	content contentapi.ContentClient,
+	desc v1.Descriptor,
+	labels map[string]string,
+	open func() (io.ReadCloser, error),
+) error {
+	_, err := content.Info(ctx, &contentapi.InfoRequest{Digest: desc.Digest.String()})
+	if err == nil && labels == nil {
+		return nil
+	}
+	if err != nil && status.Code(err) != codes.NotFound {
+		return err
+	}
+	if err == nil {
+		// NOTE: the manifest may exist without the labels of a previous import
+		_, err = content.Update(ctx, &contentapi.UpdateRequest{
+			Info: &contentapi.Info{
+				Digest: desc.Digest.String(),
+				Labels: labels,
+			},
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: labelPaths(labels)},
+		})
+		return err
+	}
+
+	stream, err := content.Write(ctx)
+	if err != nil {
+		return err
+	}
+	reader, err := open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	ref := "stove8s-" + desc.Digest.String()
+	buf := make([]byte, containerdWriteChunkSize)
+	var offset int64
+	for {
+		n, err := io.ReadFull(reader, buf)
+		if n > 0 {
+			err := containerdWriteSend(stream, &contentapi.WriteContentRequest{
+				Action:   contentapi.WriteAction_WRITE,
+				Ref:      ref,
+				Total:    desc.Size,
+				Expected: desc.Digest.String(),
+				Offset:   offset,
+				Data:     buf[:n],
+			})
+			if status.Code(err) == codes.AlreadyExists {
+				// NOTE: another import committed the same blob meanwhile
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			offset += int64(n)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err = containerdWriteSend(stream, &contentapi.WriteContentRequest{
+		Action:   contentapi.WriteAction_COMMIT,
+		Ref:      ref,
+		Total:    desc.Size,
+		Expected: desc.Digest.String(),
+		Offset:   offset,
+		Labels:   labels,
+	})
+	if status.Code(err) == codes.AlreadyExists {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return stream.CloseSend()
+}
+
+// containerdWriteSend sends a write request, containerd answers every request.
+// A failed Send only reports io.EOF, the actual error is returned by Recv
+func containerdWriteSend(stream contentapi.Content_WriteClient, req *contentapi.WriteContentRequest) error {
+	err := stream.Send(req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	_, err = stream.Recv()
+	return err
+}
+
+func labelPaths(labels map[string]string) []string {
+	paths := make([]string, 0, len(labels))
+	for key := range labels {
+		paths = append(paths, "labels."+key)
+	}
+	return paths
+}
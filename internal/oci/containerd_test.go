@@ -0,0 +1,292 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	contentapi "github.com/containerd/containerd/api/services/content/v1"
+	imagesapi "github.com/containerd/containerd/api/services/images/v1"
+	leasesapi "github.com/containerd/containerd/api/services/leases/v1"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// testContainerd is the subset of the containerd content, images and leases
+// services ContainerdImport uses, it checks the digests like containerd does
+type testContainerd struct {
+	mu      sync.Mutex
+	blobs   map[string][]byte
+	labels  map[string]map[string]string
+	images  map[string]*imagesapi.Image
+	leases  map[string]bool
+	leased  int
+	commits int
+}
+
+func testContainerdServe(t *testing.T) (string, *testContainerd) {
+	t.Helper()
+
+	// NOTE: unix socket paths are limited to 108 bytes, t.TempDir can be longer
+	dir, err := os.MkdirTemp("", "containerd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	socket := filepath.Join(dir, "containerd.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub := &testContainerd{
+		blobs:  make(map[string][]byte),
+		labels: make(map[string]map[string]string),
+		images: make(map[string]*imagesapi.Image),
+		leases: make(map[string]bool),
+	}
+	namespaceCheck := func(ctx context.Context) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if namespaces := md.Get(containerdNamespaceHeader); len(namespaces) != 1 || namespaces[0] != ContainerdDefaultNamespace {
+			return status.Errorf(codes.FailedPrecondition, "namespace is required")
+		}
+		return nil
+	}
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if err := namespaceCheck(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := namespaceCheck(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	contentapi.RegisterContentServer(server, testContainerdContent{testContainerd: stub})
+	imagesapi.RegisterImagesServer(server, testContainerdImages{testContainerd: stub})
+	leasesapi.RegisterLeasesServer(server, testContainerdLeases{testContainerd: stub})
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	return socket, stub
+}
+
+type testContainerdContent struct {
+	contentapi.UnimplementedContentServer
+	*testContainerd
+}
+
+type testContainerdImages struct {
+	imagesapi.UnimplementedImagesServer
+	*testContainerd
+}
+
+type testContainerdLeases struct {
+	leasesapi.UnimplementedLeasesServer
+	*testContainerd
+}
+
+func (stub testContainerdContent) Info(_ context.Context, req *contentapi.InfoRequest) (*contentapi.InfoResponse, error) {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	blob, ok := stub.blobs[req.Digest]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "content %v: not found", req.Digest)
+	}
+	return &contentapi.InfoResponse{Info: &contentapi.Info{
+		Digest: req.Digest,
+		Size:   int64(len(blob)),
+		Labels: stub.labels[req.Digest],
+	}}, nil
+}
+
+func (stub testContainerdContent) Update(_ context.Context, req *contentapi.UpdateRequest) (*contentapi.UpdateResponse, error) {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if _, ok := stub.blobs[req.Info.Digest]; !ok {
+		return nil, status.Errorf(codes.NotFound, "content %v: not found", req.Info.Digest)
+	}
+	for _, path := range req.UpdateMask.Paths {
+		key := strings.TrimPrefix(path, "labels.")
+		stub.labels[req.Info.Digest][key] = req.Info.Labels[key]
+	}
+	return &contentapi.UpdateResponse{Info: req.Info}, nil
+}
+
+func (stub testContainerdContent) Write(stream contentapi.Content_WriteServer) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	if len(md.Get(containerdLeaseHeader)) != 1 {
+		return status.Errorf(codes.FailedPrecondition, "writes must be leased")
+	}
+
+	var buf bytes.Buffer
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch req.Action {
+		case contentapi.WriteAction_WRITE:
+			if req.Offset != int64(buf.Len()) {
+				return status.Errorf(codes.OutOfRange, "write at %d, expected %d", req.Offset, buf.Len())
+			}
+			buf.Write(req.Data)
+		case contentapi.WriteAction_COMMIT:
+			sum := sha256.Sum256(buf.Bytes())
+			if int64(buf.Len()) != req.Total || "sha256:"+hex.EncodeToString(sum[:]) != req.Expected {
+				return status.Errorf(codes.FailedPrecondition, "unexpected commit size %d or digest", buf.Len())
+			}
+			stub.mu.Lock()
+			stub.blobs[req.Expected] = bytes.Clone(buf.Bytes())
+			stub.labels[req.Expected] = req.Labels
+			if stub.labels[req.Expected] == nil {
+				stub.labels[req.Expected] = make(map[string]string)
+			}
+			stub.commits++
+			stub.mu.Unlock()
+		}
+
+		err = stream.Send(&contentapi.WriteContentResponse{
+			Action: req.Action,
+			Offset: int64(buf.Len()),
+			Total:  req.Total,
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (stub testContainerdImages) Create(_ context.Context, req *imagesapi.CreateImageRequest) (*imagesapi.CreateImageResponse, error) {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if _, ok := stub.images[req.Image.Name]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "image %q: already exists", req.Image.Name)
+	}
+	if _, ok := stub.blobs[req.Image.Target.Digest]; !ok {
+		return nil, status.Errorf(codes.NotFound, "target %v: not found", req.Image.Target.Digest)
+	}
+	stub.images[req.Image.Name] = req.Image
+	return &imagesapi.CreateImageResponse{Image: req.Image}, nil
+}
+
+func (stub testContainerdImages) Update(_ context.Context, req *imagesapi.UpdateImageRequest) (*imagesapi.UpdateImageResponse, error) {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	stub.images[req.Image.Name] = req.Image
+	return &imagesapi.UpdateImageResponse{Image: req.Image}, nil
+}
+
+func (stub testContainerdLeases) Create(_ context.Context, req *leasesapi.CreateRequest) (*leasesapi.CreateResponse, error) {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	stub.leased++
+	id := "lease-" + strconv.Itoa(stub.leased)
+	stub.leases[id] = true
+	return &leasesapi.CreateResponse{Lease: &leasesapi.Lease{ID: id, Labels: req.Labels}}, nil
+}
+
+func (stub testContainerdLeases) Delete(_ context.Context, req *leasesapi.DeleteRequest) (*emptypb.Empty, error) {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	delete(stub.leases, req.ID)
+	return &emptypb.Empty{}, nil
+}
+
+func TestContainerdImport(t *testing.T) {
+	socket, stub := testContainerdServe(t)
+
+	ref, err := name.ParseReference("checkpoint/service:restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageName := ContainerdImageName(ref)
+	if imageName != "docker.io/checkpoint/service:restore" {
+		t.Errorf("unexpected containerd image name %s", imageName)
+	}
+
+	img, err := random.Image(1024, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ContainerdImport(context.Background(), socket, ContainerdDefaultNamespace, imageName, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, ok := stub.images[imageName]
+	if !ok || image.Target.Digest != digest.String() || image.Labels[containerdCRIImageLabel] != "managed" {
+		t.Fatalf("unexpected image %v", image)
+	}
+	// NOTE: 3 layers, the config and the manifest
+	if stub.commits != 5 {
+		t.Errorf("expected 5 blobs committed, got %d", stub.commits)
+	}
+	labels := stub.labels[digest.String()]
+	if labels["containerd.io/gc.ref.content.config"] != manifest.Config.Digest.String() ||
+		labels["containerd.io/gc.ref.content.l.2"] != manifest.Layers[2].Digest.String() {
+		t.Errorf("unexpected manifest gc labels %v", labels)
+	}
+	if len(stub.leases) != 0 {
+		t.Errorf("leases left behind: %v", stub.leases)
+	}
+
+	// NOTE: a reimport only moves the name to the new image
+	img2, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ContainerdImport(context.Background(), socket, ContainerdDefaultNamespace, imageName, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stub.commits != 5 {
+		t.Errorf("existing blobs were written again, %d commits", stub.commits)
+	}
+	err = ContainerdImport(context.Background(), socket, ContainerdDefaultNamespace, imageName, img2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest2, err := img2.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stub.images[imageName].Target.Digest != digest2.String() {
+		t.Errorf("image wasn't updated to %v", digest2)
+	}
+}
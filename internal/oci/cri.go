@@ -0,0 +1,180 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// criSockets are the CRI endpoints of containerd and CRI-O, relative to the
+// host root, searched in order
+var criSockets = []string{
+	"/run/containerd/containerd.sock",
+	"/var/run/containerd/containerd.sock",
+	"/run/crio/crio.sock",
+	"/var/run/crio/crio.sock",
+}
+
+// CRISocketDetect returns the CRI socket of the node hostRoot is the root
+// filesystem of, the kubelet doesn't report its runtime endpoint
+func CRISocketDetect(hostRoot string) (string, error) {
+	for _, socket := range criSockets {
+		path := filepath.Join(hostRoot, socket)
+		stat, err := os.Stat(path)
+		if err == nil && stat.Mode().Type() == os.ModeSocket {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no CRI socket found under %s", hostRoot)
+}
+
+// decryptionKeysDirs are the directories CRI-O (decryption_keys_path) and the
+// containerd ocicrypt stream processors read decryption keys from by default
+var decryptionKeysDirs = map[string]string{
+	"crio.sock":       "/etc/crio/keys",
+	"containerd.sock": "/etc/containerd/ocicrypt/keys",
+}
+
+// DecryptionKeysDirDetect returns the decryption keys directory of the
+// container runtime of the node hostRoot is the root filesystem of, under hostRoot
+func DecryptionKeysDirDetect(hostRoot string) (string, error) {
+	socket, err := CRISocketDetect(hostRoot)
+	if err != nil {
+		return "", err
+	}
+	dir, ok := decryptionKeysDirs[filepath.Base(socket)]
+	if !ok {
+		return "", fmt.Errorf("no decryption keys directory known for %s", socket)
+	}
+	return filepath.Join(hostRoot, dir), nil
+}
+
+// CRIImagePull pulls imageName through the CRI image service on socket, like
+// the kubelet does, so the image lands in the runtime's own store. It returns
+// the image ID the runtime reports
+func CRIImagePull(ctx context.Context, socket, imageName string, auth authn.Authenticator) (string, error) {
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	req := &runtimeapi.PullImageRequest{
+		Image: &runtimeapi.ImageSpec{Image: imageName},
+	}
+	if auth != nil && auth != authn.Anonymous {
+		cfg, err := auth.Authorization()
+		if err != nil {
+			return "", err
+		}
+		req.Auth = &runtimeapi.AuthConfig{
+			Username:      cfg.Username,
+			Password:      cfg.Password,
+			Auth:          cfg.Auth,
+			IdentityToken: cfg.IdentityToken,
+			RegistryToken: cfg.RegistryToken,
+		}
+	}
+
+	resp, err := runtimeapi.NewImageServiceClient(conn).PullImage(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("pulling %s: %v", imageName, err)
+	}
+	return resp.ImageRef, nil
+}
+
+// CRIImageRepoDigests returns the repository digests of imageID in the CRI
+// image service on socket, the manifests the runtime pulled the image by
+func CRIImageRepoDigests(ctx context.Context, socket, imageID string) ([]string, error) {
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	resp, err := runtimeapi.NewImageServiceClient(conn).ImageStatus(ctx, &runtimeapi.ImageStatusRequest{
+		Image: &runtimeapi.ImageSpec{Image: imageID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting the status of %s: %v", imageID, err)
+	}
+	if resp.Image == nil {
+		return nil, fmt.Errorf("image %s not found", imageID)
+	}
+	return resp.Image.RepoDigests, nil
+}
+
+// CRIRuntimeVersion returns the version of the container runtime (containerd,
+// CRI-O) serving CRI on socket
+func CRIRuntimeVersion(ctx context.Context, socket string) (string, error) {
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	resp, err := runtimeapi.NewRuntimeServiceClient(conn).Version(ctx, &runtimeapi.VersionRequest{})
+	if err != nil {
+		return "", fmt.Errorf("getting the runtime version: %v", err)
+	}
+	return strings.TrimPrefix(resp.RuntimeVersion, "v"), nil
+}
+
+// criPodNamespaceLabel is the label the kubelet sets on containers to the
+// namespace of their pod
+const criPodNamespaceLabel = "io.kubernetes.pod.namespace"
+
+// CRIContainerPID returns the host PID of the init process of containerID, as
+// reported in the verbose status of the CRI runtime on socket, and the
+// namespace of its pod. containerID may carry the <runtime>:// prefix of the
+// pod container statuses
+func CRIContainerPID(ctx context.Context, socket, containerID string) (int, string, error) {
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return 0, "", err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if _, id, ok := strings.Cut(containerID, "://"); ok {
+		containerID = id
+	}
+	resp, err := runtimeapi.NewRuntimeServiceClient(conn).ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{
+		ContainerId: containerID,
+		Verbose:     true,
+	})
+	if err != nil {
+		return 0, "", fmt.Errorf("getting the status of %s: %v", containerID, err)
+	}
+	if resp.Status.GetState() != runtimeapi.ContainerState_CONTAINER_RUNNING {
+		return 0, "", fmt.Errorf("container %s isn't running", containerID)
+	}
+
+	// NOTE: containerd and CRI-O both report the pid in the verbose info
+	var info struct {
+		PID int `json:"pid"`
+	}
+	err = json.Unmarshal([]byte(resp.Info["info"]), &info)
+	if err != nil {
+		return 0, "", fmt.Errorf("parsing the verbose status of %s: %v", containerID, err)
+	}
+	if info.PID <= 0 {
+		return 0, "", fmt.Errorf("no pid in the verbose status of %s", containerID)
+	}
+	return info.PID, resp.Status.GetLabels()[criPodNamespaceLabel], nil
+}
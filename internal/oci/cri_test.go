@@ -0,0 +1,219 @@
+package oci
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"google.golang.org/grpc"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// testCRIImages is the CRI image service, it records the pulls
+type testCRIImages struct {
+	runtimeapi.UnimplementedImageServiceServer
+	pulls []*runtimeapi.PullImageRequest
+}
+
+func (stub *testCRIImages) PullImage(_ context.Context, req *runtimeapi.PullImageRequest) (*runtimeapi.PullImageResponse, error) {
+	stub.pulls = append(stub.pulls, req)
+	return &runtimeapi.PullImageResponse{ImageRef: "sha256:0123"}, nil
+}
+
+func (*testCRIImages) ImageStatus(_ context.Context, req *runtimeapi.ImageStatusRequest) (*runtimeapi.ImageStatusResponse, error) {
+	if req.Image.GetImage() != "sha256:0123" {
+		return &runtimeapi.ImageStatusResponse{}, nil
+	}
+	return &runtimeapi.ImageStatusResponse{
+		Image: &runtimeapi.Image{
+			Id:          "sha256:0123",
+			RepoDigests: []string{"registry.local/library/base@sha256:4567"},
+		},
+	}, nil
+}
+
+// testCRIRuntime is the CRI runtime service, with a single running container
+type testCRIRuntime struct {
+	runtimeapi.UnimplementedRuntimeServiceServer
+}
+
+func (*testCRIRuntime) ContainerStatus(_ context.Context, req *runtimeapi.ContainerStatusRequest) (*runtimeapi.ContainerStatusResponse, error) {
+	state := runtimeapi.ContainerState_CONTAINER_EXITED
+	if req.ContainerId == "running" {
+		state = runtimeapi.ContainerState_CONTAINER_RUNNING
+	}
+	resp := &runtimeapi.ContainerStatusResponse{
+		Status: &runtimeapi.ContainerStatus{
+			Id:     req.ContainerId,
+			State:  state,
+			Labels: map[string]string{criPodNamespaceLabel: "default"},
+		},
+	}
+	if req.Verbose {
+		resp.Info = map[string]string{"info": `{"sandboxID":"sandbox","pid":4242}`}
+	}
+	return resp, nil
+}
+
+func (*testCRIRuntime) Version(context.Context, *runtimeapi.VersionRequest) (*runtimeapi.VersionResponse, error) {
+	return &runtimeapi.VersionResponse{RuntimeName: "containerd", RuntimeVersion: "v1.7.2"}, nil
+}
+
+func TestCRIContainerPID(t *testing.T) {
+	// NOTE: unix socket paths are limited to 108 bytes, t.TempDir can be longer
+	dir, err := os.MkdirTemp("", "cri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	socket := filepath.Join(dir, "cri.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	runtimeapi.RegisterRuntimeServiceServer(server, &testCRIRuntime{})
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	pid, namespace, err := CRIContainerPID(context.Background(), socket, "containerd://running")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid != 4242 {
+		t.Errorf("unexpected pid %d", pid)
+	}
+	if namespace != "default" {
+		t.Errorf("unexpected pod namespace %s", namespace)
+	}
+
+	_, _, err = CRIContainerPID(context.Background(), socket, "exited")
+	if err == nil {
+		t.Error("expected an exited container to have no pid")
+	}
+
+	version, err := CRIRuntimeVersion(context.Background(), socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "1.7.2" {
+		t.Errorf("unexpected runtime version %s", version)
+	}
+}
+
+func TestCRIImagePull(t *testing.T) {
+	// NOTE: unix socket paths are limited to 108 bytes, t.TempDir can be longer
+	hostRoot, err := os.MkdirTemp("", "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(hostRoot)
+	})
+
+	_, err = CRISocketDetect(hostRoot)
+	if err == nil {
+		t.Error("expected no CRI socket to be found")
+	}
+
+	err = os.MkdirAll(filepath.Join(hostRoot, "run/crio"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("unix", filepath.Join(hostRoot, "run/crio/crio.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &testCRIImages{}
+	server := grpc.NewServer()
+	runtimeapi.RegisterImageServiceServer(server, stub)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	socket, err := CRISocketDetect(hostRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if socket != filepath.Join(hostRoot, "run/crio/crio.sock") {
+		t.Errorf("unexpected CRI socket %s", socket)
+	}
+
+	imageID, err := CRIImagePull(context.Background(), socket, "registry.local/checkpoint/service:latest", authn.Anonymous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imageID != "sha256:0123" || len(stub.pulls) != 1 || stub.pulls[0].Auth != nil {
+		t.Errorf("unexpected anonymous pull %s %v", imageID, stub.pulls)
+	}
+
+	_, err = CRIImagePull(context.Background(), socket, "registry.local/checkpoint/service:latest", &authn.Basic{
+		Username: "user",
+		Password: "password",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stub.pulls) != 2 || stub.pulls[1].Auth.GetUsername() != "user" || stub.pulls[1].Auth.GetPassword() != "password" {
+		t.Errorf("unexpected authenticated pull %v", stub.pulls)
+	}
+
+	repoDigests, err := CRIImageRepoDigests(context.Background(), socket, imageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repoDigests) != 1 || repoDigests[0] != "registry.local/library/base@sha256:4567" {
+		t.Errorf("unexpected repo digests %v", repoDigests)
+	}
+	_, err = CRIImageRepoDigests(context.Background(), socket, "sha256:89ab")
+	if err == nil {
+		t.Error("expected an unknown image to fail")
+	}
+}
+
+func TestDecryptionKeysDirDetect(t *testing.T) {
+	for _, tc := range []struct {
+		socket string
+		dir    string
+	}{
+		{"/run/crio/crio.sock", "/etc/crio/keys"},
+		{"/run/containerd/containerd.sock", "/etc/containerd/ocicrypt/keys"},
+	} {
+		// NOTE: unix socket paths are limited to 108 bytes, t.TempDir can be longer
+		hostRoot, err := os.MkdirTemp("", "host")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = os.RemoveAll(hostRoot)
+		})
+		socket := filepath.Join(hostRoot, tc.socket)
+		err = os.MkdirAll(filepath.Dir(socket), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener, err := net.Listen("unix", socket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = listener.Close()
+		})
+
+		dir, err := DecryptionKeysDirDetect(hostRoot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := filepath.Join(hostRoot, tc.dir); dir != expected {
+			t.Errorf("expected %s, got %s", expected, dir)
+		}
+	}
+}
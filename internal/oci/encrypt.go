@@ -0,0 +1,184 @@
+package oci
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/containers/ocicrypt"
+	encconfig "github.com/containers/ocicrypt/config"
+	ocicryptutils "github.com/containers/ocicrypt/utils"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const encryptedMediaTypeSuffix = "+encrypted"
+
+// EncryptionRecipients are the PEM public keys (JWE) and x509 certificates
+// (PKCS#7) the layer keys are wrapped for
+type EncryptionRecipients struct {
+	JWE   [][]byte
+	PKCS7 [][]byte
+}
+
+func (r EncryptionRecipients) empty() bool {
+	return len(r.JWE) == 0 && len(r.PKCS7) == 0
+}
+
+func encryptConfig(recipients EncryptionRecipients) (*encconfig.EncryptConfig, error) {
+	var ccs []encconfig.CryptoConfig
+	if len(recipients.JWE) > 0 {
+		cc, err := encconfig.EncryptWithJwe(recipients.JWE)
+		if err != nil {
+			return nil, err
+		}
+		ccs = append(ccs, cc)
+	}
+	if len(recipients.PKCS7) > 0 {
+		cc, err := encconfig.EncryptWithPkcs7(recipients.PKCS7)
+		if err != nil {
+			return nil, err
+		}
+		ccs = append(ccs, cc)
+	}
+
+	return encconfig.CombineCryptoConfigs(ccs).EncryptConfig, nil
+}
+
+// DecryptConfigFromK8sSecret loads every entry of secret as a decryption key,
+// x509 certificates are kept alongside for PKCS#7
+func DecryptConfigFromK8sSecret(secret *corev1.Secret) (*encconfig.DecryptConfig, error) {
+	var privKeys, passwords, x509s [][]byte
+	for _, key := range slices.Sorted(maps.Keys(secret.Data)) {
+		data := secret.Data[key]
+		if ocicryptutils.IsCertificate(data) {
+			x509s = append(x509s, data)
+			continue
+		}
+		isPrivateKey, err := ocicryptutils.IsPrivateKey(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %v", key, err)
+		}
+		if !isPrivateKey {
+			return nil, fmt.Errorf("%s is neither a private key nor a certificate", key)
+		}
+		privKeys = append(privKeys, data)
+		passwords = append(passwords, nil)
+	}
+	if len(privKeys) == 0 {
+		return nil, errors.New("secret has no private key")
+	}
+
+	cc, err := encconfig.DecryptWithPrivKeys(privKeys, passwords)
+	if err != nil {
+		return nil, err
+	}
+	ccs := []encconfig.CryptoConfig{cc}
+	if len(x509s) > 0 {
+		cc, err := encconfig.DecryptWithX509s(x509s)
+		if err != nil {
+			return nil, err
+		}
+		ccs = append(ccs, cc)
+	}
+
+	return encconfig.CombineCryptoConfigs(ccs).DecryptConfig, nil
+}
+
+// encryptedLayer is a layer encrypted with ocicrypt, the layer key is random so
+// the ciphertext is written to a file once and every read is served from it
+type encryptedLayer struct {
+	plain     v1.Layer
+	path      string
+	digest    v1.Hash
+	size      int64
+	mediaType types.MediaType
+}
+
+// layerEncrypt encrypts layer into a file of dir and returns the annotations
+// holding the wrapped keys, they have to be set on the layer descriptor
+func layerEncrypt(layer v1.Layer, ec *encconfig.EncryptConfig, dir string) (v1.Layer, map[string]string, error) {
+	mediaType, err := layer.MediaType()
+	if err != nil {
+		return nil, nil, err
+	}
+	plainDigest, err := layer.Digest()
+	if err != nil {
+		return nil, nil, err
+	}
+	plainSize, err := layer.Size()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rc, err := layer.Compressed()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+	encReader, finalizer, err := ocicrypt.EncryptLayer(ec, rc, ocispec.Descriptor{
+		MediaType: string(mediaType),
+		Digest:    digest.Digest(plainDigest.String()),
+		Size:      plainSize,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.CreateTemp(dir, "layer-*.enc")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	hash, size, err := v1.SHA256(io.TeeReader(encReader, file))
+	if err != nil {
+		return nil, nil, fmt.Errorf("encrypting layer: %v", err)
+	}
+	annotations, err := finalizer()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &encryptedLayer{
+		plain:     layer,
+		path:      file.Name(),
+		digest:    hash,
+		size:      size,
+		mediaType: mediaType + encryptedMediaTypeSuffix,
+	}, annotations, nil
+}
+
+func (l *encryptedLayer) Digest() (v1.Hash, error) {
+	return l.digest, nil
+}
+
+// DiffID is the one of the decrypted layer, it's what ends up in the rootfs
+func (l *encryptedLayer) DiffID() (v1.Hash, error) {
+	return l.plain.DiffID()
+}
+
+func (l *encryptedLayer) Compressed() (io.ReadCloser, error) {
+	return os.Open(l.path)
+}
+
+func (l *encryptedLayer) Uncompressed() (io.ReadCloser, error) {
+	return l.plain.Uncompressed()
+}
+
+func (l *encryptedLayer) Size() (int64, error) {
+	return l.size, nil
+}
+
+func (l *encryptedLayer) MediaType() (types.MediaType, error) {
+	return l.mediaType, nil
+}
@@ -0,0 +1,117 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"strings"
+	"testing"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	"github.com/containers/ocicrypt"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestArchiveLayersEncrypt(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDer, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	privDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pages := bytes.Repeat([]byte("secret"), 1024)
+	archivePath := testArchiveWrite(t, []testArchiveFile{
+		{name: "checkpoint/pages-1.img", typeflag: tar.TypeReg, data: pages},
+	})
+	img, err := appendArchiveLayers(empty.Image, archivePath, BuildOptions{
+		Compression: stove8sv1beta1.SnapShotOutputCompression{Algorithm: stove8sv1beta1.Uncompressed},
+		Encryption: EncryptionRecipients{
+			JWE: [][]byte{pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDer})},
+		},
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := img.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Layers) != 1 {
+		t.Fatalf("expected 1 layer, got %d", len(manifest.Layers))
+	}
+	desc := manifest.Layers[0]
+	if !strings.HasSuffix(string(desc.MediaType), encryptedMediaTypeSuffix) {
+		t.Errorf("expected an encrypted media type, got %s", desc.MediaType)
+	}
+	if desc.Annotations[Stove8sAnnotationLayerContent] != string(LayerContentCriu) {
+		t.Errorf("expected the stove8s annotations to be kept, got %v", desc.Annotations)
+	}
+	if _, ok := desc.Annotations[Stove8sAnnotationLayerFiles]; ok {
+		t.Error("expected the file digests of an encrypted layer to be omitted")
+	}
+
+	layer, err := img.LayerByDigest(desc.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := layer.Compressed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+	ciphertext, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(ciphertext, pages[:64]) {
+		t.Fatal("layer content isn't encrypted")
+	}
+
+	dc, err := DecryptConfigFromK8sSecret(&corev1.Secret{
+		Data: map[string][]byte{
+			"key.pem": pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDer}),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _, err := ocicrypt.DecryptLayer(dc, bytes.NewReader(ciphertext), ocispec.Descriptor{
+		MediaType:   string(desc.MediaType),
+		Digest:      digest.Digest(desc.Digest.String()),
+		Size:        desc.Size,
+		Annotations: desc.Annotations,
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(plain)
+	header, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Name != "checkpoint/pages-1.img" || !bytes.Equal(data, pages) {
+		t.Errorf("decrypted layer differs from the checkpoint archive")
+	}
+}
@@ -0,0 +1,154 @@
+package oci
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// containerManagerAnnotation is set in the spec by the engines built on
+// containers/common (CRI-O, Podman), containerd doesn't set it
+const containerManagerAnnotation = "io.container.manager"
+
+// CheckpointEngine reads the checkpoint archive of a container engine, each
+// engine records the container identity in its own spec annotations and
+// expects its own annotations when restoring from an image
+type CheckpointEngine interface {
+	// Name is the value of CheckpointAnnotationEngine
+	Name() string
+	// Match reports whether the archive was written by this engine
+	Match(spec *specs.Spec, containerConfig *ContainerConfig) bool
+	// Annotations returns the container identity annotations
+	Annotations(spec *specs.Spec, containerConfig *ContainerConfig) (map[string]string, error)
+}
+
+// checkpointEngines are matched in order
+var checkpointEngines = []CheckpointEngine{
+	CrioEngine{},
+	PodmanEngine{},
+	ContainerdEngine{},
+}
+
+// CheckpointEngineDetect returns the engine that wrote the checkpoint archive
+func CheckpointEngineDetect(spec *specs.Spec, containerConfig *ContainerConfig) (CheckpointEngine, error) {
+	for _, engine := range checkpointEngines {
+		if engine.Match(spec, containerConfig) {
+			return engine, nil
+		}
+	}
+
+	if manager, ok := spec.Annotations[containerManagerAnnotation]; ok {
+		return nil, fmt.Errorf("unsupported high-level container runtime %v", manager)
+	}
+	return nil, fmt.Errorf("can't detect the container runtime of the checkpoint")
+}
+
+type ContainerdEngine struct{}
+
+func (ContainerdEngine) Name() string {
+	return "containerd"
+}
+
+func (ContainerdEngine) Match(spec *specs.Spec, _ *ContainerConfig) bool {
+	_, managed := spec.Annotations[containerManagerAnnotation]
+	_, ok := spec.Annotations["io.kubernetes.cri.container-name"]
+	return !managed && ok
+}
+
+func (ContainerdEngine) Annotations(spec *specs.Spec, _ *ContainerConfig) (map[string]string, error) {
+	return map[string]string{
+		CheckpointAnnotationName:      spec.Annotations["io.kubernetes.cri.container-name"],
+		CheckpointAnnotationPod:       spec.Annotations["io.kubernetes.cri.sandbox-name"],
+		CheckpointAnnotationPodID:     spec.Annotations["io.kubernetes.cri.sandbox-id"],
+		CheckpointAnnotationNamespace: spec.Annotations["io.kubernetes.cri.sandbox-namespace"],
+	}, nil
+}
+
+// CrioCheckpointAnnotationName is the container name CRI-O restores an image
+// checkpoint into
+const CrioCheckpointAnnotationName = "io.kubernetes.cri-o.annotations.checkpoint.name"
+
+type CrioEngine struct{}
+
+func (CrioEngine) Name() string {
+	return "cri-o"
+}
+
+func (CrioEngine) Match(spec *specs.Spec, _ *ContainerConfig) bool {
+	return spec.Annotations[containerManagerAnnotation] == "cri-o"
+}
+
+func (CrioEngine) Annotations(spec *specs.Spec, _ *ContainerConfig) (map[string]string, error) {
+	// NOTE: io.kubernetes.cri-o.ContainerName is the kubelet generated k8s_* name
+	var metadata struct {
+		Name string `json:"name"`
+	}
+	if raw, ok := spec.Annotations["io.kubernetes.cri-o.Metadata"]; ok {
+		err := json.Unmarshal([]byte(raw), &metadata)
+		if err != nil {
+			return nil, fmt.Errorf("parsing cri-o container metadata: %v", err)
+		}
+	}
+	// NOTE: the kubelet labels are a JSON object, older CRI-O releases don't
+	// copy them to the spec annotations
+	labels := make(map[string]string)
+	if raw, ok := spec.Annotations["io.kubernetes.cri-o.Labels"]; ok {
+		err := json.Unmarshal([]byte(raw), &labels)
+		if err != nil {
+			return nil, fmt.Errorf("parsing cri-o container labels: %v", err)
+		}
+	}
+	label := func(key string) string {
+		if value, ok := spec.Annotations[key]; ok {
+			return value
+		}
+		return labels[key]
+	}
+
+	name := metadata.Name
+	if name == "" {
+		name = label("io.kubernetes.container.name")
+	}
+
+	return map[string]string{
+		CheckpointAnnotationName:      name,
+		CrioCheckpointAnnotationName:  name,
+		CheckpointAnnotationPod:       label("io.kubernetes.pod.name"),
+		CheckpointAnnotationPodID:     spec.Annotations["io.kubernetes.cri-o.SandboxID"],
+		CheckpointAnnotationNamespace: label("io.kubernetes.pod.namespace"),
+	}, nil
+}
+
+// Podman restores the name and image of a checkpoint image from its own
+// annotations, the equivalent of CrioCheckpointAnnotationName
+const (
+	PodmanCheckpointAnnotationName            = "io.podman.annotations.checkpoint.name"
+	PodmanCheckpointAnnotationRawImageName    = "io.podman.annotations.checkpoint.rawImageName"
+	PodmanCheckpointAnnotationRootfsImageID   = "io.podman.annotations.checkpoint.rootfsImageID"
+	PodmanCheckpointAnnotationRootfsImageName = "io.podman.annotations.checkpoint.rootfsImageName"
+)
+
+type PodmanEngine struct{}
+
+func (PodmanEngine) Name() string {
+	return "podman"
+}
+
+func (PodmanEngine) Match(spec *specs.Spec, _ *ContainerConfig) bool {
+	return spec.Annotations[containerManagerAnnotation] == "libpod"
+}
+
+func (PodmanEngine) Annotations(_ *specs.Spec, containerConfig *ContainerConfig) (map[string]string, error) {
+	// NOTE: podman pods have no name in the archive, only their ID
+	return map[string]string{
+		CheckpointAnnotationName:                  containerConfig.Name,
+		PodmanCheckpointAnnotationName:            containerConfig.Name,
+		CheckpointAnnotationPodID:                 containerConfig.Pod,
+		CheckpointAnnotationNamespace:             containerConfig.Namespace,
+		CheckpointAnnotationRawImageName:          containerConfig.RawImageName,
+		PodmanCheckpointAnnotationRawImageName:    containerConfig.RawImageName,
+		PodmanCheckpointAnnotationRootfsImageID:   containerConfig.RootfsImageID,
+		PodmanCheckpointAnnotationRootfsImageName: containerConfig.RootfsImageName,
+	}, nil
+}
@@ -0,0 +1,102 @@
+package oci
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestCheckpointEngineDetect(t *testing.T) {
+	tests := []struct {
+		name            string
+		spec            *specs.Spec
+		containerConfig *ContainerConfig
+		engine          string
+		annotations     map[string]string
+	}{
+		{
+			name: "containerd",
+			spec: &specs.Spec{Annotations: map[string]string{
+				"io.kubernetes.cri.container-name":    "service",
+				"io.kubernetes.cri.sandbox-id":        "0123",
+				"io.kubernetes.cri.sandbox-name":      "service-0",
+				"io.kubernetes.cri.sandbox-namespace": "default",
+			}},
+			engine: "containerd",
+			annotations: map[string]string{
+				CheckpointAnnotationName:      "service",
+				CheckpointAnnotationPod:       "service-0",
+				CheckpointAnnotationPodID:     "0123",
+				CheckpointAnnotationNamespace: "default",
+			},
+		},
+		{
+			name: "cri-o",
+			spec: &specs.Spec{Annotations: map[string]string{
+				containerManagerAnnotation:      "cri-o",
+				"io.kubernetes.cri-o.Metadata":  `{"name":"service","attempt":1}`,
+				"io.kubernetes.cri-o.Labels":    `{"io.kubernetes.pod.name":"service-0","io.kubernetes.pod.namespace":"default"}`,
+				"io.kubernetes.cri-o.SandboxID": "0123",
+			}},
+			engine: "cri-o",
+			annotations: map[string]string{
+				CheckpointAnnotationName:      "service",
+				CrioCheckpointAnnotationName:  "service",
+				CheckpointAnnotationPod:       "service-0",
+				CheckpointAnnotationPodID:     "0123",
+				CheckpointAnnotationNamespace: "default",
+			},
+		},
+		{
+			name: "podman",
+			spec: &specs.Spec{Annotations: map[string]string{containerManagerAnnotation: "libpod"}},
+			containerConfig: &ContainerConfig{
+				Name:            "service",
+				Pod:             "0123",
+				RawImageName:    "nginx",
+				RootfsImageName: "docker.io/library/nginx:latest",
+				RootfsImageID:   "4567",
+			},
+			engine: "podman",
+			annotations: map[string]string{
+				CheckpointAnnotationName:                  "service",
+				PodmanCheckpointAnnotationName:            "service",
+				CheckpointAnnotationPodID:                 "0123",
+				CheckpointAnnotationRawImageName:          "nginx",
+				PodmanCheckpointAnnotationRawImageName:    "nginx",
+				PodmanCheckpointAnnotationRootfsImageID:   "4567",
+				PodmanCheckpointAnnotationRootfsImageName: "docker.io/library/nginx:latest",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			containerConfig := test.containerConfig
+			if containerConfig == nil {
+				containerConfig = &ContainerConfig{}
+			}
+			engine, err := CheckpointEngineDetect(test.spec, containerConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if engine.Name() != test.engine {
+				t.Fatalf("expected engine %s, got %s", test.engine, engine.Name())
+			}
+			annotations, err := engine.Annotations(test.spec, containerConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, expected := range test.annotations {
+				if annotations[key] != expected {
+					t.Errorf("expected %s=%q, got %q", key, expected, annotations[key])
+				}
+			}
+		})
+	}
+
+	_, err := CheckpointEngineDetect(&specs.Spec{Annotations: map[string]string{containerManagerAnnotation: "other"}}, &ContainerConfig{})
+	if err == nil {
+		t.Error("expected an unknown container manager to fail")
+	}
+}
@@ -0,0 +1,188 @@
+package oci
+
+import (
+	"archive/tar"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// TarballSignatureSuffix is appended to the tarball path for the file
+	// holding its cosign signature
+	TarballSignatureSuffix = ".sig.json"
+	// TarballProvenanceSuffix is appended to the tarball path for the file
+	// holding its provenance DSSE envelope
+	TarballProvenanceSuffix = ".intoto.jsonl"
+)
+
+// ExportOptions are the artifacts exported along with the image
+type ExportOptions struct {
+	// Signer adds a cosign signature of the image
+	Signer crypto.Signer
+	// Provenance is exported in a DSSE envelope, signed with Signer when set
+	Provenance *ProvenanceStatement
+}
+
+// TarballSignature is the content of the TarballSignatureSuffix file, the
+// payload is the simple signing payload cosign signs
+type TarballSignature struct {
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// LayoutExport appends img to the OCI image layout at dir, creating it when
+// needed, named after ref. The signature is stored under the cosign tag name
+// and the provenance as a manifest whose subject is img, like in a registry
+func LayoutExport(dir string, ref name.Reference, img v1.Image, opts ExportOptions) error {
+	path, err := layout.FromPath(dir)
+	if err != nil {
+		path, err = layout.Write(dir, empty.Index)
+		if err != nil {
+			return fmt.Errorf("creating layout: %v", err)
+		}
+	}
+
+	err = path.AppendImage(img, layout.WithAnnotations(map[string]string{
+		ocispec.AnnotationRefName: ref.Name(),
+	}))
+	if err != nil {
+		return err
+	}
+
+	if opts.Signer != nil {
+		digest, err := img.Digest()
+		if err != nil {
+			return err
+		}
+		sigImg, err := signatureAppend(nil, ref, img, opts.Signer)
+		if err != nil {
+			return err
+		}
+		err = path.AppendImage(sigImg, layout.WithAnnotations(map[string]string{
+			ocispec.AnnotationRefName: SignatureReference(ref.Context(), digest).Name(),
+		}))
+		if err != nil {
+			return fmt.Errorf("exporting signature: %v", err)
+		}
+	}
+
+	if opts.Provenance != nil {
+		artifact, err := provenanceArtifact(img, opts.Provenance, opts.Signer)
+		if err != nil {
+			return err
+		}
+		err = path.AppendImage(artifact)
+		if err != nil {
+			return fmt.Errorf("exporting provenance: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// TarballExport writes img to a `docker save` compatible tarball at path, the
+// signature and provenance can't be loaded as images so they're written next
+// to it with the TarballSignatureSuffix and TarballProvenanceSuffix suffixes
+func TarballExport(path string, ref name.Reference, img v1.Image, opts ExportOptions) error {
+	err := tarball.WriteToFile(path, ref, img)
+	if err != nil {
+		return err
+	}
+
+	if opts.Signer != nil {
+		payload, signature, err := signatureCreate(ref, img, opts.Signer)
+		if err != nil {
+			return err
+		}
+		raw, err := json.Marshal(TarballSignature{
+			Payload:   base64.StdEncoding.EncodeToString(payload),
+			Signature: base64.StdEncoding.EncodeToString(signature),
+		})
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(path+TarballSignatureSuffix, raw, 0o644)
+		if err != nil {
+			return fmt.Errorf("exporting signature: %v", err)
+		}
+	}
+
+	if opts.Provenance != nil {
+		envelope, err := provenanceEnvelope(opts.Provenance, opts.Signer)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(path+TarballProvenanceSuffix, append(envelope, '\n'), 0o644)
+		if err != nil {
+			return fmt.Errorf("exporting provenance: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// DirTarWrite writes the regular files and directories under dir to w as a
+// tar, with paths relative to dir
+func DirTarWrite(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && !entry.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if entry.IsDir() {
+			header.Name += "/"
+		}
+		err = tw.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
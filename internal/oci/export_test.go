@@ -0,0 +1,216 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// TestLayoutExport imports the exported layout into a registry the way an
+// air-gapped mirror would, the signature and provenance must still verify
+func TestLayoutExport(t *testing.T) {
+	keySecret := testCosignSecret(t, []byte("password"))
+	signer, err := SignerFromK8sSecret(keySecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := PublicKeyFromK8sSecret(keySecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(registry.New(registry.WithReferrersSupport(true)))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.ParseReference(u.Host + "/checkpoint/service:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statement, err := ProvenanceStatementBuild(ref, img, ProvenanceInfo{Node: "node-0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "export")
+	err = LayoutExport(dir, ref, img, ExportOptions{
+		Signer:     signer,
+		Provenance: statement,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := layout.FromPath(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := path.ImageIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Manifests) != 3 {
+		t.Fatalf("expected image, signature and provenance manifests, got %d", len(manifest.Manifests))
+	}
+
+	for _, desc := range manifest.Manifests {
+		exported, err := index.Image(desc.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		target := ref.Context().Digest(desc.Digest.String()).String()
+		if refName, ok := desc.Annotations[ocispec.AnnotationRefName]; ok {
+			target = refName
+		}
+		targetRef, err := name.ParseReference(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = remote.Write(targetRef, exported)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified, err := Verify(ref, pub, authn.Anonymous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified != digest {
+		t.Errorf("verified %v, expected %v", verified, digest)
+	}
+	fetched, err := ProvenanceFetch(ref, pub, authn.Anonymous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched.Predicate.BuildDefinition.ExternalParameters.Node != "node-0" {
+		t.Errorf("unexpected external parameters %+v", fetched.Predicate.BuildDefinition.ExternalParameters)
+	}
+
+	var buf bytes.Buffer
+	err = DirTarWrite(&buf, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	tr := tar.NewReader(&buf)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names[header.Name] = true
+	}
+	for _, expected := range []string{"oci-layout", "index.json", "blobs/sha256/" + digest.Hex} {
+		if !names[expected] {
+			t.Errorf("archive is missing %s", expected)
+		}
+	}
+}
+
+func TestTarballExport(t *testing.T) {
+	keySecret := testCosignSecret(t, []byte("password"))
+	signer, err := SignerFromK8sSecret(keySecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := name.ParseReference("registry.local/checkpoint/service:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statement, err := ProvenanceStatementBuild(ref, img, ProvenanceInfo{Node: "node-0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "service.tar")
+	err = TarballExport(path, ref, img, ExportOptions{
+		Signer:     signer,
+		Provenance: statement,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tag, err := name.NewTag(ref.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := tarball.ImageFromPath(path, &tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadedDigest, err := loaded.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loadedDigest != digest {
+		t.Errorf("loaded %v, expected %v", loadedDigest, digest)
+	}
+
+	raw, err := os.ReadFile(path + TarballSignatureSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var signature TarballSignature
+	err = json.Unmarshal(raw, &signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := base64.StdEncoding.DecodeString(signature.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(payload, []byte(digest.String())) {
+		t.Errorf("signature payload doesn't reference %v", digest)
+	}
+
+	_, err = os.Stat(path + TarballProvenanceSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
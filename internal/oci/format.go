@@ -0,0 +1,83 @@
+package oci
+
+import (
+	"strings"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	// CheckpointArchiveArtifactType is the artifactType of archival checkpoints
+	CheckpointArchiveArtifactType = "application/vnd.bud.stove8s.checkpoint.archive.v1"
+	// CheckpointArchiveConfigMediaType keeps container runtimes from pulling
+	// archival checkpoints as images
+	CheckpointArchiveConfigMediaType types.MediaType = "application/vnd.bud.stove8s.checkpoint.config.v1+json"
+
+	criuAnnotationPrefix = "org.criu.checkpoint."
+)
+
+// formatResolve picks the format of the engine the checkpoint was taken with
+// for auto, podman restores the same images CRI-O does
+func formatResolve(format stove8sv1beta1.SnapShotOutputFormat, engine string) stove8sv1beta1.SnapShotOutputFormat {
+	if format != "" && format != stove8sv1beta1.Auto {
+		return format
+	}
+	if engine == (ContainerdEngine{}).Name() {
+		return stove8sv1beta1.Containerd
+	}
+	return stove8sv1beta1.CRIO
+}
+
+// formatApply sets the config and manifest annotations the runtime of format
+// looks for to restore from the image instead of starting it
+func formatApply(
+	format stove8sv1beta1.SnapShotOutputFormat,
+	cfg *v1.ConfigFile,
+	annotations map[string]string,
+	spec *specs.Spec,
+) {
+	if format == stove8sv1beta1.Artifact {
+		return
+	}
+
+	// NOTE: the runtimes restore the process from spec.dump, the entrypoint
+	// only describes the image to tools inspecting it. The environment isn't
+	// copied, it holds the Secrets the pod gets as variables and the config
+	// is never encrypted, unlike the spec.dump layer
+	if spec.Process != nil {
+		cfg.Config.Entrypoint = spec.Process.Args
+		if spec.Process.Cwd != "" {
+			cfg.Config.WorkingDir = spec.Process.Cwd
+		}
+	}
+
+	switch format {
+	case stove8sv1beta1.CRIO:
+		// NOTE: CRI-O before 1.28 only knows the name under its own prefix
+		annotations[CrioCheckpointAnnotationName] = annotations[CheckpointAnnotationName]
+	case stove8sv1beta1.Containerd:
+		// NOTE: the CRI image store only keeps the config, not the manifest annotations
+		for key, value := range annotations {
+			if strings.HasPrefix(key, criuAnnotationPrefix) {
+				cfg.Config.Labels[key] = value
+			}
+		}
+	}
+}
+
+func formatConfigMediaType(format stove8sv1beta1.SnapShotOutputFormat) types.MediaType {
+	if format == stove8sv1beta1.Artifact {
+		return CheckpointArchiveConfigMediaType
+	}
+	return types.OCIConfigJSON
+}
+
+func formatArtifactType(format stove8sv1beta1.SnapShotOutputFormat) string {
+	if format == stove8sv1beta1.Artifact {
+		return CheckpointArchiveArtifactType
+	}
+	return CheckpointArtifactType
+}
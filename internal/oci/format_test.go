@@ -0,0 +1,113 @@
+package oci
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"testing"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func testCheckpointArchiveWrite(t *testing.T, annotations map[string]string) string {
+	spec, err := json.Marshal(specs.Spec{
+		Process: &specs.Process{
+			Args: []string{"/bin/service"},
+			Env:  []string{"DATABASE_PASSWORD=secret"},
+			Cwd:  "/srv",
+		},
+		Annotations: annotations,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := json.Marshal(ContainerConfig{
+		Name:            "service",
+		RootfsImageName: "docker.io/library/service:latest",
+		OCIRuntime:      "runc",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testArchiveWrite(t, []testArchiveFile{
+		{name: specDumpFile, typeflag: tar.TypeReg, data: spec},
+		{name: configDumpFile, typeflag: tar.TypeReg, data: config},
+		{name: "checkpoint/pages-1.img", typeflag: tar.TypeReg, data: []byte("pages")},
+	})
+}
+
+func TestBuildImageFormat(t *testing.T) {
+	containerd := map[string]string{
+		"io.kubernetes.cri.container-name":    "service",
+		"io.kubernetes.cri.sandbox-name":      "service-0",
+		"io.kubernetes.cri.sandbox-namespace": "default",
+	}
+	crio := map[string]string{
+		containerManagerAnnotation:     "cri-o",
+		"io.kubernetes.cri-o.Metadata": `{"name":"service"}`,
+	}
+
+	tests := []struct {
+		name            string
+		annotations     map[string]string
+		format          stove8sv1beta1.SnapShotOutputFormat
+		configMediaType types.MediaType
+		artifactType    string
+		crioName        bool
+		criuLabels      bool
+	}{
+		{"auto containerd", containerd, stove8sv1beta1.Auto, types.OCIConfigJSON, CheckpointArtifactType, false, true},
+		{"auto cri-o", crio, "", types.OCIConfigJSON, CheckpointArtifactType, true, false},
+		{"cri-o from containerd", containerd, stove8sv1beta1.CRIO, types.OCIConfigJSON, CheckpointArtifactType, true, false},
+		{"artifact", containerd, stove8sv1beta1.Artifact, CheckpointArchiveConfigMediaType, CheckpointArchiveArtifactType, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img, err := BuildImage(testCheckpointArchiveWrite(t, test.annotations), BuildOptions{
+				Compression: stove8sv1beta1.SnapShotOutputCompression{Algorithm: stove8sv1beta1.Uncompressed},
+				Host:        HostInfo{Arch: "arm64"},
+				Format:      test.format,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			manifest, err := img.Manifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if manifest.Config.MediaType != test.configMediaType {
+				t.Errorf("expected config media type %s, got %s", test.configMediaType, manifest.Config.MediaType)
+			}
+			artifactType, err := img.(interface{ ArtifactType() (string, error) }).ArtifactType()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if artifactType != test.artifactType {
+				t.Errorf("expected artifact type %s, got %s", test.artifactType, artifactType)
+			}
+			if _, ok := manifest.Annotations[CrioCheckpointAnnotationName]; ok != test.crioName {
+				t.Errorf("expected %s to be set: %v", CrioCheckpointAnnotationName, test.crioName)
+			}
+
+			cfg, err := img.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Architecture != "arm64" {
+				t.Errorf("expected the host architecture, got %s", cfg.Architecture)
+			}
+			if _, ok := cfg.Config.Labels[CheckpointAnnotationName]; ok != test.criuLabels {
+				t.Errorf("expected %s label to be set: %v", CheckpointAnnotationName, test.criuLabels)
+			}
+			if test.format != stove8sv1beta1.Artifact && (len(cfg.Config.Entrypoint) != 1 || cfg.Config.WorkingDir != "/srv") {
+				t.Errorf("expected the entrypoint of spec.dump, got %v in %s", cfg.Config.Entrypoint, cfg.Config.WorkingDir)
+			}
+			if len(cfg.Config.Env) != 0 {
+				t.Errorf("expected the environment to be left out of the config, got %v", cfg.Config.Env)
+			}
+		})
+	}
+}
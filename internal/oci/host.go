@@ -0,0 +1,167 @@
+package oci
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	hostCRITimeout = 4 * time.Second
+	dumpLogFile    = "dump.log"
+)
+
+// HostInfo describes the node a checkpoint was taken on, restores on a node
+// that differs too much are likely to fail
+type HostInfo struct {
+	Arch                string
+	Kernel              string
+	CgroupVersion       string
+	DistributionName    string
+	DistributionVersion string
+	EngineVersion       string
+	// CriuVersion is read from the dump.log of the archive by BuildImage when
+	// it's left empty
+	CriuVersion string
+}
+
+// HostInfoCollect inspects the node hostRoot holds the os-release of, the
+// engine version is asked to the CRI runtime on criSocket (detected under
+// hostRoot when empty) and falls back to containerRuntimeVersion, the one
+// reported by the kubelet (containerd://1.7.2). Anything that can't be found
+// is left empty
+//
+// NOTE: the OCI runtime and conmon versions aren't reported over CRI and the
+// node binaries aren't reachable from the daemonset, they stay unset
+func HostInfoCollect(hostRoot, criSocket, containerRuntimeVersion string) HostInfo {
+	info := HostInfo{
+		Arch: runtime.GOARCH,
+	}
+
+	// NOTE: the kernel and the cgroup filesystem type are shared with the host
+	var uname unix.Utsname
+	err := unix.Uname(&uname)
+	if err != nil {
+		slog.Warn("Getting host kernel", "err", err)
+	} else {
+		info.Kernel = unix.ByteSliceToString(uname.Release[:])
+	}
+
+	info.CgroupVersion, err = cgroupVersion("/sys/fs/cgroup")
+	if err != nil {
+		slog.Warn("Getting host cgroup version", "err", err)
+	}
+
+	osRelease, err := osReleaseRead(hostRoot)
+	if err != nil {
+		slog.Warn("Reading host os-release", "err", err)
+	}
+	info.DistributionName = osRelease["ID"]
+	info.DistributionVersion = osRelease["VERSION_ID"]
+
+	_, info.EngineVersion, _ = strings.Cut(containerRuntimeVersion, "://")
+	if criSocket == "" {
+		criSocket, err = CRISocketDetect(hostRoot)
+	}
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), hostCRITimeout)
+		defer cancel()
+		var version string
+		version, err = CRIRuntimeVersion(ctx, criSocket)
+		if err == nil {
+			info.EngineVersion = version
+		}
+	}
+	if err != nil {
+		slog.Warn("Getting host engine version", "err", err)
+	}
+
+	return info
+}
+
+func cgroupVersion(mountPath string) (string, error) {
+	var stat unix.Statfs_t
+	err := unix.Statfs(mountPath, &stat)
+	if err != nil {
+		return "", err
+	}
+	if stat.Type == unix.CGROUP2_SUPER_MAGIC {
+		return "v2", nil
+	}
+	return "v1", nil
+}
+
+// osReleaseRead parses the os-release(5) file of hostRoot
+func osReleaseRead(hostRoot string) (map[string]string, error) {
+	var raw []byte
+	var err error
+	for _, path := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		raw, err = os.ReadFile(filepath.Join(hostRoot, path))
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		res[key] = strings.Trim(value, `"'`)
+	}
+
+	return res, scanner.Err()
+}
+
+// criuVersionRead returns the version CRIU logged at the top of the dump.log
+// of the checkpoint archive, "(00.000000) Version: 3.19 (gitid v3.19)"
+func criuVersionRead(checkpointDump io.Reader) (string, error) {
+	tr := tar.NewReader(checkpointDump)
+	for {
+		tarHeader, err := tr.Next()
+		if err == io.EOF {
+			return "", errors.New("no dump.log in the checkpoint archive")
+		} else if err != nil {
+			return "", err
+		}
+		if tarHeader.Typeflag != tar.TypeReg || tarHeader.Name != dumpLogFile {
+			continue
+		}
+
+		scanner := bufio.NewScanner(tr)
+		for scanner.Scan() {
+			_, version, ok := strings.Cut(scanner.Text(), "Version: ")
+			if !ok {
+				continue
+			}
+			fields := strings.Fields(version)
+			if len(fields) > 0 {
+				return fields[0], nil
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", errors.New("no version in dump.log")
+	}
+}
@@ -0,0 +1,119 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestOsReleaseRead(t *testing.T) {
+	root := t.TempDir()
+	err := os.MkdirAll(filepath.Join(root, "etc"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(root, "etc", "os-release"), []byte(`# comment
+NAME="Ubuntu"
+ID=ubuntu
+VERSION_ID="24.04"
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	osRelease, err := osReleaseRead(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if osRelease["ID"] != "ubuntu" || osRelease["VERSION_ID"] != "24.04" || osRelease["NAME"] != "Ubuntu" {
+		t.Errorf("unexpected os-release %v", osRelease)
+	}
+
+	_, err = osReleaseRead(t.TempDir())
+	if err == nil {
+		t.Error("expected a missing os-release to fail")
+	}
+}
+
+func TestCriuVersionRead(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"config.dump": "{}",
+		"dump.log":    "(00.000000) Unable to get $HOME directory, local configuration file will not be used.\n(00.000011) Version: 3.19 (gitid v3.19)\n(00.000020) Running on node\n",
+	} {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tw.Write([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := tw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := criuVersionRead(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "3.19" {
+		t.Errorf("unexpected CRIU version %q", version)
+	}
+
+	var empty bytes.Buffer
+	err = tar.NewWriter(&empty).Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = criuVersionRead(&empty)
+	if err == nil {
+		t.Error("expected an archive without dump.log to fail")
+	}
+}
+
+func TestAnnotationsFromDumpHost(t *testing.T) {
+	spec := &specs.Spec{
+		Annotations: map[string]string{
+			"io.kubernetes.cri.container-name":    "service",
+			"io.kubernetes.cri.sandbox-id":        "0123",
+			"io.kubernetes.cri.sandbox-name":      "service-0",
+			"io.kubernetes.cri.sandbox-namespace": "default",
+		},
+	}
+	annotations, err := annotationsFromDump(spec, &ContainerConfig{OCIRuntime: "io.containerd.runc.v2"}, HostInfo{
+		Arch:          "amd64",
+		Kernel:        "6.8.0",
+		CgroupVersion: "v2",
+		EngineVersion: "1.7.2",
+		CriuVersion:   "3.19",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, expected := range map[string]string{
+		CheckpointAnnotationPodID:         "0123",
+		CheckpointAnnotationHostArch:      "amd64",
+		CheckpointAnnotationHostKernel:    "6.8.0",
+		CheckpointAnnotationCgroupVersion: "v2",
+		CheckpointAnnotationEngineVersion: "1.7.2",
+		CheckpointAnnotationCriuVersion:   "3.19",
+	} {
+		if annotations[key] != expected {
+			t.Errorf("expected %s=%q, got %q", key, expected, annotations[key])
+		}
+	}
+	for _, key := range []string{CheckpointAnnotationRuntimeVersion, CheckpointAnnotationConmonVersion} {
+		if _, ok := annotations[key]; ok {
+			t.Errorf("expected the unknown %s to be left out", key)
+		}
+	}
+}
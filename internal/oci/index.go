@@ -0,0 +1,64 @@
+package oci
+
+import (
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// IndexPush merges the per-architecture checkpoint manifests, already pushed
+// by digest to the repository of ref, into an image index tagged ref, so each
+// node's runtime pulls the checkpoint of its own architecture
+func IndexPush(ref name.Reference, digests []string, opts ...remote.Option) (v1.Hash, error) {
+	index := mutate.IndexMediaType(empty.Index, types.OCIImageIndex)
+	for _, digest := range digests {
+		img, err := remote.Image(ref.Context().Digest(digest), opts...)
+		if err != nil {
+			return v1.Hash{}, fmt.Errorf("fetching %s: %v", digest, err)
+		}
+		desc, err := platformDescriptor(img)
+		if err != nil {
+			return v1.Hash{}, fmt.Errorf("describing %s: %v", digest, err)
+		}
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{
+			Add:        img,
+			Descriptor: *desc,
+		})
+	}
+
+	err := remote.WriteIndex(ref, index, opts...)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	return index.Digest()
+}
+
+func platformDescriptor(img v1.Image) (*v1.Descriptor, error) {
+	mediaType, err := img.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.Descriptor{
+		MediaType: mediaType,
+		Platform: &v1.Platform{
+			OS:           cfg.OS,
+			Architecture: cfg.Architecture,
+			Variant:      cfg.Variant,
+		},
+		Annotations: manifest.Annotations,
+	}, nil
+}
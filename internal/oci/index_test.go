@@ -0,0 +1,97 @@
+package oci
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestIndexPush(t *testing.T) {
+	keySecret := testCosignSecret(t, nil)
+	signer, err := SignerFromK8sSecret(keySecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := PublicKeyFromK8sSecret(keySecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.ParseReference(u.Host + "/checkpoint/service:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var digests []string
+	for _, arch := range []string{"amd64", "arm64"} {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.OS = "linux"
+		cfg.Architecture = arch
+		img, err = mutate.ConfigFile(img, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest, err := img.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		digestRef := ref.Context().Digest(digest.String())
+		err = remote.Write(digestRef, img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Sign(digestRef, img, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests = append(digests, digest.String())
+	}
+
+	indexDigest, err := IndexPush(ref, digests)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, arch := range []string{"amd64", "arm64"} {
+		img, err := remote.Image(ref, remote.WithPlatform(v1.Platform{OS: "linux", Architecture: arch}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest, err := img.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if digest.String() != digests[i] {
+			t.Errorf("expected %s for %s, got %s", digests[i], arch, digest)
+		}
+	}
+
+	verified, err := Verify(ref, pub, authn.Anonymous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified != indexDigest {
+		t.Errorf("expected the index digest %s to be verified, got %s", indexDigest, verified)
+	}
+}
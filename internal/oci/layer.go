@@ -0,0 +1,108 @@
+package oci
+
+import (
+	"fmt"
+	"io"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	ggcrcompression "github.com/google/go-containerregistry/pkg/compression"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// compressedLayer wraps the uncompressed tar returned by opener in a layer
+// using the requested compression, the opener is called once to compute the
+// digests up front and again for every read of the layer
+func compressedLayer(opener tarball.Opener, compression stove8sv1beta1.SnapShotOutputCompression) (v1.Layer, error) {
+	var opts []tarball.LayerOption
+	if compression.Level != 0 {
+		opts = append(opts, tarball.WithCompressionLevel(compression.Level))
+	}
+
+	switch compression.Algorithm {
+	case stove8sv1beta1.Gzip, "":
+		opts = append(opts, tarball.WithMediaType(types.OCILayer))
+	case stove8sv1beta1.Zstd:
+		opts = append(opts,
+			tarball.WithCompression(ggcrcompression.ZStd),
+			tarball.WithMediaType(types.OCILayerZStd),
+		)
+	case stove8sv1beta1.EStargz:
+		// NOTE: deprecated upstream without a replacement, the TOC digest annotation
+		// it adds to the layer descriptor is what lazy-pulling snapshotters look for
+		// nolint: staticcheck
+		opts = append(opts, tarball.WithEstargz, tarball.WithMediaType(types.OCILayer))
+	case stove8sv1beta1.Uncompressed:
+		return uncompressedLayerFromOpener(opener)
+	default:
+		return nil, fmt.Errorf("unsupported compression %v", compression.Algorithm)
+	}
+
+	return tarball.LayerFromOpener(opener, opts...)
+}
+
+// layerMediaType is the media type of the layers compressedLayer returns for algorithm
+func layerMediaType(algorithm stove8sv1beta1.SnapShotOutputCompressionAlgorithm) types.MediaType {
+	switch algorithm {
+	case stove8sv1beta1.Zstd:
+		return types.OCILayerZStd
+	case stove8sv1beta1.Uncompressed:
+		return types.OCIUncompressedLayer
+	default:
+		return types.OCILayer
+	}
+}
+
+// uncompressedLayer is a plain tar layer, tarball.LayerFromOpener always
+// compresses uncompressed input so it can't be used here
+type uncompressedLayer struct {
+	opener tarball.Opener
+	digest v1.Hash
+	size   int64
+}
+
+func uncompressedLayerFromOpener(opener tarball.Opener) (v1.Layer, error) {
+	rc, err := opener()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	digest, size, err := v1.SHA256(rc)
+	if err != nil {
+		return nil, fmt.Errorf("hashing layer: %v", err)
+	}
+
+	return &uncompressedLayer{
+		opener: opener,
+		digest: digest,
+		size:   size,
+	}, nil
+}
+
+func (l *uncompressedLayer) Digest() (v1.Hash, error) {
+	return l.digest, nil
+}
+
+func (l *uncompressedLayer) DiffID() (v1.Hash, error) {
+	return l.digest, nil
+}
+
+func (l *uncompressedLayer) Compressed() (io.ReadCloser, error) {
+	return l.opener()
+}
+
+func (l *uncompressedLayer) Uncompressed() (io.ReadCloser, error) {
+	return l.opener()
+}
+
+func (l *uncompressedLayer) Size() (int64, error) {
+	return l.size, nil
+}
+
+func (l *uncompressedLayer) MediaType() (types.MediaType, error) {
+	return types.OCIUncompressedLayer, nil
+}
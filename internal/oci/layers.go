@@ -0,0 +1,448 @@
+package oci
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	encconfig "github.com/containers/ocicrypt/config"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+const (
+	rootfsDiffFile      = "rootfs-diff.tar"
+	checkpointDirectory = "checkpoint"
+	pagesFilePattern    = "pages-*.img"
+)
+
+// LayerContent is the part of the checkpoint archive a layer holds, it's
+// stored in the Stove8sAnnotationLayerContent annotation of the layer
+type LayerContent string
+
+const (
+	// LayerContentMetadata holds the runtime metadata (config.dump, spec.dump, ...)
+	LayerContentMetadata LayerContent = "metadata"
+	// LayerContentRootfsDiff holds rootfs-diff.tar
+	LayerContentRootfsDiff LayerContent = "rootfs-diff"
+	// LayerContentCriu holds the CRIU process images
+	LayerContentCriu LayerContent = "criu"
+	// LayerContentPages holds a single CRIU pages-*.img
+	LayerContentPages LayerContent = "pages"
+	// LayerContentDelta holds the files changed since the parent checkpoint
+	LayerContentDelta LayerContent = "delta"
+)
+
+type archiveEntry struct {
+	header *tar.Header
+	// offset of the entry data in the archive
+	offset int64
+	// digest of the entry data, or of the link target for links
+	digest string
+}
+
+type archiveLayer struct {
+	content LayerContent
+	entries []archiveEntry
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// archiveEntriesRead lists the entries of the uncompressed tar at archivePath
+// along with the offset and digest of their data, so they can be read back
+// without walking the whole archive again
+func archiveEntriesRead(archivePath string) ([]archiveEntry, error) {
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = archive.Close()
+	}()
+
+	var entries []archiveEntry
+	cr := &countingReader{r: archive}
+	tr := tar.NewReader(cr)
+	for {
+		tarHeader, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch tarHeader.Typeflag {
+		case tar.TypeReg, tar.TypeDir, tar.TypeSymlink, tar.TypeLink:
+		default:
+			continue
+		}
+
+		// NOTE: tar.Reader consumes the header blocks only, so the data starts here
+		entry := archiveEntry{
+			header: tarHeader,
+			offset: cr.n,
+		}
+		hash := sha256.New()
+		if tarHeader.Typeflag == tar.TypeReg {
+			_, err = io.Copy(hash, tr)
+			if err != nil {
+				return nil, fmt.Errorf("hashing %s: %v", tarHeader.Name, err)
+			}
+		} else {
+			_, _ = hash.Write([]byte(tarHeader.Linkname))
+		}
+		entry.digest = fmt.Sprintf("sha256:%x", hash.Sum(nil))
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// archiveLayersSplit groups the archive entries into layers, the layers
+// extracted in order reassemble the original archive layout
+func archiveLayersSplit(entries []archiveEntry, pagesSplitThreshold int64) []archiveLayer {
+	metadata := archiveLayer{content: LayerContentMetadata}
+	rootfsDiff := archiveLayer{content: LayerContentRootfsDiff}
+	criu := archiveLayer{content: LayerContentCriu}
+	var pages []archiveLayer
+
+	for _, entry := range entries {
+		name := path.Clean(entry.header.Name)
+		switch {
+		case name == rootfsDiffFile:
+			rootfsDiff.entries = append(rootfsDiff.entries, entry)
+		case name == checkpointDirectory || strings.HasPrefix(name, checkpointDirectory+"/"):
+			if pagesSplit(entry, pagesSplitThreshold) {
+				pages = append(pages, archiveLayer{
+					content: LayerContentPages,
+					entries: []archiveEntry{entry},
+				})
+				continue
+			}
+			criu.entries = append(criu.entries, entry)
+		default:
+			metadata.entries = append(metadata.entries, entry)
+		}
+	}
+
+	var layers []archiveLayer
+	for _, layer := range append([]archiveLayer{metadata, rootfsDiff, criu}, pages...) {
+		if len(layer.entries) == 0 {
+			continue
+		}
+		layers = append(layers, layer)
+	}
+	return layers
+}
+
+// archiveDeltaSplit groups the entries changed since the parent into a delta
+// layer, the CRIU pages files are split out of it like archiveLayersSplit does
+func archiveDeltaSplit(entries []archiveEntry, pagesSplitThreshold int64) []archiveLayer {
+	delta := archiveLayer{content: LayerContentDelta}
+	var pages []archiveLayer
+	for _, entry := range entries {
+		if pagesSplit(entry, pagesSplitThreshold) {
+			pages = append(pages, archiveLayer{
+				content: LayerContentPages,
+				entries: []archiveEntry{entry},
+			})
+			continue
+		}
+		delta.entries = append(delta.entries, entry)
+	}
+
+	var layers []archiveLayer
+	if len(delta.entries) > 0 {
+		layers = append(layers, delta)
+	}
+	return append(layers, pages...)
+}
+
+// pagesSplit reports whether entry is a CRIU pages file large enough to get
+// a layer of its own
+func pagesSplit(entry archiveEntry, pagesSplitThreshold int64) bool {
+	name := path.Clean(entry.header.Name)
+	if !strings.HasPrefix(name, checkpointDirectory+"/") {
+		return false
+	}
+	isPages, _ := path.Match(pagesFilePattern, path.Base(name))
+	return isPages && pagesSplitThreshold > 0 && entry.header.Size >= pagesSplitThreshold
+}
+
+// archiveEntryHeader strips the fields that change between two checkpoints of
+// the same content, so identical files end up in identical blobs
+func archiveEntryHeader(header *tar.Header) *tar.Header {
+	return &tar.Header{
+		Typeflag: header.Typeflag,
+		Name:     header.Name,
+		Linkname: header.Linkname,
+		Size:     header.Size,
+		Mode:     header.Mode,
+		Uid:      header.Uid,
+		Gid:      header.Gid,
+		ModTime:  time.Unix(0, 0),
+	}
+}
+
+func archiveLayerOpener(archivePath string, entries []archiveEntry) tarball.Opener {
+	return func() (io.ReadCloser, error) {
+		archive, err := os.Open(archivePath)
+		if err != nil {
+			return nil, err
+		}
+
+		pr, pw := io.Pipe()
+		go func() {
+			err := archiveLayerWrite(pw, archive, entries)
+			if closeErr := archive.Close(); err == nil {
+				err = closeErr
+			}
+			_ = pw.CloseWithError(err)
+		}()
+
+		return pr, nil
+	}
+}
+
+func archiveLayerWrite(w io.Writer, archive io.ReaderAt, entries []archiveEntry) error {
+	tw := tar.NewWriter(w)
+	for _, entry := range entries {
+		header := archiveEntryHeader(entry.header)
+		err := tw.WriteHeader(header)
+		if err != nil {
+			return fmt.Errorf("writing header for %s: %v", header.Name, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		_, err = io.Copy(tw, io.NewSectionReader(archive, entry.offset, header.Size))
+		if err != nil {
+			return fmt.Errorf("writing %s: %v", header.Name, err)
+		}
+	}
+
+	return tw.Close()
+}
+
+// archiveLayerFiles returns the Stove8sAnnotationLayerFiles annotation value for entries
+func archiveLayerFiles(entries []archiveEntry) (string, error) {
+	files := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		files[entry.header.Name] = entry.digest
+	}
+
+	raw, err := json.Marshal(files)
+	return string(raw), err
+}
+
+// parentLayersReuse picks the parent layers whose files are all present and
+// unchanged in entries. The ones stored with another compression or encryption
+// than opts asks for are rebuilt from entries instead, keeping their content,
+// and the entries no parent layer covers are returned as the delta
+func parentLayersReuse(
+	parent v1.Image,
+	entries []archiveEntry,
+	opts BuildOptions,
+) ([]mutate.Addendum, []archiveLayer, []archiveEntry, error) {
+	manifest, err := parent.Manifest()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	digests := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		digests[entry.header.Name] = entry.digest
+	}
+
+	var reused []mutate.Addendum
+	var rebuilt []archiveLayer
+	covered := make(map[string]bool)
+	for _, desc := range manifest.Layers {
+		raw, ok := desc.Annotations[Stove8sAnnotationLayerFiles]
+		if !ok {
+			continue
+		}
+		var files map[string]string
+		err := json.Unmarshal([]byte(raw), &files)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("parsing files of parent layer %s: %v", desc.Digest, err)
+		}
+		if len(files) == 0 {
+			continue
+		}
+
+		unchanged := true
+		for name, digest := range files {
+			if digests[name] != digest {
+				unchanged = false
+				break
+			}
+		}
+		if !unchanged {
+			continue
+		}
+		for name := range files {
+			covered[name] = true
+		}
+
+		if !layerReusable(desc, manifest.Annotations[Stove8sAnnotationCompression], opts) {
+			layer := archiveLayer{content: LayerContent(desc.Annotations[Stove8sAnnotationLayerContent])}
+			for _, entry := range entries {
+				if _, ok := files[entry.header.Name]; ok {
+					layer.entries = append(layer.entries, entry)
+				}
+			}
+			rebuilt = append(rebuilt, layer)
+			continue
+		}
+		layer, err := parent.LayerByDigest(desc.Digest)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		reused = append(reused, mutate.Addendum{
+			Layer:       layer,
+			Annotations: desc.Annotations,
+		})
+	}
+
+	var delta []archiveEntry
+	for _, entry := range entries {
+		if !covered[entry.header.Name] {
+			delta = append(delta, entry)
+		}
+	}
+
+	return reused, rebuilt, delta, nil
+}
+
+// layerReusable reports whether the parent layer desc is compressed and
+// encrypted the way the layers built with opts are, parentCompression is the
+// Stove8sAnnotationCompression of the parent image
+func layerReusable(desc v1.Descriptor, parentCompression string, opts BuildOptions) bool {
+	mediaType, encrypted := strings.CutSuffix(string(desc.MediaType), encryptedMediaTypeSuffix)
+	if encrypted == opts.Encryption.empty() {
+		return false
+	}
+
+	algorithm := opts.Compression.Algorithm
+	if algorithm == "" {
+		algorithm = stove8sv1beta1.Gzip
+	}
+	if types.MediaType(mediaType) != layerMediaType(algorithm) {
+		return false
+	}
+	// NOTE: eStargz layers are gzip layers with a table of contents appended,
+	// only the image annotation tells them apart
+	if parentCompression == "" {
+		parentCompression = string(stove8sv1beta1.Gzip)
+	}
+	return (algorithm == stove8sv1beta1.EStargz) == (parentCompression == string(stove8sv1beta1.EStargz))
+}
+
+// appendArchiveLayers splits the checkpoint archive into layers and appends
+// them to img, with a parent only the layers that changed are added
+func appendArchiveLayers(img v1.Image, archivePath string, opts BuildOptions) (v1.Image, error) {
+	entries, err := archiveEntriesRead(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading archive entries: %v", err)
+	}
+
+	var addenda []mutate.Addendum
+	var archiveLayers []archiveLayer
+	if opts.Parent != nil {
+		reused, rebuilt, delta, err := parentLayersReuse(opts.Parent, entries, opts)
+		if err != nil {
+			return nil, fmt.Errorf("reusing parent layers: %v", err)
+		}
+		addenda = reused
+		archiveLayers = append(rebuilt, archiveDeltaSplit(delta, opts.PagesSplitThreshold)...)
+	} else {
+		archiveLayers = archiveLayersSplit(entries, opts.PagesSplitThreshold)
+	}
+
+	var ec *encconfig.EncryptConfig
+	if !opts.Encryption.empty() {
+		ec, err = encryptConfig(opts.Encryption)
+		if err != nil {
+			return nil, fmt.Errorf("configuring encryption: %v", err)
+		}
+	}
+
+	for _, archiveLayer := range archiveLayers {
+		layer, err := compressedLayer(archiveLayerOpener(archivePath, archiveLayer.entries), opts.Compression)
+		if err != nil {
+			return nil, fmt.Errorf("creating %s Layer: %v", archiveLayer.content, err)
+		}
+		annotations := map[string]string{
+			Stove8sAnnotationLayerContent: string(archiveLayer.content),
+		}
+		// NOTE: the file digests would tell which files of an encrypted layer
+		// changed, its children can't reuse its layers without them
+		if ec == nil {
+			files, err := archiveLayerFiles(archiveLayer.entries)
+			if err != nil {
+				return nil, err
+			}
+			annotations[Stove8sAnnotationLayerFiles] = files
+		} else {
+			var encAnnotations map[string]string
+			layer, encAnnotations, err = layerEncrypt(layer, ec, opts.TempDir)
+			if err != nil {
+				return nil, fmt.Errorf("encrypting %s Layer: %v", archiveLayer.content, err)
+			}
+			maps.Copy(annotations, encAnnotations)
+		}
+		addenda = append(addenda, mutate.Addendum{
+			Layer:       layer,
+			Annotations: annotations,
+		})
+	}
+
+	return mutate.Append(img, addenda...)
+}
+
+// SharedSize returns the size of the layers of img that are also in parent
+func SharedSize(img v1.Image, parent v1.Image) (int64, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return 0, err
+	}
+	parentManifest, err := parent.Manifest()
+	if err != nil {
+		return 0, err
+	}
+
+	parentLayers := make(map[v1.Hash]bool, len(parentManifest.Layers))
+	for _, desc := range parentManifest.Layers {
+		parentLayers[desc.Digest] = true
+	}
+
+	var size int64
+	for _, desc := range manifest.Layers {
+		if parentLayers[desc.Digest] {
+			size += desc.Size
+		}
+	}
+	return size, nil
+}
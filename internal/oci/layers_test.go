@@ -0,0 +1,291 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+type testArchiveFile struct {
+	name     string
+	typeflag byte
+	data     []byte
+}
+
+func testArchiveWrite(t *testing.T, files []testArchiveFile) string {
+	t.Helper()
+
+	archivePath := filepath.Join(t.TempDir(), "checkpoint.tar")
+	archive, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(archive)
+	for _, file := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     file.name,
+			Typeflag: file.typeflag,
+			Mode:     0o644,
+			Size:     int64(len(file.data)),
+			ModTime:  time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(file.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return archivePath
+}
+
+func testLayerRead(t *testing.T, archivePath string, layer archiveLayer) map[string][]byte {
+	t.Helper()
+
+	rc, err := archiveLayerOpener(archivePath, layer.entries)()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(rc)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name], err = io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return files
+}
+
+func TestArchiveLayersSplit(t *testing.T) {
+	files := []testArchiveFile{
+		{name: configDumpFile, typeflag: tar.TypeReg, data: []byte(`{}`)},
+		{name: specDumpFile, typeflag: tar.TypeReg, data: []byte(`{}`)},
+		{name: rootfsDiffFile, typeflag: tar.TypeReg, data: bytes.Repeat([]byte("r"), 2048)},
+		{name: checkpointDirectory, typeflag: tar.TypeDir},
+		{name: "checkpoint/core-1.img", typeflag: tar.TypeReg, data: bytes.Repeat([]byte("c"), 700)},
+		{name: "checkpoint/pages-1.img", typeflag: tar.TypeReg, data: bytes.Repeat([]byte("p"), 4096)},
+		{name: "checkpoint/pages-2.img", typeflag: tar.TypeReg, data: bytes.Repeat([]byte("q"), 16)},
+	}
+	archivePath := testArchiveWrite(t, files)
+
+	entries, err := archiveEntriesRead(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		content LayerContent
+		files   []string
+	}{
+		{LayerContentMetadata, []string{configDumpFile, specDumpFile}},
+		{LayerContentRootfsDiff, []string{rootfsDiffFile}},
+		{LayerContentCriu, []string{checkpointDirectory, "checkpoint/core-1.img", "checkpoint/pages-2.img"}},
+		{LayerContentPages, []string{"checkpoint/pages-1.img"}},
+	}
+	layers := archiveLayersSplit(entries, 1024)
+	if len(layers) != len(expected) {
+		t.Fatalf("expected %d layers, got %d", len(expected), len(layers))
+	}
+
+	reassembled := make(map[string][]byte)
+	for i, layer := range layers {
+		if layer.content != expected[i].content {
+			t.Errorf("layer %d: expected %s, got %s", i, expected[i].content, layer.content)
+		}
+		layerFiles := testLayerRead(t, archivePath, layer)
+		for _, name := range expected[i].files {
+			if _, ok := layerFiles[name]; !ok {
+				t.Errorf("layer %s: missing %s", layer.content, name)
+			}
+		}
+		for name, data := range layerFiles {
+			reassembled[name] = data
+		}
+	}
+
+	for _, file := range files {
+		if !bytes.Equal(reassembled[file.name], file.data) {
+			t.Errorf("%s differs after reassembly", file.name)
+		}
+	}
+}
+
+func TestArchiveLayerDeterministic(t *testing.T) {
+	files := []testArchiveFile{
+		{name: rootfsDiffFile, typeflag: tar.TypeReg, data: bytes.Repeat([]byte("r"), 2048)},
+	}
+	first := testArchiveWrite(t, files)
+	time.Sleep(time.Second)
+	second := testArchiveWrite(t, files)
+
+	var digests []string
+	for _, archivePath := range []string{first, second} {
+		entries, err := archiveEntriesRead(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		layer, err := uncompressedLayerFromOpener(archiveLayerOpener(archivePath, entries))
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest, err := layer.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests = append(digests, digest.String())
+	}
+
+	if digests[0] != digests[1] {
+		t.Errorf("same content produced different layers: %v", digests)
+	}
+}
+
+func TestParentLayersReuse(t *testing.T) {
+	pages := bytes.Repeat([]byte("p"), 4096)
+	parentArchive := testArchiveWrite(t, []testArchiveFile{
+		{name: configDumpFile, typeflag: tar.TypeReg, data: []byte(`{"id":"1"}`)},
+		{name: "checkpoint/core-1.img", typeflag: tar.TypeReg, data: []byte("before")},
+		{name: "checkpoint/pages-1.img", typeflag: tar.TypeReg, data: pages},
+	})
+	childArchive := testArchiveWrite(t, []testArchiveFile{
+		{name: configDumpFile, typeflag: tar.TypeReg, data: []byte(`{"id":"2"}`)},
+		{name: "checkpoint/core-1.img", typeflag: tar.TypeReg, data: []byte("after")},
+		{name: "checkpoint/pages-1.img", typeflag: tar.TypeReg, data: pages},
+	})
+
+	parent, err := appendArchiveLayers(empty.Image, parentArchive, BuildOptions{PagesSplitThreshold: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := appendArchiveLayers(empty.Image, childArchive, BuildOptions{Parent: parent})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := child.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contents []string
+	for _, desc := range manifest.Layers {
+		contents = append(contents, desc.Annotations[Stove8sAnnotationLayerContent])
+	}
+	expected := []string{string(LayerContentPages), string(LayerContentDelta)}
+	if !slices.Equal(contents, expected) {
+		t.Fatalf("expected layers %v, got %v", expected, contents)
+	}
+
+	sharedSize, err := SharedSize(child, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sharedSize != manifest.Layers[0].Size {
+		t.Errorf("expected %d shared bytes, got %d", manifest.Layers[0].Size, sharedSize)
+	}
+}
+
+func TestParentLayersReuseCompressionMismatch(t *testing.T) {
+	pages := bytes.Repeat([]byte("p"), 4096)
+	files := []testArchiveFile{
+		{name: configDumpFile, typeflag: tar.TypeReg, data: []byte(`{"id":"1"}`)},
+		{name: "checkpoint/pages-1.img", typeflag: tar.TypeReg, data: pages},
+	}
+	parent, err := appendArchiveLayers(empty.Image, testArchiveWrite(t, files), BuildOptions{PagesSplitThreshold: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := appendArchiveLayers(empty.Image, testArchiveWrite(t, files), BuildOptions{
+		Parent:              parent,
+		PagesSplitThreshold: 1024,
+		Compression: stove8sv1beta1.SnapShotOutputCompression{
+			Algorithm: stove8sv1beta1.Zstd,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := child.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Layers) != 2 {
+		t.Fatalf("expected 2 layers, got %d", len(manifest.Layers))
+	}
+	for _, desc := range manifest.Layers {
+		if desc.MediaType != types.OCILayerZStd {
+			t.Errorf("%s layer: expected %s, got %s", desc.Annotations[Stove8sAnnotationLayerContent], types.OCILayerZStd, desc.MediaType)
+		}
+	}
+	sharedSize, err := SharedSize(child, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sharedSize != 0 {
+		t.Errorf("expected no gzip layer reused, got %d shared bytes", sharedSize)
+	}
+}
+
+func TestParentLayersReuseDeltaSplit(t *testing.T) {
+	parentArchive := testArchiveWrite(t, []testArchiveFile{
+		{name: configDumpFile, typeflag: tar.TypeReg, data: []byte(`{"id":"1"}`)},
+		{name: "checkpoint/pages-1.img", typeflag: tar.TypeReg, data: bytes.Repeat([]byte("p"), 4096)},
+	})
+	childArchive := testArchiveWrite(t, []testArchiveFile{
+		{name: configDumpFile, typeflag: tar.TypeReg, data: []byte(`{"id":"2"}`)},
+		{name: "checkpoint/core-1.img", typeflag: tar.TypeReg, data: []byte("after")},
+		{name: "checkpoint/pages-1.img", typeflag: tar.TypeReg, data: bytes.Repeat([]byte("q"), 4096)},
+	})
+
+	parent, err := appendArchiveLayers(empty.Image, parentArchive, BuildOptions{PagesSplitThreshold: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := appendArchiveLayers(empty.Image, childArchive, BuildOptions{
+		Parent:              parent,
+		PagesSplitThreshold: 1024,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := child.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contents []string
+	for _, desc := range manifest.Layers {
+		contents = append(contents, desc.Annotations[Stove8sAnnotationLayerContent])
+	}
+	expected := []string{string(LayerContentDelta), string(LayerContentPages)}
+	if !slices.Equal(contents, expected) {
+		t.Fatalf("expected layers %v, got %v", expected, contents)
+	}
+}
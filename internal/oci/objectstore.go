@@ -0,0 +1,187 @@
+package oci
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	ObjectStoreSecretAccessKeyID     = "AWS_ACCESS_KEY_ID"
+	ObjectStoreSecretSecretAccessKey = "AWS_SECRET_ACCESS_KEY"
+	ObjectStoreSecretSessionToken    = "AWS_SESSION_TOKEN"
+
+	// ObjectStoreMinPartSize is the smallest part S3 accepts, but for the last one
+	ObjectStoreMinPartSize int64 = 5 << 20
+	// ObjectStoreDefaultPartSize keeps a 10 GiB archive well under the 10000 parts limit
+	ObjectStoreDefaultPartSize int64 = 64 << 20
+
+	objectStoreMaxParts = 10000
+)
+
+// ObjectStoreCredentials sign the object store requests with AWS SigV4
+type ObjectStoreCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// ObjectStoreCredentialsFromK8sSecret reads the credentials the way the AWS
+// SDKs read them from the environment
+func ObjectStoreCredentialsFromK8sSecret(secret *corev1.Secret) (ObjectStoreCredentials, error) {
+	credentials := ObjectStoreCredentials{
+		AccessKeyID:     string(secret.Data[ObjectStoreSecretAccessKeyID]),
+		SecretAccessKey: string(secret.Data[ObjectStoreSecretSecretAccessKey]),
+		SessionToken:    string(secret.Data[ObjectStoreSecretSessionToken]),
+	}
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return credentials, fmt.Errorf(
+			"secret %s/%s is missing %s or %s",
+			secret.Namespace, secret.Name, ObjectStoreSecretAccessKeyID, ObjectStoreSecretSecretAccessKey,
+		)
+	}
+	return credentials, nil
+}
+
+// ObjectStore is an S3 compatible bucket, addressed path-style so any
+// endpoint works without wildcard DNS
+type ObjectStore struct {
+	Endpoint    *url.URL
+	Region      string
+	Bucket      string
+	Credentials ObjectStoreCredentials
+	// PartSize defaults to ObjectStoreDefaultPartSize
+	PartSize int64
+}
+
+// ObjectInfo is an uploaded object
+type ObjectInfo struct {
+	URL  string
+	ETag string
+}
+
+// ObjectURL is the URL of key, without credentials
+func (s *ObjectStore) ObjectURL(key string) *url.URL {
+	return &url.URL{
+		Scheme: s.Endpoint.Scheme,
+		Host:   s.Endpoint.Host,
+		Path:   path.Join("/", s.Bucket, key),
+	}
+}
+
+func (s *ObjectStore) client() (*minio.Client, error) {
+	if s.Endpoint.Path != "" && s.Endpoint.Path != "/" {
+		return nil, fmt.Errorf("object store endpoint %s can't have a path", s.Endpoint)
+	}
+	return minio.New(s.Endpoint.Host, &minio.Options{
+		Creds: credentials.NewStaticV4(
+			s.Credentials.AccessKeyID,
+			s.Credentials.SecretAccessKey,
+			s.Credentials.SessionToken,
+		),
+		Secure:       s.Endpoint.Scheme == "https",
+		Region:       s.Region,
+		BucketLookup: minio.BucketLookupPath,
+		// NOTE: the SHA-256 checksums are sent as trailers of the streamed bodies
+		TrailingHeaders: true,
+		// NOTE: retries are counted by UploadOptions.Retry
+		MaxRetries: 1,
+	})
+}
+
+func (s *ObjectStore) partSize() int64 {
+	if s.PartSize <= 0 {
+		return ObjectStoreDefaultPartSize
+	}
+	return max(s.PartSize, ObjectStoreMinPartSize)
+}
+
+// Upload stores the file at filePath under key, files larger than the part
+// size go through a multipart upload that's aborted when it fails. Every
+// request carries the SHA-256 of its body, so the store rejects corrupted parts
+func (s *ObjectStore) Upload(ctx context.Context, key, filePath string, opts UploadOptions) (ObjectInfo, error) {
+	client, err := s.client()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	stat, err := file.Stat()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	// NOTE: the part size grows for objects that would need too many parts
+	partSize := s.partSize()
+	if parts := (stat.Size() + partSize - 1) / partSize; parts > objectStoreMaxParts {
+		partSize = (stat.Size() + objectStoreMaxParts - 1) / objectStoreMaxParts
+	}
+
+	var info minio.UploadInfo
+	err = opts.Retry(ctx, func() error {
+		var err error
+		info, err = client.PutObject(ctx, s.Bucket, key, io.NewSectionReader(file, 0, stat.Size()), stat.Size(), minio.PutObjectOptions{
+			ContentType: "application/octet-stream",
+			PartSize:    uint64(partSize),
+			Checksum:    minio.ChecksumSHA256,
+		})
+		return err
+	})
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("uploading %s: %w", key, err)
+	}
+	return ObjectInfo{URL: s.ObjectURL(key).String(), ETag: info.ETag}, nil
+}
+
+// LayoutUpload uploads the files of the OCI image layout at dir under
+// prefix, blobs first so index.json never references a missing blob
+func (s *ObjectStore) LayoutUpload(ctx context.Context, prefix, dir string, opts UploadOptions) (ObjectInfo, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if idx := slices.Index(files, "index.json"); idx != -1 {
+		files = append(slices.Delete(files, idx, idx+1), "index.json")
+	}
+
+	var index ObjectInfo
+	for _, file := range files {
+		info, err := s.Upload(ctx, path.Join(prefix, file), filepath.Join(dir, file), opts)
+		if err != nil {
+			return ObjectInfo{}, err
+		}
+		if file == "index.json" {
+			index = info
+		}
+	}
+	return index, nil
+}
@@ -0,0 +1,362 @@
+package oci
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/minio/minio-go/v7"
+)
+
+// testObjectStoreServer is a MinIO-style stand-in for the subset of the S3 API
+// ObjectStore uses, it checks the access key and the SHA-256 checksums
+type testObjectStoreServer struct {
+	t           *testing.T
+	credentials ObjectStoreCredentials
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+	// failParts fails that many part uploads with a 503 before accepting them
+	failParts int
+	parts     int
+}
+
+func testObjectStore(t *testing.T) (*ObjectStore, *testObjectStoreServer) {
+	t.Helper()
+
+	stub := &testObjectStoreServer{
+		t: t,
+		credentials: ObjectStoreCredentials{
+			AccessKeyID:     "minioadmin",
+			SecretAccessKey: "minioadmin",
+		},
+		objects: make(map[string][]byte),
+		uploads: make(map[string]map[int][]byte),
+	}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	endpoint, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &ObjectStore{
+		Endpoint:    endpoint,
+		Region:      "us-east-1",
+		Bucket:      "checkpoints",
+		Credentials: stub.credentials,
+	}, stub
+}
+
+func (stub *testObjectStoreServer) error(rw http.ResponseWriter, status int, code string) {
+	rw.Header().Set("Content-Type", "application/xml")
+	rw.WriteHeader(status)
+	_ = xml.NewEncoder(rw).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: code})
+}
+
+// testChunkedDecode decodes an aws-chunked body and returns its trailers, the
+// chunk signatures of plain HTTP endpoints are skipped
+func testChunkedDecode(body []byte) ([]byte, http.Header, error) {
+	var data []byte
+	trailer := http.Header{}
+	reader := bufio.NewReader(bytes.NewReader(body))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, nil, err
+		}
+		hexSize, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(hexSize, 16, 64)
+		if err != nil {
+			return nil, nil, err
+		}
+		if size == 0 {
+			break
+		}
+		chunk := make([]byte, size+2)
+		_, err = io.ReadFull(reader, chunk)
+		if err != nil {
+			return nil, nil, err
+		}
+		data = append(data, chunk[:size]...)
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), ":"); ok {
+			trailer.Set(key, value)
+		}
+		if err != nil {
+			break
+		}
+	}
+	return data, trailer, nil
+}
+
+func (stub *testObjectStoreServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		stub.error(rw, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+
+	if !strings.Contains(req.Header.Get("Authorization"), "Credential="+stub.credentials.AccessKeyID+"/") {
+		stub.error(rw, http.StatusForbidden, "InvalidAccessKeyId")
+		return
+	}
+	checksum := req.Header.Get("X-Amz-Checksum-Sha256")
+	if strings.Contains(req.Header.Get("Content-Encoding"), "aws-chunked") {
+		var trailer http.Header
+		body, trailer, err = testChunkedDecode(body)
+		if err != nil {
+			stub.error(rw, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		checksum = trailer.Get("X-Amz-Checksum-Sha256")
+	}
+	// NOTE: CompleteMultipartUpload carries the checksum of the part checksums
+	sum := sha256.Sum256(body)
+	if req.Method == http.MethodPut && checksum != "" && checksum != base64.StdEncoding.EncodeToString(sum[:]) {
+		stub.error(rw, http.StatusBadRequest, "BadDigest")
+		return
+	}
+
+	key := req.URL.Path
+	query := req.URL.Query()
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+
+	switch {
+	case req.Method == http.MethodPost && query.Has("uploads"):
+		uploadID := strconv.Itoa(len(stub.uploads) + 1)
+		stub.uploads[uploadID] = make(map[int][]byte)
+		_ = xml.NewEncoder(rw).Encode(struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			UploadID string   `xml:"UploadId"`
+		}{UploadID: uploadID})
+
+	case req.Method == http.MethodPut && query.Has("uploadId"):
+		parts, ok := stub.uploads[query.Get("uploadId")]
+		if !ok {
+			stub.error(rw, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		if checksum == "" {
+			stub.error(rw, http.StatusBadRequest, "MissingChecksum")
+			return
+		}
+		stub.parts++
+		if stub.failParts > 0 {
+			stub.failParts--
+			stub.error(rw, http.StatusServiceUnavailable, "SlowDown")
+			return
+		}
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		parts[number] = body
+		rw.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(body)))
+		rw.Header().Set("X-Amz-Checksum-Sha256", checksum)
+
+	case req.Method == http.MethodPost && query.Has("uploadId"):
+		parts, ok := stub.uploads[query.Get("uploadId")]
+		if !ok {
+			stub.error(rw, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		var complete struct {
+			Parts []struct {
+				PartNumber     int    `xml:"PartNumber"`
+				ChecksumSHA256 string `xml:"ChecksumSHA256"`
+			} `xml:"Part"`
+		}
+		err := xml.Unmarshal(body, &complete)
+		if err != nil {
+			stub.error(rw, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		var object []byte
+		digests := md5.New()
+		for idx, part := range complete.Parts {
+			data, ok := parts[part.PartNumber]
+			if !ok || part.PartNumber != idx+1 {
+				stub.error(rw, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			partSum := sha256.Sum256(data)
+			if part.ChecksumSHA256 != base64.StdEncoding.EncodeToString(partSum[:]) {
+				stub.error(rw, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			object = append(object, data...)
+			partMD5 := md5.Sum(data)
+			digests.Write(partMD5[:])
+		}
+		stub.objects[key] = object
+		delete(stub.uploads, query.Get("uploadId"))
+		bucket, objectKey, _ := strings.Cut(strings.TrimPrefix(key, "/"), "/")
+		_ = xml.NewEncoder(rw).Encode(struct {
+			XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+			Location string   `xml:"Location"`
+			Bucket   string   `xml:"Bucket"`
+			Key      string   `xml:"Key"`
+			ETag     string   `xml:"ETag"`
+		}{
+			Location: key,
+			Bucket:   bucket,
+			Key:      objectKey,
+			ETag:     fmt.Sprintf(`"%x-%d"`, digests.Sum(nil), len(complete.Parts)),
+		})
+
+	case req.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(stub.uploads, query.Get("uploadId"))
+		rw.WriteHeader(http.StatusNoContent)
+
+	case req.Method == http.MethodPut:
+		stub.objects[key] = body
+		rw.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(body)))
+
+	default:
+		stub.error(rw, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func TestObjectStoreUpload(t *testing.T) {
+	store, stub := testObjectStore(t)
+	store.PartSize = ObjectStoreMinPartSize
+
+	data := make([]byte, 2*ObjectStoreMinPartSize+1024)
+	_, _ = rand.Read(data)
+	filePath := filepath.Join(t.TempDir(), "checkpoint.tar")
+	err := os.WriteFile(filePath, data, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		key       string
+		data      []byte
+		failParts int
+		parts     int
+	}{
+		{name: "single", key: "default/service/small file.tar", data: data[:1024]},
+		{name: "multipart", key: "default/service/checkpoint.tar", data: data, parts: 3},
+		{name: "retry", key: "default/service/retried.tar", data: data, failParts: 1, parts: 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := os.WriteFile(filePath, tc.data, 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stub.failParts = tc.failParts
+			stub.parts = 0
+
+			var retries int
+			info, err := store.Upload(context.Background(), tc.key, filePath, UploadOptions{
+				Retries: 2,
+				OnRetry: func(error) { retries++ },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// NOTE: a failed part fails the upload, the whole object is retried
+			if stub.parts < tc.parts || retries != tc.failParts {
+				t.Errorf("expected at least %d part uploads and %d retries, got %d and %d", tc.parts, tc.failParts, stub.parts, retries)
+			}
+			if info.ETag == "" || !strings.HasSuffix(info.URL, "/checkpoints/"+strings.ReplaceAll(tc.key, " ", "%20")) {
+				t.Errorf("unexpected object %+v", info)
+			}
+			if !bytes.Equal(stub.objects["/checkpoints/"+tc.key], tc.data) {
+				t.Error("uploaded object differs")
+			}
+			if len(stub.uploads) != 0 {
+				t.Errorf("multipart uploads left behind: %d", len(stub.uploads))
+			}
+		})
+	}
+
+	t.Run("wrong credentials", func(t *testing.T) {
+		wrong := *store
+		wrong.Credentials.AccessKeyID = "wrong"
+		_, err := wrong.Upload(context.Background(), "default/service/denied.tar", filePath, UploadOptions{})
+		var storeErr minio.ErrorResponse
+		if !errors.As(err, &storeErr) || storeErr.Code != "InvalidAccessKeyId" {
+			t.Errorf("expected an access key error, got %v", err)
+		}
+		if len(stub.uploads) != 0 {
+			t.Errorf("multipart uploads left behind: %d", len(stub.uploads))
+		}
+	})
+}
+
+func TestObjectStoreLayout(t *testing.T) {
+	store, stub := testObjectStore(t)
+
+	ref, err := name.ParseReference("registry.local/checkpoint/service:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := random.Image(1024, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := SignerFromK8sSecret(testCosignSecret(t, []byte("password")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exportDir := filepath.Join(t.TempDir(), "export")
+	err = LayoutExport(exportDir, ref, img, ExportOptions{Signer: signer})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := store.LayoutUpload(context.Background(), "default/service", exportDir, UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(info.URL, "/checkpoints/default/service/index.json") {
+		t.Errorf("unexpected index object %+v", info)
+	}
+	var keys []string
+	for key := range stub.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	// NOTE: image and signature manifests and configs, 3 + 1 layers, index.json and oci-layout
+	if len(keys) != 10 {
+		t.Errorf("unexpected objects %v", keys)
+	}
+
+	for _, key := range keys {
+		exported, err := os.ReadFile(filepath.Join(exportDir, strings.TrimPrefix(key, "/checkpoints/default/service/")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(stub.objects[key], exported) {
+			t.Errorf("object %s differs from the exported file", key)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package oci
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"bud.studio/stove8s/internal/version"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+const (
+	// InTotoMediaType is the artifact type of the provenance referrers
+	InTotoMediaType types.MediaType = "application/vnd.in-toto+json"
+	// DSSEMediaType is the media type of the envelope holding the statement
+	DSSEMediaType types.MediaType = "application/vnd.dsse.envelope.v1+json"
+
+	inTotoStatementType = "https://in-toto.io/Statement/v1"
+	slsaProvenanceType  = "https://slsa.dev/provenance/v1"
+	provenanceBuildType = "https://bud.studio/stove8s/checkpoint/v1"
+	provenanceBuilderID = "https://bud.studio/stove8s"
+)
+
+// ProvenanceInfo is what the checkpoint image doesn't know about itself
+type ProvenanceInfo struct {
+	Node                    string
+	KubeletVersion          string
+	ContainerRuntimeVersion string
+	// InvocationID identifies the daemonset job that built the image
+	InvocationID string
+}
+
+type ProvenanceStatement struct {
+	Type          string              `json:"_type"`
+	Subject       []ProvenanceSubject `json:"subject"`
+	PredicateType string              `json:"predicateType"`
+	Predicate     ProvenancePredicate `json:"predicate"`
+}
+
+type ProvenanceSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type ProvenancePredicate struct {
+	BuildDefinition struct {
+		BuildType          string `json:"buildType"`
+		ExternalParameters struct {
+			Pod       string `json:"pod"`
+			Namespace string `json:"namespace"`
+			Container string `json:"container"`
+			Node      string `json:"node"`
+		} `json:"externalParameters"`
+		ResolvedDependencies []ProvenanceDependency `json:"resolvedDependencies,omitempty"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			ID      string            `json:"id"`
+			Version map[string]string `json:"version,omitempty"`
+		} `json:"builder"`
+		Metadata struct {
+			InvocationID string    `json:"invocationId,omitempty"`
+			StartedOn    time.Time `json:"startedOn"`
+		} `json:"metadata"`
+	} `json:"runDetails"`
+}
+
+type ProvenanceDependency struct {
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// dssePAE is the pre-authentication encoding DSSE signatures are made over
+func dssePAE(payloadType string, payload []byte) []byte {
+	return fmt.Appendf(nil, "DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload)
+}
+
+// ProvenanceStatementBuild describes how img, pushed to ref, came to be, the
+// source pod, image and checkpoint time come from the image annotations
+func ProvenanceStatementBuild(ref name.Reference, img v1.Image, info ProvenanceInfo) (*ProvenanceStatement, error) {
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	statement := ProvenanceStatement{
+		Type: inTotoStatementType,
+		Subject: []ProvenanceSubject{{
+			Name:   ref.Context().Name(),
+			Digest: map[string]string{digest.Algorithm: digest.Hex},
+		}},
+		PredicateType: slsaProvenanceType,
+	}
+
+	buildDefinition := &statement.Predicate.BuildDefinition
+	buildDefinition.BuildType = provenanceBuildType
+	buildDefinition.ExternalParameters.Pod = manifest.Annotations[CheckpointAnnotationPod]
+	buildDefinition.ExternalParameters.Namespace = manifest.Annotations[CheckpointAnnotationNamespace]
+	buildDefinition.ExternalParameters.Container = manifest.Annotations[CheckpointAnnotationName]
+	buildDefinition.ExternalParameters.Node = info.Node
+	if imageID := manifest.Annotations[CheckpointAnnotationRootfsImageID]; imageID != "" {
+		dependency := ProvenanceDependency{
+			URI: manifest.Annotations[CheckpointAnnotationRootfsImageName],
+		}
+		if algorithm, hex, ok := strings.Cut(imageID, ":"); ok {
+			dependency.Digest = map[string]string{algorithm: hex}
+		}
+		buildDefinition.ResolvedDependencies = append(buildDefinition.ResolvedDependencies, dependency)
+	}
+
+	runDetails := &statement.Predicate.RunDetails
+	runDetails.Builder.ID = provenanceBuilderID
+	runDetails.Builder.Version = map[string]string{
+		"stove8s": version.Version,
+	}
+	if info.KubeletVersion != "" {
+		runDetails.Builder.Version["kubelet"] = info.KubeletVersion
+	}
+	if info.ContainerRuntimeVersion != "" {
+		runDetails.Builder.Version["containerRuntime"] = info.ContainerRuntimeVersion
+	}
+	runDetails.Metadata.InvocationID = info.InvocationID
+	if len(cfg.History) > 0 {
+		runDetails.Metadata.StartedOn = cfg.History[0].Created.Time
+	}
+
+	return &statement, nil
+}
+
+// ProvenanceAttach pushes statement in a DSSE envelope as an OCI referrer of
+// the image it's about, signing it when signer isn't nil. It returns the
+// digest of the referrer manifest
+func ProvenanceAttach(
+	ref name.Reference,
+	img v1.Image,
+	statement *ProvenanceStatement,
+	signer crypto.Signer,
+	opts ...remote.Option,
+) (v1.Hash, error) {
+	artifact, err := provenanceArtifact(img, statement, signer)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+
+	digest, err := artifact.Digest()
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	err = remote.Write(ref.Context().Digest(digest.String()), artifact, opts...)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	return digest, nil
+}
+
+// provenanceEnvelope returns statement in a DSSE envelope, signed when signer
+// isn't nil
+func provenanceEnvelope(statement *ProvenanceStatement, signer crypto.Signer) ([]byte, error) {
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return nil, err
+	}
+	envelope := dsseEnvelope{
+		PayloadType: string(InTotoMediaType),
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []dsseSignature{},
+	}
+	if signer != nil {
+		signature, err := payloadSign(signer, dssePAE(envelope.PayloadType, payload))
+		if err != nil {
+			return nil, fmt.Errorf("signing statement: %v", err)
+		}
+		envelope.Signatures = append(envelope.Signatures, dsseSignature{
+			Sig: base64.StdEncoding.EncodeToString(signature),
+		})
+	}
+
+	return json.Marshal(envelope)
+}
+
+// provenanceArtifact is the referrer of img holding the statement envelope
+func provenanceArtifact(img v1.Image, statement *ProvenanceStatement, signer crypto.Signer) (v1.Image, error) {
+	subject, err := partial.Descriptor(img)
+	if err != nil {
+		return nil, err
+	}
+	envelopeBytes, err := provenanceEnvelope(statement, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: the config media type is the artifact type registries index referrers by
+	artifact := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	artifact = mutate.ConfigMediaType(artifact, InTotoMediaType)
+	artifact, err = mutate.Append(artifact, mutate.Addendum{
+		Layer: static.NewLayer(envelopeBytes, DSSEMediaType),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mutate.Subject(artifact, *subject).(v1.Image), nil
+}
+
+// ProvenanceFetch returns the provenance statement attached to the image ref
+// points to, when pub isn't nil the statement must be signed with it
+func ProvenanceFetch(ref name.Reference, pub crypto.PublicKey, auth authn.Authenticator) (*ProvenanceStatement, error) {
+	desc, err := remote.Head(ref, remote.WithAuth(auth))
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := remote.Referrers(
+		ref.Context().Digest(desc.Digest.String()),
+		remote.WithAuth(auth),
+		remote.WithFilter("artifactType", string(InTotoMediaType)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("listing referrers of %s: %v", desc.Digest, err)
+	}
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, referrer := range indexManifest.Manifests {
+		statement, err := provenanceRead(ref.Context().Digest(referrer.Digest.String()), pub, auth)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", referrer.Digest, err))
+			continue
+		}
+		for _, subject := range statement.Subject {
+			if subject.Digest[desc.Digest.Algorithm] == desc.Digest.Hex {
+				return statement, nil
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s: statement isn't about %s", referrer.Digest, desc.Digest))
+	}
+
+	return nil, errors.Join(append([]error{fmt.Errorf("no valid provenance for %s", desc.Digest)}, errs...)...)
+}
+
+// ProvenanceVerify checks that the image ref points to, or every platform
+// image of the index, has a provenance statement signed with pub, like Verify
+// does for the signatures
+func ProvenanceVerify(ref name.Reference, pub crypto.PublicKey, auth authn.Authenticator) error {
+	desc, err := remote.Get(ref, remote.WithAuth(auth))
+	if err != nil {
+		return err
+	}
+	if !desc.MediaType.IsIndex() {
+		_, err := ProvenanceFetch(ref.Context().Digest(desc.Digest.String()), pub, auth)
+		return err
+	}
+
+	index, err := desc.ImageIndex()
+	if err != nil {
+		return err
+	}
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return err
+	}
+	for _, child := range indexManifest.Manifests {
+		_, err := ProvenanceFetch(ref.Context().Digest(child.Digest.String()), pub, auth)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func provenanceRead(ref name.Digest, pub crypto.PublicKey, auth authn.Authenticator) (*ProvenanceStatement, error) {
+	img, err := remote.Image(ref, remote.WithAuth(auth))
+	if err != nil {
+		return nil, err
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	if len(layers) != 1 {
+		return nil, fmt.Errorf("expected 1 layer, got %d", len(layers))
+	}
+	envelopeBytes, err := layerRead(layers[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var envelope dsseEnvelope
+	err = json.Unmarshal(envelopeBytes, &envelope)
+	if err != nil {
+		return nil, err
+	}
+	if envelope.PayloadType != string(InTotoMediaType) {
+		return nil, fmt.Errorf("unexpected payload type %s", envelope.PayloadType)
+	}
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if pub != nil {
+		pae := dssePAE(envelope.PayloadType, payload)
+		verified := false
+		for _, signature := range envelope.Signatures {
+			sig, err := base64.StdEncoding.DecodeString(signature.Sig)
+			if err == nil && payloadVerify(pub, pae, sig) {
+				verified = true
+				break
+			}
+		}
+		if !verified {
+			return nil, errors.New("no valid signature")
+		}
+	}
+
+	var statement ProvenanceStatement
+	err = json.Unmarshal(payload, &statement)
+	if err != nil {
+		return nil, err
+	}
+	if statement.Type != inTotoStatementType || statement.PredicateType != slsaProvenanceType {
+		return nil, fmt.Errorf("unexpected statement %s/%s", statement.Type, statement.PredicateType)
+	}
+	return &statement, nil
+}
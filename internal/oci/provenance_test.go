@@ -0,0 +1,101 @@
+package oci
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestProvenanceAttachFetch(t *testing.T) {
+	keySecret := testCosignSecret(t, []byte("password"))
+	signer, err := SignerFromK8sSecret(keySecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := PublicKeyFromK8sSecret(keySecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, err := PublicKeyFromK8sSecret(testCosignSecret(t, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// NOTE: without the referrers API the referrers are tracked in a fallback tag
+	for _, referrersSupport := range []bool{true, false} {
+		t.Run(fmt.Sprintf("referrers=%v", referrersSupport), func(t *testing.T) {
+			server := httptest.NewServer(registry.New(registry.WithReferrersSupport(referrersSupport)))
+			defer server.Close()
+			u, err := url.Parse(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ref, err := name.ParseReference(u.Host + "/checkpoint/service:latest")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			img, err := random.Image(1024, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img = mutate.Annotations(img, map[string]string{
+				CheckpointAnnotationPod:           "service-0",
+				CheckpointAnnotationNamespace:     "default",
+				CheckpointAnnotationRootfsImageID: "sha256:0123",
+			}).(v1.Image)
+			err = remote.Write(ref, img)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			statement, err := ProvenanceStatementBuild(ref, img, ProvenanceInfo{
+				Node:           "node-0",
+				KubeletVersion: "v1.33.0",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = ProvenanceAttach(ref, img, statement, signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fetched, err := ProvenanceFetch(ref, pub, authn.Anonymous)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parameters := fetched.Predicate.BuildDefinition.ExternalParameters
+			if parameters.Pod != "service-0" || parameters.Namespace != "default" || parameters.Node != "node-0" {
+				t.Errorf("unexpected external parameters %+v", parameters)
+			}
+			dependencies := fetched.Predicate.BuildDefinition.ResolvedDependencies
+			if len(dependencies) != 1 || dependencies[0].Digest["sha256"] != "0123" {
+				t.Errorf("unexpected resolved dependencies %+v", dependencies)
+			}
+
+			_, err = ProvenanceFetch(ref, otherPub, authn.Anonymous)
+			if err == nil {
+				t.Error("expected verification with another key to fail")
+			}
+
+			err = ProvenanceVerify(ref, pub, authn.Anonymous)
+			if err != nil {
+				t.Error(err)
+			}
+			err = ProvenanceVerify(ref, otherPub, authn.Anonymous)
+			if err == nil {
+				t.Error("expected verification with another key to fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,346 @@
+package oci
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// keys of the Secret created by `cosign generate-key-pair k8s://<namespace>/<name>`
+const (
+	CosignSecretPrivateKey = "cosign.key"
+	CosignSecretPassword   = "cosign.password"
+	CosignSecretPublicKey  = "cosign.pub"
+)
+
+const (
+	// CosignAnnotationSignature holds the base64 signature of the layer payload
+	CosignAnnotationSignature = "dev.cosignproject.cosign/signature"
+
+	cosignSignatureMediaType types.MediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	cosignSignatureType                      = "cosign container image signature"
+	cosignSignatureTagSuffix                 = ".sig"
+)
+
+type simpleSigning struct {
+	Critical simpleSigningCritical `json:"critical"`
+	Optional map[string]string     `json:"optional"`
+}
+
+type simpleSigningCritical struct {
+	Identity struct {
+		DockerReference string `json:"docker-reference"`
+	} `json:"identity"`
+	Image struct {
+		DockerManifestDigest string `json:"docker-manifest-digest"`
+	} `json:"image"`
+	Type string `json:"type"`
+}
+
+// cosignEncryptedKey is the envelope of cosign private keys
+type cosignEncryptedKey struct {
+	KDF struct {
+		Name   string `json:"name"`
+		Params struct {
+			N int `json:"N"`
+			R int `json:"r"`
+			P int `json:"p"`
+		} `json:"params"`
+		Salt []byte `json:"salt"`
+	} `json:"kdf"`
+	Cipher struct {
+		Name  string `json:"name"`
+		Nonce []byte `json:"nonce"`
+	} `json:"cipher"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// SignerFromK8sSecret loads the cosign private key of secret, encrypted keys
+// are decrypted with the password stored next to them
+func SignerFromK8sSecret(secret *corev1.Secret) (crypto.Signer, error) {
+	keyPEM, exists := secret.Data[CosignSecretPrivateKey]
+	if !exists {
+		return nil, fmt.Errorf("secret missing %s field", CosignSecretPrivateKey)
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+
+	der := block.Bytes
+	switch block.Type {
+	case "ENCRYPTED SIGSTORE PRIVATE KEY", "ENCRYPTED COSIGN PRIVATE KEY":
+		var err error
+		der, err = cosignKeyDecrypt(block.Bytes, secret.Data[CosignSecretPassword])
+		if err != nil {
+			return nil, fmt.Errorf("decrypting private key: %v", err)
+		}
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(der)
+	case "PRIVATE KEY":
+	default:
+		return nil, fmt.Errorf("unsupported private key type %s", block.Type)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key %T", key)
+	}
+	return signer, nil
+}
+
+func cosignKeyDecrypt(data []byte, password []byte) ([]byte, error) {
+	var encrypted cosignEncryptedKey
+	err := json.Unmarshal(data, &encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if encrypted.KDF.Name != "scrypt" || encrypted.Cipher.Name != "nacl/secretbox" {
+		return nil, fmt.Errorf("unsupported encryption %s/%s", encrypted.KDF.Name, encrypted.Cipher.Name)
+	}
+	if len(encrypted.Cipher.Nonce) != 24 {
+		return nil, errors.New("invalid nonce")
+	}
+
+	params := encrypted.KDF.Params
+	secret, err := scrypt.Key(password, encrypted.KDF.Salt, params.N, params.R, params.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	var key [32]byte
+	var nonce [24]byte
+	copy(key[:], secret)
+	copy(nonce[:], encrypted.Cipher.Nonce)
+
+	der, ok := secretbox.Open(nil, encrypted.Ciphertext, &nonce, &key)
+	if !ok {
+		return nil, errors.New("wrong password")
+	}
+	return der, nil
+}
+
+// PublicKeyFromK8sSecret loads the cosign public key of secret
+func PublicKeyFromK8sSecret(secret *corev1.Secret) (crypto.PublicKey, error) {
+	keyPEM, exists := secret.Data[CosignSecretPublicKey]
+	if !exists {
+		return nil, fmt.Errorf("secret missing %s field", CosignSecretPublicKey)
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("public key is not PEM encoded")
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// SignatureReference is the tag cosign stores the signatures of digest under
+func SignatureReference(repo name.Repository, digest v1.Hash) name.Tag {
+	return repo.Tag(fmt.Sprintf("%s-%s%s", digest.Algorithm, digest.Hex, cosignSignatureTagSuffix))
+}
+
+// Sign signs the manifest digest of img pushed to ref and pushes the signature
+// next to it the way cosign does, appending to the signatures already there
+func Sign(ref name.Reference, img v1.Image, signer crypto.Signer, opts ...remote.Option) error {
+	digest, err := img.Digest()
+	if err != nil {
+		return err
+	}
+
+	sigRef := SignatureReference(ref.Context(), digest)
+	sigImg, err := remote.Image(sigRef, opts...)
+	if err != nil {
+		var transportErr *transport.Error
+		if !errors.As(err, &transportErr) || transportErr.StatusCode != http.StatusNotFound {
+			return fmt.Errorf("fetching existing signatures: %v", err)
+		}
+	}
+
+	sigImg, err = signatureAppend(sigImg, ref, img, signer)
+	if err != nil {
+		return err
+	}
+
+	return remote.Write(sigRef, sigImg, opts...)
+}
+
+// signatureCreate returns the simple signing payload of img for ref and its signature
+func signatureCreate(ref name.Reference, img v1.Image, signer crypto.Signer) ([]byte, []byte, error) {
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var payload simpleSigning
+	payload.Critical.Identity.DockerReference = ref.Context().Name()
+	payload.Critical.Image.DockerManifestDigest = digest.String()
+	payload.Critical.Type = cosignSignatureType
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signature, err := payloadSign(signer, payloadBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("signing payload: %v", err)
+	}
+	return payloadBytes, signature, nil
+}
+
+// signatureAppend adds a signature of img to the cosign signature image
+// sigImg, a new one is created when it's nil
+func signatureAppend(sigImg v1.Image, ref name.Reference, img v1.Image, signer crypto.Signer) (v1.Image, error) {
+	payload, signature, err := signatureCreate(ref, img, signer)
+	if err != nil {
+		return nil, err
+	}
+	if sigImg == nil {
+		sigImg = mutate.ConfigMediaType(mutate.MediaType(empty.Image, types.OCIManifestSchema1), types.OCIConfigJSON)
+	}
+
+	return mutate.Append(sigImg, mutate.Addendum{
+		Layer: static.NewLayer(payload, cosignSignatureMediaType),
+		Annotations: map[string]string{
+			CosignAnnotationSignature: base64.StdEncoding.EncodeToString(signature),
+		},
+	})
+}
+
+func payloadSign(signer crypto.Signer, payload []byte) ([]byte, error) {
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		return signer.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+	hash := sha256.Sum256(payload)
+	return signer.Sign(rand.Reader, hash[:], crypto.SHA256)
+}
+
+func payloadVerify(pub crypto.PublicKey, payload []byte, signature []byte) bool {
+	hash := sha256.Sum256(payload)
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, hash[:], signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, payload, signature)
+	default:
+		return false
+	}
+}
+
+// Verify makes sure the image ref currently points to carries a signature made
+// with pub, for an index every platform manifest must. It returns the verified
+// manifest digest
+func Verify(ref name.Reference, pub crypto.PublicKey, auth authn.Authenticator) (v1.Hash, error) {
+	desc, err := remote.Get(ref, remote.WithAuth(auth))
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	if !desc.MediaType.IsIndex() {
+		err := digestVerify(ref.Context(), desc.Digest, pub, auth)
+		if err != nil {
+			return v1.Hash{}, err
+		}
+		return desc.Digest, nil
+	}
+
+	// NOTE: multi-architecture snapshots sign every platform manifest, not the index
+	index, err := desc.ImageIndex()
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	for _, child := range indexManifest.Manifests {
+		err := digestVerify(ref.Context(), child.Digest, pub, auth)
+		if err != nil {
+			return v1.Hash{}, err
+		}
+	}
+	return desc.Digest, nil
+}
+
+func digestVerify(repo name.Repository, digest v1.Hash, pub crypto.PublicKey, auth authn.Authenticator) error {
+	sigImg, err := remote.Image(SignatureReference(repo, digest), remote.WithAuth(auth))
+	if err != nil {
+		return fmt.Errorf("fetching signatures of %s: %v", digest, err)
+	}
+	manifest, err := sigImg.Manifest()
+	if err != nil {
+		return err
+	}
+
+	for _, layerDesc := range manifest.Layers {
+		if layerDesc.MediaType != cosignSignatureMediaType {
+			continue
+		}
+		signature, err := base64.StdEncoding.DecodeString(layerDesc.Annotations[CosignAnnotationSignature])
+		if err != nil {
+			continue
+		}
+		layer, err := sigImg.LayerByDigest(layerDesc.Digest)
+		if err != nil {
+			return err
+		}
+		payload, err := layerRead(layer)
+		if err != nil {
+			return err
+		}
+		if !payloadVerify(pub, payload, signature) {
+			continue
+		}
+
+		var signed simpleSigning
+		err = json.Unmarshal(payload, &signed)
+		if err != nil {
+			continue
+		}
+		if signed.Critical.Type == cosignSignatureType &&
+			signed.Critical.Image.DockerManifestDigest == digest.String() {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no valid signature for %s", digest)
+}
+
+func layerRead(layer v1.Layer) ([]byte, error) {
+	rc, err := layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	return io.ReadAll(rc)
+}
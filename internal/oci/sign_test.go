@@ -0,0 +1,157 @@
+package oci
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// testCosignSecret builds a Secret the way `cosign generate-key-pair k8s://` does
+func testCosignSecret(t *testing.T, password []byte) *corev1.Secret {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDer, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var encrypted cosignEncryptedKey
+	encrypted.KDF.Name = "scrypt"
+	encrypted.KDF.Params.N = 1 << 10
+	encrypted.KDF.Params.R = 8
+	encrypted.KDF.Params.P = 1
+	encrypted.KDF.Salt = make([]byte, 32)
+	encrypted.Cipher.Name = "nacl/secretbox"
+	encrypted.Cipher.Nonce = make([]byte, 24)
+	_, _ = rand.Read(encrypted.KDF.Salt)
+	_, _ = rand.Read(encrypted.Cipher.Nonce)
+	secret, err := scrypt.Key(password, encrypted.KDF.Salt, 1<<10, 8, 1, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var boxKey [32]byte
+	var nonce [24]byte
+	copy(boxKey[:], secret)
+	copy(nonce[:], encrypted.Cipher.Nonce)
+	encrypted.Ciphertext = secretbox.Seal(nil, der, &nonce, &boxKey)
+	encryptedBytes, err := json.Marshal(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &corev1.Secret{
+		Data: map[string][]byte{
+			CosignSecretPrivateKey: pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED SIGSTORE PRIVATE KEY", Bytes: encryptedBytes}),
+			CosignSecretPassword:   password,
+			CosignSecretPublicKey:  pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDer}),
+		},
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.ParseReference(u.Host + "/checkpoint/service:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keySecret := testCosignSecret(t, []byte("password"))
+	signer, err := SignerFromK8sSecret(keySecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := PublicKeyFromK8sSecret(keySecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, err := PublicKeyFromK8sSecret(testCosignSecret(t, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = remote.Write(ref, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Verify(ref, pub, authn.Anonymous)
+	if err == nil {
+		t.Fatal("expected unsigned image to fail verification")
+	}
+
+	err = Sign(ref, img, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := Verify(ref, pub, authn.Anonymous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != expected {
+		t.Errorf("expected verified digest %s, got %s", expected, digest)
+	}
+
+	_, err = Verify(ref, otherPub, authn.Anonymous)
+	if err == nil {
+		t.Error("expected verification with another key to fail")
+	}
+
+	tampered, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = remote.Write(ref, tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Verify(ref, pub, authn.Anonymous)
+	if err == nil {
+		t.Error("expected tampered image to fail verification")
+	}
+}
+
+func TestSignerFromK8sSecretWrongPassword(t *testing.T) {
+	keySecret := testCosignSecret(t, []byte("password"))
+	keySecret.Data[CosignSecretPassword] = []byte("wrong")
+
+	_, err := SignerFromK8sSecret(keySecret)
+	if err == nil {
+		t.Fatal("expected decryption with the wrong password to fail")
+	}
+}
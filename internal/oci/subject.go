@@ -0,0 +1,200 @@
+package oci
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// CheckpointArtifactType is the artifactType of checkpoint manifests, so they
+// can be told apart from other referrers of their base image
+const CheckpointArtifactType = "application/vnd.bud.stove8s.checkpoint.v1"
+
+// SubjectResolve returns the descriptor of the base image a checkpoint was
+// taken from and the digest reference it was found at. It's only resolved by
+// digest, the tag may point to another image by now: repoDigests are the
+// manifests the runtime pulled the image by, imageID is the config or
+// manifest digest it recorded and imageName where it was pulled from. For an
+// index the platform manifest holding imageID is returned
+func SubjectResolve(
+	imageName string,
+	imageID string,
+	repoDigests []string,
+	keychain authn.Keychain,
+) (name.Digest, *v1.Descriptor, error) {
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		return name.Digest{}, nil, err
+	}
+	id, err := v1.NewHash(imageID)
+	if err != nil {
+		return name.Digest{}, nil, fmt.Errorf("parsing image id: %v", err)
+	}
+
+	// NOTE: the digests of the repository the image was pulled from come first,
+	// the runtime also records those of other names of the image
+	var candidates, others []name.Digest
+	if digest, ok := ref.(name.Digest); ok {
+		candidates = append(candidates, digest)
+	}
+	for _, repoDigest := range repoDigests {
+		digest, err := name.NewDigest(repoDigest)
+		if err != nil {
+			continue
+		}
+		if digest.Context() == ref.Context() {
+			candidates = append(candidates, digest)
+		} else {
+			others = append(others, digest)
+		}
+	}
+	candidates = append(candidates, ref.Context().Digest(id.String()))
+	candidates = append(candidates, others...)
+
+	var errs []error
+	for _, candidate := range candidates {
+		subject, err := subjectMatch(candidate, id, keychain)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", candidate, err))
+			continue
+		}
+		return candidate.Context().Digest(subject.Digest.String()), subject, nil
+	}
+
+	return name.Digest{}, nil, errors.Join(append(
+		[]error{fmt.Errorf("no digest of %s holds %s", imageName, imageID)},
+		errs...,
+	)...)
+}
+
+// subjectMatch returns the manifest ref points to, or the platform manifest
+// of the index, whose digest or config digest is id
+func subjectMatch(ref name.Digest, id v1.Hash, keychain authn.Keychain) (*v1.Descriptor, error) {
+	desc, err := remote.Get(ref, remote.WithAuthFromKeychain(keychain))
+	if err != nil {
+		return nil, err
+	}
+	if desc.Digest == id {
+		return &desc.Descriptor, nil
+	}
+
+	var candidates []v1.Descriptor
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return nil, err
+		}
+		manifest, err := index.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range manifest.Manifests {
+			if child.Digest == id {
+				return &child, nil
+			}
+			if child.MediaType.IsImage() {
+				candidates = append(candidates, child)
+			}
+		}
+	} else {
+		candidates = append(candidates, desc.Descriptor)
+	}
+
+	for _, candidate := range candidates {
+		img, err := remote.Image(ref.Context().Digest(candidate.Digest.String()), remote.WithAuthFromKeychain(keychain))
+		if err != nil {
+			return nil, err
+		}
+		configName, err := img.ConfigName()
+		if err != nil {
+			return nil, err
+		}
+		if configName == id {
+			return &candidate, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s isn't %s", ref.DigestStr(), id)
+}
+
+// artifactTypedImage sets the artifactType of the manifest, v1.Manifest has no
+// field for it on images
+type artifactTypedImage struct {
+	v1.Image
+	artifactType string
+}
+
+func withArtifactType(img v1.Image, artifactType string) v1.Image {
+	return &artifactTypedImage{
+		Image:        img,
+		artifactType: artifactType,
+	}
+}
+
+func (i *artifactTypedImage) RawManifest() ([]byte, error) {
+	raw, err := i.Image.RawManifest()
+	if err != nil {
+		return nil, err
+	}
+	var manifest map[string]json.RawMessage
+	err = json.Unmarshal(raw, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	manifest["artifactType"], err = json.Marshal(i.artifactType)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(manifest)
+}
+
+func (i *artifactTypedImage) Digest() (v1.Hash, error) {
+	return partial.Digest(i)
+}
+
+func (i *artifactTypedImage) Size() (int64, error) {
+	return partial.Size(i)
+}
+
+func (i *artifactTypedImage) ArtifactType() (string, error) {
+	return i.artifactType, nil
+}
+
+// BaseImageReplicate copies the base image source resolved to into repo by
+// digest, so the copy has the same digest, and returns its reference
+func BaseImageReplicate(
+	source name.Digest,
+	repo name.Repository,
+	keychain authn.Keychain,
+	opts ...remote.Option,
+) (name.Digest, error) {
+	img, err := remote.Image(source, remote.WithAuthFromKeychain(keychain))
+	if err != nil {
+		return name.Digest{}, err
+	}
+
+	copyRef := repo.Digest(source.DigestStr())
+	err = remote.Write(copyRef, img, append([]remote.Option{remote.WithAuthFromKeychain(keychain)}, opts...)...)
+	if err != nil {
+		return name.Digest{}, err
+	}
+	return copyRef, nil
+}
+
+// ReferrerReplicate copies the manifest ref points to into repo by digest, so
+// it's listed as a referrer of its subject there. The blobs are mounted when
+// both repositories are in the same registry
+func ReferrerReplicate(ref name.Digest, repo name.Repository, opts ...remote.Option) error {
+	img, err := remote.Image(ref, opts...)
+	if err != nil {
+		return err
+	}
+	return remote.Write(repo.Digest(ref.DigestStr()), img, opts...)
+}
@@ -0,0 +1,254 @@
+package oci
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestSubjectResolve(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := random.Index(1024, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexRef, err := name.ParseReference(u.Host + "/library/multiarch:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = remote.WriteIndex(indexRef, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := index.Image(indexManifest.Manifests[1].Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configName, err := child.ConfigName()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indexDigest, err := index.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoDigests := []string{indexRef.Context().Digest(indexDigest.String()).String()}
+
+	// NOTE: the tag is moved, the subject is still resolved through the digest
+	other, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = remote.Write(indexRef, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, subject, err := SubjectResolve(indexRef.String(), configName.String(), repoDigests, authn.DefaultKeychain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject.Digest != indexManifest.Manifests[1].Digest {
+		t.Errorf("expected subject %s, got %s", indexManifest.Manifests[1].Digest, subject.Digest)
+	}
+	if source.Context() != indexRef.Context() || source.DigestStr() != subject.Digest.String() {
+		t.Errorf("unexpected subject reference %s", source)
+	}
+
+	otherConfigName, err := other.ConfigName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = SubjectResolve(indexRef.String(), otherConfigName.String(), repoDigests, authn.DefaultKeychain)
+	if err == nil {
+		t.Error("expected resolving an image the digests don't hold to fail")
+	}
+	_, _, err = SubjectResolve(indexRef.String(), configName.String(), nil, authn.DefaultKeychain)
+	if err == nil {
+		t.Error("expected resolving through the tag alone to fail")
+	}
+}
+
+func TestWithArtifactType(t *testing.T) {
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := base.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img = mutate.Subject(img, v1.Descriptor{Digest: subject}).(v1.Image)
+	img = withArtifactType(img, CheckpointArtifactType)
+
+	raw, err := img.RawManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest struct {
+		ArtifactType string         `json:"artifactType"`
+		Subject      *v1.Descriptor `json:"subject"`
+	}
+	err = json.Unmarshal(raw, &manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.ArtifactType != CheckpointArtifactType {
+		t.Errorf("expected artifactType %s, got %q", CheckpointArtifactType, manifest.ArtifactType)
+	}
+	if manifest.Subject == nil || manifest.Subject.Digest != subject {
+		t.Errorf("expected subject %s, got %v", subject, manifest.Subject)
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _, err := v1.SHA256(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != expected {
+		t.Errorf("digest %s doesn't match the manifest %s", digest, expected)
+	}
+}
+
+func TestBaseImageReplicate(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseRef, err := name.ParseReference(u.Host + "/library/base:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = remote.Write(baseRef, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := base.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, _, err := SubjectResolve(baseRef.String(), digest.String(), nil, authn.DefaultKeychain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := name.NewRepository(u.Host + "/checkpoint/service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	copyRef, err := BaseImageReplicate(source, repo, authn.DefaultKeychain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copyRef.DigestStr() != digest.String() {
+		t.Errorf("expected the copy to keep digest %s, got %s", digest, copyRef.DigestStr())
+	}
+
+	desc, err := remote.Head(copyRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Digest != digest {
+		t.Errorf("expected %s in the destination repository, got %s", digest, desc.Digest)
+	}
+}
+
+func TestReferrerReplicate(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.WithReferrersSupport(true)))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseDigest, err := base.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseRepo, err := name.NewRepository(u.Host + "/library/base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = remote.Write(baseRepo.Digest(baseDigest.String()), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := partial.Descriptor(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoint, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoint = withArtifactType(mutate.Subject(checkpoint, *subject).(v1.Image), CheckpointArtifactType)
+	digest, err := checkpoint.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.NewDigest(u.Host + "/checkpoint/service@" + digest.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = remote.Write(ref, checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ReferrerReplicate(ref, baseRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := remote.Referrers(baseRepo.Digest(baseDigest.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Manifests) != 1 || manifest.Manifests[0].Digest != digest {
+		t.Errorf("expected %s to be listed as referrer of the base image, got %v", digest, manifest.Manifests)
+	}
+}
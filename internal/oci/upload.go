@@ -0,0 +1,410 @@
+package oci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/minio/minio-go/v7"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const defaultUploadChunkSize = 64 << 20
+
+var errChunksRejected = errors.New("registry rejected the chunked upload")
+
+type UploadOptions struct {
+	// Retries is the number of times a blob upload is retried, 0 disables retries
+	Retries int
+	// ChunkSize is the size of the PATCH requests, a failed upload resumes
+	// from the last chunk the registry acknowledged
+	ChunkSize int64
+	// OnRetry is called with the error before every retry
+	OnRetry func(err error)
+}
+
+// backoff is the jittered exponential backoff used between retries
+func (opts UploadOptions) backoff() wait.Backoff {
+	return wait.Backoff{
+		Duration: time.Second,
+		Factor:   2,
+		Jitter:   0.5,
+		Steps:    opts.Retries + 1,
+		Cap:      2 * time.Minute,
+	}
+}
+
+// Retry calls fn until it succeeds, fails with an error that isn't Retryable
+// or has been retried Retries times, backing off in between. OnRetry is only
+// called when another attempt follows
+func (opts UploadOptions) Retry(ctx context.Context, fn func() error) error {
+	backoff := opts.backoff()
+	for retries := 0; ; retries++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if retries >= opts.Retries || !Retryable(err) {
+			return err
+		}
+		if opts.OnRetry != nil {
+			opts.OnRetry(err)
+		}
+
+		delay := backoff.Step()
+		slog.Warn("Retrying upload", "err", err, "delay", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// RemoteOptions turns the retries of remote off, its calls are wrapped in
+// Retry instead. remote asks its predicate about the last attempt too, so
+// retries counted from there would include one that never happens
+func (opts UploadOptions) RemoteOptions() []remote.Option {
+	return []remote.Option{remote.WithRetryBackoff(remote.Backoff{Steps: 1})}
+}
+
+// Retryable reports whether err is a transient network, registry or object
+// store failure
+func Retryable(err error) bool {
+	if err == nil || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) {
+		switch transportErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests:
+			return true
+		}
+		return transportErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	var storeErr minio.ErrorResponse
+	if errors.As(err, &storeErr) {
+		switch storeErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests:
+			return true
+		}
+		return storeErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	// NOTE: every *url.Error is a net.Error, so only timeouts are taken from
+	// it, certificate and handshake failures won't go away with a retry
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// BlobsUpload uploads the layers of img missing in the repository of ref in
+// chunks, retrying transient failures with backoff, remote.Write then only
+// has to push the config and the manifest
+func BlobsUpload(
+	ctx context.Context,
+	ref name.Reference,
+	img v1.Image,
+	auth authn.Authenticator,
+	opts UploadOptions,
+) error {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = defaultUploadChunkSize
+	}
+
+	repo := ref.Context()
+	rt, err := transport.NewWithContext(ctx, repo.Registry, auth, http.DefaultTransport, []string{
+		repo.Scope(transport.PushScope),
+	})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Transport: rt}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return err
+	}
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return err
+		}
+		exists, err := blobExists(ctx, client, repo, digest)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		err = blobUpload(ctx, client, repo, layer, opts)
+		if err != nil {
+			return fmt.Errorf("uploading blob %s: %w", digest, err)
+		}
+	}
+
+	return nil
+}
+
+func blobUpload(
+	ctx context.Context,
+	client *http.Client,
+	repo name.Repository,
+	layer v1.Layer,
+	opts UploadOptions,
+) error {
+	var location string
+	return opts.Retry(ctx, func() error {
+		var offset int64
+		if location != "" {
+			var err error
+			offset, err = uploadOffset(ctx, client, location)
+			if err != nil {
+				slog.Warn("Can't resume blob upload, restarting it", "err", err)
+				location = ""
+			}
+		}
+		if location == "" {
+			var err error
+			location, err = uploadStart(ctx, client, repo)
+			if err != nil {
+				return err
+			}
+			offset = 0
+		}
+
+		err := uploadChunks(ctx, client, &location, layer, offset, opts.ChunkSize)
+		if errors.Is(err, errChunksRejected) {
+			slog.Warn("Registry rejected the chunked upload, uploading the blob at once", "err", err)
+			location, err = uploadStart(ctx, client, repo)
+			if err != nil {
+				return err
+			}
+			return uploadMonolithic(ctx, client, location, layer)
+		}
+		return err
+	})
+}
+
+// chunksRejected reports whether the registry answered a PATCH with a status
+// of a registry that doesn't take chunked uploads
+func chunksRejected(err error) bool {
+	var transportErr *transport.Error
+	if !errors.As(err, &transportErr) {
+		return false
+	}
+	switch transportErr.StatusCode {
+	case http.StatusMethodNotAllowed, http.StatusRequestedRangeNotSatisfiable, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
+
+func uploadNextLocation(resp *http.Response) (string, error) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", errors.New("missing Location header")
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.Request.URL.ResolveReference(u).String(), nil
+}
+
+func uploadStart(ctx context.Context, client *http.Client, repo name.Repository) (string, error) {
+	u := blobURL(repo, "uploads/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	err = transport.CheckError(resp, http.StatusAccepted)
+	if err != nil {
+		return "", err
+	}
+	return uploadNextLocation(resp)
+}
+
+// uploadOffset asks the registry how much of the upload at location it has
+func uploadOffset(ctx context.Context, client *http.Client, location string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	err = transport.CheckError(resp, http.StatusNoContent)
+	if err != nil {
+		return 0, err
+	}
+
+	// NOTE: registries that have nothing yet answer either without a Range or
+	// with 0-0, which can't be told apart from a single byte, both restart
+	uploadRange := resp.Header.Get("Range")
+	if uploadRange == "" {
+		return 0, errors.New("missing Range header")
+	}
+	var start, end int64
+	_, err = fmt.Sscanf(uploadRange, "%d-%d", &start, &end)
+	if err != nil {
+		return 0, fmt.Errorf("parsing Range %q: %v", uploadRange, err)
+	}
+	if end <= 0 {
+		return 0, fmt.Errorf("ambiguous Range %q", uploadRange)
+	}
+	return end + 1, nil
+}
+
+func uploadChunks(
+	ctx context.Context,
+	client *http.Client,
+	location *string,
+	layer v1.Layer,
+	offset int64,
+	chunkSize int64,
+) error {
+	size, err := layer.Size()
+	if err != nil {
+		return err
+	}
+	blob, err := layer.Compressed()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = blob.Close()
+	}()
+	if offset > 0 {
+		// NOTE: the compressed stream is deterministic, so it's regenerated up to offset
+		_, err = io.CopyN(io.Discard, blob, offset)
+		if err != nil {
+			return err
+		}
+	}
+
+	for offset < size {
+		n := min(chunkSize, size-offset)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPatch, *location, io.LimitReader(blob, n))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = n
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+n-1))
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		err = transport.CheckError(resp, http.StatusAccepted, http.StatusNoContent)
+		if chunksRejected(err) {
+			err = fmt.Errorf("%w: %w", errChunksRejected, err)
+		}
+		if err == nil {
+			*location, err = uploadNextLocation(resp)
+		}
+		_ = resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		offset += n
+	}
+
+	digest, err := layer.Digest()
+	if err != nil {
+		return err
+	}
+	return uploadPut(ctx, client, *location, digest, nil, 0)
+}
+
+// uploadMonolithic uploads the whole blob of layer with the PUT closing the
+// upload at location, for registries that don't take chunks
+func uploadMonolithic(ctx context.Context, client *http.Client, location string, layer v1.Layer) error {
+	size, err := layer.Size()
+	if err != nil {
+		return err
+	}
+	digest, err := layer.Digest()
+	if err != nil {
+		return err
+	}
+	blob, err := layer.Compressed()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = blob.Close()
+	}()
+
+	return uploadPut(ctx, client, location, digest, blob, size)
+}
+
+// uploadPut closes the upload at location with the digest of the blob,
+// sending the size bytes of body that weren't PATCHed
+func uploadPut(
+	ctx context.Context,
+	client *http.Client,
+	location string,
+	digest v1.Hash,
+	body io.Reader,
+	size int64,
+) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	query := u.Query()
+	query.Set("digest", digest.String())
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	return transport.CheckError(resp, http.StatusCreated)
+}
@@ -0,0 +1,288 @@
+package oci
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestBlobsUploadRetry(t *testing.T) {
+	var patches atomic.Int32
+	handler := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// fail the second chunk once
+		if req.Method == http.MethodPatch && patches.Add(1) == 2 {
+			http.Error(rw, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(rw, req)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := random.Image(4096, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.ParseReference(u.Host + "/checkpoint/service:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var retries int
+	err = BlobsUpload(context.Background(), ref, img, authn.Anonymous, UploadOptions{
+		Retries:   2,
+		ChunkSize: 1024,
+		OnRetry: func(error) {
+			retries++
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retries != 1 {
+		t.Errorf("expected 1 retry, got %d", retries)
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := layers[0].Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = remote.Layer(ref.Context().Digest(digest.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = remote.Write(ref, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBlobsUploadNoRetry(t *testing.T) {
+	handler := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPatch {
+			http.Error(rw, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+		handler.ServeHTTP(rw, req)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.ParseReference(u.Host + "/checkpoint/service:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = BlobsUpload(context.Background(), ref, img, authn.Anonymous, UploadOptions{})
+	if err == nil {
+		t.Fatal("expected the upload to fail without retries")
+	}
+}
+
+func TestUploadOptionsRetryExhausted(t *testing.T) {
+	var attempts, retries int
+	opts := UploadOptions{
+		Retries: 1,
+		OnRetry: func(error) {
+			retries++
+		},
+	}
+	err := opts.Retry(context.Background(), func() error {
+		attempts++
+		return &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}
+	})
+	if err == nil {
+		t.Fatal("expected the retries to be exhausted")
+	}
+	if attempts != 2 || retries != 1 {
+		t.Errorf("expected 2 attempts and 1 retry, got %d and %d", attempts, retries)
+	}
+}
+
+func TestRetryableEOF(t *testing.T) {
+	if Retryable(io.EOF) {
+		t.Error("expected io.EOF not to be retryable")
+	}
+}
+
+func TestBlobsUploadResumeEmpty(t *testing.T) {
+	var patches atomic.Int32
+	handler := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// fail the first chunk once, the registry then reports 0-0 received
+		if req.Method == http.MethodPatch && patches.Add(1) == 1 {
+			http.Error(rw, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		if req.Method == http.MethodGet && strings.Contains(req.URL.Path, "/blobs/uploads/") {
+			rw.Header().Set("Range", "0-0")
+			rw.WriteHeader(http.StatusNoContent)
+			return
+		}
+		handler.ServeHTTP(rw, req)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := random.Image(4096, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.ParseReference(u.Host + "/checkpoint/service:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = BlobsUpload(context.Background(), ref, img, authn.Anonymous, UploadOptions{
+		Retries:   1,
+		ChunkSize: 1024,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = remote.Write(ref, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBlobsUploadMonolithic(t *testing.T) {
+	var patches atomic.Int32
+	handler := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPatch {
+			patches.Add(1)
+			http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		handler.ServeHTTP(rw, req)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := random.Image(4096, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.ParseReference(u.Host + "/checkpoint/service:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = BlobsUpload(context.Background(), ref, img, authn.Anonymous, UploadOptions{
+		ChunkSize: 1024,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patches.Load() != 1 {
+		t.Errorf("expected 1 rejected PATCH, got %d", patches.Load())
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := layers[0].Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = remote.Layer(ref.Context().Digest(digest.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "timeout",
+			err:  &url.Error{Op: "Patch", URL: "https://registry", Err: timeoutError{}},
+			want: true,
+		},
+		{
+			name: "connection reset",
+			err:  &url.Error{Op: "Patch", URL: "https://registry", Err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}},
+			want: true,
+		},
+		{
+			name: "connection refused",
+			err:  &url.Error{Op: "Post", URL: "https://registry", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}},
+			want: true,
+		},
+		{
+			name: "unexpected EOF",
+			err:  &url.Error{Op: "Patch", URL: "https://registry", Err: io.ErrUnexpectedEOF},
+			want: true,
+		},
+		{
+			name: "unknown authority",
+			err:  &url.Error{Op: "Post", URL: "https://registry", Err: x509.UnknownAuthorityError{}},
+			want: false,
+		},
+		{
+			name: "certificate",
+			err:  &url.Error{Op: "Post", URL: "https://registry", Err: x509.CertificateInvalidError{Reason: x509.Expired}},
+			want: false,
+		},
+		{
+			name: "handshake",
+			err:  &url.Error{Op: "Post", URL: "https://registry", Err: errors.New("tls: handshake failure")},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Retryable(tt.err); got != tt.want {
+				t.Errorf("Retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -8,12 +8,14 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"os"
 	"runtime"
 	"slices"
 	"strings"
 	"time"
 
+	stove8sv1beta1 "bud.studio/stove8s/api/v1beta1"
 	"bud.studio/stove8s/internal/version"
 	"github.com/docker/cli/cli/config"
 	"github.com/google/go-containerregistry/pkg/authn"
@@ -22,7 +24,7 @@ import (
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
-	"github.com/google/go-containerregistry/pkg/v1/stream"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	corev1 "k8s.io/api/core/v1"
 )
@@ -38,38 +40,91 @@ type ContainerConfig struct {
 	RootfsImage     string    `json:"rootfsImage,omitempty"`
 	RootfsImageRef  string    `json:"rootfsImageRef,omitempty"`
 	RootfsImageName string    `json:"rootfsImageName,omitempty"`
+	RawImageName    string    `json:"rawImageName,omitempty"`
 	OCIRuntime      string    `json:"runtime,omitempty"`
 	CreatedTime     time.Time `json:"createdTime"`
 	CheckpointedAt  time.Time `json:"checkpointedTime"`
 	RestoredAt      time.Time `json:"restoredTime"`
 	Restored        bool      `json:"restored"`
+
+	// Pod, Namespace and RootfsImageID are the libpod pod ID, namespace and
+	// image ID, podman only
+	Pod           string `json:"pod,omitempty"`
+	Namespace     string `json:"namespace,omitempty"`
+	RootfsImageID string `json:"rootfsImageID,omitempty"`
 }
 
-func BuildImage(checkpointDumpPath string) (v1.Image, *os.File, error) {
-	var checkpointDump *os.File
-	on_err_exit := func() {
-		err := checkpointDump.Close()
-		if err != nil {
-			slog.Error("Closing checkpointDump file", "err", err)
-		}
-	}
+type BuildOptions struct {
+	Compression stove8sv1beta1.SnapShotOutputCompression
+	// PagesSplitThreshold puts every CRIU pages-*.img of at least this many
+	// bytes in its own layer, 0 disables it
+	PagesSplitThreshold int64
+	// Parent makes the image incremental, reusing the parent layers whose files
+	// didn't change and adding a delta layer for the rest, split like the
+	// layers of a full checkpoint. Parent layers compressed or encrypted
+	// otherwise than the output are rebuilt
+	Parent v1.Image
+	// Encryption encrypts the new layers with ocicrypt for these recipients,
+	// they don't record their file digests so the children of an encrypted
+	// image don't reuse its layers
+	Encryption EncryptionRecipients
+	// TempDir holds the encrypted layers, it must outlive the image
+	TempDir string
+	// Subject is the base image, registries list the checkpoint as its
+	// referrer when both are in the same repository
+	Subject *v1.Descriptor
+	// BaseImageName pins the restore to a copy of the base image, it replaces
+	// the rootfs image name the runtime recorded
+	BaseImageName string
+	// Host describes the node the checkpoint was taken on
+	Host HostInfo
+	// Format is the image shape, unset or auto picks the one of the engine
+	// the checkpoint was taken with
+	Format stove8sv1beta1.SnapShotOutputFormat
+}
 
+func BuildImage(checkpointDumpPath string, opts BuildOptions) (v1.Image, error) {
 	checkpointDump, err := os.Open(checkpointDumpPath)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-
 	spec, dumpConfig, err := dumpInspect(checkpointDump)
+	if closeErr := checkpointDump.Close(); closeErr != nil {
+		slog.Error("Closing checkpointDump file", "err", closeErr)
+	}
 	if err != nil {
-		on_err_exit()
-		return nil, nil, err
+		return nil, err
+	}
+
+	host := opts.Host
+	if host.CriuVersion == "" {
+		host.CriuVersion, err = criuVersionFromDump(checkpointDumpPath)
+		if err != nil {
+			slog.Warn("Reading CRIU version", "err", err)
+		}
 	}
-	_, err = checkpointDump.Seek(0, io.SeekStart)
+	annotations, err := annotationsFromDump(spec, dumpConfig, host)
 	if err != nil {
-		on_err_exit()
-		return nil, nil, err
+		return nil, fmt.Errorf("getting annotations: %v", err)
+	}
+	compression := opts.Compression.Algorithm
+	if compression == "" {
+		compression = stove8sv1beta1.Gzip
+	}
+	annotations[Stove8sAnnotationCompression] = string(compression)
+	if opts.BaseImageName != "" {
+		annotations[Stove8sAnnotationBaseImageSource] = annotations[CheckpointAnnotationRootfsImageName]
+		annotations[CheckpointAnnotationRootfsImageName] = opts.BaseImageName
+	}
+	if opts.Parent != nil {
+		parentDigest, err := opts.Parent.Digest()
+		if err != nil {
+			return nil, fmt.Errorf("getting parent digest: %v", err)
+		}
+		annotations[Stove8sAnnotationParent] = parentDigest.String()
 	}
 
+	format := formatResolve(opts.Format, annotations[CheckpointAnnotationEngine])
 	cfg := v1.ConfigFile{
 		Architecture: runtime.GOARCH,
 		OS:           runtime.GOOS,
@@ -84,51 +139,110 @@ func BuildImage(checkpointDumpPath string) (v1.Image, *os.File, error) {
 			Created:   v1.Time{Time: dumpConfig.CheckpointedAt},
 		}},
 	}
-	img, err := mutate.ConfigFile(empty.Image, &cfg)
-	if err != nil {
-		on_err_exit()
-		return nil, nil, fmt.Errorf("mutating configFile: %v", err)
+	if opts.Host.Arch != "" {
+		cfg.Architecture = opts.Host.Arch
 	}
+	formatApply(format, &cfg, annotations, spec)
 
-	annotations, err := annotationsFromDump(spec, dumpConfig)
+	// NOTE: zstd and annotations aren't part of the docker manifest schema
+	img := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	img = mutate.ConfigMediaType(img, formatConfigMediaType(format))
+	img, err = mutate.ConfigFile(img, &cfg)
 	if err != nil {
-		on_err_exit()
-		return nil, nil, fmt.Errorf("getting annotations: %v", err)
+		return nil, fmt.Errorf("mutating configFile: %v", err)
 	}
 	img = mutate.Annotations(img, annotations).(v1.Image)
 
-	checkpointDumpLayer := stream.NewLayer(checkpointDump)
-	img, err = mutate.AppendLayers(img, checkpointDumpLayer)
+	img, err = appendArchiveLayers(img, checkpointDumpPath, opts)
 	if err != nil {
-		on_err_exit()
-		return nil, nil, fmt.Errorf("appending Layer: %v", err)
+		return nil, fmt.Errorf("appending Layers: %v", err)
+	}
+	if opts.Subject != nil {
+		img = mutate.Subject(img, *opts.Subject).(v1.Image)
 	}
 
-	return img, checkpointDump, nil
+	return withArtifactType(img, formatArtifactType(format)), nil
 }
 
-func annotationsFromDump(spec *specs.Spec, containerConfig *ContainerConfig) (map[string]string, error) {
-	annotations := make(map[string]string)
+// ImageSize returns the sum of the compressed layer sizes of img
+func ImageSize(img v1.Image) (int64, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return 0, err
+	}
 
-	criName, ok := spec.Annotations["io.container.manager"]
-	// NOTE: we only support containerd for the initial phase
-	// podman and cri-o sets this, so exclude them
-	if ok {
-		return nil, fmt.Errorf("unsupported high-level container runtime %v", criName)
+	var size int64
+	for _, layer := range manifest.Layers {
+		size += layer.Size
 	}
+	return size, nil
+}
 
-	annotations[CheckpointAnnotationEngine] = "containerd"
-	annotations[CheckpointAnnotationName] = spec.Annotations["io.kubernetes.cri.container-name"]
-	annotations[CheckpointAnnotationPod] = spec.Annotations["io.kubernetes.cri.sandbox-name"]
-	annotations[CheckpointAnnotationNamespace] = spec.Annotations["io.kubernetes.cri.sandbox-namespace"]
+func annotationsFromDump(spec *specs.Spec, containerConfig *ContainerConfig, host HostInfo) (map[string]string, error) {
+	annotations := make(map[string]string)
+
+	engine, err := CheckpointEngineDetect(spec, containerConfig)
+	if err != nil {
+		return nil, err
+	}
+	engineAnnotations, err := engine.Annotations(spec, containerConfig)
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(annotations, engineAnnotations)
+	annotations[CheckpointAnnotationEngine] = engine.Name()
 	annotations[CheckpointAnnotationRootfsImageUserRequested] = containerConfig.RootfsImage
 	annotations[CheckpointAnnotationRootfsImageName] = containerConfig.RootfsImageName
 	annotations[CheckpointAnnotationRootfsImageID] = containerConfig.RootfsImageRef
+	if containerConfig.RootfsImageRef == "" {
+		annotations[CheckpointAnnotationRootfsImageID] = containerConfig.RootfsImageID
+	}
 	annotations[CheckpointAnnotationRuntimeName] = containerConfig.OCIRuntime
 
+	hostAnnotations := map[string]string{
+		CheckpointAnnotationEngineVersion:       host.EngineVersion,
+		CheckpointAnnotationCriuVersion:         host.CriuVersion,
+		CheckpointAnnotationHostArch:            host.Arch,
+		CheckpointAnnotationHostKernel:          host.Kernel,
+		CheckpointAnnotationCgroupVersion:       host.CgroupVersion,
+		CheckpointAnnotationDistributionName:    host.DistributionName,
+		CheckpointAnnotationDistributionVersion: host.DistributionVersion,
+	}
+	for key, value := range hostAnnotations {
+		if value != "" {
+			annotations[key] = value
+		}
+	}
+
 	return annotations, nil
 }
 
+func criuVersionFromDump(checkpointDumpPath string) (string, error) {
+	checkpointDump, err := os.Open(checkpointDumpPath)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = checkpointDump.Close()
+	}()
+
+	return criuVersionRead(checkpointDump)
+}
+
+// ContainerConfigInspect returns the config.dump of the checkpoint archive
+func ContainerConfigInspect(checkpointDumpPath string) (*ContainerConfig, error) {
+	checkpointDump, err := os.Open(checkpointDumpPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = checkpointDump.Close()
+	}()
+
+	_, dumpConfig, err := dumpInspect(checkpointDump)
+	return dumpConfig, err
+}
+
 func dumpInspect(checkpointDump io.Reader) (*specs.Spec, *ContainerConfig, error) {
 	files := []string{
 		specDumpFile,
@@ -222,6 +336,19 @@ func tarFilesRead(files []string, tarFile io.Reader) (map[string][]byte, error)
 	return res, nil
 }
 
+type k8sSecretKeychain struct {
+	secret *corev1.Secret
+}
+
+// KeychainFromK8sSecret resolves the credentials of any registry in secret
+func KeychainFromK8sSecret(secret *corev1.Secret) authn.Keychain {
+	return k8sSecretKeychain{secret: secret}
+}
+
+func (k k8sSecretKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	return AuthFromK8sSecret(k.secret, target.RegistryStr())
+}
+
 func AuthFromK8sSecret(secret *corev1.Secret, registry string) (authn.Authenticator, error) {
 	if secret.Type != corev1.SecretTypeDockerConfigJson {
 		return nil, fmt.Errorf("secret is not of type %s", corev1.SecretTypeDockerConfigJson)